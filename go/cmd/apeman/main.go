@@ -1,11 +1,19 @@
 package main
 
 import (
+	"flag"
+
 	api "github.com/hotnops/apeman/go/internal/api"
 )
 
 func main() {
+	daemonMode := flag.Bool("daemon", false, "reingest and analyze on the configured schedule and drop directory")
+	flag.Parse()
+
 	s := new(api.Server)
 	s.InitializeServer()
+	if *daemonMode {
+		go s.RunDaemon()
+	}
 	s.Start()
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hotnops/apeman/analyze"
+	"github.com/hotnops/apeman/go/internal/cfn"
+)
+
+// Convert the IAM resources of CloudFormation templates into the output of
+// aws iam get-account-authorization-details, as if the templates were
+// deployed to one account. Each template is deployed as a stack named
+// after its file.
+func main() {
+	accountID := flag.String("account", cfn.DefaultAccountID, "the account the templates are deployed to")
+	region := flag.String("region", cfn.DefaultRegion, "the region the templates are deployed to")
+	policiesFile := flag.String("policies", "", "authorization details to take the documents of attached AWS managed policies from")
+	output := flag.String("o", "", "the file to write to, instead of stdout")
+	parameters := map[string]string{}
+	flag.Func("parameter", "a template parameter as Name=Value, which can be repeated", func(value string) error {
+		name, parameter, ok := strings.Cut(value, "=")
+		if !ok {
+			return fmt.Errorf("%s is not Name=Value", value)
+		}
+		parameters[name] = parameter
+		return nil
+	})
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] template...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	options := cfn.Options{
+		AccountID:       *accountID,
+		Region:          *region,
+		ManagedPolicies: map[string]analyze.ManagedPolicyDetail{},
+	}
+	if *policiesFile != "" {
+		data, err := os.ReadFile(*policiesFile)
+		if err != nil {
+			log.Fatalf("[!] %v", err)
+		}
+		details, err := analyze.ParseAuthorizationDetails(data)
+		if err != nil {
+			log.Fatalf("[!] %s: %v", *policiesFile, err)
+		}
+		for _, policy := range details.Policies {
+			options.ManagedPolicies[policy.Arn] = policy
+		}
+	}
+
+	synthesizer := cfn.NewSynthesizer(options)
+	for _, path := range flag.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("[!] %v", err)
+		}
+		template, err := cfn.ParseTemplate(data)
+		if err != nil {
+			log.Fatalf("[!] %s: %v", path, err)
+		}
+
+		stackName := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		if err := synthesizer.AddTemplate(stackName, template, parameters); err != nil {
+			log.Fatalf("[!] %s: %v", path, err)
+		}
+	}
+
+	details, warnings := synthesizer.AuthorizationDetails()
+	for _, warning := range warnings {
+		log.Printf("[!] %s", warning)
+	}
+
+	data, err := json.MarshalIndent(details, "", "    ")
+	if err != nil {
+		log.Fatalf("[!] %v", err)
+	}
+	data = append(data, '\n')
+
+	if *output == "" {
+		os.Stdout.Write(data)
+	} else if err := os.WriteFile(*output, data, 0644); err != nil {
+		log.Fatalf("[!] %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hotnops/apeman/analyze"
+	"github.com/hotnops/apeman/go/internal/config"
+)
+
+const webhookTimeout = 30 * time.Second
+
+var httpClient = &http.Client{Timeout: webhookTimeout}
+
+func postWebhook(url string, payload []byte) error {
+	response, err := httpClient.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed posting alert to %s: %w", url, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook %s returned %s", url, response.Status)
+	}
+	return nil
+}
+
+// Write the alert to its own file in the spool directory, for another
+// process to pick up
+func writeSpoolFile(dir string, alert analyze.EscalationAlert, payload []byte) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed creating alert spool directory %s: %w", dir, err)
+	}
+
+	name := fmt.Sprintf("alert-%s.json", alert.GeneratedAt.UTC().Format("20060102T150405.000000000Z"))
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, payload, 0644); err != nil {
+		return fmt.Errorf("failed writing alert %s: %w", path, err)
+	}
+	return nil
+}
+
+// Deliver sends the alert to every configured webhook and the spool
+// directory. Every destination is attempted, and the errors of the ones
+// that failed are returned together.
+func Deliver(cfg config.AlertConfiguration, alert analyze.EscalationAlert) error {
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, url := range cfg.Webhooks {
+		if err := postWebhook(url, payload); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if cfg.SpoolDirectory != "" {
+		if err := writeSpoolFile(cfg.SpoolDirectory, alert, payload); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
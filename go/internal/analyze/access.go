@@ -0,0 +1,86 @@
+package analyze
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/specterops/bloodhound/dawgs/graph"
+)
+
+type ResourceToPrincipalMap map[string]PrincipalToActionMap
+
+// WildcardToRegex converts an IAM wildcard pattern into an anchored regex,
+// where * matches any run of characters and ? matches a single character.
+// Everything else is matched literally.
+func WildcardToRegex(pattern string) string {
+	var builder strings.Builder
+	builder.WriteString("^")
+	for _, char := range pattern {
+		switch char {
+		case '*':
+			builder.WriteString(".*")
+		case '?':
+			builder.WriteString(".")
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(char)))
+		}
+	}
+	builder.WriteString("$")
+	return builder.String()
+}
+
+// ActionWildcardToRegex is WildcardToRegex for action names. Actions are
+// case insensitive and are stored lowercase in the graph.
+func ActionWildcardToRegex(pattern string) string {
+	return WildcardToRegex(strings.ToLower(pattern))
+}
+
+// AccessPathSetToMap groups resolved paths by resource, then principal,
+// listing the actions each principal has on the resource
+func AccessPathSetToMap(actionSet ActionPathSet) ResourceToPrincipalMap {
+	resourceMap := make(ResourceToPrincipalMap)
+	for _, actionPath := range actionSet {
+		principalMap, ok := resourceMap[actionPath.ResourceArn]
+		if !ok {
+			principalMap = make(PrincipalToActionMap)
+			resourceMap[actionPath.ResourceArn] = principalMap
+		}
+		principalMap[actionPath.PrincipalArn] = addUniqueItem(principalMap[actionPath.PrincipalArn], actionPath.Action)
+	}
+	return resourceMap
+}
+
+type PrincipalScope struct {
+	PrincipalArn string   `json:"principal_arn"`
+	PrincipalID  graph.ID `json:"principal_id"`
+	Resources    []string `json:"resources"`
+}
+
+// GetPrincipalScopes lists each principal in the resolved paths along with
+// the resources it can act on
+func GetPrincipalScopes(actionSet ActionPathSet) []PrincipalScope {
+	scopes := map[string]*PrincipalScope{}
+	for _, actionPath := range actionSet {
+		scope, ok := scopes[actionPath.PrincipalArn]
+		if !ok {
+			scope = &PrincipalScope{
+				PrincipalArn: actionPath.PrincipalArn,
+				PrincipalID:  actionPath.PrincipalID,
+				Resources:    []string{},
+			}
+			scopes[actionPath.PrincipalArn] = scope
+		}
+		scope.Resources = addUniqueItem(scope.Resources, actionPath.ResourceArn)
+	}
+
+	principalScopes := []PrincipalScope{}
+	for _, scope := range scopes {
+		sort.Strings(scope.Resources)
+		principalScopes = append(principalScopes, *scope)
+	}
+	sort.Slice(principalScopes, func(i, j int) bool {
+		return principalScopes[i].PrincipalArn < principalScopes[j].PrincipalArn
+	})
+	return principalScopes
+}
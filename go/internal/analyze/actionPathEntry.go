@@ -11,6 +11,7 @@ type ActionPathEntry struct {
 	PrincipalID       graph.ID                     `json:"principal_id"`
 	PrincipalTags     map[string]string            `json:"principal_tags"`
 	PrincipalArn      string                       `json:"principal_arn"`
+	PrincipalMFA      *bool                        `json:"principal_mfa,omitempty"`
 	IsPrincipalDirect bool                         `json:"is_principal_direct"`
 	ResourceArn       string                       `json:"resource_arn"`
 	ResourceID        graph.ID                     `json:"resource_id"`
@@ -20,6 +21,9 @@ type ActionPathEntry struct {
 	Effect            string                       `json:"effect"`
 	Statement         *graph.Node                  `json:"statement"`
 	Conditions        []awsconditions.AWSCondition `json:"conditions"`
+	// Set when a conditional deny that couldn't be evaluated may still
+	// apply, so the access depends on the context of the request
+	UnresolvedConditions bool `json:"unresolved_conditions"`
 }
 
 func (a *ActionPathEntry) IsEqual(other ActionPathEntry) bool {
@@ -53,6 +53,16 @@ func (a *ActionPathSet) RemoveActionPathEntry(actionPath ActionPathEntry) {
 	*a = tempPaths
 }
 
+// Flag the entries equal to actionPath as depending on unresolved
+// conditions
+func (a *ActionPathSet) MarkUnresolvedConditions(actionPath ActionPathEntry) {
+	for i := range *a {
+		if (*a)[i].IsEqual(actionPath) {
+			(*a)[i].UnresolvedConditions = true
+		}
+	}
+}
+
 func (p *ActionPathSet) SplitByEffect() (allow *ActionPathSet, deny *ActionPathSet) {
 	allow = new(ActionPathSet)
 	deny = new(ActionPathSet)
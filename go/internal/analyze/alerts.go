@@ -0,0 +1,114 @@
+package analyze
+
+import "time"
+
+// The analysis state alerts are computed against is kept as a snapshot
+// under this name
+const LastAnalysisSnapshotName = "last-analysis"
+
+// A principal that can now reach a tier zero principal through identity
+// transforms, and the shortest chain that gets it there
+type TierZeroPathAlert struct {
+	Source string                  `json:"source"`
+	Target string                  `json:"target"`
+	Path   []IdentityTransformEdge `json:"path"`
+}
+
+type EscalationAlert struct {
+	GeneratedAt           time.Time               `json:"generated_at"`
+	PreviousAnalysis      time.Time               `json:"previous_analysis"`
+	NewIdentityTransforms []IdentityTransformEdge `json:"new_identity_transforms"`
+	NewTierZeroPaths      []TierZeroPathAlert     `json:"new_tier_zero_paths"`
+}
+
+func (a *EscalationAlert) IsEmpty() bool {
+	return len(a.NewIdentityTransforms) == 0 && len(a.NewTierZeroPaths) == 0
+}
+
+// GetShortestIdentityChain returns the fewest identity transforms leading
+// from source to target, or nil if there is no chain
+func GetShortestIdentityChain(edges []IdentityTransformEdge, source string, target string) []IdentityTransformEdge {
+	adjacent := map[string][]IdentityTransformEdge{}
+	for _, edge := range edges {
+		adjacent[edge.Source] = append(adjacent[edge.Source], edge)
+	}
+
+	previous := map[string]IdentityTransformEdge{}
+	visited := map[string]bool{source: true}
+	queue := []string{source}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if current == target {
+			break
+		}
+		for _, edge := range adjacent[current] {
+			if visited[edge.Target] {
+				continue
+			}
+			visited[edge.Target] = true
+			previous[edge.Target] = edge
+			queue = append(queue, edge.Target)
+		}
+	}
+
+	if !visited[target] || source == target {
+		return nil
+	}
+
+	chain := []IdentityTransformEdge{}
+	for current := target; current != source; current = previous[current].Source {
+		chain = append([]IdentityTransformEdge{previous[current]}, chain...)
+	}
+	return chain
+}
+
+// GetEscalationAlert compares two analysis runs. It reports identity
+// transforms that didn't exist before, and principals that can now reach
+// a tier zero principal they couldn't reach, or that wasn't tier zero,
+// in the previous run. Tier zero principals reaching each other are not
+// reported.
+func GetEscalationAlert(previous *Snapshot, current *Snapshot) EscalationAlert {
+	alert := EscalationAlert{
+		GeneratedAt:      current.CreatedAt,
+		PreviousAnalysis: previous.CreatedAt,
+		NewTierZeroPaths: []TierZeroPathAlert{},
+	}
+
+	alert.NewIdentityTransforms, _ = diffItems(previous.IdentityTransforms, current.IdentityTransforms)
+
+	previousTierZero := map[string]bool{}
+	for _, name := range previous.TierZero {
+		previousTierZero[name] = true
+	}
+	currentTierZero := map[string]bool{}
+	for _, name := range current.TierZero {
+		currentTierZero[name] = true
+	}
+
+	previousReachable := map[IdentityPathEndpoints]bool{}
+	for _, endpoints := range GetIdentityPathEndpoints(previous.IdentityTransforms) {
+		if previousTierZero[endpoints.Target] {
+			previousReachable[endpoints] = true
+		}
+	}
+
+	for _, endpoints := range GetIdentityPathEndpoints(current.IdentityTransforms) {
+		if !currentTierZero[endpoints.Target] || previousReachable[endpoints] {
+			continue
+		}
+		// A tier zero principal reaching another is already as privileged
+		// as it gets
+		if previousTierZero[endpoints.Source] {
+			continue
+		}
+
+		alert.NewTierZeroPaths = append(alert.NewTierZeroPaths, TierZeroPathAlert{
+			Source: endpoints.Source,
+			Target: endpoints.Target,
+			Path:   GetShortestIdentityChain(current.IdentityTransforms, endpoints.Source, endpoints.Target),
+		})
+	}
+
+	return alert
+}
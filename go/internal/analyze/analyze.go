@@ -92,6 +92,13 @@ func ResolveAssumeRolePaths(assumeRoleSet *ActionPathSet, identityActionSet *Act
 
 			identityAllow.RemoveActionPathEntry(condDenyPath)
 			identityCondAllow.RemoveActionPathEntry(condDenyPath)
+		} else if ConditionsUnresolved(condDenyPath) {
+			// The deny may or may not apply at request time
+			resourceAllow.MarkUnresolvedConditions(condDenyPath)
+			resourceCondAllow.MarkUnresolvedConditions(condDenyPath)
+
+			identityAllow.MarkUnresolvedConditions(condDenyPath)
+			identityCondAllow.MarkUnresolvedConditions(condDenyPath)
 		}
 	}
 
@@ -165,6 +172,13 @@ func ResolveResourceAgainstIdentityPolicies(resourceActionSet *ActionPathSet, id
 
 			identityAllow.RemoveActionPathEntry(condDenyPath)
 			identityCondAllow.RemoveActionPathEntry(condDenyPath)
+		} else if ConditionsUnresolved(condDenyPath) {
+			// The deny may or may not apply at request time
+			resourceAllow.MarkUnresolvedConditions(condDenyPath)
+			resourceCondAllow.MarkUnresolvedConditions(condDenyPath)
+
+			identityAllow.MarkUnresolvedConditions(condDenyPath)
+			identityCondAllow.MarkUnresolvedConditions(condDenyPath)
 		}
 	}
 
@@ -190,6 +204,23 @@ func ResolveResourceAgainstIdentityPolicies(resourceActionSet *ActionPathSet, id
 	return resolvedPaths, nil
 }
 
+// ApplyPermissionsBoundary keeps only the resolved paths that are also
+// allowed by the resolved permissions boundary. A nil boundary means the
+// principal has no boundary, so nothing is removed.
+func ApplyPermissionsBoundary(resolvedPaths *ActionPathSet, boundaryPaths *ActionPathSet) *ActionPathSet {
+	if boundaryPaths == nil {
+		return resolvedPaths
+	}
+
+	boundedPaths := new(ActionPathSet)
+	for _, resolvedPath := range *resolvedPaths {
+		if boundaryPaths.ContainsActionPath(resolvedPath) {
+			boundedPaths.Add(resolvedPath)
+		}
+	}
+	return boundedPaths
+}
+
 func GetPrincipalsOfPolicy(ctx context.Context, db graph.Database, policyNode *graph.Node) (graph.NodeSet, error) {
 	var (
 		traversalInst = traversal.New(db, analysis.MaximumDatabaseParallelWorkers)
@@ -250,18 +281,6 @@ func GetAttachedKinds(ctx context.Context, db graph.Database, node *graph.Node,
 
 }
 
-func GetAWSNodeByGraphID(ctx context.Context, db graph.Database, id graph.ID) (*graph.Node, error) {
-	var node *graph.Node
-	var err error
-
-	err = db.ReadTransaction(ctx, func(tx graph.Transaction) error {
-		node, err = ops.FetchNode(tx, id)
-		return nil
-	})
-
-	return node, err
-}
-
 func GetAWSNodesByKind(ctx context.Context, db graph.Database, nodeKind graph.Kind) (graph.NodeSet, error) {
 	var fetchedNodes graph.NodeSet
 
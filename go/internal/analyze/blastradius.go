@@ -0,0 +1,183 @@
+package analyze
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/specterops/bloodhound/dawgs/graph"
+)
+
+type BlastRadiusResource struct {
+	ResourceArn  string   `json:"resource_arn"`
+	PrincipalArn string   `json:"principal_arn"`
+	Chain        []string `json:"chain"`
+	Actions      []string `json:"actions"`
+}
+
+type BlastRadius struct {
+	Principals []string                  `json:"principals"`
+	Counts     map[string]map[string]int `json:"counts"`
+	Resources  []BlastRadiusResource     `json:"resources"`
+
+	resources map[string]*BlastRadiusResource
+	counted   map[string]bool
+}
+
+func NewBlastRadius() *BlastRadius {
+	return &BlastRadius{
+		Principals: []string{},
+		Counts:     map[string]map[string]int{},
+		Resources:  []BlastRadiusResource{},
+		resources:  map[string]*BlastRadiusResource{},
+		counted:    map[string]bool{},
+	}
+}
+
+func GetServiceFromArn(arn string) string {
+	arnParts := strings.Split(arn, ":")
+	if len(arnParts) > 2 {
+		return arnParts[2]
+	}
+	return ""
+}
+
+// GetChainFromPath returns the ARNs of each principal along an identity
+// transform path
+func GetChainFromPath(path graph.Path) []string {
+	chain := []string{}
+	for _, node := range path.Nodes {
+		arn, _ := node.Properties.Get("arn").String()
+		chain = append(chain, arn)
+	}
+	return chain
+}
+
+// GetTransformTargetPaths walks the identity transform edges forwards from
+// the source, and returns one shortest path to every node it reaches.
+func GetTransformTargetPaths(nodes map[graph.ID]*graph.Node, edges []*graph.Relationship, sourceID graph.ID) graph.PathSet {
+	return getShortestTransformPaths(nodes, edges, sourceID, false)
+}
+
+// GetTransformSourcePathsTo walks the identity transform edges backwards
+// from the target, and returns one shortest path from every node that
+// reaches it.
+func GetTransformSourcePathsTo(nodes map[graph.ID]*graph.Node, edges []*graph.Relationship, targetID graph.ID) graph.PathSet {
+	return getShortestTransformPaths(nodes, edges, targetID, true)
+}
+
+// Breadth first search from a node, keeping the edge each node was first
+// reached through. Each node is visited once, so cycles of roles that can
+// assume each other don't matter. The returned paths always point along
+// the edges, so walking backwards gives paths that end at the start node.
+func getShortestTransformPaths(nodes map[graph.ID]*graph.Node, edges []*graph.Relationship, startID graph.ID, backwards bool) graph.PathSet {
+	next := func(edge *graph.Relationship) graph.ID {
+		if backwards {
+			return edge.StartID
+		}
+		return edge.EndID
+	}
+	previous := func(edge *graph.Relationship) graph.ID {
+		if backwards {
+			return edge.EndID
+		}
+		return edge.StartID
+	}
+
+	adjacent := map[graph.ID][]*graph.Relationship{}
+	for _, edge := range edges {
+		adjacent[previous(edge)] = append(adjacent[previous(edge)], edge)
+	}
+
+	parents := map[graph.ID]*graph.Relationship{}
+	visited := map[graph.ID]bool{startID: true}
+	reached := []graph.ID{}
+	queue := []graph.ID{startID}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, edge := range adjacent[current] {
+			if visited[next(edge)] {
+				continue
+			}
+			visited[next(edge)] = true
+			parents[next(edge)] = edge
+			reached = append(reached, next(edge))
+			queue = append(queue, next(edge))
+		}
+	}
+
+	pathSet := graph.NewPathSet()
+	for _, nodeID := range reached {
+		path := graph.Path{Nodes: []*graph.Node{nodes[nodeID]}}
+		for current := nodeID; current != startID; {
+			edge := parents[current]
+			current = previous(edge)
+			if backwards {
+				path.Nodes = append(path.Nodes, nodes[current])
+				path.Edges = append(path.Edges, edge)
+			} else {
+				path.Nodes = append([]*graph.Node{nodes[current]}, path.Nodes...)
+				path.Edges = append([]*graph.Relationship{edge}, path.Edges...)
+			}
+		}
+		pathSet.AddPath(path)
+	}
+
+	return pathSet
+}
+
+// AddResolvedPaths adds the resolved paths of a principal that was reached
+// through chain. The chain starts at the compromised principal and ends at
+// the principal the paths belong to.
+func (b *BlastRadius) AddResolvedPaths(chain []string, resolvedPaths *ActionPathSet) {
+	principalArn := ""
+	if len(chain) > 0 {
+		principalArn = chain[len(chain)-1]
+	}
+	b.Principals = addUniqueItem(b.Principals, principalArn)
+
+	for _, entry := range *resolvedPaths {
+		key := entry.ResourceArn + "|" + principalArn
+		resource, ok := b.resources[key]
+		if !ok {
+			resource = &BlastRadiusResource{
+				ResourceArn:  entry.ResourceArn,
+				PrincipalArn: principalArn,
+				Chain:        chain,
+				Actions:      []string{},
+			}
+			b.resources[key] = resource
+		}
+		resource.Actions = addUniqueItem(resource.Actions, entry.Action)
+
+		if b.counted[entry.ResourceArn] {
+			continue
+		}
+		b.counted[entry.ResourceArn] = true
+
+		accountID := GetAccountIDFromArn(entry.ResourceArn)
+		services, ok := b.Counts[accountID]
+		if !ok {
+			services = map[string]int{}
+			b.Counts[accountID] = services
+		}
+		services[GetServiceFromArn(entry.ResourceArn)]++
+	}
+}
+
+// Finalize sorts the principals and copies the collected resources into a
+// sorted list
+func (b *BlastRadius) Finalize() {
+	sort.Strings(b.Principals)
+	b.Resources = []BlastRadiusResource{}
+	for _, resource := range b.resources {
+		sort.Strings(resource.Actions)
+		b.Resources = append(b.Resources, *resource)
+	}
+	sort.Slice(b.Resources, func(i, j int) bool {
+		if b.Resources[i].ResourceArn != b.Resources[j].ResourceArn {
+			return b.Resources[i].ResourceArn < b.Resources[j].ResourceArn
+		}
+		return len(b.Resources[i].Chain) < len(b.Resources[j].Chain)
+	})
+}
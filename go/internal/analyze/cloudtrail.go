@@ -0,0 +1,269 @@
+package analyze
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Principals that haven't used a granted action for this long are
+// reported as having unused permissions, unless configured otherwise
+const DefaultUnusedPermissionThreshold = 90 * 24 * time.Hour
+
+// The most source IPs kept for each principal and action
+const MaxActivitySourceIPs = 10
+
+// ActionActivity is when a principal last used an action according to
+// CloudTrail, and the addresses it most recently used it from
+type ActionActivity struct {
+	PrincipalArn string    `json:"principal_arn"`
+	Action       string    `json:"action"`
+	LastUsed     time.Time `json:"last_used"`
+	SourceIPs    []string  `json:"source_ips"`
+}
+
+type activityKey struct {
+	principalArn string
+	action       string
+}
+
+type activityEntry struct {
+	lastUsed  time.Time
+	sourceIPs map[string]time.Time
+}
+
+// CloudTrailCoverage is the time span of the events in the ingested
+// CloudTrail logs. Nothing can be said about use outside of it.
+type CloudTrailCoverage struct {
+	FirstEvent time.Time `json:"first_event"`
+	LastEvent  time.Time `json:"last_event"`
+}
+
+func (c CloudTrailCoverage) IsEmpty() bool {
+	return c.FirstEvent.IsZero() || c.LastEvent.IsZero()
+}
+
+func (c CloudTrailCoverage) Duration() time.Duration {
+	if c.IsEmpty() {
+		return 0
+	}
+	return c.LastEvent.Sub(c.FirstEvent)
+}
+
+func (c *CloudTrailCoverage) add(eventTime time.Time) {
+	if c.FirstEvent.IsZero() || eventTime.Before(c.FirstEvent) {
+		c.FirstEvent = eventTime
+	}
+	if c.LastEvent.IsZero() || eventTime.After(c.LastEvent) {
+		c.LastEvent = eventTime
+	}
+}
+
+// CloudTrailActivity collects the last use of every action by every
+// principal across any number of CloudTrail log files
+type CloudTrailActivity struct {
+	entries  map[activityKey]*activityEntry
+	coverage CloudTrailCoverage
+}
+
+func NewCloudTrailActivity() *CloudTrailActivity {
+	return &CloudTrailActivity{
+		entries: map[activityKey]*activityEntry{},
+	}
+}
+
+// The principal a CloudTrail event counts towards. Role sessions count
+// towards the role that was assumed.
+func getCloudTrailPrincipalArn(record cloudTrailRecord) string {
+	if issuer := record.UserIdentity.SessionContext.SessionIssuer.Arn; issuer != "" {
+		return issuer
+	}
+	return record.UserIdentity.Arn
+}
+
+// Every event counts towards the coverage, including the ones that don't
+// count as use of an action
+func (a *CloudTrailActivity) addRecord(record cloudTrailRecord) {
+	eventTime, err := time.Parse(time.RFC3339, record.EventTime)
+	if err != nil {
+		return
+	}
+	a.coverage.add(eventTime.UTC())
+
+	principalArn := getCloudTrailPrincipalArn(record)
+	if principalArn == "" || record.EventSource == "" || record.EventName == "" || isAccessDenied(record.ErrorCode) {
+		return
+	}
+
+	key := activityKey{principalArn: principalArn, action: getCloudTrailAction(record)}
+	entry, ok := a.entries[key]
+	if !ok {
+		entry = &activityEntry{sourceIPs: map[string]time.Time{}}
+		a.entries[key] = entry
+	}
+	if eventTime.After(entry.lastUsed) {
+		entry.lastUsed = eventTime
+	}
+	if record.SourceIPAddress != "" && eventTime.After(entry.sourceIPs[record.SourceIPAddress]) {
+		entry.sourceIPs[record.SourceIPAddress] = eventTime
+	}
+}
+
+// AddLog adds the events of a CloudTrail log file, optionally gzipped
+func (a *CloudTrailActivity) AddLog(data []byte) error {
+	data, err := decompressUsage(data)
+	if err != nil {
+		return fmt.Errorf("failed decompressing CloudTrail log: %w", err)
+	}
+
+	var trail cloudTrailLog
+	if err := json.Unmarshal(data, &trail); err != nil {
+		return fmt.Errorf("failed parsing CloudTrail log: %w", err)
+	}
+
+	for _, record := range trail.Records {
+		a.addRecord(record)
+	}
+	return nil
+}
+
+// Entries returns the activity sorted by principal and action. Source IPs
+// are ordered from most to least recently seen.
+func (a *CloudTrailActivity) Entries() []ActionActivity {
+	activity := []ActionActivity{}
+	for key, entry := range a.entries {
+		sourceIPs := []string{}
+		for sourceIP := range entry.sourceIPs {
+			sourceIPs = append(sourceIPs, sourceIP)
+		}
+		sort.Slice(sourceIPs, func(i, j int) bool {
+			if !entry.sourceIPs[sourceIPs[i]].Equal(entry.sourceIPs[sourceIPs[j]]) {
+				return entry.sourceIPs[sourceIPs[i]].After(entry.sourceIPs[sourceIPs[j]])
+			}
+			return sourceIPs[i] < sourceIPs[j]
+		})
+		if len(sourceIPs) > MaxActivitySourceIPs {
+			sourceIPs = sourceIPs[:MaxActivitySourceIPs]
+		}
+
+		activity = append(activity, ActionActivity{
+			PrincipalArn: key.principalArn,
+			Action:       key.action,
+			LastUsed:     entry.lastUsed.UTC(),
+			SourceIPs:    sourceIPs,
+		})
+	}
+
+	sort.Slice(activity, func(i, j int) bool {
+		if activity[i].PrincipalArn != activity[j].PrincipalArn {
+			return activity[i].PrincipalArn < activity[j].PrincipalArn
+		}
+		return activity[i].Action < activity[j].Action
+	})
+	return activity
+}
+
+// Coverage returns the time span of every event added so far
+func (a *CloudTrailActivity) Coverage() CloudTrailCoverage {
+	return a.coverage
+}
+
+// CloudTrailIngest is the outcome of storing CloudTrail activity in the
+// graph
+type CloudTrailIngest struct {
+	Coverage CloudTrailCoverage `json:"coverage"`
+	Stored   []ActionActivity   `json:"stored"`
+	// Activity of principals or actions that aren't in the graph, such as
+	// principals of other accounts or actions missing from the action list
+	Unmatched []ActionActivity `json:"unmatched"`
+}
+
+func isCloudTrailFile(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".json.gz")
+}
+
+// ReadCloudTrailDirectory reads every CloudTrail log below dir. The
+// directory can be a copy of the bucket CloudTrail delivers to, which
+// nests the logs by account, region and date.
+func ReadCloudTrailDirectory(dir string) (*CloudTrailActivity, error) {
+	activity := NewCloudTrailActivity()
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !isCloudTrailFile(entry.Name()) {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := activity.AddLog(data); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed reading CloudTrail directory %s: %w", dir, err)
+	}
+
+	return activity, nil
+}
+
+// ActionUsage is whether a granted action has been used, according to the
+// CloudTrail logs that were ingested
+type ActionUsage struct {
+	Action    string     `json:"action"`
+	Used      bool       `json:"used"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+	SourceIPs []string   `json:"source_ips,omitempty"`
+}
+
+// GetActionUsage lists every action allowed by the effective permissions
+// of a principal with its last use, given the principal's activity keyed
+// by action
+func GetActionUsage(effective ActionPathSet, activity map[string]ActionActivity) []ActionUsage {
+	granted := map[string]bool{}
+	for _, entry := range effective {
+		if entry.Effect != "" && entry.Effect != "Allow" {
+			continue
+		}
+		granted[entry.Action] = true
+	}
+
+	usage := []ActionUsage{}
+	for action := range granted {
+		actionUsage := ActionUsage{Action: action}
+		if used, ok := activity[action]; ok {
+			lastUsed := used.LastUsed
+			actionUsage.Used = true
+			actionUsage.LastUsed = &lastUsed
+			actionUsage.SourceIPs = used.SourceIPs
+		}
+		usage = append(usage, actionUsage)
+	}
+
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].Action < usage[j].Action
+	})
+	return usage
+}
+
+// GetUnusedActions returns the granted actions that haven't been used
+// within the threshold
+func GetUnusedActions(usage []ActionUsage, now time.Time, threshold time.Duration) []string {
+	unused := []string{}
+	for _, actionUsage := range usage {
+		if actionUsage.LastUsed == nil || now.Sub(*actionUsage.LastUsed) >= threshold {
+			unused = append(unused, actionUsage.Action)
+		}
+	}
+	return unused
+}
@@ -2,6 +2,7 @@ package analyze
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -24,6 +25,16 @@ func PrincipalTag(entry ActionPathEntry, policyVariable string) (string, error)
 	return entry.PrincipalTags[tagName], nil
 }
 
+// Users with an MFA device can make requests with MFA, and users without
+// one never can. It's unknown for principals missing from the
+// credential report.
+func MultiFactorAuthPresent(entry ActionPathEntry, policyVariable string) (string, error) {
+	if entry.PrincipalMFA == nil {
+		return "", fmt.Errorf("MFA status of %s is unknown", entry.PrincipalArn)
+	}
+	return strconv.FormatBool(*entry.PrincipalMFA), nil
+}
+
 func NotImplemented(entry ActionPathEntry, policyVariable string) (string, error) {
 	return "", fmt.Errorf("not implemented")
 }
@@ -56,6 +67,7 @@ var ContextKeyFunctionMap = map[string]func(ActionPathEntry, string) (string, er
 	"aws:userid":                    NotImplemented,
 	"aws:username":                  NotImplemented,
 	"aws:FederatedProvider":         NotImplemented,
+	"aws:MultiFactorAuthPresent":    MultiFactorAuthPresent,
 	"aws:ResourceAccount":           ResourceAccount,
 	"aws:ResourceOrgPaths":          NotImplemented,
 	"aws:ResourceOrgID":             NotImplemented,
@@ -0,0 +1,64 @@
+package analyze
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/specterops/bloodhound/dawgs/graph"
+)
+
+// Tag key that marks a principal as a high value target
+const HighValueTagKey = "apeman:highvalue"
+
+const (
+	EscalationReasonTierZero  = "tierzero"
+	EscalationReasonHighValue = "highvalue"
+)
+
+type EscalationPath struct {
+	TargetID   graph.ID   `json:"target_id"`
+	TargetArn  string     `json:"target_arn"`
+	Reason     string     `json:"reason"`
+	Hops       int        `json:"hops"`
+	Transforms []string   `json:"transforms"`
+	Cost       float64    `json:"cost"`
+	Path       graph.Path `json:"path"`
+}
+
+// NewEscalationPath builds an escalation path from an identity transform
+// path, where the last node is the target
+func NewEscalationPath(path graph.Path, reason string, costs PathCosts) EscalationPath {
+	escalationPath := EscalationPath{
+		Reason:     reason,
+		Hops:       len(path.Edges),
+		Transforms: []string{},
+		Cost:       costs.PathCost(path),
+		Path:       path,
+	}
+
+	if len(path.Nodes) > 0 {
+		target := path.Nodes[len(path.Nodes)-1]
+		escalationPath.TargetID = target.ID
+		escalationPath.TargetArn, _ = target.Properties.Get("arn").String()
+	}
+
+	for _, edge := range path.Edges {
+		name, _ := edge.Properties.Get("name").String()
+		escalationPath.Transforms = append(escalationPath.Transforms, strings.ToLower(name))
+	}
+
+	return escalationPath
+}
+
+// RankEscalationPaths orders paths by cost, then by hop count
+func RankEscalationPaths(paths []EscalationPath) {
+	sort.SliceStable(paths, func(i, j int) bool {
+		if paths[i].Cost != paths[j].Cost {
+			return paths[i].Cost < paths[j].Cost
+		}
+		if paths[i].Hops != paths[j].Hops {
+			return paths[i].Hops < paths[j].Hops
+		}
+		return paths[i].TargetArn < paths[j].TargetArn
+	})
+}
@@ -0,0 +1,249 @@
+package analyze
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/specterops/bloodhound/dawgs/graph"
+)
+
+const (
+	SeverityCritical = "critical"
+	SeverityHigh     = "high"
+	SeverityMedium   = "medium"
+	SeverityLow      = "low"
+	SeverityInfo     = "info"
+)
+
+// Severities from most to least severe
+var Severities = []string{SeverityCritical, SeverityHigh, SeverityMedium, SeverityLow, SeverityInfo}
+
+const (
+	CheckIAMWildcardAction      = "iam-wildcard-action"
+	CheckAdminWildcard          = "admin-wildcard"
+	CheckPublicTrust            = "public-trust"
+	CheckExternalTrustNoId      = "external-trust-no-externalid"
+	CheckInlineAdminPolicy      = "inline-admin-policy"
+	CheckUserConsoleKeysNoGroup = "user-console-keys-no-group"
+	CheckUserConsoleNoMFA       = "user-console-no-mfa"
+	CheckUnusedRole             = "unused-role"
+	CheckUnusedPermissions      = "unused-permissions"
+)
+
+// Action blob names that match every IAM action
+var IAMWildcardActionNames = []string{"iam:*"}
+
+// Roles that haven't been used for this long are reported as unused
+const UnusedRoleThreshold = 90 * 24 * time.Hour
+
+// FindingOptions tunes the built in checks
+type FindingOptions struct {
+	// How long a granted action can go unused before it's reported
+	UnusedPermissionThreshold time.Duration
+}
+
+// NewFindingOptions creates the options of the built in checks. An unused
+// permission period of zero days keeps the default.
+func NewFindingOptions(unusedPermissionDays int) (FindingOptions, error) {
+	options := FindingOptions{
+		UnusedPermissionThreshold: DefaultUnusedPermissionThreshold,
+	}
+	if unusedPermissionDays < 0 {
+		return options, fmt.Errorf("unused permission days can't be negative: %d", unusedPermissionDays)
+	}
+	if unusedPermissionDays > 0 {
+		options.UnusedPermissionThreshold = time.Duration(unusedPermissionDays) * 24 * time.Hour
+	}
+	return options, nil
+}
+
+type FindingCheck struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Severity    string `json:"severity"`
+	Description string `json:"description"`
+}
+
+// The built in checks, run by every findings analysis
+var FindingChecks = []FindingCheck{
+	{
+		ID:          CheckAdminWildcard,
+		Title:       "Policy allows all actions on all resources",
+		Severity:    SeverityCritical,
+		Description: "An allow statement grants Action \"*\" on Resource \"*\", making the principal an administrator.",
+	},
+	{
+		ID:          CheckPublicTrust,
+		Title:       "Role trusts any principal",
+		Severity:    SeverityCritical,
+		Description: "The trust policy allows Principal \"*\" without conditions, so anyone with an AWS account can assume the role.",
+	},
+	{
+		ID:          CheckIAMWildcardAction,
+		Title:       "Policy allows all IAM actions",
+		Severity:    SeverityHigh,
+		Description: "An allow statement grants iam:*, which is enough to escalate to administrator.",
+	},
+	{
+		ID:          CheckInlineAdminPolicy,
+		Title:       "Inline policy grants administrator access",
+		Severity:    SeverityHigh,
+		Description: "An inline policy grants all actions on all resources. Inline policies are harder to audit than managed policies.",
+	},
+	{
+		ID:          CheckExternalTrustNoId,
+		Title:       "Cross account trust without an external ID",
+		Severity:    SeverityMedium,
+		Description: "The trust policy allows an account outside of the ingested accounts without requiring sts:ExternalId.",
+	},
+	{
+		ID:          CheckUserConsoleKeysNoGroup,
+		Title:       "User with console access and access keys outside of any group",
+		Severity:    SeverityMedium,
+		Description: "The user has a console password and an active access key but isn't managed through a group.",
+	},
+	{
+		ID:          CheckUserConsoleNoMFA,
+		Title:       "User with console access and no MFA",
+		Severity:    SeverityMedium,
+		Description: "The user has a console password but no MFA device, so the password alone is enough to sign in.",
+	},
+	{
+		ID:          CheckUnusedRole,
+		Title:       "Unused role",
+		Severity:    SeverityLow,
+		Description: "The role has not been used in the last 90 days.",
+	},
+	{
+		ID:          CheckUnusedPermissions,
+		Title:       "Unused permissions",
+		Severity:    SeverityLow,
+		Description: "The principal is allowed actions that it hasn't used within the unused permission period, 90 days unless configured otherwise, according to the ingested CloudTrail logs. Logs covering less than the period aren't checked.",
+	},
+}
+
+func GetFindingCheck(checks []FindingCheck, checkID string) (FindingCheck, bool) {
+	for _, check := range checks {
+		if check.ID == checkID {
+			return check, true
+		}
+	}
+	return FindingCheck{}, false
+}
+
+func IsValidSeverity(severity string) bool {
+	for _, s := range Severities {
+		if s == severity {
+			return true
+		}
+	}
+	return false
+}
+
+type Finding struct {
+	ID            string   `json:"id"`
+	CheckID       string   `json:"check_id"`
+	Title         string   `json:"title"`
+	Severity      string   `json:"severity"`
+	Description   string   `json:"description"`
+	NodeID        graph.ID `json:"node_id"`
+	NodeArn       string   `json:"node_arn"`
+	AccountID     string   `json:"account_id"`
+	StatementHash string   `json:"statement_hash"`
+	Detail        string   `json:"detail"`
+}
+
+// NewFinding creates a finding of check against a node. The statement hash
+// is the evidence for the finding and may be empty for checks that don't
+// look at policies.
+func NewFinding(check FindingCheck, nodeID graph.ID, nodeArn string, statementHash string, detail string) Finding {
+	hash := sha256.Sum256([]byte(strings.Join([]string{check.ID, nodeArn, statementHash}, "|")))
+
+	return Finding{
+		ID:            hex.EncodeToString(hash[:]),
+		CheckID:       check.ID,
+		Title:         check.Title,
+		Severity:      check.Severity,
+		Description:   check.Description,
+		NodeID:        nodeID,
+		NodeArn:       nodeArn,
+		AccountID:     GetAccountIDFromArn(nodeArn),
+		StatementHash: statementHash,
+		Detail:        detail,
+	}
+}
+
+var roleLastUsedDateRegex = regexp.MustCompile(`LastUsedDate['"]?\s*:\s*['"]?([0-9T:\-+. Z]+)`)
+
+// ParseAWSDate parses the timestamp formats found in authorization details
+// files
+func ParseAWSDate(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05Z07:00", "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ParseRoleLastUsed returns when a role was last used from its rolelastused
+// property. The property holds the RoleLastUsed object of the
+// authorization details file, which is empty if the role was never used.
+func ParseRoleLastUsed(roleLastUsed string) (time.Time, bool) {
+	match := roleLastUsedDateRegex.FindStringSubmatch(roleLastUsed)
+	if match == nil {
+		return time.Time{}, false
+	}
+	return ParseAWSDate(match[1])
+}
+
+// IsRoleUnused returns true if a role older than the threshold hasn't
+// been used within it
+func IsRoleUnused(createDate string, roleLastUsed string, now time.Time, threshold time.Duration) bool {
+	created, ok := ParseAWSDate(createDate)
+	if ok && now.Sub(created) < threshold {
+		return false
+	}
+
+	lastUsed, ok := ParseRoleLastUsed(roleLastUsed)
+	if !ok {
+		return true
+	}
+	return now.Sub(lastUsed) >= threshold
+}
+
+// SeverityRank orders severities, with zero being the most severe
+func SeverityRank(severity string) int {
+	for i, s := range Severities {
+		if s == severity {
+			return i
+		}
+	}
+	return len(Severities)
+}
+
+// Sort findings by severity, then check and affected node
+func SortFindings(findings []Finding) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		if SeverityRank(findings[i].Severity) != SeverityRank(findings[j].Severity) {
+			return SeverityRank(findings[i].Severity) < SeverityRank(findings[j].Severity)
+		}
+		if findings[i].CheckID != findings[j].CheckID {
+			return findings[i].CheckID < findings[j].CheckID
+		}
+		return findings[i].NodeArn < findings[j].NodeArn
+	})
+}
+
+// FindingFilter selects stored findings. Empty fields match everything.
+type FindingFilter struct {
+	AccountID string
+	Severity  string
+	CheckID   string
+}
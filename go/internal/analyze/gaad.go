@@ -0,0 +1,114 @@
+package analyze
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// AuthorizationDetails is the output of aws iam
+// get-account-authorization-details, which is what the ingest reads. The
+// lists are never omitted, as the ingest tells principals apart by the
+// lists they have.
+type AuthorizationDetails struct {
+	UserDetailList  []UserDetail          `json:"UserDetailList"`
+	GroupDetailList []GroupDetail         `json:"GroupDetailList"`
+	RoleDetailList  []RoleDetail          `json:"RoleDetailList"`
+	Policies        []ManagedPolicyDetail `json:"Policies"`
+}
+
+type InlinePolicyDetail struct {
+	PolicyName     string         `json:"PolicyName"`
+	PolicyDocument map[string]any `json:"PolicyDocument"`
+}
+
+type AttachedPolicy struct {
+	PolicyName string `json:"PolicyName"`
+	PolicyArn  string `json:"PolicyArn"`
+}
+
+type PermissionsBoundaryDetail struct {
+	PermissionsBoundaryType string `json:"PermissionsBoundaryType"`
+	PermissionsBoundaryArn  string `json:"PermissionsBoundaryArn"`
+}
+
+type TagDetail struct {
+	Key   string `json:"Key"`
+	Value string `json:"Value"`
+}
+
+type UserDetail struct {
+	Path                    string                     `json:"Path"`
+	UserName                string                     `json:"UserName"`
+	UserId                  string                     `json:"UserId"`
+	Arn                     string                     `json:"Arn"`
+	CreateDate              time.Time                  `json:"CreateDate"`
+	UserPolicyList          []InlinePolicyDetail       `json:"UserPolicyList"`
+	GroupList               []string                   `json:"GroupList"`
+	AttachedManagedPolicies []AttachedPolicy           `json:"AttachedManagedPolicies"`
+	PermissionsBoundary     *PermissionsBoundaryDetail `json:"PermissionsBoundary,omitempty"`
+	Tags                    []TagDetail                `json:"Tags"`
+}
+
+type GroupDetail struct {
+	Path                    string               `json:"Path"`
+	GroupName               string               `json:"GroupName"`
+	GroupId                 string               `json:"GroupId"`
+	Arn                     string               `json:"Arn"`
+	CreateDate              time.Time            `json:"CreateDate"`
+	GroupPolicyList         []InlinePolicyDetail `json:"GroupPolicyList"`
+	AttachedManagedPolicies []AttachedPolicy     `json:"AttachedManagedPolicies"`
+}
+
+type RoleDetail struct {
+	Path                     string                     `json:"Path"`
+	RoleName                 string                     `json:"RoleName"`
+	RoleId                   string                     `json:"RoleId"`
+	Arn                      string                     `json:"Arn"`
+	CreateDate               time.Time                  `json:"CreateDate"`
+	AssumeRolePolicyDocument map[string]any             `json:"AssumeRolePolicyDocument"`
+	RolePolicyList           []InlinePolicyDetail       `json:"RolePolicyList"`
+	AttachedManagedPolicies  []AttachedPolicy           `json:"AttachedManagedPolicies"`
+	PermissionsBoundary      *PermissionsBoundaryDetail `json:"PermissionsBoundary,omitempty"`
+	Tags                     []TagDetail                `json:"Tags"`
+}
+
+type PolicyVersionDetail struct {
+	Document         map[string]any `json:"Document"`
+	VersionId        string         `json:"VersionId"`
+	IsDefaultVersion bool           `json:"IsDefaultVersion"`
+	CreateDate       time.Time      `json:"CreateDate"`
+}
+
+type ManagedPolicyDetail struct {
+	PolicyName                    string                `json:"PolicyName"`
+	PolicyId                      string                `json:"PolicyId"`
+	Arn                           string                `json:"Arn"`
+	Path                          string                `json:"Path"`
+	DefaultVersionId              string                `json:"DefaultVersionId"`
+	AttachmentCount               int                   `json:"AttachmentCount"`
+	PermissionsBoundaryUsageCount int                   `json:"PermissionsBoundaryUsageCount"`
+	IsAttachable                  bool                  `json:"IsAttachable"`
+	CreateDate                    time.Time             `json:"CreateDate"`
+	UpdateDate                    time.Time             `json:"UpdateDate"`
+	PolicyVersionList             []PolicyVersionDetail `json:"PolicyVersionList"`
+}
+
+// The document of the default version of the policy, or nil if there
+// isn't one
+func (p ManagedPolicyDetail) DefaultDocument() map[string]any {
+	for _, version := range p.PolicyVersionList {
+		if version.IsDefaultVersion {
+			return version.Document
+		}
+	}
+	return nil
+}
+
+func ParseAuthorizationDetails(data []byte) (*AuthorizationDetails, error) {
+	details := &AuthorizationDetails{}
+	if err := json.Unmarshal(data, details); err != nil {
+		return nil, fmt.Errorf("failed parsing authorization details: %w", err)
+	}
+	return details, nil
+}
@@ -15,6 +15,7 @@ require (
 	github.com/specterops/bloodhound/mediatypes v0.0.0-20240109195535-c358cb6e4aa5
 	github.com/specterops/bloodhound/slices v0.0.0-20240109195535-c358cb6e4aa5
 	github.com/specterops/bloodhound/src v0.0.0-20240109195535-c358cb6e4aa5
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require github.com/go-pkgz/expirable-cache v1.0.0 // indirect
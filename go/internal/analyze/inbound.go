@@ -0,0 +1,77 @@
+package analyze
+
+import (
+	"sort"
+
+	"github.com/specterops/bloodhound/dawgs/graph"
+)
+
+type InboundExposure struct {
+	PrincipalID  graph.ID `json:"principal_id"`
+	PrincipalArn string   `json:"principal_arn"`
+	HolderArn    string   `json:"holder_arn"`
+	Hops         int      `json:"hops"`
+	Chain        []string `json:"chain"`
+	Actions      []string `json:"actions"`
+}
+
+// GetInboundExposures combines the principals with direct access to a
+// resource with the identity transform paths that lead to them. There is
+// an exposure for each principal and each holder of direct access it can
+// reach, with the shortest chain between the two and the actions the
+// holder is allowed.
+func GetInboundExposures(directPaths ActionPathSet, identityPaths graph.PathSet) []InboundExposure {
+	actionMap := ActionPathSetToMap(directPaths)
+	exposures := map[string]*InboundExposure{}
+
+	addExposure := func(principalID graph.ID, principalArn string, chain []string) {
+		holderArn := chain[len(chain)-1]
+		actions, ok := actionMap[holderArn]
+		if !ok {
+			return
+		}
+		key := principalArn + "|" + holderArn
+		if existing, ok := exposures[key]; ok && existing.Hops <= len(chain)-1 {
+			return
+		}
+		exposures[key] = &InboundExposure{
+			PrincipalID:  principalID,
+			PrincipalArn: principalArn,
+			HolderArn:    holderArn,
+			Hops:         len(chain) - 1,
+			Chain:        chain,
+			Actions:      actions,
+		}
+	}
+
+	for _, entry := range directPaths {
+		addExposure(entry.PrincipalID, entry.PrincipalArn, []string{entry.PrincipalArn})
+	}
+
+	for _, path := range identityPaths {
+		root := path.Root()
+		if root == nil {
+			continue
+		}
+		rootArn, _ := root.Properties.Get("arn").String()
+		addExposure(root.ID, rootArn, GetChainFromPath(path))
+	}
+
+	inboundExposures := []InboundExposure{}
+	for _, exposure := range exposures {
+		sort.Strings(exposure.Actions)
+		inboundExposures = append(inboundExposures, *exposure)
+	}
+	sort.Slice(inboundExposures, func(i, j int) bool {
+		a, b := inboundExposures[i], inboundExposures[j]
+		if a.Hops != b.Hops {
+			return a.Hops < b.Hops
+		}
+		if a.PrincipalArn != b.PrincipalArn {
+			return a.PrincipalArn < b.PrincipalArn
+		}
+		return a.HolderArn < b.HolderArn
+	})
+
+	return inboundExposures
+}
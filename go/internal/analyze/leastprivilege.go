@@ -0,0 +1,508 @@
+package analyze
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Usage is an action a principal was seen using and the resource it used
+// it on. Resource is "*" when the source doesn't record one, and Action
+// is "service:*" when only the service is known.
+type Usage struct {
+	Action   string `json:"action"`
+	Resource string `json:"resource"`
+}
+
+type cloudTrailLog struct {
+	Records []cloudTrailRecord `json:"Records"`
+}
+
+type cloudTrailRecord struct {
+	EventTime       string `json:"eventTime"`
+	EventSource     string `json:"eventSource"`
+	EventName       string `json:"eventName"`
+	ErrorCode       string `json:"errorCode"`
+	SourceIPAddress string `json:"sourceIPAddress"`
+	UserIdentity    struct {
+		Arn            string `json:"arn"`
+		SessionContext struct {
+			SessionIssuer struct {
+				Arn string `json:"arn"`
+			} `json:"sessionIssuer"`
+		} `json:"sessionContext"`
+	} `json:"userIdentity"`
+	Resources []struct {
+		ARN string `json:"ARN"`
+	} `json:"resources"`
+}
+
+// The output of aws iam get-service-last-accessed-details, generated with
+// action level granularity where the service supports it
+type accessAdvisorReport struct {
+	ServicesLastAccessed []struct {
+		ServiceNamespace           string  `json:"ServiceNamespace"`
+		LastAuthenticated          *string `json:"LastAuthenticated"`
+		TrackedActionsLastAccessed []struct {
+			ActionName       string  `json:"ActionName"`
+			LastAccessedTime *string `json:"LastAccessedTime"`
+		} `json:"TrackedActionsLastAccessed"`
+	} `json:"ServicesLastAccessed"`
+}
+
+// CloudTrail delivers its log files gzipped
+func decompressUsage(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		return data, nil
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// Event sources whose endpoint isn't named after the IAM prefix of the
+// service
+var cloudTrailServicePrefixes = map[string]string{
+	"monitoring.amazonaws.com":        "cloudwatch",
+	"email.amazonaws.com":             "ses",
+	"models.lex.amazonaws.com":        "lex",
+	"runtime.lex.amazonaws.com":       "lex",
+	"runtime.sagemaker.amazonaws.com": "sagemaker",
+	"api.ecr.amazonaws.com":           "ecr",
+	"api.mediatailor.amazonaws.com":   "mediatailor",
+	"data.iot.amazonaws.com":          "iot",
+	"tagging.amazonaws.com":           "tag",
+}
+
+// Events that are authorized by an action with a different name. Keys
+// are the lowercased IAM prefix and event name, after version suffixes
+// are removed.
+var cloudTrailEventActions = map[string]string{
+	"lambda:invoke":              "lambda:invokefunction",
+	"s3:listobjects":             "s3:listbucket",
+	"s3:listobjectsv2":           "s3:listbucket",
+	"s3:listobjectversions":      "s3:listbucketversions",
+	"s3:listbuckets":             "s3:listallmybuckets",
+	"s3:listmultipartuploads":    "s3:listbucketmultipartuploads",
+	"s3:listparts":               "s3:listmultipartuploadparts",
+	"s3:headbucket":              "s3:listbucket",
+	"s3:headobject":              "s3:getobject",
+	"s3:copyobject":              "s3:putobject",
+	"s3:createmultipartupload":   "s3:putobject",
+	"s3:uploadpart":              "s3:putobject",
+	"s3:uploadpartcopy":          "s3:putobject",
+	"s3:completemultipartupload": "s3:putobject",
+	"s3:deleteobjects":           "s3:deleteobject",
+	"dynamodb:transactgetitems":  "dynamodb:getitem",
+}
+
+// Some services put the API version at the end of the event name, such
+// as ListFunctions20150331 and GetFunction20150331v2 for Lambda, or
+// GetDistribution2020_05_31 for CloudFront
+var cloudTrailVersionSuffix = regexp.MustCompile(`(\d{8}(v\d+)?|\d{4}_\d{2}_\d{2})$`)
+
+// The lowercased IAM action that authorizes a CloudTrail event. The event
+// source is the service endpoint, such as s3.amazonaws.com.
+func getCloudTrailAction(record cloudTrailRecord) string {
+	prefix, ok := cloudTrailServicePrefixes[record.EventSource]
+	if !ok {
+		prefix = strings.TrimSuffix(record.EventSource, ".amazonaws.com")
+	}
+	name := cloudTrailVersionSuffix.ReplaceAllString(record.EventName, "")
+
+	action := strings.ToLower(prefix + ":" + name)
+	if mapped, ok := cloudTrailEventActions[action]; ok {
+		return mapped
+	}
+	return action
+}
+
+func isAccessDenied(errorCode string) bool {
+	return strings.Contains(errorCode, "AccessDenied") || strings.Contains(errorCode, "UnauthorizedOperation")
+}
+
+// Get the actions principalArn used in a CloudTrail log. Events of a role
+// session count towards the role. Calls that were denied aren't usage.
+func ParseCloudTrailUsage(data []byte, principalArn string) ([]Usage, error) {
+	var trail cloudTrailLog
+	if err := json.Unmarshal(data, &trail); err != nil {
+		return nil, fmt.Errorf("failed parsing CloudTrail log: %w", err)
+	}
+
+	usage := []Usage{}
+	for _, record := range trail.Records {
+		identity := record.UserIdentity
+		if identity.Arn != principalArn && identity.SessionContext.SessionIssuer.Arn != principalArn {
+			continue
+		}
+		if record.EventSource == "" || record.EventName == "" || isAccessDenied(record.ErrorCode) {
+			continue
+		}
+
+		action := getCloudTrailAction(record)
+		if len(record.Resources) == 0 {
+			usage = append(usage, Usage{Action: action, Resource: "*"})
+		}
+		for _, resource := range record.Resources {
+			if resource.ARN == "" {
+				continue
+			}
+			usage = append(usage, Usage{Action: action, Resource: resource.ARN})
+		}
+	}
+
+	return uniqueUsage(usage), nil
+}
+
+// Get the actions used according to an Access Advisor report. Services
+// without action level detail only record that the service was used.
+func ParseAccessAdvisorUsage(data []byte) ([]Usage, error) {
+	var report accessAdvisorReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed parsing Access Advisor report: %w", err)
+	}
+
+	usage := []Usage{}
+	for _, service := range report.ServicesLastAccessed {
+		if service.LastAuthenticated == nil {
+			continue
+		}
+
+		tracked := false
+		for _, action := range service.TrackedActionsLastAccessed {
+			if action.LastAccessedTime == nil {
+				continue
+			}
+			tracked = true
+			usage = append(usage, Usage{
+				Action:   strings.ToLower(service.ServiceNamespace + ":" + action.ActionName),
+				Resource: "*",
+			})
+		}
+		if !tracked {
+			usage = append(usage, Usage{
+				Action:   strings.ToLower(service.ServiceNamespace + ":*"),
+				Resource: "*",
+			})
+		}
+	}
+
+	return uniqueUsage(usage), nil
+}
+
+// ParseUsage reads either a CloudTrail log, optionally gzipped, or an
+// Access Advisor report
+func ParseUsage(data []byte, principalArn string) ([]Usage, error) {
+	data, err := decompressUsage(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed decompressing usage: %w", err)
+	}
+
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("usage must be a CloudTrail log or Access Advisor report: %w", err)
+	}
+
+	if _, ok := keys["Records"]; ok {
+		return ParseCloudTrailUsage(data, principalArn)
+	} else if _, ok := keys["ServicesLastAccessed"]; ok {
+		return ParseAccessAdvisorUsage(data)
+	}
+	return nil, fmt.Errorf("usage must be a CloudTrail log or Access Advisor report")
+}
+
+func uniqueUsage(usage []Usage) []Usage {
+	seen := map[Usage]bool{}
+	unique := []Usage{}
+	for _, u := range usage {
+		if seen[u] {
+			continue
+		}
+		seen[u] = true
+		unique = append(unique, u)
+	}
+	sort.Slice(unique, func(i, j int) bool {
+		if unique[i].Action != unique[j].Action {
+			return unique[i].Action < unique[j].Action
+		}
+		return unique[i].Resource < unique[j].Resource
+	})
+	return unique
+}
+
+// LeastPrivilegeDiff compares the generated policy against the current
+// effective permissions. Kept and Removed map resources to actions, like
+// the RSOP. Ungranted is usage that the identity policies don't explain,
+// such as access granted by a resource policy.
+type LeastPrivilegeDiff struct {
+	Kept      PrincipalToActionMap `json:"kept"`
+	Removed   PrincipalToActionMap `json:"removed"`
+	Ungranted []Usage              `json:"ungranted"`
+}
+
+type LeastPrivilegePolicy struct {
+	PrincipalArn string             `json:"principal_arn"`
+	Policy       map[string]any     `json:"policy"`
+	Diff         LeastPrivilegeDiff `json:"diff"`
+}
+
+type policyStatement struct {
+	actions       []string
+	resources     []string
+	actionRegexes []*regexp.Regexp
+}
+
+// Collapse the actions of a service into wildcards that match exactly
+// the given actions in the catalog of known actions. A wildcard is only
+// used when it covers at least two actions, and the whole service is only
+// granted when every one of its actions is used.
+func collapseActions(service string, actions []string, catalog []string) []string {
+	used := map[string]bool{}
+	for _, action := range actions {
+		used[action] = true
+	}
+
+	serviceActions := []string{}
+	for _, action := range catalog {
+		if GetServiceFromAction(action) == service {
+			serviceActions = append(serviceActions, action)
+		}
+	}
+	if len(serviceActions) == 0 {
+		return actions
+	}
+
+	allUsed := true
+	for _, action := range serviceActions {
+		if !used[action] {
+			allUsed = false
+			break
+		}
+	}
+	if allUsed && len(serviceActions) > 1 {
+		return []string{service + ":*"}
+	}
+
+	collapsed := []string{}
+	covered := map[string]bool{}
+	for _, action := range actions {
+		if covered[action] {
+			continue
+		}
+		name := strings.TrimPrefix(action, service+":")
+
+		pattern := action
+		for length := 1; length < len(name); length++ {
+			prefix := service + ":" + name[:length]
+			matches := []string{}
+			safe := true
+			for _, candidate := range serviceActions {
+				if !strings.HasPrefix(candidate, prefix) {
+					continue
+				}
+				if !used[candidate] {
+					safe = false
+					break
+				}
+				matches = append(matches, candidate)
+			}
+			if safe && len(matches) > 1 {
+				pattern = prefix + "*"
+				for _, match := range matches {
+					covered[match] = true
+				}
+				break
+			}
+		}
+
+		covered[action] = true
+		collapsed = append(collapsed, pattern)
+	}
+
+	sort.Strings(collapsed)
+	return collapsed
+}
+
+// Group actions that were used on the same resources into one statement
+func buildStatements(actionResources map[string]map[string]bool, catalog []string) []policyStatement {
+	groups := map[string]*policyStatement{}
+	for action, resourceSet := range actionResources {
+		resources := []string{}
+		for resource := range resourceSet {
+			resources = append(resources, resource)
+		}
+		sort.Strings(resources)
+		// A wildcard resource makes the others redundant
+		if resourceSet["*"] {
+			resources = []string{"*"}
+		}
+
+		key := strings.Join(resources, "\n")
+		group, ok := groups[key]
+		if !ok {
+			group = &policyStatement{resources: resources}
+			groups[key] = group
+		}
+		group.actions = append(group.actions, action)
+	}
+
+	statements := []policyStatement{}
+	for _, group := range groups {
+		serviceActions := map[string][]string{}
+		for _, action := range group.actions {
+			service := GetServiceFromAction(action)
+			serviceActions[service] = append(serviceActions[service], action)
+		}
+
+		actions := []string{}
+		for service, serviceGroup := range serviceActions {
+			sort.Strings(serviceGroup)
+			actions = append(actions, collapseActions(service, serviceGroup, catalog)...)
+		}
+		sort.Strings(actions)
+		group.actions = actions
+		for _, action := range actions {
+			group.actionRegexes = append(group.actionRegexes, regexp.MustCompile(ActionWildcardToRegex(action)))
+		}
+		statements = append(statements, *group)
+	}
+
+	sort.Slice(statements, func(i, j int) bool {
+		return strings.Join(statements[i].actions, ",") < strings.Join(statements[j].actions, ",")
+	})
+	return statements
+}
+
+func (s policyStatement) allows(action string, resource string) bool {
+	resourceMatches := false
+	for _, r := range s.resources {
+		if r == "*" || r == resource {
+			resourceMatches = true
+			break
+		}
+	}
+	if !resourceMatches {
+		return false
+	}
+
+	for _, actionRegex := range s.actionRegexes {
+		if actionRegex.MatchString(action) {
+			return true
+		}
+	}
+	return false
+}
+
+// The resources of usage that granted covers. Usage without a resource
+// covers everything granted, so it's narrowed to the granted resources
+// rather than widened to *. Granted resources can be wildcards.
+func getUsedResources(resource string, granted map[string]bool) []string {
+	resources := []string{}
+	for grantedResource := range granted {
+		if resource == "*" {
+			resources = append(resources, grantedResource)
+		} else if regexp.MustCompile(WildcardToRegex(grantedResource)).MatchString(resource) {
+			resources = append(resources, resource)
+			break
+		}
+	}
+	sort.Strings(resources)
+	return resources
+}
+
+// GenerateLeastPrivilegePolicy builds the smallest policy that still
+// allows what the principal used, out of what its effective permissions
+// allow. Usage of actions or resources that aren't currently granted is
+// left out of the policy and reported. catalog lists every known action
+// name and bounds which wildcards are safe.
+func GenerateLeastPrivilegePolicy(principalArn string, effective ActionPathSet, usage []Usage, catalog []string) LeastPrivilegePolicy {
+	grantedResources := map[string]map[string]bool{}
+	for _, entry := range effective {
+		if entry.Effect != "" && entry.Effect != "Allow" {
+			continue
+		}
+		action := strings.ToLower(entry.Action)
+		if _, ok := grantedResources[action]; !ok {
+			grantedResources[action] = map[string]bool{}
+		}
+		grantedResources[action][entry.ResourceArn] = true
+	}
+
+	actionResources := map[string]map[string]bool{}
+	ungranted := []Usage{}
+	for _, u := range usage {
+		actionRegex := regexp.MustCompile(ActionWildcardToRegex(u.Action))
+		matched := false
+		for action, granted := range grantedResources {
+			if !actionRegex.MatchString(action) {
+				continue
+			}
+			resources := getUsedResources(u.Resource, granted)
+			if len(resources) == 0 {
+				continue
+			}
+			matched = true
+			if _, ok := actionResources[action]; !ok {
+				actionResources[action] = map[string]bool{}
+			}
+			for _, resource := range resources {
+				actionResources[action][resource] = true
+			}
+		}
+		if !matched {
+			ungranted = append(ungranted, u)
+		}
+	}
+
+	statements := buildStatements(actionResources, catalog)
+	policyStatements := []map[string]any{}
+	for _, statement := range statements {
+		policyStatements = append(policyStatements, map[string]any{
+			"Effect":   "Allow",
+			"Action":   statement.actions,
+			"Resource": statement.resources,
+		})
+	}
+
+	diff := LeastPrivilegeDiff{
+		Kept:      PrincipalToActionMap{},
+		Removed:   PrincipalToActionMap{},
+		Ungranted: ungranted,
+	}
+	for _, entry := range effective {
+		if entry.Effect != "" && entry.Effect != "Allow" {
+			continue
+		}
+		action := strings.ToLower(entry.Action)
+
+		kept := false
+		for _, statement := range statements {
+			if statement.allows(action, entry.ResourceArn) {
+				kept = true
+				break
+			}
+		}
+		if kept {
+			diff.Kept[entry.ResourceArn] = addUniqueItem(diff.Kept[entry.ResourceArn], action)
+		} else {
+			diff.Removed[entry.ResourceArn] = addUniqueItem(diff.Removed[entry.ResourceArn], action)
+		}
+	}
+
+	return LeastPrivilegePolicy{
+		PrincipalArn: principalArn,
+		Policy: map[string]any{
+			"Version":   "2012-10-17",
+			"Statement": policyStatements,
+		},
+		Diff: diff,
+	}
+}
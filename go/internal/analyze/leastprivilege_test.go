@@ -0,0 +1,139 @@
+package analyze
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGenerateLeastPrivilegePolicyIntersectsResources(t *testing.T) {
+	effective := ActionPathSet{}
+	effective.Add(ActionPathEntry{
+		PrincipalArn: "arn:aws:iam::123456789012:role/reader",
+		ResourceArn:  "arn:aws:s3:::bucket-a/*",
+		Action:       "s3:getobject",
+		Effect:       "Allow",
+	})
+	usage := []Usage{
+		{Action: "s3:getobject", Resource: "*"},
+		{Action: "s3:getobject", Resource: "arn:aws:s3:::bucket-secret/x"},
+	}
+
+	policy := GenerateLeastPrivilegePolicy("arn:aws:iam::123456789012:role/reader", effective, usage, []string{"s3:getobject", "s3:putobject"})
+
+	statements := policy.Policy["Statement"].([]map[string]any)
+	if len(statements) != 1 {
+		t.Fatalf("got %d statements, want 1", len(statements))
+	}
+	if resources := statements[0]["Resource"]; !reflect.DeepEqual(resources, []string{"arn:aws:s3:::bucket-a/*"}) {
+		t.Errorf("got resources %v, want only the granted bucket", resources)
+	}
+	if actions := statements[0]["Action"]; !reflect.DeepEqual(actions, []string{"s3:getobject"}) {
+		t.Errorf("got actions %v", actions)
+	}
+
+	if ungranted := policy.Diff.Ungranted; !reflect.DeepEqual(ungranted, []Usage{usage[1]}) {
+		t.Errorf("got ungranted %v, want the secret bucket", ungranted)
+	}
+	if kept := policy.Diff.Kept["arn:aws:s3:::bucket-a/*"]; !reflect.DeepEqual(kept, []string{"s3:getobject"}) {
+		t.Errorf("got kept %v", kept)
+	}
+}
+
+func TestGenerateLeastPrivilegePolicyKeepsUsedResources(t *testing.T) {
+	effective := ActionPathSet{}
+	for _, resource := range []string{"arn:aws:s3:::bucket-a/*", "arn:aws:s3:::bucket-b/*"} {
+		effective.Add(ActionPathEntry{
+			ResourceArn: resource,
+			Action:      "s3:getobject",
+			Effect:      "Allow",
+		})
+	}
+	usage := []Usage{{Action: "s3:getobject", Resource: "arn:aws:s3:::bucket-b/report.csv"}}
+
+	policy := GenerateLeastPrivilegePolicy("arn:aws:iam::123456789012:role/reader", effective, usage, []string{"s3:getobject"})
+
+	statements := policy.Policy["Statement"].([]map[string]any)
+	if len(statements) != 1 || !reflect.DeepEqual(statements[0]["Resource"], []string{"arn:aws:s3:::bucket-b/report.csv"}) {
+		t.Errorf("got statements %v, want only the used object", statements)
+	}
+	if len(policy.Diff.Ungranted) != 0 {
+		t.Errorf("got ungranted %v", policy.Diff.Ungranted)
+	}
+}
+
+func TestGetCloudTrailAction(t *testing.T) {
+	tests := []struct {
+		eventSource string
+		eventName   string
+		want        string
+	}{
+		{"s3.amazonaws.com", "GetObject", "s3:getobject"},
+		{"lambda.amazonaws.com", "ListFunctions20150331", "lambda:listfunctions"},
+		{"lambda.amazonaws.com", "GetFunction20150331v2", "lambda:getfunction"},
+		{"lambda.amazonaws.com", "Invoke", "lambda:invokefunction"},
+		{"monitoring.amazonaws.com", "PutMetricData", "cloudwatch:putmetricdata"},
+		{"cloudfront.amazonaws.com", "GetDistribution2020_05_31", "cloudfront:getdistribution"},
+		{"s3.amazonaws.com", "ListObjectsV2", "s3:listbucket"},
+		{"tagging.amazonaws.com", "GetResources", "tag:getresources"},
+	}
+
+	for _, test := range tests {
+		record := cloudTrailRecord{EventSource: test.eventSource, EventName: test.eventName}
+		if got := getCloudTrailAction(record); got != test.want {
+			t.Errorf("%s %s: got %s, want %s", test.eventSource, test.eventName, got, test.want)
+		}
+	}
+}
+
+func TestParseUsageCloudTrail(t *testing.T) {
+	trail := []byte(`{"Records": [
+		{"eventSource": "lambda.amazonaws.com", "eventName": "GetFunction20150331v2",
+		 "userIdentity": {"arn": "arn:aws:sts::123456789012:assumed-role/deployer/session",
+		  "sessionContext": {"sessionIssuer": {"arn": "arn:aws:iam::123456789012:role/deployer"}}},
+		 "resources": [{"ARN": "arn:aws:lambda:us-east-1:123456789012:function:app"}]},
+		{"eventSource": "monitoring.amazonaws.com", "eventName": "PutMetricData",
+		 "userIdentity": {"arn": "arn:aws:iam::123456789012:role/deployer"}},
+		{"eventSource": "lambda.amazonaws.com", "eventName": "Invoke", "errorCode": "AccessDenied",
+		 "userIdentity": {"arn": "arn:aws:iam::123456789012:role/deployer"}},
+		{"eventSource": "s3.amazonaws.com", "eventName": "GetObject",
+		 "userIdentity": {"arn": "arn:aws:iam::123456789012:role/other"}}
+	]}`)
+
+	usage, err := ParseUsage(trail, "arn:aws:iam::123456789012:role/deployer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Usage{
+		{Action: "cloudwatch:putmetricdata", Resource: "*"},
+		{Action: "lambda:getfunction", Resource: "arn:aws:lambda:us-east-1:123456789012:function:app"},
+	}
+	if !reflect.DeepEqual(usage, want) {
+		t.Errorf("got %v, want %v", usage, want)
+	}
+}
+
+func TestParseUsageAccessAdvisor(t *testing.T) {
+	report := []byte(`{"ServicesLastAccessed": [
+		{"ServiceNamespace": "s3", "LastAuthenticated": "2024-01-01T00:00:00Z",
+		 "TrackedActionsLastAccessed": [
+			{"ActionName": "GetObject", "LastAccessedTime": "2024-01-01T00:00:00Z"},
+			{"ActionName": "PutObject"}
+		 ]},
+		{"ServiceNamespace": "sqs", "LastAuthenticated": "2024-01-01T00:00:00Z"},
+		{"ServiceNamespace": "ec2"}
+	]}`)
+
+	usage, err := ParseUsage(report, "arn:aws:iam::123456789012:role/deployer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Usage{
+		{Action: "s3:getobject", Resource: "*"},
+		{Action: "sqs:*", Resource: "*"},
+	}
+	if !reflect.DeepEqual(usage, want) {
+		t.Errorf("got %v, want %v", usage, want)
+	}
+}
@@ -0,0 +1,260 @@
+package analyze
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hotnops/apeman/graphschema/aws"
+	"github.com/specterops/bloodhound/dawgs/graph"
+)
+
+// Edge property set on identity transforms that depend on conditions the
+// analysis couldn't resolve
+const ConditionalProperty = "conditional"
+
+type PathCosts struct {
+	Transforms  map[string]float64
+	Default     float64
+	Conditional float64
+}
+
+// PathCostOverrides replaces some of the path costs. Nil values keep the
+// cost they override.
+type PathCostOverrides struct {
+	Transforms  map[string]float64
+	Default     *float64
+	Conditional *float64
+}
+
+// DefaultPathCosts favours assuming a role, which only needs a call to STS,
+// over changes to the account that are likely to be noticed
+func DefaultPathCosts() PathCosts {
+	return PathCosts{
+		Transforms: map[string]float64{
+			string(aws.IdentityTransformAssumeRole):             1,
+			string(aws.IdentityTransformCreateAccessKey):        3,
+			string(aws.IdentityTransformUpdateAssumeRolePolicy): 5,
+		},
+		Default:     5,
+		Conditional: 2,
+	}
+}
+
+// Merge returns a copy of the costs with the overrides applied. Negative
+// costs are rejected, since the cheapest path search relies on a longer
+// path never costing less.
+func (p PathCosts) Merge(overrides PathCostOverrides) (PathCosts, error) {
+	merged := PathCosts{
+		Transforms:  map[string]float64{},
+		Default:     p.Default,
+		Conditional: p.Conditional,
+	}
+	for name, cost := range p.Transforms {
+		merged.Transforms[name] = cost
+	}
+	for name, cost := range overrides.Transforms {
+		if cost < 0 {
+			return PathCosts{}, fmt.Errorf("cost of %s is negative", name)
+		}
+		merged.Transforms[strings.ToLower(name)] = cost
+	}
+	if overrides.Default != nil {
+		if *overrides.Default < 0 {
+			return PathCosts{}, fmt.Errorf("default cost is negative")
+		}
+		merged.Default = *overrides.Default
+	}
+	if overrides.Conditional != nil {
+		if *overrides.Conditional < 0 {
+			return PathCosts{}, fmt.Errorf("conditional cost is negative")
+		}
+		merged.Conditional = *overrides.Conditional
+	}
+	return merged, nil
+}
+
+func (p PathCosts) EdgeCost(edge *graph.Relationship) float64 {
+	name, _ := edge.Properties.Get("name").String()
+	cost, ok := p.Transforms[strings.ToLower(name)]
+	if !ok {
+		cost = p.Default
+	}
+	if conditional, _ := edge.Properties.Get(ConditionalProperty).Bool(); conditional {
+		cost += p.Conditional
+	}
+	return cost
+}
+
+func (p PathCosts) PathCost(path graph.Path) float64 {
+	cost := 0.0
+	for _, edge := range path.Edges {
+		cost += p.EdgeCost(edge)
+	}
+	return cost
+}
+
+type WeightedPath struct {
+	Cost float64    `json:"cost"`
+	Path graph.Path `json:"path"`
+}
+
+// A path found while searching for the cheapest paths, as the edges from
+// the source
+type searchPath struct {
+	edges []*graph.Relationship
+	cost  float64
+}
+
+func (s searchPath) cheaperThan(other searchPath) bool {
+	if s.cost != other.cost {
+		return s.cost < other.cost
+	}
+	return len(s.edges) < len(other.edges)
+}
+
+// The nodes along a path, starting with the source
+func (s searchPath) nodeIDs(source graph.ID) []graph.ID {
+	ids := []graph.ID{source}
+	for _, edge := range s.edges {
+		ids = append(ids, edge.EndID)
+	}
+	return ids
+}
+
+func (s searchPath) hasPrefix(edges []*graph.Relationship) bool {
+	if len(s.edges) < len(edges) {
+		return false
+	}
+	for i, edge := range edges {
+		if s.edges[i].ID != edge.ID {
+			return false
+		}
+	}
+	return true
+}
+
+// Dijkstra's algorithm from source to dest, leaving out the removed edges
+// and nodes
+func (p PathCosts) cheapestPath(outbound map[graph.ID][]*graph.Relationship, source, dest graph.ID, removedEdges, removedNodes map[graph.ID]bool) (searchPath, bool) {
+	best := map[graph.ID]searchPath{source: {}}
+	done := map[graph.ID]bool{}
+	for {
+		current, found := graph.ID(0), false
+		for id, path := range best {
+			if !done[id] && (!found || path.cheaperThan(best[current])) {
+				current, found = id, true
+			}
+		}
+		if !found {
+			return searchPath{}, false
+		}
+		if current == dest {
+			return best[current], true
+		}
+		done[current] = true
+
+		for _, edge := range outbound[current] {
+			if removedEdges[edge.ID] || removedNodes[edge.EndID] || done[edge.EndID] {
+				continue
+			}
+			next := searchPath{
+				edges: append(append([]*graph.Relationship{}, best[current].edges...), edge),
+				cost:  best[current].cost + p.EdgeCost(edge),
+			}
+			if existing, ok := best[edge.EndID]; !ok || next.cheaperThan(existing) {
+				best[edge.EndID] = next
+			}
+		}
+	}
+}
+
+// KCheapestPaths finds up to k of the cheapest loopless paths from source to
+// dest over the edges, with Yen's algorithm. The nodes are used to fill in
+// the paths.
+func (p PathCosts) KCheapestPaths(nodes map[graph.ID]*graph.Node, edges []*graph.Relationship, source, dest graph.ID, k int) []WeightedPath {
+	outbound := map[graph.ID][]*graph.Relationship{}
+	for _, edge := range edges {
+		outbound[edge.StartID] = append(outbound[edge.StartID], edge)
+	}
+
+	if source == dest {
+		return []WeightedPath{}
+	}
+	found := []searchPath{}
+	if path, ok := p.cheapestPath(outbound, source, dest, nil, nil); ok {
+		found = append(found, path)
+	}
+
+	candidates := []searchPath{}
+	for len(found) > 0 && len(found) < k {
+		previous := found[len(found)-1]
+		previousNodes := previous.nodeIDs(source)
+
+		// Branch off every node of the last path found, avoiding the
+		// edges that paths with the same start already took from there
+		for i := range previous.edges {
+			spurNode := previousNodes[i]
+			root := previous.edges[:i]
+
+			removedEdges := map[graph.ID]bool{}
+			for _, path := range found {
+				if path.hasPrefix(root) {
+					removedEdges[path.edges[i].ID] = true
+				}
+			}
+			removedNodes := map[graph.ID]bool{}
+			for _, id := range previousNodes[:i] {
+				removedNodes[id] = true
+			}
+
+			spur, ok := p.cheapestPath(outbound, spurNode, dest, removedEdges, removedNodes)
+			if !ok {
+				continue
+			}
+			candidate := searchPath{
+				edges: append(append([]*graph.Relationship{}, root...), spur.edges...),
+			}
+			for _, edge := range candidate.edges {
+				candidate.cost += p.EdgeCost(edge)
+			}
+
+			duplicate := false
+			for _, path := range append(found, candidates...) {
+				if len(path.edges) == len(candidate.edges) && path.hasPrefix(candidate.edges) {
+					duplicate = true
+					break
+				}
+			}
+			if !duplicate {
+				candidates = append(candidates, candidate)
+			}
+		}
+
+		if len(candidates) == 0 {
+			break
+		}
+		cheapest := 0
+		for i, candidate := range candidates {
+			if candidate.cheaperThan(candidates[cheapest]) {
+				cheapest = i
+			}
+		}
+		found = append(found, candidates[cheapest])
+		candidates = append(candidates[:cheapest], candidates[cheapest+1:]...)
+	}
+
+	weightedPaths := []WeightedPath{}
+	for _, path := range found {
+		weightedPath := WeightedPath{
+			Cost: path.cost,
+			Path: graph.Path{
+				Edges: path.edges,
+			},
+		}
+		for _, id := range path.nodeIDs(source) {
+			weightedPath.Path.Nodes = append(weightedPath.Path.Nodes, nodes[id])
+		}
+		weightedPaths = append(weightedPaths, weightedPath)
+	}
+	return weightedPaths
+}
@@ -0,0 +1,80 @@
+package analyze
+
+import (
+	"sort"
+
+	"github.com/specterops/bloodhound/dawgs/graph"
+)
+
+// The principal blob created for "Principal": "*" and {"AWS": "*"}
+const AnonymousPrincipal = "*"
+
+type PublicExposure struct {
+	ResourceArn   string   `json:"resource_arn"`
+	StatementHash string   `json:"statement_hash"`
+	Actions       []string `json:"actions"`
+	Conditional   bool     `json:"conditional"`
+}
+
+type PublicAccountExposure struct {
+	AccountID     string                      `json:"account_id"`
+	ResourceTypes map[string][]PublicExposure `json:"resource_types"`
+}
+
+// GroupPublicExposures takes the resolved anonymous action paths and groups
+// them by account, then resource type, then the statement granting access
+func GroupPublicExposures(resolvedPaths *ActionPathSet, resourceTypes map[graph.ID]string) []PublicAccountExposure {
+	accounts := map[string]*PublicAccountExposure{}
+	exposures := map[string]*PublicExposure{}
+	exposureTypes := map[string]string{}
+	exposureOrder := []string{}
+
+	for _, entry := range *resolvedPaths {
+		statementHash := ""
+		if entry.Statement != nil {
+			statementHash, _ = entry.Statement.Properties.Get("hash").String()
+		}
+
+		key := entry.ResourceArn + "|" + statementHash
+		exposure, ok := exposures[key]
+		if !ok {
+			exposure = &PublicExposure{
+				ResourceArn:   entry.ResourceArn,
+				StatementHash: statementHash,
+				Actions:       []string{},
+				Conditional:   len(entry.Conditions) > 0,
+			}
+			exposures[key] = exposure
+			exposureTypes[key] = resourceTypes[entry.ResourceID]
+			exposureOrder = append(exposureOrder, key)
+		}
+		exposure.Actions = addUniqueItem(exposure.Actions, entry.Action)
+
+		accountID := GetAccountIDFromArn(entry.ResourceArn)
+		if _, ok := accounts[accountID]; !ok {
+			accounts[accountID] = &PublicAccountExposure{
+				AccountID:     accountID,
+				ResourceTypes: map[string][]PublicExposure{},
+			}
+		}
+	}
+
+	for _, key := range exposureOrder {
+		exposure := exposures[key]
+		sort.Strings(exposure.Actions)
+
+		account := accounts[GetAccountIDFromArn(exposure.ResourceArn)]
+		resourceType := exposureTypes[key]
+		account.ResourceTypes[resourceType] = append(account.ResourceTypes[resourceType], *exposure)
+	}
+
+	publicAccounts := []PublicAccountExposure{}
+	for _, account := range accounts {
+		publicAccounts = append(publicAccounts, *account)
+	}
+	sort.Slice(publicAccounts, func(i, j int) bool {
+		return publicAccounts[i].AccountID < publicAccounts[j].AccountID
+	})
+
+	return publicAccounts
+}
@@ -0,0 +1,121 @@
+package analyze
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rule is a user defined finding check. The query is run as read only
+// Cypher and must return the affected node first. An optional second
+// column holding an AWSStatement is stored as the evidence for the finding.
+//
+//	id: s3-full-access
+//	title: Principal can do anything to S3
+//	severity: medium
+//	description: An allow statement grants s3:* on every bucket.
+//	query: |
+//	  MATCH (s:AWSStatement {effect: 'Allow'}) - [:Action] -> (:AWSActionBlob {name: 's3:*'})
+//	  MATCH (s) - [:AttachedTo*3..4] -> (a:AWSUser|AWSRole|AWSGroup)
+//	  RETURN DISTINCT a, s
+type Rule struct {
+	FindingCheck `yaml:",inline"`
+	Query        string `json:"query" yaml:"query"`
+}
+
+var ruleFileExtensions = []string{".yaml", ".yml", ".json"}
+
+func isRuleFile(name string) bool {
+	extension := strings.ToLower(filepath.Ext(name))
+	for _, ruleExtension := range ruleFileExtensions {
+		if extension == ruleExtension {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that a rule is complete and that its ID doesn't collide
+// with an existing check
+func (r *Rule) Validate(existing []FindingCheck) error {
+	if r.ID == "" {
+		return fmt.Errorf("rule is missing an id")
+	}
+	if r.Title == "" {
+		return fmt.Errorf("rule %s is missing a title", r.ID)
+	}
+	if strings.TrimSpace(r.Query) == "" {
+		return fmt.Errorf("rule %s is missing a query", r.ID)
+	}
+
+	r.Severity = strings.ToLower(r.Severity)
+	if !IsValidSeverity(r.Severity) {
+		return fmt.Errorf("rule %s has unknown severity %s", r.ID, r.Severity)
+	}
+
+	if _, ok := GetFindingCheck(existing, r.ID); ok {
+		return fmt.Errorf("rule %s duplicates an existing check", r.ID)
+	}
+
+	return nil
+}
+
+// LoadRules reads every rule file in dir. Each file holds a single rule,
+// written as YAML or JSON. No rules are loaded if dir is empty.
+func LoadRules(dir string) ([]Rule, error) {
+	rules := []Rule{}
+	if dir == "" {
+		return rules, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading rules directory %s: %w", dir, err)
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() || !isRuleFile(entry.Name()) {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+
+	checks := append([]FindingCheck{}, FindingChecks...)
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading rule file %s: %w", path, err)
+		}
+
+		// JSON is a subset of YAML, so one decoder handles both
+		var rule Rule
+		if err := yaml.Unmarshal(content, &rule); err != nil {
+			return nil, fmt.Errorf("failed parsing rule file %s: %w", path, err)
+		}
+		if err := rule.Validate(checks); err != nil {
+			return nil, fmt.Errorf("invalid rule file %s: %w", path, err)
+		}
+
+		checks = append(checks, rule.FindingCheck)
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// GetAllFindingChecks returns the built in checks followed by the checks
+// of the user defined rules
+func GetAllFindingChecks(rules []Rule) []FindingCheck {
+	checks := append([]FindingCheck{}, FindingChecks...)
+	for _, rule := range rules {
+		checks = append(checks, rule.FindingCheck)
+	}
+	return checks
+}
@@ -0,0 +1,276 @@
+package analyze
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const snapshotFileExtension = ".json"
+
+var snapshotNameRegex = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+type PolicyAttachment struct {
+	Policy    string `json:"policy"`
+	Principal string `json:"principal"`
+	// AttachedTo or PermissionsBoundary
+	Kind string `json:"kind"`
+}
+
+type TrustRelationship struct {
+	Role      string `json:"role"`
+	Principal string `json:"principal"`
+}
+
+type IdentityTransformEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Name   string `json:"name"`
+}
+
+type IdentityPathEndpoints struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// Snapshot is the part of the graph that is compared between ingests.
+// Principals and policies are identified by ARN, inline policies by name
+// and statements by hash.
+type Snapshot struct {
+	Name               string                  `json:"name"`
+	CreatedAt          time.Time               `json:"created_at"`
+	Principals         []string                `json:"principals"`
+	PolicyAttachments  []PolicyAttachment      `json:"policy_attachments"`
+	Statements         []string                `json:"statements"`
+	TrustRelationships []TrustRelationship     `json:"trust_relationships"`
+	IdentityTransforms []IdentityTransformEdge `json:"identity_transforms"`
+	TierZero           []string                `json:"tier_zero"`
+}
+
+type SnapshotInfo struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type PrincipalChanges struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+type PolicyAttachmentChanges struct {
+	Added   []PolicyAttachment `json:"added"`
+	Removed []PolicyAttachment `json:"removed"`
+}
+
+type StatementChanges struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+type TrustRelationshipChanges struct {
+	Added   []TrustRelationship `json:"added"`
+	Removed []TrustRelationship `json:"removed"`
+}
+
+type IdentityTransformChanges struct {
+	Added   []IdentityTransformEdge `json:"added"`
+	Removed []IdentityTransformEdge `json:"removed"`
+	// Source and target pairs joined by a chain of identity transforms in
+	// the newer snapshot but not the older one
+	NewPaths []IdentityPathEndpoints `json:"new_paths"`
+}
+
+type SnapshotDiff struct {
+	From               string                   `json:"from"`
+	To                 string                   `json:"to"`
+	Principals         PrincipalChanges         `json:"principals"`
+	PolicyAttachments  PolicyAttachmentChanges  `json:"policy_attachments"`
+	Statements         StatementChanges         `json:"statements"`
+	TrustRelationships TrustRelationshipChanges `json:"trust_relationships"`
+	IdentityTransforms IdentityTransformChanges `json:"identity_transforms"`
+	TierZero           PrincipalChanges         `json:"tier_zero"`
+}
+
+// DefaultSnapshotName names a snapshot after the time it was taken
+func DefaultSnapshotName(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+func ValidateSnapshotName(name string) error {
+	if !snapshotNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid snapshot name %s", name)
+	}
+	return nil
+}
+
+func snapshotPath(dir string, name string) (string, error) {
+	if err := ValidateSnapshotName(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+snapshotFileExtension), nil
+}
+
+// SaveSnapshot writes a snapshot to dir. An existing snapshot with the
+// same name is replaced.
+func SaveSnapshot(dir string, snapshot *Snapshot) error {
+	path, err := snapshotPath(dir, snapshot.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed creating snapshot directory %s: %w", dir, err)
+	}
+
+	content, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return fmt.Errorf("failed writing snapshot %s: %w", path, err)
+	}
+	return nil
+}
+
+func LoadSnapshot(dir string, name string) (*Snapshot, error) {
+	path, err := snapshotPath(dir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading snapshot %s: %w", name, err)
+	}
+
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(content, snapshot); err != nil {
+		return nil, fmt.Errorf("failed parsing snapshot %s: %w", name, err)
+	}
+	return snapshot, nil
+}
+
+// ListSnapshots returns the snapshots in dir, oldest first. A missing
+// directory has no snapshots.
+func ListSnapshots(dir string) ([]SnapshotInfo, error) {
+	infos := []SnapshotInfo{}
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return infos, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed reading snapshot directory %s: %w", dir, err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), snapshotFileExtension) {
+			continue
+		}
+
+		snapshot, err := LoadSnapshot(dir, strings.TrimSuffix(entry.Name(), snapshotFileExtension))
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, SnapshotInfo{
+			Name:      snapshot.Name,
+			CreatedAt: snapshot.CreatedAt,
+		})
+	}
+
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].CreatedAt.Before(infos[j].CreatedAt)
+	})
+
+	return infos, nil
+}
+
+// Items in to but not in from, and items in from but not in to, each in
+// the order of the list they came from
+func diffItems[T comparable](from []T, to []T) ([]T, []T) {
+	fromSet := map[T]bool{}
+	for _, item := range from {
+		fromSet[item] = true
+	}
+	toSet := map[T]bool{}
+	for _, item := range to {
+		toSet[item] = true
+	}
+
+	added := []T{}
+	for _, item := range to {
+		if !fromSet[item] {
+			added = append(added, item)
+		}
+	}
+	removed := []T{}
+	for _, item := range from {
+		if !toSet[item] {
+			removed = append(removed, item)
+		}
+	}
+	return added, removed
+}
+
+// GetIdentityPathEndpoints returns every source and target joined by a
+// chain of one or more identity transforms
+func GetIdentityPathEndpoints(edges []IdentityTransformEdge) []IdentityPathEndpoints {
+	adjacent := map[string][]string{}
+	for _, edge := range edges {
+		adjacent[edge.Source] = append(adjacent[edge.Source], edge.Target)
+	}
+
+	sources := make([]string, 0, len(adjacent))
+	for source := range adjacent {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	endpoints := []IdentityPathEndpoints{}
+	for _, source := range sources {
+		visited := map[string]bool{source: true}
+		queue := append([]string{}, adjacent[source]...)
+		reachable := []string{}
+		for len(queue) > 0 {
+			target := queue[0]
+			queue = queue[1:]
+			if visited[target] {
+				continue
+			}
+			visited[target] = true
+			reachable = append(reachable, target)
+			queue = append(queue, adjacent[target]...)
+		}
+
+		sort.Strings(reachable)
+		for _, target := range reachable {
+			endpoints = append(endpoints, IdentityPathEndpoints{Source: source, Target: target})
+		}
+	}
+
+	return endpoints
+}
+
+// DiffSnapshots reports what was added and removed going from one
+// snapshot to another
+func DiffSnapshots(from *Snapshot, to *Snapshot) SnapshotDiff {
+	diff := SnapshotDiff{
+		From: from.Name,
+		To:   to.Name,
+	}
+
+	diff.Principals.Added, diff.Principals.Removed = diffItems(from.Principals, to.Principals)
+	diff.PolicyAttachments.Added, diff.PolicyAttachments.Removed = diffItems(from.PolicyAttachments, to.PolicyAttachments)
+	diff.Statements.Added, diff.Statements.Removed = diffItems(from.Statements, to.Statements)
+	diff.TrustRelationships.Added, diff.TrustRelationships.Removed = diffItems(from.TrustRelationships, to.TrustRelationships)
+	diff.IdentityTransforms.Added, diff.IdentityTransforms.Removed = diffItems(from.IdentityTransforms, to.IdentityTransforms)
+	diff.IdentityTransforms.NewPaths, _ = diffItems(GetIdentityPathEndpoints(from.IdentityTransforms), GetIdentityPathEndpoints(to.IdentityTransforms))
+	diff.TierZero.Added, diff.TierZero.Removed = diffItems(from.TierZero, to.TierZero)
+
+	return diff
+}
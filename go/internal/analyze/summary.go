@@ -0,0 +1,49 @@
+package analyze
+
+import (
+	"sort"
+	"strings"
+)
+
+// Access level used for actions missing from the service authorization
+// reference, such as those only referenced by an ingested policy
+const UnknownAccessLevel = "unknown"
+
+// ServiceAccessSummary maps a service prefix to access levels, and each
+// access level to the actions granted at that level
+type ServiceAccessSummary map[string]map[string][]string
+
+func GetServiceFromAction(action string) string {
+	service, _, found := strings.Cut(action, ":")
+	if !found {
+		return ""
+	}
+	return service
+}
+
+// SummarizeActionMap groups the actions of an action map by service and
+// access level. accessLevels maps action names to their access level.
+func SummarizeActionMap(actionMap ActionToPathMap, accessLevels map[string]string) ServiceAccessSummary {
+	summary := make(ServiceAccessSummary)
+	for action := range actionMap {
+		service := GetServiceFromAction(action)
+		accessLevel, ok := accessLevels[action]
+		if !ok || accessLevel == "" {
+			accessLevel = UnknownAccessLevel
+		}
+
+		levels, ok := summary[service]
+		if !ok {
+			levels = make(map[string][]string)
+			summary[service] = levels
+		}
+		levels[accessLevel] = append(levels[accessLevel], action)
+	}
+
+	for _, levels := range summary {
+		for _, actions := range levels {
+			sort.Strings(actions)
+		}
+	}
+	return summary
+}
@@ -0,0 +1,349 @@
+package analyze
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	IAMChangeCreate = "create"
+	IAMChangeUpdate = "update"
+	IAMChangeDelete = "delete"
+)
+
+// The kinds of IAM resource a change applies to
+const (
+	IAMResourceRole       = "role"
+	IAMResourceUser       = "user"
+	IAMResourceGroup      = "group"
+	IAMResourcePolicy     = "policy"
+	IAMResourceInline     = "inline_policy"
+	IAMResourceAttachment = "attachment"
+)
+
+// The output of terraform show -json for a saved plan. The configuration
+// is only read for the resources that attributes refer to, which tells
+// which resource an attribute comes from when its value isn't known until
+// apply.
+type TerraformPlan struct {
+	ResourceChanges []TerraformResourceChange `json:"resource_changes"`
+	Configuration   struct {
+		RootModule TerraformModule `json:"root_module"`
+	} `json:"configuration"`
+}
+
+type TerraformModule struct {
+	Resources []struct {
+		Address     string                     `json:"address"`
+		Expressions map[string]json.RawMessage `json:"expressions"`
+	} `json:"resources"`
+	ModuleCalls map[string]struct {
+		Module TerraformModule `json:"module"`
+	} `json:"module_calls"`
+}
+
+type TerraformResourceChange struct {
+	Address string `json:"address"`
+	Type    string `json:"type"`
+	Change  struct {
+		Actions []string       `json:"actions"`
+		Before  map[string]any `json:"before"`
+		After   map[string]any `json:"after"`
+	} `json:"change"`
+}
+
+// IAMChange is a proposed change to an IAM resource. Principals are
+// identified by name, as Terraform does, and ARNs are empty when they
+// aren't known until the resource is created. The references are the
+// configuration addresses of the resources the principal and policy come
+// from, if they are managed in the same configuration. Document is nil
+// when the policy isn't known until apply.
+type IAMChange struct {
+	Address            string         `json:"address"`
+	Action             string         `json:"action"`
+	Resource           string         `json:"resource"`
+	Name               string         `json:"name"`
+	Path               string         `json:"path,omitempty"`
+	Arn                string         `json:"arn,omitempty"`
+	PrincipalKind      string         `json:"principal_kind,omitempty"`
+	PrincipalName      string         `json:"principal_name,omitempty"`
+	PrincipalReference string         `json:"principal_reference,omitempty"`
+	PolicyArn          string         `json:"policy_arn,omitempty"`
+	PolicyReference    string         `json:"policy_reference,omitempty"`
+	Document           map[string]any `json:"-"`
+}
+
+// SkippedChange is a planned change that couldn't be applied to the graph
+type SkippedChange struct {
+	Address string `json:"address"`
+	Reason  string `json:"reason"`
+}
+
+// The resource and principal kind of each supported Terraform resource
+// type. Attributes holding the principal name are named after its kind.
+var terraformIAMResources = map[string][2]string{
+	"aws_iam_role":                    {IAMResourceRole, ""},
+	"aws_iam_user":                    {IAMResourceUser, ""},
+	"aws_iam_group":                   {IAMResourceGroup, ""},
+	"aws_iam_policy":                  {IAMResourcePolicy, ""},
+	"aws_iam_role_policy":             {IAMResourceInline, IAMResourceRole},
+	"aws_iam_user_policy":             {IAMResourceInline, IAMResourceUser},
+	"aws_iam_group_policy":            {IAMResourceInline, IAMResourceGroup},
+	"aws_iam_role_policy_attachment":  {IAMResourceAttachment, IAMResourceRole},
+	"aws_iam_user_policy_attachment":  {IAMResourceAttachment, IAMResourceUser},
+	"aws_iam_group_policy_attachment": {IAMResourceAttachment, IAMResourceGroup},
+}
+
+// Terraform plans a replacement as a delete and a create, which is an
+// update as far as the graph is concerned
+func getIAMChangeAction(actions []string) string {
+	hasCreate, hasDelete := false, false
+	for _, action := range actions {
+		switch action {
+		case "create":
+			hasCreate = true
+		case "delete":
+			hasDelete = true
+		case "update":
+			return IAMChangeUpdate
+		}
+	}
+
+	if hasCreate && hasDelete {
+		return IAMChangeUpdate
+	} else if hasCreate {
+		return IAMChangeCreate
+	} else if hasDelete {
+		return IAMChangeDelete
+	}
+	return ""
+}
+
+var resourceIndexRegex = regexp.MustCompile(`\[[^\]]*\]`)
+
+// The configuration address of a resource instance, which drops the
+// count and for_each keys
+func GetTerraformConfigAddress(address string) string {
+	return resourceIndexRegex.ReplaceAllString(address, "")
+}
+
+// Collect the IAM resources every attribute refers to, keyed by the
+// configuration address of the resource and the attribute name. Addresses
+// inside modules are relative to the module, so they are prefixed with
+// the module path.
+func getTerraformReferences(module TerraformModule, prefix string, references map[string]map[string]string) {
+	for _, resource := range module.Resources {
+		attributes := map[string]string{}
+		for name, expression := range resource.Expressions {
+			var value struct {
+				References []string `json:"references"`
+			}
+			if json.Unmarshal(expression, &value) != nil {
+				continue
+			}
+
+			// References list the attribute and the resource it belongs
+			// to, and the resource is the shortest of them
+			reference := ""
+			for _, candidate := range value.References {
+				if !strings.HasPrefix(candidate, "aws_iam_") {
+					continue
+				}
+				if reference == "" || len(candidate) < len(reference) {
+					reference = candidate
+				}
+			}
+			if reference != "" {
+				attributes[name] = prefix + GetTerraformConfigAddress(reference)
+			}
+		}
+		references[prefix+resource.Address] = attributes
+	}
+
+	for name, call := range module.ModuleCalls {
+		getTerraformReferences(call.Module, fmt.Sprintf("%smodule.%s.", prefix, name), references)
+	}
+}
+
+func getStringAttribute(values map[string]any, name string) string {
+	value, _ := values[name].(string)
+	return value
+}
+
+// Policies are JSON strings in Terraform
+func parsePolicyAttribute(values map[string]any, name string) (map[string]any, error) {
+	policy := getStringAttribute(values, name)
+	if policy == "" {
+		return nil, nil
+	}
+
+	var document map[string]any
+	if err := json.Unmarshal([]byte(policy), &document); err != nil {
+		return nil, fmt.Errorf("%s is not a valid policy: %w", name, err)
+	}
+	return document, nil
+}
+
+// ParseTerraformPlan reads the IAM changes of a plan. Changes to other
+// resources are ignored, and IAM resources that aren't supported are
+// returned as skipped.
+func ParseTerraformPlan(data []byte) ([]IAMChange, []SkippedChange, error) {
+	var plan TerraformPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, nil, fmt.Errorf("failed parsing Terraform plan: %w", err)
+	}
+
+	references := map[string]map[string]string{}
+	getTerraformReferences(plan.Configuration.RootModule, "", references)
+
+	changes := []IAMChange{}
+	skipped := []SkippedChange{}
+	for _, resourceChange := range plan.ResourceChanges {
+		action := getIAMChangeAction(resourceChange.Change.Actions)
+		if action == "" {
+			continue
+		}
+
+		kinds, ok := terraformIAMResources[resourceChange.Type]
+		if !ok {
+			if strings.HasPrefix(resourceChange.Type, "aws_iam_") {
+				skipped = append(skipped, SkippedChange{
+					Address: resourceChange.Address,
+					Reason:  fmt.Sprintf("%s is not supported", resourceChange.Type),
+				})
+			}
+			continue
+		}
+
+		values := resourceChange.Change.After
+		if action == IAMChangeDelete {
+			values = resourceChange.Change.Before
+		}
+
+		change := IAMChange{
+			Address:       resourceChange.Address,
+			Action:        action,
+			Resource:      kinds[0],
+			Name:          getStringAttribute(values, "name"),
+			Path:          getStringAttribute(values, "path"),
+			Arn:           getStringAttribute(values, "arn"),
+			PrincipalKind: kinds[1],
+			PolicyArn:     getStringAttribute(values, "policy_arn"),
+		}
+		attributeReferences := references[GetTerraformConfigAddress(change.Address)]
+		if change.PrincipalKind != "" {
+			change.PrincipalName = getStringAttribute(values, change.PrincipalKind)
+			change.PrincipalReference = attributeReferences[change.PrincipalKind]
+		}
+		if change.Resource == IAMResourceAttachment {
+			change.PolicyReference = attributeReferences["policy_arn"]
+		}
+
+		documentAttribute := ""
+		switch change.Resource {
+		case IAMResourceRole:
+			documentAttribute = "assume_role_policy"
+		case IAMResourcePolicy, IAMResourceInline:
+			documentAttribute = "policy"
+		}
+		if documentAttribute != "" && action != IAMChangeDelete {
+			document, err := parsePolicyAttribute(values, documentAttribute)
+			if err != nil {
+				skipped = append(skipped, SkippedChange{Address: change.Address, Reason: err.Error()})
+				continue
+			}
+			if document == nil {
+				skipped = append(skipped, SkippedChange{
+					Address: change.Address,
+					Reason:  fmt.Sprintf("%s is not known until apply", documentAttribute),
+				})
+				if change.Resource != IAMResourceRole {
+					continue
+				}
+			}
+			change.Document = document
+		}
+
+		changes = append(changes, change)
+	}
+
+	SortIAMChanges(changes)
+	return changes, skipped, nil
+}
+
+// The order changes are applied in. Principals and policies have to exist
+// before they are attached, and deletions come last.
+func iamChangeRank(change IAMChange) int {
+	rank := 0
+	if change.Resource == IAMResourceInline || change.Resource == IAMResourceAttachment {
+		rank = 1
+	}
+	if change.Action == IAMChangeDelete {
+		rank += 2
+	}
+	return rank
+}
+
+func SortIAMChanges(changes []IAMChange) {
+	sort.SliceStable(changes, func(i, j int) bool {
+		return iamChangeRank(changes[i]) < iamChangeRank(changes[j])
+	})
+}
+
+// The ARN IAM gives a principal or policy
+func GetIAMArn(accountID string, resource string, path string, name string) string {
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("arn:aws:iam::%s:%s%s%s", accountID, resource, path, name)
+}
+
+// RSOPChange is how the effective permissions of a principal change, as
+// resources mapped to actions
+type RSOPChange struct {
+	Principal string               `json:"principal"`
+	Added     PrincipalToActionMap `json:"added"`
+	Removed   PrincipalToActionMap `json:"removed"`
+}
+
+func (c RSOPChange) IsEmpty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0
+}
+
+func subtractActionMap(from PrincipalToActionMap, other PrincipalToActionMap) PrincipalToActionMap {
+	difference := PrincipalToActionMap{}
+	for resource, actions := range from {
+		otherActions := map[string]bool{}
+		for _, action := range other[resource] {
+			otherActions[action] = true
+		}
+		for _, action := range actions {
+			if !otherActions[action] {
+				difference[resource] = append(difference[resource], action)
+			}
+		}
+		sort.Strings(difference[resource])
+	}
+	return difference
+}
+
+func DiffRSOP(principal string, before PrincipalToActionMap, after PrincipalToActionMap) RSOPChange {
+	return RSOPChange{
+		Principal: principal,
+		Added:     subtractActionMap(after, before),
+		Removed:   subtractActionMap(before, after),
+	}
+}
+
+// ImpactReport is how a Terraform plan would change the graph. Graph
+// compares the current graph with the graph after the plan is applied.
+type ImpactReport struct {
+	Collection string          `json:"collection"`
+	Applied    []IAMChange     `json:"applied"`
+	Skipped    []SkippedChange `json:"skipped"`
+	Graph      SnapshotDiff    `json:"graph"`
+	RSOP       []RSOPChange    `json:"rsop"`
+}
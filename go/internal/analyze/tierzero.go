@@ -0,0 +1,86 @@
+package analyze
+
+import "github.com/specterops/bloodhound/dawgs/graph"
+
+const (
+	TierZeroProperty       = "tierzero"
+	TierZeroReasonProperty = "tierzero_reason"
+	TierZeroManualProperty = "tierzero_manual"
+
+	// The principal's own policies allow every action on every resource
+	TierZeroReasonAdmin = "admin"
+	// The principal can become a tier zero principal through an
+	// identity transform
+	TierZeroReasonTransform = "transform"
+	// A reviewer marked the node as tier zero
+	TierZeroReasonManual = "manual"
+)
+
+// Action blob names that match every action
+var AdminActionNames = []string{"*", "*:*"}
+
+const AdminResourceName = "*"
+
+type TierZeroEntry struct {
+	PrincipalID  graph.ID `json:"principal_id"`
+	PrincipalArn string   `json:"principal_arn"`
+	Reason       string   `json:"reason"`
+	Manual       bool     `json:"manual"`
+}
+
+// IsAdminEquivalent resolves the statements of a principal that act on
+// every action and every resource. The principal is admin equivalent if
+// an allow survives the denies and conditions, and, when boundaryPaths is
+// not nil, the permissions boundary allows the same.
+//
+// Denies that only cover some actions or resources don't appear in these
+// paths, so a principal with narrow guardrails is still considered admin.
+func IsAdminEquivalent(adminPaths *ActionPathSet, boundaryPaths *ActionPathSet) (bool, error) {
+	resolvedPaths, err := ResolveResourceAgainstIdentityPolicies(&ActionPathSet{}, adminPaths)
+	if err != nil {
+		return false, err
+	}
+
+	if boundaryPaths != nil {
+		resolvedBoundaryPaths, err := ResolveResourceAgainstIdentityPolicies(&ActionPathSet{}, boundaryPaths)
+		if err != nil {
+			return false, err
+		}
+		resolvedPaths = ApplyPermissionsBoundary(resolvedPaths, resolvedBoundaryPaths)
+	}
+
+	return len(*resolvedPaths) > 0, nil
+}
+
+// GetTransformSources walks the identity transform edges backwards from
+// the targets, and returns every node that can reach one of them. The
+// targets themselves aren't included. Each node is visited once, so
+// cycles of roles that can assume each other don't matter.
+func GetTransformSources(edges []graph.Relationship, targets []graph.ID) []graph.ID {
+	inbound := map[graph.ID][]graph.ID{}
+	for _, edge := range edges {
+		inbound[edge.EndID] = append(inbound[edge.EndID], edge.StartID)
+	}
+
+	visited := map[graph.ID]bool{}
+	for _, target := range targets {
+		visited[target] = true
+	}
+
+	sources := []graph.ID{}
+	queue := append([]graph.ID{}, targets...)
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, source := range inbound[current] {
+			if visited[source] {
+				continue
+			}
+			visited[source] = true
+			sources = append(sources, source)
+			queue = append(queue, source)
+		}
+	}
+
+	return sources
+}
@@ -0,0 +1,95 @@
+package analyze
+
+import (
+	"strings"
+
+	"github.com/hotnops/apeman/awsconditions"
+)
+
+// Condition keys that bind a service principal to the account or resource
+// it is acting on behalf of. Without one of these, any customer of the
+// service can point it at the trusting resource.
+var ConfusedDeputyConditionKeys = []string{
+	"aws:sourcearn",
+	"aws:sourceaccount",
+	"aws:sourceowner",
+	"aws:sourceorgid",
+	"aws:sourceorgpaths",
+}
+
+type ConfusedDeputyEntry struct {
+	StatementHash string   `json:"statement_hash"`
+	Services      []string `json:"services"`
+	TargetArns    []string `json:"target_arns"`
+}
+
+func IsServicePrincipal(principal string) bool {
+	principal = strings.ToLower(principal)
+	return strings.HasSuffix(principal, ".amazonaws.com") || strings.HasSuffix(principal, ".amazonaws.com.cn")
+}
+
+// A condition only restricts the caller when the operator is a positive
+// match. Negated operators and IfExists variants both pass when the key
+// is absent from the request, so they offer no protection.
+func isRestrictingOperator(operator string) bool {
+	operator = strings.ToLower(operator)
+	if strings.Contains(operator, "not") || strings.HasSuffix(operator, "ifexists") {
+		return false
+	}
+	return operator != "null"
+}
+
+// HasRestrictingConditionKey returns true if any of the conditions
+// requires one of the given condition keys to match
+func HasRestrictingConditionKey(conditions []awsconditions.AWSCondition, keys ...string) bool {
+	for _, condition := range conditions {
+		if !isRestrictingOperator(condition.Operator) {
+			continue
+		}
+		for conditionKey := range condition.ConditionKeys {
+			for _, key := range keys {
+				if strings.EqualFold(conditionKey, key) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func IsConfusedDeputy(services []string, conditions []awsconditions.AWSCondition) bool {
+	if len(services) == 0 {
+		return false
+	}
+	return !HasRestrictingConditionKey(conditions, ConfusedDeputyConditionKeys...)
+}
+
+type ExternalTrustTarget struct {
+	Arn                string `json:"arn"`
+	Principal          string `json:"principal"`
+	StatementHash      string `json:"statement_hash"`
+	ExternalIdEnforced bool   `json:"external_id_enforced"`
+}
+
+type ExternalAccount struct {
+	AccountID string                `json:"account_id"`
+	Vendor    string                `json:"vendor"`
+	Targets   []ExternalTrustTarget `json:"targets"`
+}
+
+// GetTrustedAccountID returns the account ID referenced by a principal in a
+// policy, which can be an ARN, a principal blob or a bare account number
+func GetTrustedAccountID(principal string) string {
+	if len(principal) == 12 && strings.Trim(principal, "0123456789") == "" {
+		return principal
+	}
+	accountID := GetAccountIDFromArn(principal)
+	if accountID == "*" || accountID == "aws" {
+		return ""
+	}
+	return accountID
+}
+
+func IsExternalIdEnforced(conditions []awsconditions.AWSCondition) bool {
+	return HasRestrictingConditionKey(conditions, "sts:externalid")
+}
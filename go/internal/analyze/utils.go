@@ -114,6 +114,18 @@ func ResolveConditions(entry ActionPathEntry) (bool, error) {
 	return true, nil
 }
 
+// ConditionsUnresolved reports whether any of the entry's conditions use
+// a context key that can't be resolved from the graph. ResolveConditions
+// treats those conditions as failed.
+func ConditionsUnresolved(entry ActionPathEntry) bool {
+	for _, condition := range entry.Conditions {
+		if _, err := ResolveConditionVariables(entry, condition); err != nil {
+			return true
+		}
+	}
+	return false
+}
+
 func GetNodeFromPathByID(path graph.Path, id graph.ID) (*graph.Node, error) {
 	for _, node := range path.Nodes {
 		if node.ID == id {
@@ -0,0 +1,48 @@
+package analyze
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+//go:embed knownvendors.json
+var knownVendorsJSON []byte
+
+// VendorRegistry maps an AWS account ID to the name of the third party
+// that owns it
+type VendorRegistry map[string]string
+
+// LoadVendorRegistry returns the built in vendor list with the entries
+// from path, if given, layered on top
+func LoadVendorRegistry(path string) (VendorRegistry, error) {
+	registry := VendorRegistry{}
+	if err := json.Unmarshal(knownVendorsJSON, &registry); err != nil {
+		return nil, fmt.Errorf("failed parsing built in vendor list: %w", err)
+	}
+
+	if path == "" {
+		return registry, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading vendor file %s: %w", path, err)
+	}
+
+	localRegistry := VendorRegistry{}
+	if err := json.Unmarshal(content, &localRegistry); err != nil {
+		return nil, fmt.Errorf("failed parsing vendor file %s: %w", path, err)
+	}
+
+	for accountID, vendor := range localRegistry {
+		registry[accountID] = vendor
+	}
+
+	return registry, nil
+}
+
+func (v VendorRegistry) Lookup(accountID string) string {
+	return v[accountID]
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotnops/apeman/analyze"
+	"github.com/hotnops/apeman/go/internal/queries"
+)
+
+func (s *Server) GetEffectiveAccess(c *gin.Context) {
+	action := c.Query("action")
+	resource := c.DefaultQuery("resource", "*")
+	if action == "" {
+		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("action is required"))
+		return
+	}
+
+	resolvedPaths, err := queries.GetEffectiveAccess(s.requestContext(c), s.db, action, resource)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, analyze.AccessPathSetToMap(*resolvedPaths))
+}
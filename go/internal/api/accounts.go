@@ -12,16 +12,17 @@ func (s *Server) GetAWSAccount(c *gin.Context) {
 	propertyName := "account_id"
 	id := c.Param(propertyName)
 
-	nodes, err := queries.GetAWSNodeByKindID(s.ctx, s.db, propertyName, id, aws.AWSAccount)
+	nodes, err := queries.GetAWSNodeByKindID(s.requestContext(c), s.db, propertyName, id, aws.AWSAccount)
 	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortNodeLookup(c, err)
+		return
 	}
 
 	c.IndentedJSON(http.StatusOK, nodes)
 }
 
 func (s *Server) GetAWSAccountIDs(c *gin.Context) {
-	nodes, err := queries.GetAWSAccountIDs(s.ctx, s.db)
+	nodes, err := queries.GetAWSAccountIDs(s.requestContext(c), s.db)
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
 	}
@@ -33,7 +34,7 @@ func (s *Server) GetAWSAccountServices(c *gin.Context) {
 	propertyName := "account_id"
 	id := c.Param(propertyName)
 
-	nodes, err := queries.GetAWSAccountServices(s.ctx, s.db, id)
+	nodes, err := queries.GetAWSAccountServices(s.requestContext(c), s.db, id)
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
 	}
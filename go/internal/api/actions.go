@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/hotnops/apeman/analyze"
 	"github.com/hotnops/apeman/go/internal/queries"
 )
 
@@ -11,7 +12,7 @@ func (s *Server) GetActionPolicies(c *gin.Context) {
 	actionName := "actionname"
 	action := c.Param(actionName)
 
-	statements, err := queries.GetActionPolicies(s.ctx, s.db, action)
+	statements, err := queries.GetActionPolicies(s.requestContext(c), s.db, action)
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
 	}
@@ -19,6 +20,20 @@ func (s *Server) GetActionPolicies(c *gin.Context) {
 	c.IndentedJSON(http.StatusOK, statements)
 }
 
+func (s *Server) GetActionPrincipals(c *gin.Context) {
+	actionName := "actionname"
+	action := c.Param(actionName)
+
+	resolvedPaths, err := queries.GetEffectiveAccess(s.requestContext(c), s.db, action, "*")
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, analyze.GetPrincipalScopes(*resolvedPaths))
+}
+
 func (s *Server) addActionsEndpoints(router *gin.RouterGroup) {
 	router.GET("policies", s.GetActionPolicies)
+	router.GET("principals", s.GetActionPrincipals)
 }
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotnops/apeman/analyze"
+	"github.com/hotnops/apeman/go/internal/alerts"
+	"github.com/hotnops/apeman/go/internal/queries"
+)
+
+// Compare the graph against the previous analysis run and alert on new
+// escalation paths. If any destination fails, the run isn't recorded and
+// the request fails, so retrying sends the alert again.
+func (s *Server) AnalyzeEscalationAlerts(c *gin.Context) {
+	ctx := s.requestContext(c)
+	var deliveryErr error
+	alert, err := queries.GetEscalationAlert(ctx, s.db, s.alertStateDirectory(ctx), func(alert analyze.EscalationAlert) error {
+		log.Printf("[*] Sending alert for %d new identity transforms and %d new tier zero paths",
+			len(alert.NewIdentityTransforms), len(alert.NewTierZeroPaths))
+		deliveryErr = alerts.Deliver(s.cfg.Apeman.Alerts, alert)
+		return deliveryErr
+	})
+	if deliveryErr != nil {
+		c.AbortWithError(http.StatusBadGateway, deliveryErr)
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, alert)
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotnops/apeman/go/internal/queries"
+	"github.com/hotnops/apeman/graphschema/aws"
+)
+
+// Ingest the CloudTrail logs in the configured directory
+func (s *Server) IngestCloudTrail(c *gin.Context) {
+	if s.cfg.Apeman.CloudTrailDirectory == "" {
+		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("cloudtrail_directory is not configured"))
+		return
+	}
+
+	ctx := s.requestContext(c)
+	ingest, err := queries.IngestCloudTrailDirectory(ctx, s.db, s.cloudTrailDirectory(ctx))
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, ingest)
+}
+
+func (s *Server) GetAWSRoleRSOPUsage(c *gin.Context) {
+	roleId := c.Param("roleid")
+	node, err := queries.GetAWSNodeByKindID(s.requestContext(c), s.db, "roleid", roleId, aws.AWSRole)
+	if err != nil {
+		abortNodeLookup(c, err)
+		return
+	}
+
+	usage, err := queries.GetPrincipalActionUsage(s.requestContext(c), s.db, node)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, usage)
+}
+
+func (s *Server) GetAWSUserRSOPUsage(c *gin.Context) {
+	userId := c.Param("userid")
+	node, err := queries.GetAWSNodeByKindID(s.requestContext(c), s.db, "userid", userId, aws.AWSUser)
+	if err != nil {
+		abortNodeLookup(c, err)
+		return
+	}
+
+	usage, err := queries.GetPrincipalActionUsage(s.requestContext(c), s.db, node)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, usage)
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotnops/apeman/go/internal/queries"
+)
+
+// Every route is also served under /collections/:collection, which
+// confines it to the data of that collection. The unprefixed routes see
+// every collection at once.
+
+func collectionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := queries.ValidateCollectionName(c.Param("collection")); err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// The context to run the queries of a request with
+func (s *Server) requestContext(c *gin.Context) context.Context {
+	if collection := c.Param("collection"); collection != "" {
+		return queries.WithCollection(s.ctx, collection)
+	}
+	return s.ctx
+}
+
+// The context daemon runs analyze the graph with
+func (s *Server) daemonContext() context.Context {
+	return queries.WithCollection(s.ctx, s.cfg.Apeman.Daemon.Collection)
+}
+
+// Each collection keeps its own snapshots and alert state. The default
+// collection uses the same directories as the unprefixed routes.
+func collectionDirectory(ctx context.Context, dir string) string {
+	if collection, ok := queries.GetCollection(ctx); ok && collection != queries.DefaultCollection {
+		return filepath.Join(dir, collection)
+	}
+	return dir
+}
+
+func (s *Server) snapshotDirectory(ctx context.Context) string {
+	return collectionDirectory(ctx, s.cfg.SnapshotDirectory())
+}
+
+func (s *Server) alertStateDirectory(ctx context.Context) string {
+	return collectionDirectory(ctx, s.cfg.AlertStateDirectory())
+}
+
+func (s *Server) cloudTrailDirectory(ctx context.Context) string {
+	return collectionDirectory(ctx, s.cfg.Apeman.CloudTrailDirectory)
+}
+
+func (s *Server) GetCollections(c *gin.Context) {
+	collections, err := queries.GetCollections(s.ctx, s.db)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, collections)
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotnops/apeman/analyze"
+	"github.com/hotnops/apeman/go/internal/alerts"
+	"github.com/hotnops/apeman/go/internal/daemon"
+	"github.com/hotnops/apeman/go/internal/queries"
+)
+
+// Every analysis, in the order it has to run after an ingest
+func (s *Server) analysisSteps() []daemon.Step {
+	steps := []daemon.Step{}
+
+	// Ingest replaces the graph, so the CloudTrail activity goes with it
+	if s.cfg.Apeman.CloudTrailDirectory != "" {
+		steps = append(steps, daemon.Step{
+			Name: "cloudtrail",
+			Run: func(ctx context.Context, run *daemon.RunRecord) (string, error) {
+				ingest, err := queries.IngestCloudTrailDirectory(ctx, s.db, s.cloudTrailDirectory(ctx))
+				return fmt.Sprintf("%d used actions, %d unmatched", len(ingest.Stored), len(ingest.Unmatched)), err
+			},
+		})
+	}
+
+	steps = append(steps, []daemon.Step{
+		{
+			Name: "expand graph",
+			Run: func(ctx context.Context, run *daemon.RunRecord) (string, error) {
+				return "", queries.ExpandGraph(ctx, s.db)
+			},
+		},
+		{
+			Name: "identity transforms",
+			Run: func(ctx context.Context, run *daemon.RunRecord) (string, error) {
+				return "", queries.CreateIdentityTransformEdges(ctx, s.db)
+			},
+		},
+		{
+			Name: "tier zero",
+			Run: func(ctx context.Context, run *daemon.RunRecord) (string, error) {
+				entries, err := queries.AnalyzeTierZero(ctx, s.db)
+				return fmt.Sprintf("%d tier zero nodes", len(entries)), err
+			},
+		},
+		{
+			Name: "findings",
+			Run: func(ctx context.Context, run *daemon.RunRecord) (string, error) {
+				findings, err := queries.AnalyzeFindings(ctx, s.db, s.rules, s.findingOptions)
+				return fmt.Sprintf("%d findings", len(findings)), err
+			},
+		},
+		{
+			Name: "alerts",
+			Run: func(ctx context.Context, run *daemon.RunRecord) (string, error) {
+				alert, err := queries.GetEscalationAlert(ctx, s.db, s.alertStateDirectory(ctx), func(alert analyze.EscalationAlert) error {
+					return alerts.Deliver(s.cfg.Apeman.Alerts, alert)
+				})
+				if alert.IsEmpty() {
+					return "no new escalation paths", err
+				}
+				output := fmt.Sprintf("%d new identity transforms, %d new tier zero paths",
+					len(alert.NewIdentityTransforms), len(alert.NewTierZeroPaths))
+				return output, err
+			},
+		},
+	}...)
+
+	if s.cfg.Apeman.Daemon.Snapshot {
+		steps = append(steps, daemon.Step{
+			Name: "snapshot",
+			Run: func(ctx context.Context, run *daemon.RunRecord) (string, error) {
+				snapshot, err := queries.CaptureSnapshot(ctx, s.db, run.ID)
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("saved snapshot %s", snapshot.Name), analyze.SaveSnapshot(s.snapshotDirectory(ctx), snapshot)
+			},
+		})
+	}
+
+	return steps
+}
+
+// Watch for new data and reingest it until the server exits
+func (s *Server) RunDaemon() {
+	s.daemon.Run(s.daemonContext())
+}
+
+func (s *Server) ExpandGraph(c *gin.Context) {
+	if err := queries.ExpandGraph(s.requestContext(c), s.db); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Done()
+}
+
+func (s *Server) GetRuns(c *gin.Context) {
+	runs, err := daemon.ListRunRecords(s.daemon.RunDirectory())
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, runs)
+}
+
+// Start ingest and analysis now. The run continues in the background, and
+// its outcome is listed by GetRuns under the returned ID.
+func (s *Server) CreateRun(c *gin.Context) {
+	run, err := s.daemon.Start(s.daemonContext(), daemon.TriggerManual)
+	if errors.Is(err, daemon.ErrRunInProgress) {
+		c.AbortWithError(http.StatusConflict, err)
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusAccepted, run)
+}
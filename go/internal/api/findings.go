@@ -0,0 +1,51 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotnops/apeman/analyze"
+	"github.com/hotnops/apeman/go/internal/queries"
+)
+
+func (s *Server) AnalyzeFindings(c *gin.Context) {
+	findings, err := queries.AnalyzeFindings(s.requestContext(c), s.db, s.rules, s.findingOptions)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, findings)
+}
+
+func (s *Server) GetFindings(c *gin.Context) {
+	filter := analyze.FindingFilter{
+		AccountID: c.Query("account"),
+		Severity:  c.Query("severity"),
+		CheckID:   c.Query("check"),
+	}
+
+	if filter.Severity != "" && !analyze.IsValidSeverity(filter.Severity) {
+		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("unknown severity %s", filter.Severity))
+		return
+	}
+	if filter.CheckID != "" {
+		if _, ok := analyze.GetFindingCheck(analyze.GetAllFindingChecks(s.rules), filter.CheckID); !ok {
+			c.AbortWithError(http.StatusBadRequest, fmt.Errorf("unknown check %s", filter.CheckID))
+			return
+		}
+	}
+
+	findings, err := queries.GetFindings(s.requestContext(c), s.db, filter)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, findings)
+}
+
+func (s *Server) GetFindingChecks(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, analyze.GetAllFindingChecks(s.rules))
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/hotnops/apeman/analyze"
 	"github.com/hotnops/apeman/go/internal/queries"
 	"github.com/hotnops/apeman/graphschema/aws"
 )
@@ -12,9 +13,10 @@ func (s *Server) GetAWSGroup(c *gin.Context) {
 	propertyName := "groupid"
 	id := c.Param(propertyName)
 
-	nodes, err := queries.GetAWSNodeByKindID(s.ctx, s.db, propertyName, id, aws.AWSGroup)
+	nodes, err := queries.GetAWSNodeByKindID(s.requestContext(c), s.db, propertyName, id, aws.AWSGroup)
 	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortNodeLookup(c, err)
+		return
 	}
 
 	c.IndentedJSON(http.StatusOK, nodes)
@@ -24,12 +26,13 @@ func (s *Server) GetAWSGroupMembers(c *gin.Context) {
 	propertyName := "groupid"
 	id := c.Param(propertyName)
 
-	node, err := queries.GetAWSNodeByKindID(s.ctx, s.db, propertyName, id, aws.AWSGroup)
+	node, err := queries.GetAWSNodeByKindID(s.requestContext(c), s.db, propertyName, id, aws.AWSGroup)
 	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortNodeLookup(c, err)
+		return
 	}
 
-	members, err := queries.GetAWSGroupMembers(s.ctx, s.db, node)
+	members, err := queries.GetAWSGroupMembers(s.requestContext(c), s.db, node)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 	}
@@ -41,7 +44,7 @@ func (s *Server) GetAWSGroupPolicies(c *gin.Context) {
 	propertyName := "groupid"
 	id := c.Param(propertyName)
 
-	nodes, err := queries.GetPoliciesOfEntity(s.ctx, s.db, propertyName, id, aws.AWSManagedPolicy)
+	nodes, err := queries.GetPoliciesOfEntity(s.requestContext(c), s.db, propertyName, id, aws.AWSManagedPolicy)
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
 	}
@@ -54,8 +57,85 @@ func (s *Server) GetAWSGroupPolicies(c *gin.Context) {
 	c.IndentedJSON(http.StatusOK, returnValue)
 }
 
+func (s *Server) getAWSGroupResolvedPaths(c *gin.Context) (*analyze.ActionPathSet, error) {
+	groupId := c.Param("groupid")
+	node, err := queries.GetAWSNodeByKindID(s.requestContext(c), s.db, "groupid", groupId, aws.AWSGroup)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := queries.GetUnresolvedOutputPaths(s.requestContext(c), s.db, node)
+	if err != nil {
+		return nil, err
+	}
+
+	return analyze.ResolveResourceAgainstIdentityPolicies(&analyze.ActionPathSet{}, &paths)
+}
+
+func (s *Server) GetAWSGroupRSOP(c *gin.Context) {
+	resolvedPaths, err := s.getAWSGroupResolvedPaths(c)
+	if err != nil {
+		abortNodeLookup(c, err)
+		return
+	}
+
+	actionToPrin := analyze.ResourcePathSetToMap(*resolvedPaths)
+	c.IndentedJSON(http.StatusOK, actionToPrin)
+}
+
+func (s *Server) GetAWSGroupRSOPActions(c *gin.Context) {
+	resolvedPaths, err := s.getAWSGroupResolvedPaths(c)
+	if err != nil {
+		abortNodeLookup(c, err)
+		return
+	}
+
+	principalMap, err := analyze.GetActionMapFromPathSet(*resolvedPaths)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, principalMap)
+}
+
+func (s *Server) GetAWSGroupRSOPPrincipals(c *gin.Context) {
+	resolvedPaths, err := s.getAWSGroupResolvedPaths(c)
+	if err != nil {
+		abortNodeLookup(c, err)
+		return
+	}
+
+	principalMap := analyze.GetResourceArnsFromActionSet(*resolvedPaths)
+	c.IndentedJSON(http.StatusOK, principalMap)
+}
+
+func (s *Server) GetAWSGroupEffectiveRSOP(c *gin.Context) {
+	groupNode, err := queries.GetAWSNodeByKindID(s.requestContext(c), s.db, "groupid", c.Param("groupid"), aws.AWSGroup)
+	if err != nil {
+		abortNodeLookup(c, err)
+		return
+	}
+	memberNode, err := queries.GetAWSNodeByKindID(s.requestContext(c), s.db, "userid", c.Param("userid"), aws.AWSUser)
+	if err != nil {
+		abortNodeLookup(c, err)
+		return
+	}
+
+	resolvedPaths, err := queries.GetEffectiveGroupPathsForMember(s.requestContext(c), s.db, groupNode, memberNode)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	actionToPrin := analyze.ResourcePathSetToMap(*resolvedPaths)
+	c.IndentedJSON(http.StatusOK, actionToPrin)
+}
+
 func (s *Server) addGroupsEndpoints(router *gin.RouterGroup) {
 	router.GET("", s.GetAWSGroup)
 	router.GET("members", s.GetAWSGroupMembers)
 	router.GET("policies", s.GetAWSGroupPolicies)
+	router.GET("rsop", s.GetAWSGroupRSOP)
+	router.GET("rsop/actions", s.GetAWSGroupRSOPActions)
+	router.GET("rsop/principals", s.GetAWSGroupRSOPPrincipals)
+	router.GET("rsop/effective/:userid", s.GetAWSGroupEffectiveRSOP)
 }
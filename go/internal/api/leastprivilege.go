@@ -0,0 +1,77 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotnops/apeman/analyze"
+	"github.com/hotnops/apeman/go/internal/queries"
+	"github.com/hotnops/apeman/graphschema/aws"
+	"github.com/specterops/bloodhound/dawgs/graph"
+)
+
+// Generate a least privilege policy for a principal from the usage in the
+// request body, which is a CloudTrail log or an Access Advisor report
+func (s *Server) generateLeastPrivilegePolicy(c *gin.Context, node *graph.Node) {
+	ctx := s.requestContext(c)
+
+	data, err := c.GetRawData()
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	if len(data) == 0 {
+		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("a CloudTrail log or Access Advisor report is required"))
+		return
+	}
+
+	principalArn, _ := node.Properties.Get("arn").String()
+	usage, err := analyze.ParseUsage(data, principalArn)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	paths, err := queries.GetUnresolvedOutputPaths(ctx, s.db, node)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	resolvedPaths, err := analyze.ResolveResourceAgainstIdentityPolicies(&analyze.ActionPathSet{}, &paths)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	catalog, err := queries.GetActionNames(ctx, s.db)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, analyze.GenerateLeastPrivilegePolicy(principalArn, *resolvedPaths, usage, catalog))
+}
+
+func (s *Server) GenerateAWSRoleLeastPrivilegePolicy(c *gin.Context) {
+	roleId := c.Param("roleid")
+	node, err := queries.GetAWSNodeByKindID(s.requestContext(c), s.db, "roleid", roleId, aws.AWSRole)
+	if err != nil {
+		abortNodeLookup(c, err)
+		return
+	}
+
+	s.generateLeastPrivilegePolicy(c, node)
+}
+
+func (s *Server) GenerateAWSUserLeastPrivilegePolicy(c *gin.Context) {
+	userId := c.Param("userid")
+	node, err := queries.GetAWSNodeByKindID(s.requestContext(c), s.db, "userid", userId, aws.AWSUser)
+	if err != nil {
+		abortNodeLookup(c, err)
+		return
+	}
+
+	s.generateLeastPrivilegePolicy(c, node)
+}
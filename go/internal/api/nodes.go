@@ -6,7 +6,6 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/hotnops/apeman/analyze"
 	"github.com/hotnops/apeman/go/internal/queries"
 	"github.com/specterops/bloodhound/dawgs/graph"
 )
@@ -15,7 +14,7 @@ func (s *Server) GetAWSNodes(c *gin.Context) {
 	queryParams := c.Request.URL.Query()
 	var nodes []*graph.Node
 	var err error
-	nodes, err = queries.GetAllAWSNodes(s.ctx, s.db, queryParams)
+	nodes, err = queries.GetAllAWSNodes(s.requestContext(c), s.db, queryParams)
 
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
@@ -31,11 +30,13 @@ func (s *Server) GetAWSNodeByGraphID(c *gin.Context) {
 	id, err := strconv.ParseUint(idString, 10, 32)
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
+		return
 	}
 
-	node, err := analyze.GetAWSNodeByGraphID(s.ctx, s.db, graph.ID(id))
+	node, err := queries.GetAWSNodeByGraphID(s.requestContext(c), s.db, graph.ID(id))
 	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortNodeLookup(c, err)
+		return
 	}
 
 	c.IndentedJSON(http.StatusOK, node)
@@ -50,11 +51,13 @@ func (s *Server) GetAWSNodeEdges(c *gin.Context, direction graph.Direction) {
 	id, err := strconv.ParseUint(idString, 10, 32)
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
+		return
 	}
 
-	relationships, err := queries.GetAWSNodeEdges(s.ctx, s.db, graph.ID(id), direction, queryParams)
+	relationships, err := queries.GetAWSNodeEdges(s.requestContext(c), s.db, graph.ID(id), direction, queryParams)
 	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortNodeLookup(c, err)
+		return
 	}
 
 	var returnValue RelationshipResponse
@@ -72,7 +75,7 @@ func (s *Server) GetAWSNodeTags(c *gin.Context) {
 		c.AbortWithError(http.StatusBadRequest, err)
 	}
 
-	tags, err := queries.GetAWSNodeTags(s.ctx, s.db, graph.ID(nodeId))
+	tags, err := queries.GetAWSNodeTags(s.requestContext(c), s.db, graph.ID(nodeId))
 
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
@@ -87,21 +90,42 @@ func (s *Server) GetNodeIdentityPath(c *gin.Context) {
 	sourceNodeId, err := strconv.ParseUint(sourceNodeIdStr, 10, 32)
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
+		return
 	}
 	destNodeId, err := strconv.ParseInt(destNodeIdStr, 10, 32)
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
+		return
 	}
 
-	query := fmt.Sprintf("MATCH p=shortestPath((a) - [:IdentityTransform*] -> (b)) WHERE ID(a) = %d AND ID(b) = %d RETURN p", sourceNodeId, destNodeId)
-	paths, err := queries.CypherQueryPaths(s.ctx, s.db, query)
+	k, err := getPathCount(c)
 	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, err)
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	paths, err := queries.GetCheapestIdentityPaths(s.requestContext(c), s.db, graph.ID(sourceNodeId), graph.ID(destNodeId), s.pathCosts, k)
+	if err != nil {
+		abortNodeLookup(c, err)
 		return
 	}
 	c.IndentedJSON(http.StatusOK, paths)
 }
 
+// Number of paths to return per destination, taken from the k query
+// parameter. Defaults to 1.
+func getPathCount(c *gin.Context) (int, error) {
+	kStr := c.DefaultQuery("k", "1")
+	k, err := strconv.Atoi(kStr)
+	if err != nil {
+		return 0, err
+	}
+	if k < 1 {
+		return 0, fmt.Errorf("k must be at least 1")
+	}
+	return k, nil
+}
+
 func (s *Server) GetNodeShortestPath(c *gin.Context) {
 	sourceNodeIdStr := c.Param("nodeid")
 	destNodeIdStr := c.Param("destnodeid")
@@ -109,13 +133,22 @@ func (s *Server) GetNodeShortestPath(c *gin.Context) {
 	sourceNodeId, err := strconv.ParseUint(sourceNodeIdStr, 10, 32)
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
+		return
 	}
 	destNodeId, err := strconv.ParseUint(destNodeIdStr, 10, 32)
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
+		return
 	}
 
-	paths, err := queries.CypherQueryPaths(s.ctx, s.db, fmt.Sprintf("MATCH p=(a) - [*1..3] - (b) WHERE ID(a) = %d AND ID(b) = %d RETURN p", sourceNodeId, destNodeId))
+	for _, nodeId := range []uint64{sourceNodeId, destNodeId} {
+		if _, err := queries.GetAWSNodeByGraphID(s.requestContext(c), s.db, graph.ID(nodeId)); err != nil {
+			abortNodeLookup(c, err)
+			return
+		}
+	}
+
+	paths, err := queries.CypherQueryPaths(s.requestContext(c), s.db, fmt.Sprintf("MATCH p=(a) - [*1..3] - (b) WHERE ID(a) = %d AND ID(b) = %d RETURN p", sourceNodeId, destNodeId))
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
@@ -132,12 +165,81 @@ func (s *Server) GetAWSNodeOutboundEdges(c *gin.Context) {
 	s.GetAWSNodeEdges(c, graph.DirectionOutbound)
 }
 
+func (s *Server) GetNodeEscalationPaths(c *gin.Context) {
+	nodeId, err := strconv.ParseUint(c.Param("nodeid"), 10, 32)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	k, err := getPathCount(c)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	paths, err := queries.GetEscalationPaths(s.requestContext(c), s.db, graph.ID(nodeId), s.pathCosts, k)
+	if err != nil {
+		abortNodeLookup(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, paths)
+}
+
+func (s *Server) GetNodeBlastRadius(c *gin.Context) {
+	nodeId, err := strconv.ParseUint(c.Param("nodeid"), 10, 32)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	node, err := queries.GetAWSNodeByGraphID(s.requestContext(c), s.db, graph.ID(nodeId))
+	if err != nil {
+		abortNodeLookup(c, err)
+		return
+	}
+
+	blastRadius, err := queries.GetBlastRadius(s.requestContext(c), s.db, node)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, blastRadius)
+}
+
+func (s *Server) setAWSNodeTierZero(c *gin.Context, manual bool) {
+	nodeId, err := strconv.ParseUint(c.Param("nodeid"), 10, 32)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	err = queries.SetTierZeroManual(s.requestContext(c), s.db, graph.ID(nodeId), manual)
+	if err != nil {
+		abortNodeLookup(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) MarkAWSNodeTierZero(c *gin.Context) {
+	s.setAWSNodeTierZero(c, true)
+}
+
+func (s *Server) UnmarkAWSNodeTierZero(c *gin.Context) {
+	s.setAWSNodeTierZero(c, false)
+}
+
 func (s *Server) addNodeEndpoints(router *gin.RouterGroup) {
 
 	router.GET("", s.GetAWSNodeByGraphID)
 	router.GET("shortestpath/:destnodeid", s.GetNodeShortestPath)
 	router.GET("identitypath/:destnodeid", s.GetNodeIdentityPath)
+	router.GET("escalation", s.GetNodeEscalationPaths)
+	router.GET("blastradius", s.GetNodeBlastRadius)
 	router.GET("inboundedges", s.GetAWSNodeInboundEdges)
 	router.GET("outboundedges", s.GetAWSNodeOutboundEdges)
 	router.GET("tags", s.GetAWSNodeTags)
+	router.POST("tierzero", s.MarkAWSNodeTierZero)
+	router.DELETE("tierzero", s.UnmarkAWSNodeTierZero)
 }
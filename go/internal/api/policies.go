@@ -11,7 +11,7 @@ import (
 func (s *Server) GenerateInlinePolicy(c *gin.Context) {
 	policyHash := c.Param("policyhash")
 
-	policyObject, err := queries.GenerateInlinePolicyObject(s.ctx, s.db, policyHash)
+	policyObject, err := queries.GenerateInlinePolicyObject(s.requestContext(c), s.db, policyHash)
 
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
@@ -23,7 +23,7 @@ func (s *Server) GenerateInlinePolicy(c *gin.Context) {
 func (s *Server) GenerateManagedPolicy(c *gin.Context) {
 	policyId := c.Param("policyid")
 
-	policyObject, err := queries.GenerateManagedPolicyObject(s.ctx, s.db, policyId)
+	policyObject, err := queries.GenerateManagedPolicyObject(s.requestContext(c), s.db, policyId)
 
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
@@ -36,12 +36,13 @@ func (s *Server) GetAWSInlinePolicyNodes(c *gin.Context) {
 	propertyName := "policyhash"
 	policyId := c.Param(propertyName)
 
-	policyNode, err := queries.GetAWSNodeByKindID(s.ctx, s.db, "hash", policyId, aws.AWSInlinePolicy)
+	policyNode, err := queries.GetAWSNodeByKindID(s.requestContext(c), s.db, "hash", policyId, aws.AWSInlinePolicy)
 	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortNodeLookup(c, err)
+		return
 	}
 
-	paths, err := queries.GetNodesOfPolicy(s.ctx, s.db, policyNode.ID)
+	paths, err := queries.GetNodesOfPolicy(s.requestContext(c), s.db, policyNode.ID)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 	}
@@ -53,12 +54,13 @@ func (s *Server) GetAWSManagedPolicyNodes(c *gin.Context) {
 	propertyName := "policyid"
 	policyId := c.Param(propertyName)
 
-	policyNode, err := queries.GetAWSNodeByKindID(s.ctx, s.db, propertyName, policyId, aws.AWSManagedPolicy)
+	policyNode, err := queries.GetAWSNodeByKindID(s.requestContext(c), s.db, propertyName, policyId, aws.AWSManagedPolicy)
 	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortNodeLookup(c, err)
+		return
 	}
 
-	paths, err := queries.GetNodesOfPolicy(s.ctx, s.db, policyNode.ID)
+	paths, err := queries.GetNodesOfPolicy(s.requestContext(c), s.db, policyNode.ID)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 	}
@@ -70,9 +72,10 @@ func (s *Server) GetAWSPolicy(c *gin.Context) {
 	propertyName := "policyid"
 	id := c.Param(propertyName)
 
-	nodes, err := queries.GetAWSNodeByKindID(s.ctx, s.db, propertyName, id, aws.AWSManagedPolicy)
+	nodes, err := queries.GetAWSNodeByKindID(s.requestContext(c), s.db, propertyName, id, aws.AWSManagedPolicy)
 	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortNodeLookup(c, err)
+		return
 	}
 
 	c.IndentedJSON(http.StatusOK, nodes)
@@ -82,7 +85,7 @@ func (s *Server) GetAWSPolicyPrincipals(c *gin.Context) {
 	propertyName := "policyid"
 	policyId := c.Param(propertyName)
 
-	principals, err := queries.GetPrincipalsOfPolicy(s.ctx, s.db, policyId)
+	principals, err := queries.GetPrincipalsOfPolicy(s.requestContext(c), s.db, policyId)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 	}
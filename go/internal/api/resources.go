@@ -18,9 +18,10 @@ func (s *Server) GetAWSResource(c *gin.Context) {
 	if arnString, err := DecodeArn((encodedArn)); err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
 	} else {
-		nodes, err := queries.GetAWSNodeByKindID(s.ctx, s.db, propertyName, arnString, aws.UniqueArn)
+		nodes, err := queries.GetAWSNodeByKindID(s.requestContext(c), s.db, propertyName, arnString, aws.UniqueArn)
 		if err != nil {
-			c.AbortWithError(http.StatusBadRequest, err)
+			abortNodeLookup(c, err)
+			return
 		}
 		c.IndentedJSON(http.StatusOK, nodes)
 	}
@@ -33,7 +34,7 @@ func (s *Server) GetAWSResourceActions(c *gin.Context) {
 	if arnString, err := DecodeArn((encodedArn)); err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
 	} else {
-		nodes, err := queries.GetResouceActions(s.ctx, s.db, arnString)
+		nodes, err := queries.GetResouceActions(s.requestContext(c), s.db, arnString)
 		if err != nil {
 			c.AbortWithError(http.StatusBadRequest, err)
 		}
@@ -49,7 +50,7 @@ func (s *Server) GetAWSInboundPrincipalsWithActionOnArn(c *gin.Context, actionNa
 		c.AbortWithError(http.StatusBadRequest, err)
 	} else {
 		// All the paths that act on this resource
-		identityPaths, err := queries.GetAllUnresolvedIdentityPolicyPathsOnArnWithAction(s.ctx, s.db, arnString, actionName)
+		identityPaths, err := queries.GetAllUnresolvedIdentityPolicyPathsOnArnWithAction(s.requestContext(c), s.db, arnString, actionName)
 		if err != nil {
 			c.AbortWithError(http.StatusBadRequest, err)
 		}
@@ -70,9 +71,10 @@ func (s *Server) GetAWSInboundPrincipalsWithActionOnArn(c *gin.Context, actionNa
 			principalNodes := []*graph.Node{}
 
 			for _, id := range principalsIDs {
-				node, err := analyze.GetAWSNodeByGraphID(s.ctx, s.db, id)
+				node, err := queries.GetAWSNodeByGraphID(s.requestContext(c), s.db, id)
 				if err != nil {
-					c.AbortWithError(http.StatusBadRequest, err)
+					abortNodeLookup(c, err)
+					return
 				}
 				principalNodes = append(principalNodes, node)
 			}
@@ -81,18 +83,54 @@ func (s *Server) GetAWSInboundPrincipalsWithActionOnArn(c *gin.Context, actionNa
 	}
 }
 
+func (s *Server) GetAWSResourceTransitiveInboundPermissions(c *gin.Context) {
+	arnString, err := DecodeArn(c.Param("arn"))
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	var identityPaths *analyze.ActionPathSet
+	if action := c.Query("actionName"); action != "" {
+		identityPaths, err = queries.GetAllUnresolvedIdentityPolicyPathsOnArnWithAction(s.requestContext(c), s.db, arnString, action)
+	} else {
+		identityPaths, err = queries.GetAllUnresolvedIdentityPolicyPathsOnArn(s.requestContext(c), s.db, arnString)
+	}
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	resolvedPaths, err := analyze.ResolveResourceAgainstIdentityPolicies(&analyze.ActionPathSet{}, identityPaths)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	// Walk back from every principal with direct access
+	inboundPaths, err := queries.GetInboundIdentityPaths(s.requestContext(c), s.db, analyze.GetPrincipalNodeIDsFromActionSet(*resolvedPaths))
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, analyze.GetInboundExposures(*resolvedPaths, inboundPaths))
+}
+
 func (s *Server) GetAWSResourceInboundPermissions(c *gin.Context) {
 	propertyName := "arn"
 	encodedArn := c.Param(propertyName)
 	action := c.Query("actionName")
-	if action != "" {
+	if c.Query("transitive") == "true" {
+		s.GetAWSResourceTransitiveInboundPermissions(c)
+	} else if action != "" {
 		s.GetAWSInboundPrincipalsWithActionOnArn(c, action)
 	} else {
 		if arnString, err := DecodeArn((encodedArn)); err != nil {
 			c.AbortWithError(http.StatusBadRequest, err)
 		} else {
 			// All the paths that act on this resource
-			identityPaths, err := queries.GetAllUnresolvedIdentityPolicyPathsOnArn(s.ctx, s.db, arnString)
+			identityPaths, err := queries.GetAllUnresolvedIdentityPolicyPathsOnArn(s.requestContext(c), s.db, arnString)
 			if err != nil {
 				c.AbortWithError(http.StatusBadRequest, err)
 			}
@@ -126,7 +164,7 @@ func (s *Server) GetAWSResourceInboundPermissionsPrincipals(c *gin.Context) {
 		c.AbortWithError(http.StatusBadRequest, err)
 	} else {
 		// All the paths that act on this resource
-		identityPaths, err := queries.GetAllUnresolvedIdentityPolicyPathsOnArn(s.ctx, s.db, arnString)
+		identityPaths, err := queries.GetAllUnresolvedIdentityPolicyPathsOnArn(s.requestContext(c), s.db, arnString)
 		if err != nil {
 			c.AbortWithError(http.StatusBadRequest, err)
 		}
@@ -170,7 +208,7 @@ func (s *Server) GetActionsWithPrincipalOnResource(c *gin.Context) {
 		c.AbortWithError(http.StatusBadRequest, err)
 	}
 
-	identityPaths, err := queries.GetAllUnresolvedIdentityPolicyPathsOnArnFromArn(s.ctx, s.db, resourceArn, principalArn)
+	identityPaths, err := queries.GetAllUnresolvedIdentityPolicyPathsOnArnFromArn(s.requestContext(c), s.db, resourceArn, principalArn)
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
 	}
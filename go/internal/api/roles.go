@@ -1,7 +1,6 @@
 package api
 
 import (
-	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -14,7 +13,7 @@ func (s *Server) GenerateAssumeRolePolicy(c *gin.Context) {
 	propertyName := "roleid"
 	id := c.Param(propertyName)
 
-	policy, err := queries.GenerateAssumeRolePolicy(s.ctx, s.db, id)
+	policy, err := queries.GenerateAssumeRolePolicy(s.requestContext(c), s.db, id)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 	}
@@ -27,9 +26,10 @@ func (s *Server) GetAWSRole(c *gin.Context) {
 	propertyName := "roleid"
 	id := c.Param(propertyName)
 
-	nodes, err := queries.GetAWSNodeByKindID(s.ctx, s.db, propertyName, id, aws.AWSRole)
+	nodes, err := queries.GetAWSNodeByKindID(s.requestContext(c), s.db, propertyName, id, aws.AWSRole)
 	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortNodeLookup(c, err)
+		return
 	}
 
 	c.IndentedJSON(http.StatusOK, nodes)
@@ -43,7 +43,7 @@ func (s *Server) GetAWSRoleManagedPolicies(c *gin.Context) {
 	propertyName := "roleid"
 	id := c.Param(propertyName)
 
-	nodes, err := queries.GetPoliciesOfEntity(s.ctx, s.db, propertyName, id, aws.AWSManagedPolicy)
+	nodes, err := queries.GetPoliciesOfEntity(s.requestContext(c), s.db, propertyName, id, aws.AWSManagedPolicy)
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
 	}
@@ -60,10 +60,12 @@ func (s *Server) GetAWSRoleOutboundRoles(c *gin.Context) {
 
 	roleId := c.Param("roleid")
 
-	//paths, err := queries.GetAWSRoleInboundRoleAssumptionPaths(s.ctx, s.db, roleId)
-	query := "MATCH p=(a:AWSRole) - [:IdentityTransform*] -> (b:AWSRole) WHERE a.roleid = '%s' AND ALL(n IN nodes(p) WHERE SINGLE(x IN nodes(p) WHERE x = n)) RETURN p"
-	query = fmt.Sprintf(query, roleId)
-	paths, err := queries.CypherQueryPaths(s.ctx, s.db, query)
+	node, err := queries.GetAWSNodeByKindID(s.requestContext(c), s.db, "roleid", roleId, aws.AWSRole)
+	if err != nil {
+		abortNodeLookup(c, err)
+		return
+	}
+	paths, err := queries.GetOutboundIdentityPaths(s.requestContext(c), s.db, node.ID, aws.AWSRole)
 
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
@@ -75,8 +77,8 @@ func (s *Server) GetAWSRoleOutboundRoles(c *gin.Context) {
 func (s *Server) GetInboundRoles(c *gin.Context) {
 	roleId := c.Param("roleid")
 
-	//paths, err := queries.GetAWSRoleInboundRoleAssumptionPaths(s.ctx, s.db, roleId)
-	paths, err := queries.GetInboundRolePaths(s.ctx, s.db, roleId)
+	//paths, err := queries.GetAWSRoleInboundRoleAssumptionPaths(s.requestContext(c), s.db, roleId)
+	paths, err := queries.GetInboundRolePaths(s.requestContext(c), s.db, roleId)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 	}
@@ -85,11 +87,12 @@ func (s *Server) GetInboundRoles(c *gin.Context) {
 
 func (s *Server) GetAWSRoleRSOP(c *gin.Context) {
 	roleId := c.Param("roleid")
-	node, err := queries.GetAWSNodeByKindID(s.ctx, s.db, "roleid", roleId, aws.AWSRole)
+	node, err := queries.GetAWSNodeByKindID(s.requestContext(c), s.db, "roleid", roleId, aws.AWSRole)
 	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortNodeLookup(c, err)
+		return
 	}
-	paths, err := queries.GetUnresolvedOutputPaths(s.ctx, s.db, node)
+	paths, err := queries.GetUnresolvedOutputPaths(s.requestContext(c), s.db, node)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 	}
@@ -106,11 +109,12 @@ func (s *Server) GetAWSRoleRSOP(c *gin.Context) {
 
 func (s *Server) GetAWSRoleRSOPActions(c *gin.Context) {
 	roleId := c.Param("roleid")
-	node, err := queries.GetAWSNodeByKindID(s.ctx, s.db, "roleid", roleId, aws.AWSRole)
+	node, err := queries.GetAWSNodeByKindID(s.requestContext(c), s.db, "roleid", roleId, aws.AWSRole)
 	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortNodeLookup(c, err)
+		return
 	}
-	paths, err := queries.GetUnresolvedOutputPaths(s.ctx, s.db, node)
+	paths, err := queries.GetUnresolvedOutputPaths(s.requestContext(c), s.db, node)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 	}
@@ -130,11 +134,12 @@ func (s *Server) GetAWSRoleRSOPActions(c *gin.Context) {
 
 func (s *Server) GetAWSRoleRSOPPrincipals(c *gin.Context) {
 	roleId := c.Param("roleid")
-	node, err := queries.GetAWSNodeByKindID(s.ctx, s.db, "roleid", roleId, aws.AWSRole)
+	node, err := queries.GetAWSNodeByKindID(s.requestContext(c), s.db, "roleid", roleId, aws.AWSRole)
 	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortNodeLookup(c, err)
+		return
 	}
-	paths, err := queries.GetUnresolvedOutputPaths(s.ctx, s.db, node)
+	paths, err := queries.GetUnresolvedOutputPaths(s.requestContext(c), s.db, node)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 	}
@@ -149,6 +154,33 @@ func (s *Server) GetAWSRoleRSOPPrincipals(c *gin.Context) {
 	c.IndentedJSON(http.StatusOK, principalMap)
 }
 
+func (s *Server) GetAWSRoleRSOPSummary(c *gin.Context) {
+	roleId := c.Param("roleid")
+	node, err := queries.GetAWSNodeByKindID(s.requestContext(c), s.db, "roleid", roleId, aws.AWSRole)
+	if err != nil {
+		abortNodeLookup(c, err)
+		return
+	}
+	paths, err := queries.GetUnresolvedOutputPaths(s.requestContext(c), s.db, node)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	resolvedPaths, err := analyze.ResolveResourceAgainstIdentityPolicies(&analyze.ActionPathSet{}, &paths)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	summary, err := queries.GetServiceAccessSummary(s.requestContext(c), s.db, resolvedPaths)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, summary)
+}
+
 func (s *Server) addRoleEndpoints(roles *gin.RouterGroup) {
 	roles.GET("", s.GetAWSRole)
 	roles.GET("managedpolicies", s.GetAWSRoleManagedPolicies)
@@ -159,4 +191,7 @@ func (s *Server) addRoleEndpoints(roles *gin.RouterGroup) {
 	roles.GET("rsop", s.GetAWSRoleRSOP)
 	roles.GET("rsop/principals", s.GetAWSRoleRSOPPrincipals)
 	roles.GET("rsop/actions", s.GetAWSRoleRSOPActions)
+	roles.GET("rsop/summary", s.GetAWSRoleRSOPSummary)
+	roles.GET("rsop/usage", s.GetAWSRoleRSOPUsage)
+	roles.POST("leastprivilege", s.GenerateAWSRoleLeastPrivilegePolicy)
 }
@@ -8,13 +8,15 @@ package api
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
-	"sync"
 
 	"github.com/gin-gonic/gin"
+	"github.com/hotnops/apeman/analyze"
 	"github.com/hotnops/apeman/go/internal/config"
+	"github.com/hotnops/apeman/go/internal/daemon"
 	"github.com/hotnops/apeman/go/internal/queries"
 	"github.com/hotnops/apeman/graphschema/aws"
 	"github.com/specterops/bloodhound/dawgs"
@@ -26,9 +28,15 @@ import (
 var API_VERSION string = "v1.0"
 
 type Server struct {
-	db     graph.Database
-	ctx    context.Context
-	config dawgs.Config
+	db             graph.Database
+	ctx            context.Context
+	config         dawgs.Config
+	cfg            config.Configuration
+	vendors        analyze.VendorRegistry
+	pathCosts      analyze.PathCosts
+	findingOptions analyze.FindingOptions
+	rules          []analyze.Rule
+	daemon         *daemon.Daemon
 }
 
 type RelationshipResponse struct {
@@ -50,11 +58,13 @@ func (s *Server) GetAWSRelationshipByGraphID(c *gin.Context) {
 	id, err := strconv.Atoi(idString)
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
+		return
 	}
 
-	relationship, err := queries.GetAWSRelationshipByGraphID(s.ctx, s.db, graph.ID(id))
+	relationship, err := queries.GetAWSRelationshipByGraphID(s.requestContext(c), s.db, graph.ID(id))
 	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortNodeLookup(c, err)
+		return
 	}
 
 	c.IndentedJSON(http.StatusOK, relationship)
@@ -63,7 +73,7 @@ func (s *Server) GetAWSRelationshipByGraphID(c *gin.Context) {
 
 func (s *Server) GetPrincipalInlinePolicy(c *gin.Context, propertyName string, id string) {
 
-	nodes, err := queries.GetPoliciesOfEntity(s.ctx, s.db, propertyName, id, aws.AWSInlinePolicy)
+	nodes, err := queries.GetPoliciesOfEntity(s.requestContext(c), s.db, propertyName, id, aws.AWSInlinePolicy)
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
 	}
@@ -74,6 +84,16 @@ func (s *Server) GetPrincipalInlinePolicy(c *gin.Context, propertyName string, i
 	c.IndentedJSON(http.StatusOK, nodes.Slice()[0])
 }
 
+// Abort a request after looking up a node failed. Nodes that don't exist,
+// or belong to another collection, aren't found.
+func abortNodeLookup(c *gin.Context, err error) {
+	if errors.Is(err, queries.ErrNotFound) {
+		c.AbortWithError(http.StatusNotFound, err)
+	} else {
+		c.AbortWithError(http.StatusInternalServerError, err)
+	}
+}
+
 func DecodeArn(encodedArn string) (string, error) {
 	arn, err := base64.URLEncoding.DecodeString(encodedArn)
 	if err != nil {
@@ -102,7 +122,7 @@ func corsMiddleware() gin.HandlerFunc {
 func (s *Server) PostQuery(c *gin.Context) {
 	query := c.PostForm("query")
 
-	if response, err := queries.CypherQueryPaths(s.ctx, s.db, query); err != nil {
+	if response, err := queries.CypherQueryPaths(s.requestContext(c), s.db, query); err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 	} else {
 		c.JSON(http.StatusOK, response)
@@ -117,7 +137,7 @@ func (s *Server) Search(c *gin.Context) {
 		c.Abort()
 	}
 
-	if response, err := queries.Search(s.ctx, s.db, searchString); err != nil {
+	if response, err := queries.Search(s.requestContext(c), s.db, searchString); err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 	} else {
 		c.JSON(http.StatusOK, response)
@@ -125,28 +145,10 @@ func (s *Server) Search(c *gin.Context) {
 }
 
 func (s *Server) AnalyzeIdentityTransforms(c *gin.Context) {
-	wg := sync.WaitGroup{}
-
-	// go func1() {
-	// 	defer wg.Done()
-	// 	queries.CreateAssumeRoleEdges(s.ctx, s.db, &wg)
-	// }()
-	// go
-	wg.Add(3)
-	go func() {
-		defer wg.Done()
-		queries.CreateAssumeRoleEdges(s.ctx, s.db)
-	}()
-	go func() {
-		defer wg.Done()
-		queries.CreateUpdateAssumeRoleEdges(s.ctx, s.db)
-	}()
-	go func() {
-		defer wg.Done()
-		queries.CreateCreateAccessKeyEdges(s.ctx, s.db)
-	}()
-
-	wg.Wait()
+	if err := queries.CreateIdentityTransformEdges(s.requestContext(c), s.db); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
 	c.Done()
 }
 
@@ -160,40 +162,75 @@ func (s *Server) GetNodePermissionPath(c *gin.Context) {
 	sourceNodeId, err := strconv.ParseUint(sourceNodeIdStr, 10, 32)
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
+		return
 	}
 	destNodeId, err := strconv.ParseInt(destNodeIdStr, 10, 32)
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
+		return
 	}
 
-	paths, err := queries.GetNodePermissionPath(s.ctx, s.db, graph.ID(sourceNodeId), graph.ID(destNodeId), action)
+	if _, err := queries.GetAWSNodeByGraphID(s.requestContext(c), s.db, graph.ID(sourceNodeId)); err != nil {
+		abortNodeLookup(c, err)
+		return
+	}
+
+	paths, err := queries.GetNodePermissionPath(s.requestContext(c), s.db, graph.ID(sourceNodeId), graph.ID(destNodeId), action)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
+		return
 	}
 	c.IndentedJSON(http.StatusOK, paths)
 }
 
+// Register the routes that can be confined to a collection
+func (s *Server) addGraphEndpoints(routes *gin.RouterGroup) {
+	s.addRoleEndpoints(routes.Group("/roles/:roleid"))
+	s.addUserEndpoints(routes.Group("/users/:userid"))
+	s.addResourceEndpoints(routes.Group("/resources/:arn"))
+	s.addStatementEndpoints(routes.Group("/statements/:statementhash"))
+	s.addManagedPoliciesEndpoints(routes.Group("/managedpolicies/:policyid"))
+	s.addInlinePoliciesEndpoints(routes.Group("/inlinepolicies/:policyhash"))
+	s.addNodeEndpoints(routes.Group("/nodes/:nodeid"))
+	s.addGroupsEndpoints(routes.Group("/groups/:groupid"))
+	s.addAccountsEndpoints(routes.Group("/accounts/:accountid"))
+	s.addActionsEndpoints(routes.Group("/actions/:actionname"))
+
+	routes.GET("/nodes", s.GetAWSNodes)
+	routes.GET("/accounts", s.GetAWSAccountIDs)
+
+	routes.GET("/permissionpath/:sourcenodeid/:destnodeid", s.GetNodePermissionPath)
+	routes.GET("/relationship/:relationshipid", s.GetAWSRelationshipByGraphID)
+	routes.GET("/analyze/expand", s.ExpandGraph)
+	routes.GET("/analyze/identitytransforms", s.AnalyzeIdentityTransforms)
+	routes.GET("/analyze/confuseddeputy", s.GetConfusedDeputyStatements)
+	routes.GET("/analyze/public", s.GetPublicExposures)
+	routes.GET("/analyze/tierzero", s.AnalyzeTierZero)
+	routes.GET("/analyze/findings", s.AnalyzeFindings)
+	routes.POST("/analyze/alerts", s.AnalyzeEscalationAlerts)
+	routes.POST("/ingest/cloudtrail", s.IngestCloudTrail)
+	routes.POST("/terraform/impact", s.GetTerraformImpact)
+	routes.GET("/findings", s.GetFindings)
+	routes.GET("/findings/checks", s.GetFindingChecks)
+	routes.POST("/snapshots", s.CreateSnapshot)
+	routes.GET("/snapshots", s.GetSnapshots)
+	routes.GET("/snapshots/:name", s.GetSnapshot)
+	routes.GET("/diff", s.GetSnapshotDiff)
+	routes.GET("/access", s.GetEffectiveAccess)
+	routes.GET("/accounts/external", s.GetExternalAccounts)
+	routes.GET("/search", s.Search)
+}
+
 func (s *Server) handleRequests() {
 	router := gin.Default()
 	router.Use(corsMiddleware())
-	s.addRoleEndpoints(router.Group("/roles/:roleid"))
-	s.addUserEndpoints(router.Group("/users/:userid"))
-	s.addResourceEndpoints(router.Group("/resources/:arn"))
-	s.addStatementEndpoints(router.Group("/statements/:statementhash"))
-	s.addManagedPoliciesEndpoints(router.Group("/managedpolicies/:policyid"))
-	s.addInlinePoliciesEndpoints(router.Group("/inlinepolicies/:policyhash"))
-	s.addNodeEndpoints(router.Group("/nodes/:nodeid"))
-	s.addGroupsEndpoints(router.Group("/groups/:groupid"))
-	s.addAccountsEndpoints(router.Group("/accounts/:accountid"))
-	s.addActionsEndpoints(router.Group("/actions/:actionname"))
-
-	router.GET("/nodes", s.GetAWSNodes)
-	router.GET("/accounts", s.GetAWSAccountIDs)
-
-	router.GET("/permissionpath/:sourcenodeid/:destnodeid", s.GetNodePermissionPath)
-	router.GET("/relationship/:relationshipid", s.GetAWSRelationshipByGraphID)
-	router.GET("/analyze/identitytransforms", s.AnalyzeIdentityTransforms)
-	router.GET("/search", s.Search)
+	s.addGraphEndpoints(&router.RouterGroup)
+	s.addGraphEndpoints(router.Group("/collections/:collection", collectionMiddleware()))
+
+	router.GET("/collections", s.GetCollections)
+	router.GET("/runs", s.GetRuns)
+	router.POST("/runs", s.CreateRun)
+	// Raw queries can't be confined to a collection
 	router.POST("/query", s.PostQuery)
 	router.Run("0.0.0.0:4400")
 }
@@ -220,6 +257,54 @@ func (s *Server) InitializeServer() {
 		Neo4J: config.DatabaseConfiguration{
 			Connection: "neo4j://a:b@neo4j:7687/",
 		},
+		Apeman: config.ApemanConfiguration{
+			Daemon: config.DaemonConfiguration{
+				PollInterval: 60,
+				IngestCommands: [][]string{
+					{"python", "-m", "ingest.ingest", "-d", "-c", "{collection}"},
+					{"python", "-m", "ingest.ingest", "-i", "{input}", "-o", "{import}", "-c", "{collection}"},
+				},
+				ImportDirectory: "../import",
+				Snapshot:        true,
+				Collection:      queries.DefaultCollection,
+			},
+		},
+	}
+
+	err = config.ApplyConfigurationFile(config.ApemanConfigurationPath(), &bhCfg)
+	if err != nil {
+		log.Fatalf("Failed to read configuration: %s", err.Error())
+	}
+	s.cfg = bhCfg
+
+	s.vendors, err = analyze.LoadVendorRegistry(bhCfg.Apeman.VendorsFile)
+	if err != nil {
+		log.Fatalf("Failed to load vendors: %s", err.Error())
+	}
+
+	s.rules, err = analyze.LoadRules(bhCfg.Apeman.RulesDirectory)
+	if err != nil {
+		log.Fatalf("Failed to load rules: %s", err.Error())
+	}
+	log.Printf("[*] Loaded %d finding rules", len(s.rules))
+
+	s.findingOptions, err = analyze.NewFindingOptions(bhCfg.Apeman.UnusedPermissionDays)
+	if err != nil {
+		log.Fatalf("Invalid finding options: %s", err.Error())
+	}
+
+	s.pathCosts, err = analyze.DefaultPathCosts().Merge(analyze.PathCostOverrides{
+		Transforms:  bhCfg.Apeman.PathCosts.Transforms,
+		Default:     bhCfg.Apeman.PathCosts.Default,
+		Conditional: bhCfg.Apeman.PathCosts.Conditional,
+	})
+	if err != nil {
+		log.Fatalf("Invalid path costs: %s", err.Error())
+	}
+
+	s.daemon, err = daemon.New(bhCfg.Apeman.Daemon, bhCfg.RunDirectory(), s.analysisSteps())
+	if err != nil {
+		log.Fatalf("Failed to configure daemon: %s", err.Error())
 	}
 
 	s.config = dawgs.Config{
@@ -231,6 +316,12 @@ func (s *Server) InitializeServer() {
 	if err != nil {
 		log.Fatalf("Failed to open graph database")
 	}
+
+	// Impact analyses delete their collection when they finish, which
+	// doesn't happen if the server stopped during one
+	if err := queries.DeleteScratchCollections(s.ctx, s.db); err != nil {
+		log.Printf("[!] Failed deleting stale impact collections: %s", err.Error())
+	}
 }
 
 func (s *Server) Start() {
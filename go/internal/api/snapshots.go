@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotnops/apeman/analyze"
+	"github.com/hotnops/apeman/go/internal/queries"
+)
+
+func (s *Server) CreateSnapshot(c *gin.Context) {
+	name := c.DefaultQuery("name", analyze.DefaultSnapshotName(time.Now()))
+	if err := analyze.ValidateSnapshotName(name); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	snapshot, err := queries.CaptureSnapshot(s.requestContext(c), s.db, name)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := analyze.SaveSnapshot(s.snapshotDirectory(s.requestContext(c)), snapshot); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, analyze.SnapshotInfo{
+		Name:      snapshot.Name,
+		CreatedAt: snapshot.CreatedAt,
+	})
+}
+
+func (s *Server) GetSnapshots(c *gin.Context) {
+	snapshots, err := analyze.ListSnapshots(s.snapshotDirectory(s.requestContext(c)))
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, snapshots)
+}
+
+func (s *Server) GetSnapshot(c *gin.Context) {
+	snapshot, err := analyze.LoadSnapshot(s.snapshotDirectory(s.requestContext(c)), c.Param("name"))
+	if err != nil {
+		c.AbortWithError(http.StatusNotFound, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, snapshot)
+}
+
+// Compare two snapshots. Without a to snapshot, from is compared against
+// the current graph.
+func (s *Server) GetSnapshotDiff(c *gin.Context) {
+	fromName := c.Query("from")
+	toName := c.Query("to")
+	if fromName == "" {
+		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("from is required"))
+		return
+	}
+
+	from, err := analyze.LoadSnapshot(s.snapshotDirectory(s.requestContext(c)), fromName)
+	if err != nil {
+		c.AbortWithError(http.StatusNotFound, err)
+		return
+	}
+
+	var to *analyze.Snapshot
+	if toName == "" {
+		to, err = queries.CaptureSnapshot(s.requestContext(c), s.db, "current")
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+	} else {
+		to, err = analyze.LoadSnapshot(s.snapshotDirectory(s.requestContext(c)), toName)
+		if err != nil {
+			c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+	}
+
+	c.IndentedJSON(http.StatusOK, analyze.DiffSnapshots(from, to))
+}
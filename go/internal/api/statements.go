@@ -10,7 +10,7 @@ import (
 func (s *Server) GenerateStatement(c *gin.Context) {
 	statementHash := c.Param("statementhash")
 
-	statementNode, err := queries.GetAllAWSNodes(s.ctx, s.db, map[string][]string{"hash": {statementHash}})
+	statementNode, err := queries.GetAllAWSNodes(s.requestContext(c), s.db, map[string][]string{"hash": {statementHash}})
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 	}
@@ -18,7 +18,7 @@ func (s *Server) GenerateStatement(c *gin.Context) {
 		c.IndentedJSON(http.StatusOK, nil)
 	}
 
-	statementObject, err := queries.GenerateStatementObject(s.ctx, s.db, *statementNode[0])
+	statementObject, err := queries.GenerateStatementObject(s.requestContext(c), s.db, *statementNode[0])
 
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
@@ -30,7 +30,7 @@ func (s *Server) GenerateStatement(c *gin.Context) {
 func (s *Server) GetStatementPolicies(c *gin.Context) {
 	statementHash := c.Param("statementhash")
 
-	paths, err := queries.GetPoliciesAttachedToStatement(s.ctx, s.db, statementHash)
+	paths, err := queries.GetPoliciesAttachedToStatement(s.requestContext(c), s.db, statementHash)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 	}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotnops/apeman/analyze"
+	"github.com/hotnops/apeman/go/internal/queries"
+)
+
+// Report how the Terraform plan in the request body, the output of
+// terraform show -json, would change the attack paths of the collection.
+// The account query parameter sets the account created principals belong
+// to when it can't be worked out from the plan or the graph.
+func (s *Server) GetTerraformImpact(c *gin.Context) {
+	data, err := c.GetRawData()
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	if len(data) == 0 {
+		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("a Terraform plan is required"))
+		return
+	}
+
+	changes, skipped, err := analyze.ParseTerraformPlan(data)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	report, err := queries.AnalyzeTerraformImpact(s.requestContext(c), s.db, changes, skipped, c.Query("account"))
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, report)
+}
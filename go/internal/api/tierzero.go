@@ -0,0 +1,18 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotnops/apeman/go/internal/queries"
+)
+
+func (s *Server) AnalyzeTierZero(c *gin.Context) {
+	entries, err := queries.AnalyzeTierZero(s.requestContext(c), s.db)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, entries)
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotnops/apeman/go/internal/queries"
+)
+
+func (s *Server) GetConfusedDeputyStatements(c *gin.Context) {
+	entries, err := queries.GetConfusedDeputyStatements(s.requestContext(c), s.db)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, entries)
+}
+
+func (s *Server) GetExternalAccounts(c *gin.Context) {
+	accounts, err := queries.GetExternalAccountTrusts(s.requestContext(c), s.db, s.vendors)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, accounts)
+}
+
+func (s *Server) GetPublicExposures(c *gin.Context) {
+	exposures, err := queries.GetPublicExposures(s.requestContext(c), s.db)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, exposures)
+}
@@ -14,9 +14,10 @@ func (s *Server) GetAWSUser(c *gin.Context) {
 	propertyName := "userid"
 	id := c.Param(propertyName)
 
-	nodes, err := queries.GetAWSNodeByKindID(s.ctx, s.db, propertyName, id, aws.AWSUser)
+	nodes, err := queries.GetAWSNodeByKindID(s.requestContext(c), s.db, propertyName, id, aws.AWSUser)
 	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortNodeLookup(c, err)
+		return
 	}
 
 	c.IndentedJSON(http.StatusOK, nodes)
@@ -30,7 +31,7 @@ func (s *Server) GetAWSUserManagedPolicies(c *gin.Context) {
 	propertyName := "userid"
 	id := c.Param(propertyName)
 
-	nodes, err := queries.GetPoliciesOfEntity(s.ctx, s.db, propertyName, id, aws.AWSManagedPolicy)
+	nodes, err := queries.GetPoliciesOfEntity(s.requestContext(c), s.db, propertyName, id, aws.AWSManagedPolicy)
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
 	}
@@ -48,10 +49,10 @@ func (s *Server) GetAWSUserOutboundRoles(c *gin.Context) {
 
 	roleId := c.Param("userid")
 
-	//paths, err := queries.GetAWSRoleInboundRoleAssumptionPaths(s.ctx, s.db, roleId)
+	//paths, err := queries.GetAWSRoleInboundRoleAssumptionPaths(s.requestContext(c), s.db, roleId)
 	query := "MATCH p=(a:AWSUser) - [:IdentityTransform* {name: 'sts:assumerole'}] -> (b:AWSRole) WHERE a.userid = '%s' AND ALL(n IN nodes(p) WHERE SINGLE(x IN nodes(p) WHERE x = n)) RETURN p"
 	query = fmt.Sprintf(query, roleId)
-	paths, err := queries.CypherQueryPaths(s.ctx, s.db, query)
+	paths, err := queries.CypherQueryPaths(s.requestContext(c), s.db, query)
 
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
@@ -62,11 +63,12 @@ func (s *Server) GetAWSUserOutboundRoles(c *gin.Context) {
 
 func (s *Server) GetAWSUserRSOP(c *gin.Context) {
 	userId := c.Param("userid")
-	node, err := queries.GetAWSNodeByKindID(s.ctx, s.db, "userid", userId, aws.AWSUser)
+	node, err := queries.GetAWSNodeByKindID(s.requestContext(c), s.db, "userid", userId, aws.AWSUser)
 	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortNodeLookup(c, err)
+		return
 	}
-	paths, err := queries.GetUnresolvedOutputPaths(s.ctx, s.db, node)
+	paths, err := queries.GetUnresolvedOutputPaths(s.requestContext(c), s.db, node)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 	}
@@ -83,11 +85,12 @@ func (s *Server) GetAWSUserRSOP(c *gin.Context) {
 
 func (s *Server) GetAWSUserRSOPActions(c *gin.Context) {
 	userId := c.Param("userid")
-	node, err := queries.GetAWSNodeByKindID(s.ctx, s.db, "userid", userId, aws.AWSUser)
+	node, err := queries.GetAWSNodeByKindID(s.requestContext(c), s.db, "userid", userId, aws.AWSUser)
 	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, err)
+		abortNodeLookup(c, err)
+		return
 	}
-	paths, err := queries.GetUnresolvedOutputPaths(s.ctx, s.db, node)
+	paths, err := queries.GetUnresolvedOutputPaths(s.requestContext(c), s.db, node)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 	}
@@ -105,11 +108,41 @@ func (s *Server) GetAWSUserRSOPActions(c *gin.Context) {
 	c.IndentedJSON(http.StatusOK, principalMap)
 }
 
+func (s *Server) GetAWSUserRSOPSummary(c *gin.Context) {
+	userId := c.Param("userid")
+	node, err := queries.GetAWSNodeByKindID(s.requestContext(c), s.db, "userid", userId, aws.AWSUser)
+	if err != nil {
+		abortNodeLookup(c, err)
+		return
+	}
+	paths, err := queries.GetUnresolvedOutputPaths(s.requestContext(c), s.db, node)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	resolvedPaths, err := analyze.ResolveResourceAgainstIdentityPolicies(&analyze.ActionPathSet{}, &paths)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	summary, err := queries.GetServiceAccessSummary(s.requestContext(c), s.db, resolvedPaths)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, summary)
+}
+
 func (s *Server) addUserEndpoints(user *gin.RouterGroup) {
 	user.GET("", s.GetAWSUser)
 	user.GET("managedpolicies", s.GetAWSUserManagedPolicies)
 	user.GET("inlinepolicy", s.GetAWSUserInlinePolicy)
 	user.GET("rsop", s.GetAWSUserRSOP)
 	user.GET("rsop/actions", s.GetAWSUserRSOPActions)
+	user.GET("rsop/summary", s.GetAWSUserRSOPSummary)
+	user.GET("rsop/usage", s.GetAWSUserRSOPUsage)
+	user.POST("leastprivilege", s.GenerateAWSUserLeastPrivilegePolicy)
 	user.GET("outboundroles", s.GetAWSUserOutboundRoles)
 }
@@ -35,6 +35,7 @@ var functions = map[string]func(string, string) bool{
 	"ArnNotEquals":              ArnNotEquals,
 	"ArnLike":                   ArnLike,
 	"ArnNotLike":                ArnNotLike,
+	"bool":                      BoolEquals,
 }
 
 func SolveCondition(condition *AWSCondition) bool {
@@ -156,6 +156,10 @@ func Bool(a string) bool {
 	return a == "true"
 }
 
+func BoolEquals(a string, b string) bool {
+	return Bool(strings.ToLower(a)) == Bool(strings.ToLower(b))
+}
+
 func IpAddress(a string, b string) bool {
 	// Determine if a is equal or in the CIDR range
 	// of b
@@ -0,0 +1,641 @@
+package cfn
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hotnops/apeman/analyze"
+)
+
+// The value of Ref AWS::NoValue, which removes the property or list item
+// it is the value of
+type noValue struct{}
+
+// The IAM resource types, with the resource part of their ARNs and the
+// prefix of their unique IDs
+var iamResourceTypes = map[string]struct {
+	arnResource string
+	idPrefix    string
+}{
+	"AWS::IAM::Role":            {"role", "AROA"},
+	"AWS::IAM::User":            {"user", "AIDA"},
+	"AWS::IAM::Group":           {"group", "AGPA"},
+	"AWS::IAM::ManagedPolicy":   {"policy", "ANPA"},
+	"AWS::IAM::InstanceProfile": {"instance-profile", "AIPA"},
+}
+
+// The property holding the name of a resource, for the types where it
+// isn't Name
+var nameProperties = map[string]string{
+	"AWS::IAM::Role":            "RoleName",
+	"AWS::IAM::User":            "UserName",
+	"AWS::IAM::Group":           "GroupName",
+	"AWS::IAM::ManagedPolicy":   "ManagedPolicyName",
+	"AWS::IAM::InstanceProfile": "InstanceProfileName",
+	"AWS::S3::Bucket":           "BucketName",
+	"AWS::SQS::Queue":           "QueueName",
+	"AWS::SNS::Topic":           "TopicName",
+	"AWS::DynamoDB::Table":      "TableName",
+	"AWS::Lambda::Function":     "FunctionName",
+}
+
+var subVariableRegex = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// stack resolves the values of one template. Names and conditions are
+// resolved on demand, as resources can refer to each other in any order.
+type stack struct {
+	name       string
+	template   *Template
+	options    *Options
+	parameters map[string]string
+	names      map[string]string
+	conditions map[string]bool
+	resolving  map[string]bool
+}
+
+// A deterministic ID with the given prefix, like the unique IDs IAM gives
+// principals and policies
+func uniqueID(prefix string, seed string, length int) string {
+	hash := sha256.Sum256([]byte(seed))
+	return prefix + base32.StdEncoding.EncodeToString(hash[:])[:length]
+}
+
+func toString(value any) (string, error) {
+	switch typed := value.(type) {
+	case string:
+		return typed, nil
+	case float64:
+		return strconv.FormatFloat(typed, 'f', -1, 64), nil
+	case int, bool:
+		return fmt.Sprint(typed), nil
+	}
+	return "", fmt.Errorf("expected a string, got %v", value)
+}
+
+func toList(value any) ([]any, error) {
+	list, ok := value.([]any)
+	if !ok {
+		return nil, fmt.Errorf("expected a list, got %v", value)
+	}
+	return list, nil
+}
+
+// Get the arguments of a function that takes a fixed number of them
+func getArguments(function string, value any, count int) ([]any, error) {
+	arguments, ok := value.([]any)
+	if !ok || len(arguments) != count {
+		return nil, fmt.Errorf("%s takes %d arguments", function, count)
+	}
+	return arguments, nil
+}
+
+func (s *stack) isParameter(name string) bool {
+	_, ok := s.template.Parameters[name]
+	return ok
+}
+
+func (s *stack) isResource(name string) bool {
+	_, ok := s.template.Resources[name]
+	return ok
+}
+
+func (s *stack) isPseudoParameter(name string) bool {
+	_, err := s.pseudoParameter(name)
+	return err == nil
+}
+
+func (s *stack) pseudoParameter(name string) (any, error) {
+	switch name {
+	case "AWS::AccountId":
+		return s.options.AccountID, nil
+	case "AWS::Region":
+		return s.options.Region, nil
+	case "AWS::Partition":
+		return "aws", nil
+	case "AWS::URLSuffix":
+		return "amazonaws.com", nil
+	case "AWS::StackName":
+		return s.name, nil
+	case "AWS::StackId":
+		return fmt.Sprintf("arn:aws:cloudformation:%s:%s:stack/%s/%s", s.options.Region, s.options.AccountID,
+			s.name, uniqueID("", s.name, 26)), nil
+	case "AWS::NotificationARNs":
+		return []any{}, nil
+	case "AWS::NoValue":
+		return noValue{}, nil
+	}
+	return nil, fmt.Errorf("unknown pseudo parameter %s", name)
+}
+
+// The value of a parameter. List parameters are split on commas.
+func (s *stack) parameter(name string) (any, error) {
+	parameter := s.template.Parameters[name]
+	value, ok := s.parameters[name]
+	if !ok {
+		if parameter.Default == nil {
+			return nil, fmt.Errorf("parameter %s has no value", name)
+		}
+		if list, ok := parameter.Default.([]any); ok {
+			return list, nil
+		}
+		text, err := toString(parameter.Default)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %w", name, err)
+		}
+		value = text
+	}
+
+	if parameter.Type == "CommaDelimitedList" || strings.HasPrefix(parameter.Type, "List<") {
+		list := []any{}
+		for _, item := range strings.Split(value, ",") {
+			list = append(list, strings.TrimSpace(item))
+		}
+		return list, nil
+	}
+	return value, nil
+}
+
+// The physical name of a resource, which is generated from the stack and
+// logical ID when the template doesn't name it, as CloudFormation does
+func (s *stack) physicalName(logicalID string) (string, error) {
+	if name, ok := s.names[logicalID]; ok {
+		return name, nil
+	}
+	if s.resolving[logicalID] {
+		return "", fmt.Errorf("the name of %s refers to itself", logicalID)
+	}
+	s.resolving[logicalID] = true
+	defer delete(s.resolving, logicalID)
+
+	resource := s.template.Resources[logicalID]
+	property, ok := nameProperties[resource.Type]
+	if !ok {
+		property = "Name"
+	}
+
+	name := ""
+	if value, ok := resource.Properties[property]; ok {
+		resolved, err := s.resolve(value)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", logicalID, err)
+		}
+		if name, err = toString(resolved); err != nil {
+			return "", fmt.Errorf("%s: %w", logicalID, err)
+		}
+	} else {
+		name = fmt.Sprintf("%s-%s-%s", s.name, logicalID, uniqueID("", s.name+"/"+logicalID, 12))
+	}
+
+	s.names[logicalID] = name
+	return name, nil
+}
+
+// The path of an IAM resource
+func (s *stack) path(logicalID string) (string, error) {
+	value, ok := s.template.Resources[logicalID].Properties["Path"]
+	if !ok {
+		return "/", nil
+	}
+	resolved, err := s.resolve(value)
+	if err != nil {
+		return "", err
+	}
+	return toString(resolved)
+}
+
+func (s *stack) arn(logicalID string) (string, error) {
+	resource := s.template.Resources[logicalID]
+	name, err := s.physicalName(logicalID)
+	if err != nil {
+		return "", err
+	}
+
+	iamType, ok := iamResourceTypes[resource.Type]
+	if !ok {
+		// Only IAM ARNs matter to the analysis, so other ARNs are made up
+		// from the service and name
+		if resource.Type == "AWS::S3::Bucket" {
+			return "arn:aws:s3:::" + name, nil
+		}
+		service := strings.ToLower(strings.Split(resource.Type, "::")[1])
+		return fmt.Sprintf("arn:aws:%s:%s:%s:%s", service, s.options.Region, s.options.AccountID, name), nil
+	}
+
+	path, err := s.path(logicalID)
+	if err != nil {
+		return "", err
+	}
+	return analyze.GetIAMArn(s.options.AccountID, iamType.arnResource, path, name), nil
+}
+
+// The unique ID of an IAM resource, derived from its ARN
+func (s *stack) uniqueID(logicalID string) (string, error) {
+	arn, err := s.arn(logicalID)
+	if err != nil {
+		return "", err
+	}
+	return uniqueID(iamResourceTypes[s.template.Resources[logicalID].Type].idPrefix, arn, 17), nil
+}
+
+func (s *stack) ref(name string) (any, error) {
+	if s.isParameter(name) {
+		return s.parameter(name)
+	}
+	if !s.isResource(name) {
+		return s.pseudoParameter(name)
+	}
+
+	// Managed policies refer to their ARN, and everything else to its name
+	if s.template.Resources[name].Type == "AWS::IAM::ManagedPolicy" {
+		return s.arn(name)
+	}
+	return s.physicalName(name)
+}
+
+func (s *stack) getAtt(logicalID string, attribute string) (any, error) {
+	resource, ok := s.template.Resources[logicalID]
+	if !ok {
+		return nil, fmt.Errorf("Fn::GetAtt refers to unknown resource %s", logicalID)
+	}
+
+	switch attribute {
+	case "Arn", "PolicyArn":
+		return s.arn(logicalID)
+	case "RoleId", "PolicyId", "UserId", "GroupId", "InstanceProfileId":
+		if _, ok := iamResourceTypes[resource.Type]; ok {
+			return s.uniqueID(logicalID)
+		}
+	case "DefaultVersionId":
+		return "v1", nil
+	case "Name", "RoleName", "UserName", "GroupName", "PolicyName":
+		return s.physicalName(logicalID)
+	}
+	return nil, fmt.Errorf("Fn::GetAtt %s.%s is not supported", logicalID, attribute)
+}
+
+func (s *stack) sub(value any) (any, error) {
+	text, ok := value.(string)
+	variables := map[string]any{}
+	if !ok {
+		arguments, err := getArguments("Fn::Sub", value, 2)
+		if err != nil {
+			return nil, err
+		}
+		if text, err = toString(arguments[0]); err != nil {
+			return nil, err
+		}
+		resolved, err := s.resolve(arguments[1])
+		if err != nil {
+			return nil, err
+		}
+		if variables, ok = resolved.(map[string]any); !ok {
+			return nil, fmt.Errorf("the Fn::Sub variables are not a map")
+		}
+	}
+
+	var subErr error
+	result := subVariableRegex.ReplaceAllStringFunc(text, func(match string) string {
+		name := match[2 : len(match)-1]
+		var value any
+		var err error
+		if strings.HasPrefix(name, "!") {
+			return "${" + name[1:] + "}"
+		} else if variable, ok := variables[name]; ok {
+			value = variable
+		} else if s.isParameter(name) || s.isResource(name) || s.isPseudoParameter(name) {
+			value, err = s.ref(name)
+		} else if logicalID, attribute, ok := strings.Cut(name, "."); ok && s.isResource(logicalID) {
+			value, err = s.getAtt(logicalID, attribute)
+		} else if strings.Contains(name, ":") {
+			// IAM policy variables, such as ${aws:username}, are kept
+			return match
+		} else {
+			err = fmt.Errorf("Fn::Sub refers to unknown name %s", name)
+		}
+
+		if err == nil {
+			var text string
+			if text, err = toString(value); err == nil {
+				return text
+			}
+		}
+		if subErr == nil {
+			subErr = err
+		}
+		return match
+	})
+	return result, subErr
+}
+
+func (s *stack) join(value any) (any, error) {
+	arguments, err := getArguments("Fn::Join", value, 2)
+	if err != nil {
+		return nil, err
+	}
+	delimiter, err := toString(arguments[0])
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := s.resolve(arguments[1])
+	if err != nil {
+		return nil, err
+	}
+	items, err := toList(resolved)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := []string{}
+	for _, item := range items {
+		part, err := toString(item)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, delimiter), nil
+}
+
+func (s *stack) selectItem(value any) (any, error) {
+	arguments, err := getArguments("Fn::Select", value, 2)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := s.resolve(arguments[0])
+	if err != nil {
+		return nil, err
+	}
+	indexText, err := toString(resolved)
+	if err != nil {
+		return nil, err
+	}
+	index, err := strconv.Atoi(indexText)
+	if err != nil {
+		return nil, fmt.Errorf("Fn::Select index %s is not a number", indexText)
+	}
+
+	resolved, err = s.resolve(arguments[1])
+	if err != nil {
+		return nil, err
+	}
+	items, err := toList(resolved)
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 || index >= len(items) {
+		return nil, fmt.Errorf("Fn::Select index %d is out of range", index)
+	}
+	return items[index], nil
+}
+
+func (s *stack) split(value any) (any, error) {
+	arguments, err := getArguments("Fn::Split", value, 2)
+	if err != nil {
+		return nil, err
+	}
+	delimiter, err := toString(arguments[0])
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := s.resolve(arguments[1])
+	if err != nil {
+		return nil, err
+	}
+	text, err := toString(resolved)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []any{}
+	for _, item := range strings.Split(text, delimiter) {
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (s *stack) findInMap(value any) (any, error) {
+	arguments, err := getArguments("Fn::FindInMap", value, 3)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for _, argument := range arguments {
+		resolved, err := s.resolve(argument)
+		if err != nil {
+			return nil, err
+		}
+		key, err := toString(resolved)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	topLevel, ok := s.template.Mappings[keys[0]][keys[1]].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("mapping %s has no key %s", keys[0], keys[1])
+	}
+	item, ok := topLevel[keys[2]]
+	if !ok {
+		return nil, fmt.Errorf("mapping %s has no key %s.%s", keys[0], keys[1], keys[2])
+	}
+	return item, nil
+}
+
+func (s *stack) ifCondition(value any) (any, error) {
+	arguments, err := getArguments("Fn::If", value, 3)
+	if err != nil {
+		return nil, err
+	}
+	name, err := toString(arguments[0])
+	if err != nil {
+		return nil, err
+	}
+	condition, err := s.evaluateCondition(name)
+	if err != nil {
+		return nil, err
+	}
+	if condition {
+		return s.resolve(arguments[1])
+	}
+	return s.resolve(arguments[2])
+}
+
+// Evaluate a named condition of the template
+func (s *stack) evaluateCondition(name string) (bool, error) {
+	if condition, ok := s.conditions[name]; ok {
+		return condition, nil
+	}
+	value, ok := s.template.Conditions[name]
+	if !ok {
+		return false, fmt.Errorf("unknown condition %s", name)
+	}
+	if s.resolving["Condition::"+name] {
+		return false, fmt.Errorf("condition %s refers to itself", name)
+	}
+	s.resolving["Condition::"+name] = true
+	defer delete(s.resolving, "Condition::"+name)
+
+	condition, err := s.evaluateConditionValue(value)
+	if err != nil {
+		return false, fmt.Errorf("condition %s: %w", name, err)
+	}
+	s.conditions[name] = condition
+	return condition, nil
+}
+
+func (s *stack) evaluateConditionValue(value any) (bool, error) {
+	function, ok := value.(map[string]any)
+	if !ok || len(function) != 1 {
+		return false, fmt.Errorf("expected a condition function, got %v", value)
+	}
+
+	for name, argument := range function {
+		switch name {
+		case "Condition":
+			conditionName, err := toString(argument)
+			if err != nil {
+				return false, err
+			}
+			return s.evaluateCondition(conditionName)
+		case "Fn::Equals":
+			arguments, err := getArguments(name, argument, 2)
+			if err != nil {
+				return false, err
+			}
+			values := []string{}
+			for _, argument := range arguments {
+				resolved, err := s.resolve(argument)
+				if err != nil {
+					return false, err
+				}
+				values = append(values, fmt.Sprint(resolved))
+			}
+			return values[0] == values[1], nil
+		case "Fn::Not":
+			arguments, err := getArguments(name, argument, 1)
+			if err != nil {
+				return false, err
+			}
+			condition, err := s.evaluateConditionValue(arguments[0])
+			return !condition, err
+		case "Fn::And", "Fn::Or":
+			arguments, err := toList(argument)
+			if err != nil {
+				return false, err
+			}
+			result := name == "Fn::And"
+			for _, argument := range arguments {
+				condition, err := s.evaluateConditionValue(argument)
+				if err != nil {
+					return false, err
+				}
+				if name == "Fn::And" {
+					result = result && condition
+				} else {
+					result = result || condition
+				}
+			}
+			return result, nil
+		}
+		return false, fmt.Errorf("%s is not a condition function", name)
+	}
+	return false, nil
+}
+
+// Resolve the intrinsic functions in a value. Properties and list items
+// set to AWS::NoValue are removed.
+func (s *stack) resolve(value any) (any, error) {
+	switch typed := value.(type) {
+	case []any:
+		items := []any{}
+		for _, item := range typed {
+			resolved, err := s.resolve(item)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := resolved.(noValue); !ok {
+				items = append(items, resolved)
+			}
+		}
+		return items, nil
+	case map[string]any:
+		if len(typed) == 1 {
+			for name, argument := range typed {
+				if resolved, ok, err := s.resolveFunction(name, argument); ok {
+					return resolved, err
+				}
+			}
+		}
+
+		properties := map[string]any{}
+		for key, item := range typed {
+			resolved, err := s.resolve(item)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := resolved.(noValue); !ok {
+				properties[key] = resolved
+			}
+		}
+		return properties, nil
+	}
+	return value, nil
+}
+
+// Resolve an intrinsic function. The returned bool is false if name isn't
+// a function.
+func (s *stack) resolveFunction(name string, argument any) (any, bool, error) {
+	var resolved any
+	var err error
+	switch name {
+	case "Ref":
+		var refName string
+		if refName, err = toString(argument); err == nil {
+			resolved, err = s.ref(refName)
+		}
+	case "Fn::GetAtt":
+		var arguments []any
+		if arguments, err = getArguments(name, argument, 2); err == nil {
+			var logicalID, attribute string
+			if logicalID, err = toString(arguments[0]); err == nil {
+				if attribute, err = toString(arguments[1]); err == nil {
+					resolved, err = s.getAtt(logicalID, attribute)
+				}
+			}
+		}
+	case "Fn::Sub":
+		resolved, err = s.sub(argument)
+	case "Fn::Join":
+		resolved, err = s.join(argument)
+	case "Fn::Select":
+		resolved, err = s.selectItem(argument)
+	case "Fn::Split":
+		resolved, err = s.split(argument)
+	case "Fn::FindInMap":
+		resolved, err = s.findInMap(argument)
+	case "Fn::If":
+		resolved, err = s.ifCondition(argument)
+	case "Fn::Base64":
+		var value any
+		if value, err = s.resolve(argument); err == nil {
+			var text string
+			if text, err = toString(value); err == nil {
+				resolved = base64.StdEncoding.EncodeToString([]byte(text))
+			}
+		}
+	case "Fn::GetAZs":
+		resolved = []any{s.options.Region + "a", s.options.Region + "b", s.options.Region + "c"}
+	default:
+		if !strings.HasPrefix(name, "Fn::") {
+			return nil, false, nil
+		}
+		err = fmt.Errorf("%s is not supported", name)
+	}
+	return resolved, true, err
+}
@@ -0,0 +1,629 @@
+package cfn
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hotnops/apeman/analyze"
+)
+
+// The account, region and creation date synthesized resources get when
+// none are given. They keep the output of a template the same between
+// runs.
+const (
+	DefaultAccountID = "123456789012"
+	DefaultRegion    = "us-east-1"
+)
+
+var DefaultCreateDate = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type Options struct {
+	AccountID  string
+	Region     string
+	CreateDate time.Time
+	// Documents of AWS managed policies, keyed by ARN. Attached AWS managed
+	// policies are only added to the output if their document is known.
+	ManagedPolicies map[string]analyze.ManagedPolicyDetail
+}
+
+// A change to a principal that is made by name, and applied once every
+// template is added
+type principalChange struct {
+	source    string
+	kind      string
+	name      string
+	policy    *analyze.InlinePolicyDetail
+	policyArn string
+	group     string
+}
+
+// Synthesizer builds the authorization details an account would have after
+// the IAM resources of CloudFormation templates are deployed to it. ARNs
+// and IDs are derived from the account and resource names, so the output
+// is the same every time.
+type Synthesizer struct {
+	options  Options
+	users    map[string]*analyze.UserDetail
+	groups   map[string]*analyze.GroupDetail
+	roles    map[string]*analyze.RoleDetail
+	policies map[string]*analyze.ManagedPolicyDetail
+	changes  []principalChange
+	warnings []string
+}
+
+func NewSynthesizer(options Options) *Synthesizer {
+	if options.AccountID == "" {
+		options.AccountID = DefaultAccountID
+	}
+	if options.Region == "" {
+		options.Region = DefaultRegion
+	}
+	if options.CreateDate.IsZero() {
+		options.CreateDate = DefaultCreateDate
+	}
+
+	return &Synthesizer{
+		options:  options,
+		users:    map[string]*analyze.UserDetail{},
+		groups:   map[string]*analyze.GroupDetail{},
+		roles:    map[string]*analyze.RoleDetail{},
+		policies: map[string]*analyze.ManagedPolicyDetail{},
+	}
+}
+
+func getStringProperty(properties map[string]any, name string) (string, error) {
+	value, ok := properties[name]
+	if !ok {
+		return "", nil
+	}
+	text, err := toString(value)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	return text, nil
+}
+
+func getStringListProperty(properties map[string]any, name string) ([]string, error) {
+	value, ok := properties[name]
+	if !ok {
+		return []string{}, nil
+	}
+	items, err := toList(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	values := []string{}
+	for _, item := range items {
+		text, err := toString(item)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		values = append(values, text)
+	}
+	return values, nil
+}
+
+// Policy documents can be given as JSON strings too
+func getDocumentProperty(properties map[string]any, name string) (map[string]any, error) {
+	switch value := properties[name].(type) {
+	case map[string]any:
+		return value, nil
+	case string:
+		document := map[string]any{}
+		if err := json.Unmarshal([]byte(value), &document); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return document, nil
+	}
+	return nil, fmt.Errorf("%s is not a policy document", name)
+}
+
+func getInlinePolicy(properties map[string]any) (*analyze.InlinePolicyDetail, error) {
+	name, err := getStringProperty(properties, "PolicyName")
+	if err != nil {
+		return nil, err
+	}
+	document, err := getDocumentProperty(properties, "PolicyDocument")
+	if err != nil {
+		return nil, err
+	}
+	return &analyze.InlinePolicyDetail{PolicyName: name, PolicyDocument: document}, nil
+}
+
+func getInlinePoliciesProperty(properties map[string]any) ([]analyze.InlinePolicyDetail, error) {
+	policies := []analyze.InlinePolicyDetail{}
+	value, ok := properties["Policies"]
+	if !ok {
+		return policies, nil
+	}
+	items, err := toList(value)
+	if err != nil {
+		return nil, fmt.Errorf("Policies: %w", err)
+	}
+
+	for _, item := range items {
+		policyProperties, ok := item.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("Policies: expected a policy, got %v", item)
+		}
+		policy, err := getInlinePolicy(policyProperties)
+		if err != nil {
+			return nil, fmt.Errorf("Policies: %w", err)
+		}
+		policies = append(policies, *policy)
+	}
+	return policies, nil
+}
+
+func getAttachedPolicies(arns []string) []analyze.AttachedPolicy {
+	attached := []analyze.AttachedPolicy{}
+	for _, arn := range arns {
+		name := arn[strings.LastIndex(arn, "/")+1:]
+		attached = append(attached, analyze.AttachedPolicy{PolicyName: name, PolicyArn: arn})
+	}
+	return attached
+}
+
+func getPermissionsBoundaryProperty(properties map[string]any) (*analyze.PermissionsBoundaryDetail, error) {
+	arn, err := getStringProperty(properties, "PermissionsBoundary")
+	if err != nil || arn == "" {
+		return nil, err
+	}
+	return &analyze.PermissionsBoundaryDetail{
+		PermissionsBoundaryType: "Policy",
+		PermissionsBoundaryArn:  arn,
+	}, nil
+}
+
+func getTagsProperty(properties map[string]any) ([]analyze.TagDetail, error) {
+	tags := []analyze.TagDetail{}
+	value, ok := properties["Tags"]
+	if !ok {
+		return tags, nil
+	}
+	items, err := toList(value)
+	if err != nil {
+		return nil, fmt.Errorf("Tags: %w", err)
+	}
+
+	for _, item := range items {
+		tag, ok := item.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("Tags: expected a tag, got %v", item)
+		}
+		key, err := getStringProperty(tag, "Key")
+		if err != nil {
+			return nil, fmt.Errorf("Tags: %w", err)
+		}
+		value, err := getStringProperty(tag, "Value")
+		if err != nil {
+			return nil, fmt.Errorf("Tags: %w", err)
+		}
+		tags = append(tags, analyze.TagDetail{Key: key, Value: value})
+	}
+	return tags, nil
+}
+
+// The parts every principal has
+type principalProperties struct {
+	name     string
+	path     string
+	arn      string
+	id       string
+	policies []analyze.InlinePolicyDetail
+	attached []analyze.AttachedPolicy
+	boundary *analyze.PermissionsBoundaryDetail
+}
+
+func getPrincipalProperties(st *stack, logicalID string, properties map[string]any) (*principalProperties, error) {
+	principal := &principalProperties{}
+	var err error
+	if principal.name, err = st.physicalName(logicalID); err != nil {
+		return nil, err
+	}
+	if principal.path, err = st.path(logicalID); err != nil {
+		return nil, err
+	}
+	if principal.arn, err = st.arn(logicalID); err != nil {
+		return nil, err
+	}
+	if principal.id, err = st.uniqueID(logicalID); err != nil {
+		return nil, err
+	}
+	if principal.policies, err = getInlinePoliciesProperty(properties); err != nil {
+		return nil, err
+	}
+	arns, err := getStringListProperty(properties, "ManagedPolicyArns")
+	if err != nil {
+		return nil, err
+	}
+	principal.attached = getAttachedPolicies(arns)
+	if principal.boundary, err = getPermissionsBoundaryProperty(properties); err != nil {
+		return nil, err
+	}
+	return principal, nil
+}
+
+func (s *Synthesizer) addRole(st *stack, logicalID string, properties map[string]any) error {
+	principal, err := getPrincipalProperties(st, logicalID, properties)
+	if err != nil {
+		return err
+	}
+	if _, ok := s.roles[principal.name]; ok {
+		return fmt.Errorf("role %s is defined more than once", principal.name)
+	}
+	trustPolicy, err := getDocumentProperty(properties, "AssumeRolePolicyDocument")
+	if err != nil {
+		return err
+	}
+	tags, err := getTagsProperty(properties)
+	if err != nil {
+		return err
+	}
+
+	s.roles[principal.name] = &analyze.RoleDetail{
+		Path:                     principal.path,
+		RoleName:                 principal.name,
+		RoleId:                   principal.id,
+		Arn:                      principal.arn,
+		CreateDate:               s.options.CreateDate,
+		AssumeRolePolicyDocument: trustPolicy,
+		RolePolicyList:           principal.policies,
+		AttachedManagedPolicies:  principal.attached,
+		PermissionsBoundary:      principal.boundary,
+		Tags:                     tags,
+	}
+	return nil
+}
+
+func (s *Synthesizer) addUser(st *stack, logicalID string, properties map[string]any) error {
+	principal, err := getPrincipalProperties(st, logicalID, properties)
+	if err != nil {
+		return err
+	}
+	if _, ok := s.users[principal.name]; ok {
+		return fmt.Errorf("user %s is defined more than once", principal.name)
+	}
+	groups, err := getStringListProperty(properties, "Groups")
+	if err != nil {
+		return err
+	}
+	tags, err := getTagsProperty(properties)
+	if err != nil {
+		return err
+	}
+
+	s.users[principal.name] = &analyze.UserDetail{
+		Path:                    principal.path,
+		UserName:                principal.name,
+		UserId:                  principal.id,
+		Arn:                     principal.arn,
+		CreateDate:              s.options.CreateDate,
+		UserPolicyList:          principal.policies,
+		GroupList:               []string{},
+		AttachedManagedPolicies: principal.attached,
+		PermissionsBoundary:     principal.boundary,
+		Tags:                    tags,
+	}
+	for _, group := range groups {
+		s.changes = append(s.changes, principalChange{source: logicalID, kind: "user", name: principal.name, group: group})
+	}
+	return nil
+}
+
+func (s *Synthesizer) addGroup(st *stack, logicalID string, properties map[string]any) error {
+	principal, err := getPrincipalProperties(st, logicalID, properties)
+	if err != nil {
+		return err
+	}
+	if _, ok := s.groups[principal.name]; ok {
+		return fmt.Errorf("group %s is defined more than once", principal.name)
+	}
+
+	s.groups[principal.name] = &analyze.GroupDetail{
+		Path:                    principal.path,
+		GroupName:               principal.name,
+		GroupId:                 principal.id,
+		Arn:                     principal.arn,
+		CreateDate:              s.options.CreateDate,
+		GroupPolicyList:         principal.policies,
+		AttachedManagedPolicies: principal.attached,
+	}
+	return nil
+}
+
+// Add a change for every principal named in the Roles, Users and Groups
+// properties
+func (s *Synthesizer) addPrincipalChanges(logicalID string, properties map[string]any, change principalChange) error {
+	for kind, property := range map[string]string{"role": "Roles", "user": "Users", "group": "Groups"} {
+		names, err := getStringListProperty(properties, property)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			change.source, change.kind, change.name = logicalID, kind, name
+			s.changes = append(s.changes, change)
+		}
+	}
+	return nil
+}
+
+func (s *Synthesizer) addManagedPolicy(st *stack, logicalID string, properties map[string]any) error {
+	name, err := st.physicalName(logicalID)
+	if err != nil {
+		return err
+	}
+	path, err := st.path(logicalID)
+	if err != nil {
+		return err
+	}
+	arn, err := st.arn(logicalID)
+	if err != nil {
+		return err
+	}
+	if _, ok := s.policies[arn]; ok {
+		return fmt.Errorf("policy %s is defined more than once", arn)
+	}
+	id, err := st.uniqueID(logicalID)
+	if err != nil {
+		return err
+	}
+	document, err := getDocumentProperty(properties, "PolicyDocument")
+	if err != nil {
+		return err
+	}
+
+	s.policies[arn] = &analyze.ManagedPolicyDetail{
+		PolicyName:       name,
+		PolicyId:         id,
+		Arn:              arn,
+		Path:             path,
+		DefaultVersionId: "v1",
+		IsAttachable:     true,
+		CreateDate:       s.options.CreateDate,
+		UpdateDate:       s.options.CreateDate,
+		PolicyVersionList: []analyze.PolicyVersionDetail{
+			{
+				Document:         document,
+				VersionId:        "v1",
+				IsDefaultVersion: true,
+				CreateDate:       s.options.CreateDate,
+			},
+		},
+	}
+	return s.addPrincipalChanges(logicalID, properties, principalChange{policyArn: arn})
+}
+
+// AWS::IAM::Policy, which is an inline policy of every principal it names
+func (s *Synthesizer) addPolicy(logicalID string, properties map[string]any) error {
+	policy, err := getInlinePolicy(properties)
+	if err != nil {
+		return err
+	}
+	return s.addPrincipalChanges(logicalID, properties, principalChange{policy: policy})
+}
+
+// AWS::IAM::RolePolicy, UserPolicy and GroupPolicy, which name the
+// principal in the given property
+func (s *Synthesizer) addPrincipalPolicy(logicalID string, kind string, property string, properties map[string]any) error {
+	policy, err := getInlinePolicy(properties)
+	if err != nil {
+		return err
+	}
+	name, err := getStringProperty(properties, property)
+	if err != nil {
+		return err
+	}
+	s.changes = append(s.changes, principalChange{source: logicalID, kind: kind, name: name, policy: policy})
+	return nil
+}
+
+func (s *Synthesizer) addUserToGroupAddition(logicalID string, properties map[string]any) error {
+	group, err := getStringProperty(properties, "GroupName")
+	if err != nil {
+		return err
+	}
+	users, err := getStringListProperty(properties, "Users")
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		s.changes = append(s.changes, principalChange{source: logicalID, kind: "user", name: user, group: group})
+	}
+	return nil
+}
+
+func sortedKeys[T any](items map[string]T) []string {
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// AddTemplate adds the IAM resources of a template deployed as the named
+// stack. Parameters without a default have to be given. Resources that
+// aren't IAM resources are ignored, and IAM resources that can't be
+// represented in authorization details are reported as warnings.
+func (s *Synthesizer) AddTemplate(stackName string, template *Template, parameters map[string]string) error {
+	st := &stack{
+		name:       stackName,
+		template:   template,
+		options:    &s.options,
+		parameters: parameters,
+		names:      map[string]string{},
+		conditions: map[string]bool{},
+		resolving:  map[string]bool{},
+	}
+
+	for _, logicalID := range sortedKeys(template.Resources) {
+		resource := template.Resources[logicalID]
+		if !strings.HasPrefix(resource.Type, "AWS::IAM::") {
+			continue
+		}
+		if resource.Condition != "" {
+			create, err := st.evaluateCondition(resource.Condition)
+			if err != nil {
+				return fmt.Errorf("%s: %w", logicalID, err)
+			}
+			if !create {
+				continue
+			}
+		}
+
+		resolved, err := st.resolve(resource.Properties)
+		if err != nil {
+			return fmt.Errorf("%s: %w", logicalID, err)
+		}
+		properties, _ := resolved.(map[string]any)
+
+		switch resource.Type {
+		case "AWS::IAM::Role":
+			err = s.addRole(st, logicalID, properties)
+		case "AWS::IAM::User":
+			err = s.addUser(st, logicalID, properties)
+		case "AWS::IAM::Group":
+			err = s.addGroup(st, logicalID, properties)
+		case "AWS::IAM::ManagedPolicy":
+			err = s.addManagedPolicy(st, logicalID, properties)
+		case "AWS::IAM::Policy":
+			err = s.addPolicy(logicalID, properties)
+		case "AWS::IAM::RolePolicy":
+			err = s.addPrincipalPolicy(logicalID, "role", "RoleName", properties)
+		case "AWS::IAM::UserPolicy":
+			err = s.addPrincipalPolicy(logicalID, "user", "UserName", properties)
+		case "AWS::IAM::GroupPolicy":
+			err = s.addPrincipalPolicy(logicalID, "group", "GroupName", properties)
+		case "AWS::IAM::UserToGroupAddition":
+			err = s.addUserToGroupAddition(logicalID, properties)
+		default:
+			s.warnings = append(s.warnings, fmt.Sprintf("%s: %s is not supported", logicalID, resource.Type))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", logicalID, err)
+		}
+	}
+	return nil
+}
+
+// Apply a change made by name. Principals that aren't in the templates
+// may exist in the account already, so they are reported rather than
+// failing the synthesis.
+func (s *Synthesizer) applyChange(change principalChange) string {
+	var policies *[]analyze.InlinePolicyDetail
+	var attached *[]analyze.AttachedPolicy
+	switch change.kind {
+	case "role":
+		if role, ok := s.roles[change.name]; ok {
+			policies, attached = &role.RolePolicyList, &role.AttachedManagedPolicies
+		}
+	case "user":
+		if user, ok := s.users[change.name]; ok {
+			policies, attached = &user.UserPolicyList, &user.AttachedManagedPolicies
+			if change.group != "" {
+				if _, ok := s.groups[change.group]; !ok {
+					return fmt.Sprintf("%s: group %s is not in the templates", change.source, change.group)
+				}
+				user.GroupList = append(user.GroupList, change.group)
+			}
+		}
+	case "group":
+		if group, ok := s.groups[change.name]; ok {
+			policies, attached = &group.GroupPolicyList, &group.AttachedManagedPolicies
+		}
+	}
+	if policies == nil {
+		return fmt.Sprintf("%s: %s %s is not in the templates", change.source, change.kind, change.name)
+	}
+
+	if change.policy != nil {
+		*policies = append(*policies, *change.policy)
+	}
+	if change.policyArn != "" {
+		*attached = append(*attached, getAttachedPolicies([]string{change.policyArn})...)
+	}
+	return ""
+}
+
+// Count a use of a policy. AWS managed policies are added the first time
+// they are used, if their document is known. Returns false if it isn't.
+func (s *Synthesizer) addPolicyUse(arn string, boundary bool) bool {
+	policy, ok := s.policies[arn]
+	if !ok {
+		awsPolicy, ok := s.options.ManagedPolicies[arn]
+		if !ok {
+			return false
+		}
+		awsPolicy.AttachmentCount = 0
+		awsPolicy.PermissionsBoundaryUsageCount = 0
+		policy = &awsPolicy
+		s.policies[arn] = policy
+	}
+
+	if boundary {
+		policy.PermissionsBoundaryUsageCount++
+	} else {
+		policy.AttachmentCount++
+	}
+	return true
+}
+
+// Count the uses of the policies of a principal, and report the ones
+// whose document isn't known
+func (s *Synthesizer) addPolicyUses(attached []analyze.AttachedPolicy, boundary *analyze.PermissionsBoundaryDetail, unknown map[string]bool) {
+	for _, policy := range attached {
+		if !s.addPolicyUse(policy.PolicyArn, false) {
+			unknown[policy.PolicyArn] = true
+		}
+	}
+	if boundary != nil && !s.addPolicyUse(boundary.PermissionsBoundaryArn, true) {
+		unknown[boundary.PermissionsBoundaryArn] = true
+	}
+}
+
+// AuthorizationDetails builds the authorization details of every template
+// added so far, along with warnings about what was left out. It should
+// only be called once.
+func (s *Synthesizer) AuthorizationDetails() (*analyze.AuthorizationDetails, []string) {
+	for _, change := range s.changes {
+		if warning := s.applyChange(change); warning != "" {
+			s.warnings = append(s.warnings, warning)
+		}
+	}
+
+	details := &analyze.AuthorizationDetails{
+		UserDetailList:  []analyze.UserDetail{},
+		GroupDetailList: []analyze.GroupDetail{},
+		RoleDetailList:  []analyze.RoleDetail{},
+		Policies:        []analyze.ManagedPolicyDetail{},
+	}
+	unknown := map[string]bool{}
+	for _, name := range sortedKeys(s.users) {
+		user := s.users[name]
+		s.addPolicyUses(user.AttachedManagedPolicies, user.PermissionsBoundary, unknown)
+		details.UserDetailList = append(details.UserDetailList, *user)
+	}
+	for _, name := range sortedKeys(s.groups) {
+		group := s.groups[name]
+		s.addPolicyUses(group.AttachedManagedPolicies, nil, unknown)
+		details.GroupDetailList = append(details.GroupDetailList, *group)
+	}
+	for _, name := range sortedKeys(s.roles) {
+		role := s.roles[name]
+		s.addPolicyUses(role.AttachedManagedPolicies, role.PermissionsBoundary, unknown)
+		details.RoleDetailList = append(details.RoleDetailList, *role)
+	}
+	for _, arn := range sortedKeys(s.policies) {
+		details.Policies = append(details.Policies, *s.policies[arn])
+	}
+
+	for _, arn := range sortedKeys(unknown) {
+		s.warnings = append(s.warnings, fmt.Sprintf("the document of %s is not known", arn))
+	}
+	return details, s.warnings
+}
@@ -0,0 +1,140 @@
+package cfn
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Template is a CloudFormation template. Values keep the long form of
+// intrinsic functions, so !Sub "x" reads as {"Fn::Sub": "x"} whether the
+// template is YAML or JSON.
+type Template struct {
+	Parameters map[string]Parameter      `json:"Parameters"`
+	Mappings   map[string]map[string]any `json:"Mappings"`
+	Conditions map[string]any            `json:"Conditions"`
+	Resources  map[string]Resource       `json:"Resources"`
+}
+
+type Parameter struct {
+	Type    string `json:"Type"`
+	Default any    `json:"Default"`
+}
+
+type Resource struct {
+	Type       string         `json:"Type"`
+	Condition  string         `json:"Condition"`
+	Properties map[string]any `json:"Properties"`
+}
+
+// The short form tags that take their arguments as is. !GetAtt also takes
+// a dotted string, and !Ref and !Condition aren't prefixed with Fn::.
+var shortFormFunctions = map[string]string{
+	"!Ref":         "Ref",
+	"!Condition":   "Condition",
+	"!GetAtt":      "Fn::GetAtt",
+	"!Sub":         "Fn::Sub",
+	"!Join":        "Fn::Join",
+	"!Select":      "Fn::Select",
+	"!Split":       "Fn::Split",
+	"!If":          "Fn::If",
+	"!Equals":      "Fn::Equals",
+	"!Not":         "Fn::Not",
+	"!And":         "Fn::And",
+	"!Or":          "Fn::Or",
+	"!FindInMap":   "Fn::FindInMap",
+	"!Base64":      "Fn::Base64",
+	"!GetAZs":      "Fn::GetAZs",
+	"!ImportValue": "Fn::ImportValue",
+	"!Cidr":        "Fn::Cidr",
+}
+
+func isShortForm(tag string) bool {
+	return strings.HasPrefix(tag, "!") && !strings.HasPrefix(tag, "!!")
+}
+
+// Convert a YAML node to plain values, expanding short form intrinsic
+// functions
+func convertNode(node *yaml.Node) (any, error) {
+	var value any
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return convertNode(node.Content[0])
+	case yaml.AliasNode:
+		return convertNode(node.Alias)
+	case yaml.MappingNode:
+		mapping := map[string]any{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			item, err := convertNode(node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			mapping[node.Content[i].Value] = item
+		}
+		value = mapping
+	case yaml.SequenceNode:
+		sequence := []any{}
+		for _, child := range node.Content {
+			item, err := convertNode(child)
+			if err != nil {
+				return nil, err
+			}
+			sequence = append(sequence, item)
+		}
+		value = sequence
+	case yaml.ScalarNode:
+		// Dates, like the policy version 2012-10-17, and the arguments of
+		// short form functions stay strings
+		if node.Tag == "!!timestamp" || isShortForm(node.Tag) {
+			value = node.Value
+		} else if err := node.Decode(&value); err != nil {
+			return nil, err
+		}
+	}
+
+	if !isShortForm(node.Tag) {
+		return value, nil
+	}
+
+	function, ok := shortFormFunctions[node.Tag]
+	if !ok {
+		return nil, fmt.Errorf("line %d: unknown tag %s", node.Line, node.Tag)
+	}
+	if text, ok := value.(string); ok && function == "Fn::GetAtt" {
+		resource, attribute, found := strings.Cut(text, ".")
+		if !found {
+			return nil, fmt.Errorf("line %d: !GetAtt %s is not Resource.Attribute", node.Line, text)
+		}
+		value = []any{resource, attribute}
+	}
+	return map[string]any{function: value}, nil
+}
+
+// ParseTemplate reads a YAML or JSON template
+func ParseTemplate(data []byte) (*Template, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed parsing template: %w", err)
+	}
+
+	value, err := convertNode(&root)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing template: %w", err)
+	}
+
+	// Round trip through JSON to fill in the template
+	text, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing template: %w", err)
+	}
+	template := &Template{}
+	if err := json.Unmarshal(text, template); err != nil {
+		return nil, fmt.Errorf("failed parsing template: %w", err)
+	}
+	return template, nil
+}
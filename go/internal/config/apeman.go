@@ -0,0 +1,113 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const (
+	ApemanConfigurationEnvironmentVariable = "APEMAN_CONFIG"
+	DefaultApemanConfigurationPath         = "/opt/apeman/apeman.json"
+)
+
+// ApemanConfiguration holds the settings used by apeman's analyses. It is
+// nested under the "apeman" key of the configuration file.
+type ApemanConfiguration struct {
+	// Path to a JSON file mapping account IDs to vendor names. Entries are
+	// merged on top of the built in vendor list.
+	VendorsFile string `json:"vendors_file"`
+	// Costs used when ranking attack paths
+	PathCosts PathCostConfiguration `json:"path_costs"`
+	// Directory of user defined finding rules, run alongside the built in
+	// checks
+	RulesDirectory string `json:"rules_directory"`
+	// Days a granted action can go unused before the unused-permissions
+	// check reports it. Zero keeps the default of 90.
+	UnusedPermissionDays int `json:"unused_permission_days"`
+	// Directory of CloudTrail logs, optionally gzipped, that usage of
+	// actions is ingested from. Collections other than the default one
+	// read the subdirectory named after them.
+	CloudTrailDirectory string `json:"cloudtrail_directory"`
+	// Where to send alerts about new escalation paths
+	Alerts AlertConfiguration `json:"alerts"`
+	// Scheduled ingest and analysis, used when running with -daemon
+	Daemon DaemonConfiguration `json:"daemon"`
+}
+
+// DaemonConfiguration controls when the daemon reingests and analyzes the
+// graph. A run starts whenever the drop directory changes and whenever the
+// schedule fires.
+type DaemonConfiguration struct {
+	// Directory of authorization details files to ingest. Without one,
+	// runs skip ingest and only analyze the current graph.
+	DropDirectory string `json:"drop_directory"`
+	// How often the drop directory is checked for changes, in seconds
+	PollInterval int `json:"poll_interval"`
+	// Five field cron schedule, such as "0 2 * * *"
+	Schedule string `json:"schedule"`
+	// Commands that ingest the drop directory, run in order from
+	// WorkingDirectory. {input} is replaced with the drop directory,
+	// {import} with ImportDirectory and {collection} with Collection.
+	IngestCommands   [][]string `json:"ingest_commands"`
+	WorkingDirectory string     `json:"working_directory"`
+	// Directory the ingest writes csv files to for the graph database
+	ImportDirectory string `json:"import_directory"`
+	// Save a snapshot named after the run once it's analyzed. On unless
+	// set to false.
+	Snapshot bool `json:"snapshot"`
+	// The collection that runs ingest into and analyze. It replaces
+	// {collection} in the ingest commands.
+	Collection string `json:"collection"`
+}
+
+// AlertConfiguration lists the destinations of escalation alerts. An
+// alert is sent to every webhook and written to the spool directory, if
+// set.
+type AlertConfiguration struct {
+	// URLs that receive each alert as a JSON POST
+	Webhooks []string `json:"webhooks"`
+	// Directory that each alert is written to as a JSON file
+	SpoolDirectory string `json:"spool_directory"`
+}
+
+// PathCostConfiguration overrides the default cost of traversing an
+// identity transform edge. Unset values keep their defaults, and costs
+// can't be negative.
+type PathCostConfiguration struct {
+	// Cost of each identity transform, keyed by its name
+	Transforms map[string]float64 `json:"transforms"`
+	// Cost of identity transforms missing from Transforms
+	Default *float64 `json:"default"`
+	// Extra cost of an edge that depends on conditions the analysis
+	// couldn't resolve
+	Conditional *float64 `json:"conditional"`
+}
+
+// ApemanConfigurationPath returns the configuration file path, which can be
+// overridden with the APEMAN_CONFIG environment variable
+func ApemanConfigurationPath() string {
+	if path := os.Getenv(ApemanConfigurationEnvironmentVariable); path != "" {
+		return path
+	}
+	return DefaultApemanConfigurationPath
+}
+
+// ApplyConfigurationFile reads the configuration file at path, if present,
+// on top of an existing configuration. Values missing from the file keep
+// whatever was already set.
+func ApplyConfigurationFile(path string, cfg *Configuration) error {
+	if hasCfgFile, err := HasConfigurationFile(path); err != nil {
+		return err
+	} else if !hasCfgFile {
+		return nil
+	}
+
+	if content, err := os.ReadFile(path); err != nil {
+		return fmt.Errorf("failed reading configuration file %s: %w", path, err)
+	} else if err := json.Unmarshal(content, cfg); err != nil {
+		return fmt.Errorf("failed parsing configuration file %s: %w", path, err)
+	}
+
+	return nil
+}
@@ -162,6 +162,7 @@ type Configuration struct {
 	DisableCypherQC        bool                      `json:"disable_cypher_qc"`
 	DisableMigrations      bool                      `json:"disable_migrations"`
 	TraversalMemoryLimit   uint16                    `json:"traversal_memory_limit"`
+	Apeman                 ApemanConfiguration       `json:"apeman"`
 }
 
 func (s Configuration) TempDirectory() string {
@@ -172,6 +173,18 @@ func (s Configuration) ClientLogDirectory() string {
 	return filepath.Join(s.WorkDir, "client_logs")
 }
 
+func (s Configuration) SnapshotDirectory() string {
+	return filepath.Join(s.WorkDir, "snapshots")
+}
+
+func (s Configuration) AlertStateDirectory() string {
+	return filepath.Join(s.WorkDir, "alerts")
+}
+
+func (s Configuration) RunDirectory() string {
+	return filepath.Join(s.WorkDir, "runs")
+}
+
 func (s Configuration) CollectorsDirectory() string {
 	return s.CollectorsBasePath
 }
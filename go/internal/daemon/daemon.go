@@ -0,0 +1,272 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hotnops/apeman/go/internal/config"
+	"github.com/hotnops/apeman/go/internal/schedule"
+)
+
+const (
+	TriggerDropDirectory = "drop_directory"
+	TriggerSchedule      = "schedule"
+	TriggerManual        = "manual"
+
+	defaultPollInterval = 60 * time.Second
+)
+
+var ErrRunInProgress = errors.New("a run is already in progress")
+
+// Step is one stage of a run. The returned string is a short description
+// of what the step did.
+type Step struct {
+	Name string
+	Run  func(ctx context.Context, run *RunRecord) (string, error)
+}
+
+// Daemon keeps the graph fresh by reingesting and reanalyzing it when the
+// drop directory changes or the schedule fires. Only one run happens at a
+// time.
+type Daemon struct {
+	cfg           config.DaemonConfiguration
+	runDirectory  string
+	schedule      *schedule.Schedule
+	analysisSteps []Step
+	running       sync.Mutex
+}
+
+func New(cfg config.DaemonConfiguration, runDirectory string, analysisSteps []Step) (*Daemon, error) {
+	d := &Daemon{
+		cfg:           cfg,
+		runDirectory:  runDirectory,
+		analysisSteps: analysisSteps,
+	}
+
+	if cfg.Schedule != "" {
+		parsed, err := schedule.Parse(cfg.Schedule)
+		if err != nil {
+			return nil, err
+		}
+		d.schedule = parsed
+	}
+
+	return d, nil
+}
+
+func (d *Daemon) RunDirectory() string {
+	return d.runDirectory
+}
+
+func (d *Daemon) pollInterval() time.Duration {
+	if d.cfg.PollInterval > 0 {
+		return time.Duration(d.cfg.PollInterval) * time.Second
+	}
+	return defaultPollInterval
+}
+
+// Replace the placeholders in an ingest command
+func (d *Daemon) expandCommand(command []string) []string {
+	replacer := strings.NewReplacer(
+		"{input}", d.cfg.DropDirectory,
+		"{import}", d.cfg.ImportDirectory,
+		"{collection}", d.cfg.Collection,
+	)
+	expanded := make([]string, len(command))
+	for i, arg := range command {
+		expanded[i] = replacer.Replace(arg)
+	}
+	return expanded
+}
+
+// The ingest commands as steps. Runs without a drop directory have
+// nothing to ingest.
+func (d *Daemon) ingestSteps() []Step {
+	if d.cfg.DropDirectory == "" {
+		return nil
+	}
+
+	steps := []Step{}
+	for _, command := range d.cfg.IngestCommands {
+		if len(command) == 0 {
+			continue
+		}
+		command := d.expandCommand(command)
+		steps = append(steps, Step{
+			Name: strings.Join(command, " "),
+			Run: func(ctx context.Context, run *RunRecord) (string, error) {
+				cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+				cmd.Dir = d.cfg.WorkingDirectory
+				output, err := cmd.CombinedOutput()
+				return truncateOutput(string(output)), err
+			},
+		})
+	}
+	return steps
+}
+
+// Start runs ingest and every analysis step in order in the background,
+// stopping at the first step that fails. The run is recorded as running
+// before Start returns it, and its outcome replaces the record once it
+// finishes.
+func (d *Daemon) Start(ctx context.Context, trigger string) (RunRecord, error) {
+	if !d.running.TryLock() {
+		return RunRecord{}, ErrRunInProgress
+	}
+
+	run := NewRunRecord(trigger, time.Now())
+	if err := SaveRunRecord(d.runDirectory, run); err != nil {
+		d.running.Unlock()
+		return RunRecord{}, err
+	}
+
+	started := *run
+	go func() {
+		defer d.running.Unlock()
+		if _, err := d.execute(ctx, run); err != nil {
+			log.Printf("[!] Could not save run %s: %s", run.ID, err.Error())
+		}
+	}()
+	return started, nil
+}
+
+func (d *Daemon) execute(ctx context.Context, run *RunRecord) (*RunRecord, error) {
+	log.Printf("[*] Starting %s run %s", run.Trigger, run.ID)
+
+	steps := append(d.ingestSteps(), d.analysisSteps...)
+	for _, step := range steps {
+		log.Printf("[*] Run %s: %s", run.ID, step.Name)
+		record := StepRecord{
+			Name:      step.Name,
+			StartedAt: time.Now().UTC(),
+		}
+		output, err := step.Run(ctx, run)
+		record.FinishedAt = time.Now().UTC()
+		record.Output = output
+		if err != nil {
+			record.Error = err.Error()
+		}
+		run.Steps = append(run.Steps, record)
+
+		if err != nil {
+			log.Printf("[!] Run %s failed at %s: %s", run.ID, step.Name, err.Error())
+			run.Status = RunStatusFailed
+			break
+		}
+	}
+
+	if run.Status != RunStatusFailed {
+		run.Status = RunStatusSucceeded
+	}
+	run.FinishedAt = time.Now().UTC()
+	log.Printf("[*] Run %s %s", run.ID, run.Status)
+
+	if err := SaveRunRecord(d.runDirectory, run); err != nil {
+		return run, err
+	}
+	return run, nil
+}
+
+func (d *Daemon) triggerInBackground(ctx context.Context, trigger string) {
+	if _, err := d.Start(ctx, trigger); err != nil {
+		log.Printf("[!] Could not start %s run: %s", trigger, err.Error())
+	}
+}
+
+// A summary of the authorization details files in dir. It changes when a
+// file is added, removed or modified.
+func dropDirectorySignature(dir string) (string, error) {
+	entries := []string{}
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, fmt.Sprintf("%s|%d|%d", path, info.Size(), info.ModTime().UnixNano()))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sort.Strings(entries)
+	return strings.Join(entries, "\n"), nil
+}
+
+// Run watches the drop directory and schedule until ctx is done. Files
+// already in the drop directory at start up don't trigger a run. A change
+// triggers a run once the directory stops changing for a poll interval,
+// so files that are still being copied in aren't ingested half written.
+func (d *Daemon) Run(ctx context.Context) {
+	var pollTicker <-chan time.Time
+	processedSignature := ""
+	lastSignature := ""
+	if d.cfg.DropDirectory != "" {
+		signature, err := dropDirectorySignature(d.cfg.DropDirectory)
+		if err != nil {
+			log.Printf("[!] Error reading drop directory: %s", err.Error())
+		}
+		processedSignature = signature
+		lastSignature = signature
+
+		ticker := time.NewTicker(d.pollInterval())
+		defer ticker.Stop()
+		pollTicker = ticker.C
+		log.Printf("[*] Watching %s for new authorization details", d.cfg.DropDirectory)
+	}
+
+	var scheduleTimer <-chan time.Time
+	nextScheduledRun := func() {
+		next := d.schedule.Next(time.Now())
+		if next.IsZero() {
+			log.Printf("[!] Schedule %s never fires", d.cfg.Schedule)
+			scheduleTimer = nil
+			return
+		}
+		log.Printf("[*] Next scheduled run at %s", next.Format(time.RFC3339))
+		scheduleTimer = time.After(time.Until(next))
+	}
+	if d.schedule != nil {
+		nextScheduledRun()
+	}
+
+	if pollTicker == nil && scheduleTimer == nil {
+		log.Printf("[!] Daemon has neither a drop directory nor a schedule")
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-pollTicker:
+			signature, err := dropDirectorySignature(d.cfg.DropDirectory)
+			if err != nil {
+				log.Printf("[!] Error reading drop directory: %s", err.Error())
+				continue
+			}
+			if signature != processedSignature && signature == lastSignature {
+				processedSignature = signature
+				d.triggerInBackground(ctx, TriggerDropDirectory)
+			}
+			lastSignature = signature
+		case <-scheduleTimer:
+			d.triggerInBackground(ctx, TriggerSchedule)
+			nextScheduledRun()
+		}
+	}
+}
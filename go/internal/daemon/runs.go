@@ -0,0 +1,107 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	RunStatusRunning   = "running"
+	RunStatusSucceeded = "succeeded"
+	RunStatusFailed    = "failed"
+
+	// Only the end of a command's output is kept in the run record
+	maxOutputLength = 4096
+)
+
+type StepRecord struct {
+	Name       string    `json:"name"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Output     string    `json:"output"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type RunRecord struct {
+	ID         string       `json:"id"`
+	Trigger    string       `json:"trigger"`
+	Status     string       `json:"status"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt time.Time    `json:"finished_at"`
+	Steps      []StepRecord `json:"steps"`
+}
+
+func NewRunRecord(trigger string, startedAt time.Time) *RunRecord {
+	return &RunRecord{
+		ID:        startedAt.UTC().Format("20060102T150405Z"),
+		Trigger:   trigger,
+		Status:    RunStatusRunning,
+		StartedAt: startedAt.UTC(),
+		Steps:     []StepRecord{},
+	}
+}
+
+func truncateOutput(output string) string {
+	if len(output) <= maxOutputLength {
+		return output
+	}
+	return "..." + output[len(output)-maxOutputLength:]
+}
+
+func SaveRunRecord(dir string, run *RunRecord) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed creating run directory %s: %w", dir, err)
+	}
+
+	content, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, run.ID+".json")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return fmt.Errorf("failed writing run record %s: %w", path, err)
+	}
+	return nil
+}
+
+// ListRunRecords returns the recorded runs, newest first
+func ListRunRecords(dir string) ([]RunRecord, error) {
+	runs := []RunRecord{}
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return runs, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed reading run directory %s: %w", dir, err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading run record %s: %w", path, err)
+		}
+
+		var run RunRecord
+		if err := json.Unmarshal(content, &run); err != nil {
+			return nil, fmt.Errorf("failed parsing run record %s: %w", path, err)
+		}
+		runs = append(runs, run)
+	}
+
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].StartedAt.After(runs[j].StartedAt)
+	})
+
+	return runs, nil
+}
@@ -45,6 +45,9 @@ var (
 	AWSGroup = graph.StringKind("AWSGroup")
 	UniqueArn = graph.StringKind("UniqueArn")
 	AWSResourceType = graph.StringKind("AWSResourceType")
+	AWSFinding = graph.StringKind("AWSFinding")
+	AWSAccessKey = graph.StringKind("AWSAccessKey")
+	AWSCloudTrailCoverage = graph.StringKind("AWSCloudTrailCoverage")
 	
 	ActsOn = graph.StringKind("ActsOn")
 	AllowAction = graph.StringKind("Action")
@@ -54,24 +57,41 @@ var (
 	Resource = graph.StringKind("Resource")
 	NotResource = graph.StringKind("NotResource")
 	MemberOf = graph.StringKind("MemberOf")
+	PermissionsBoundary = graph.StringKind("PermissionsBoundary")
 	TypeOf = graph.StringKind("TypeOf")
 	IdentityTransform = graph.StringKind("IdentityTransform")
+	Affects = graph.StringKind("Affects")
+	Evidence = graph.StringKind("Evidence")
+	UsedAction = graph.StringKind("UsedAction")
 
 )
 
 type Property string
 
 const (
+	AccessKey1Active		Property = "access_key_1_active"
+	AccessKey2Active		Property = "access_key_2_active"
+	AccessLevel				Property = "access_level"
 	AttachmentCount			Property = "attachmentcount"
+	Collection				Property = "collection"
 	CreateDate				Property = "createdate"
 	DefaultVersionId		Property = "defaultversionid"
+	FirstEvent				Property = "first_event"
 	IsAttachable			Property = "isattachable"
+	LastEvent				Property = "last_event"
+	LastUsed				Property = "last_used"
+	MFAActive				Property = "mfa_active"
+	PasswordEnabled			Property = "password_enabled"
+	PasswordLastChanged		Property = "password_last_changed"
+	PasswordLastUsed		Property = "password_last_used"
 	Path 					Property = "path"
 	PermissionsBoundaryUsageCount Property = "permissionsboundaryusagecount"
 	PolicyId				Property = "policyid"
 	PolicyName				Property = "policyname"
 	RoleId					Property = "roleid"
+	RoleLastUsed			Property = "rolelastused"
 	RoleName				Property = "rolename"
+	SourceIPs				Property = "source_ips"
 	UpdateDate				Property = "updatedate"
 )
 
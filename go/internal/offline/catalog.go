@@ -0,0 +1,65 @@
+package offline
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Catalog is the part of the AWS schema the analysis relies on: the
+// actions, and the resource types each of them acts on. The init script
+// loads every service from the service authorization reference, while a
+// catalog only has to list what a scenario uses. Names are service:name,
+// like the actions and resource types in the graph.
+type Catalog struct {
+	ResourceTypes []CatalogResourceType `json:"ResourceTypes"`
+	Actions       []CatalogAction       `json:"Actions"`
+}
+
+type CatalogResourceType struct {
+	Name string `json:"Name"`
+	// The ARN format from the service authorization reference, like
+	// arn:${Partition}:iam::${Account}:role/${RoleNameWithPath}
+	Arn string `json:"Arn"`
+}
+
+type CatalogAction struct {
+	Name          string   `json:"Name"`
+	ResourceTypes []string `json:"ResourceTypes"`
+}
+
+func ParseCatalog(data []byte) (*Catalog, error) {
+	catalog := &Catalog{}
+	if err := json.Unmarshal(data, catalog); err != nil {
+		return nil, fmt.Errorf("failed parsing catalog: %w", err)
+	}
+	return catalog, nil
+}
+
+var arnFormatVariable = regexp.MustCompile(`\$\{[^}]+\}`)
+var repeatedWildcards = regexp.MustCompile(`\.\*+`)
+
+// The regex the init script stores on a resource type, where every
+// variable in the ARN format matches anything
+func resourceTypeRegex(arnFormat string) string {
+	pattern := arnFormatVariable.ReplaceAllString(arnFormat, ".*")
+	pattern = repeatedWildcards.ReplaceAllString(pattern, ".*")
+
+	escaped := strings.Builder{}
+	for _, char := range pattern {
+		if strings.ContainsRune(`\^$|?+()[]{}`, char) {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(char)
+	}
+	return strings.ToLower(escaped.String())
+}
+
+// The regex the ingest stores on action, resource and principal blobs
+func blobRegex(name string) string {
+	name = strings.ReplaceAll(name, ".", "\\.")
+	name = strings.ReplaceAll(name, "*", ".*")
+	name = strings.ReplaceAll(name, "?", "\\?")
+	return strings.ReplaceAll(name, "[", "\\[")
+}
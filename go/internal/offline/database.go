@@ -0,0 +1,512 @@
+// Package offline is an in-memory graph.Database for tests. It runs the
+// Cypher the queries package sends, so the analysis can be checked
+// against known scenarios without a running Neo4j. Authorization details
+// are loaded the way the ingest loads them.
+//
+// Only the part of Cypher the queries use is supported: MATCH, OPTIONAL
+// MATCH, WITH, UNWIND, RETURN, MERGE, CREATE, SET, REMOVE and DELETE, with
+// the functions the queries call.
+package offline
+
+//go:generate go run ../../cmd/cfn2gaad -policies testdata/aws_managed_policies.json -o testdata/dev_account.json ../../../testenv/dev_account.yaml
+//go:generate go run ../../cmd/cfn2gaad -account 210987654321 -parameter DevAccountId=123456789012 -o testdata/prod_account.json ../../../testenv/prod_account.yaml
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j/dbtype"
+	"github.com/specterops/bloodhound/dawgs/drivers/neo4j"
+	"github.com/specterops/bloodhound/dawgs/graph"
+	"github.com/specterops/bloodhound/dawgs/util/size"
+)
+
+var errReadOnly = errors.New("write in a read transaction")
+
+type storedNode struct {
+	id         int64
+	labels     []string
+	properties map[string]any
+	// The relationships starting and ending at the node
+	outbound []int64
+	inbound  []int64
+}
+
+type storedRelationship struct {
+	id         int64
+	start      int64
+	end        int64
+	kind       string
+	properties map[string]any
+}
+
+func (n *storedNode) hasLabel(label string) bool {
+	for _, existing := range n.labels {
+		if existing == label {
+			return true
+		}
+	}
+	return false
+}
+
+// The nodes and relationships of a database. A write transaction works on
+// a copy, which replaces the original when the transaction succeeds.
+type store struct {
+	nextNodeID         int64
+	nextRelationshipID int64
+	nodes              map[int64]*storedNode
+	// Node IDs in the order the nodes were created. Deleted nodes are
+	// skipped when iterating.
+	nodeOrder     []int64
+	relationships map[int64]*storedRelationship
+}
+
+func newStore() *store {
+	return &store{
+		nodes:         map[int64]*storedNode{},
+		relationships: map[int64]*storedRelationship{},
+	}
+}
+
+func (s *store) clone() *store {
+	copied := &store{
+		nextNodeID:         s.nextNodeID,
+		nextRelationshipID: s.nextRelationshipID,
+		nodes:              make(map[int64]*storedNode, len(s.nodes)),
+		nodeOrder:          make([]int64, 0, len(s.nodes)),
+		relationships:      make(map[int64]*storedRelationship, len(s.relationships)),
+	}
+	for _, id := range s.nodeOrder {
+		node, ok := s.nodes[id]
+		if !ok {
+			continue
+		}
+		copied.nodes[id] = &storedNode{
+			id:         node.id,
+			labels:     append([]string{}, node.labels...),
+			properties: cloneProperties(node.properties),
+			outbound:   append([]int64{}, node.outbound...),
+			inbound:    append([]int64{}, node.inbound...),
+		}
+		copied.nodeOrder = append(copied.nodeOrder, id)
+	}
+	for id, relationship := range s.relationships {
+		copied.relationships[id] = &storedRelationship{
+			id:         relationship.id,
+			start:      relationship.start,
+			end:        relationship.end,
+			kind:       relationship.kind,
+			properties: cloneProperties(relationship.properties),
+		}
+	}
+	return copied
+}
+
+// Call delegate with every node, in the order they were created, until it
+// returns false
+func (s *store) eachNode(delegate func(node *storedNode) bool) {
+	for _, id := range s.nodeOrder {
+		if node, ok := s.nodes[id]; ok && !delegate(node) {
+			return
+		}
+	}
+}
+
+func (s *store) createNode(labels []string, properties map[string]any) *storedNode {
+	s.nextNodeID++
+	node := &storedNode{
+		id:         s.nextNodeID,
+		properties: map[string]any{},
+	}
+	for _, label := range labels {
+		if !node.hasLabel(label) {
+			node.labels = append(node.labels, label)
+		}
+	}
+	setProperties(node.properties, properties)
+	s.nodes[node.id] = node
+	s.nodeOrder = append(s.nodeOrder, node.id)
+	return node
+}
+
+func (s *store) createRelationship(start int64, end int64, kind string, properties map[string]any) (*storedRelationship, error) {
+	startNode, ok := s.nodes[start]
+	if !ok {
+		return nil, fmt.Errorf("node %d not found", start)
+	}
+	endNode, ok := s.nodes[end]
+	if !ok {
+		return nil, fmt.Errorf("node %d not found", end)
+	}
+
+	s.nextRelationshipID++
+	relationship := &storedRelationship{
+		id:         s.nextRelationshipID,
+		start:      start,
+		end:        end,
+		kind:       kind,
+		properties: map[string]any{},
+	}
+	setProperties(relationship.properties, properties)
+	s.relationships[relationship.id] = relationship
+	startNode.outbound = append(startNode.outbound, relationship.id)
+	endNode.inbound = append(endNode.inbound, relationship.id)
+	return relationship, nil
+}
+
+func removeID(ids []int64, id int64) []int64 {
+	for i, existing := range ids {
+		if existing == id {
+			return append(ids[:i], ids[i+1:]...)
+		}
+	}
+	return ids
+}
+
+func (s *store) deleteRelationship(id int64) {
+	relationship, ok := s.relationships[id]
+	if !ok {
+		return
+	}
+	if start, ok := s.nodes[relationship.start]; ok {
+		start.outbound = removeID(start.outbound, id)
+	}
+	if end, ok := s.nodes[relationship.end]; ok {
+		end.inbound = removeID(end.inbound, id)
+	}
+	delete(s.relationships, id)
+}
+
+// Delete a node. Nodes with relationships are only deleted along with
+// them when detach is set, like DETACH DELETE.
+func (s *store) deleteNode(id int64, detach bool) error {
+	node, ok := s.nodes[id]
+	if !ok {
+		return nil
+	}
+	if len(node.outbound)+len(node.inbound) > 0 {
+		if !detach {
+			return fmt.Errorf("node %d still has relationships", id)
+		}
+		for _, relationshipID := range append(append([]int64{}, node.outbound...), node.inbound...) {
+			s.deleteRelationship(relationshipID)
+		}
+	}
+	delete(s.nodes, id)
+	return nil
+}
+
+// Database is a graph.Database that keeps the graph in memory. Read
+// transactions see the graph as it was when they started. Write
+// transactions run one at a time and are rolled back when they fail.
+type Database struct {
+	lock    sync.Mutex
+	current *store
+	// Held for the whole of a write transaction
+	writeLock sync.Mutex
+	// Parsed queries by their text
+	queries sync.Map
+}
+
+func NewDatabase() *Database {
+	return &Database{current: newStore()}
+}
+
+func (d *Database) getStore() *store {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.current
+}
+
+func (d *Database) parse(text string) (*cypherQuery, error) {
+	if query, ok := d.queries.Load(text); ok {
+		return query.(*cypherQuery), nil
+	}
+	query, err := parseQuery(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing query %q: %w", text, err)
+	}
+	d.queries.Store(text, query)
+	return query, nil
+}
+
+func (d *Database) SetWriteFlushSize(interval int) {}
+
+func (d *Database) SetBatchWriteSize(interval int) {}
+
+func (d *Database) ReadTransaction(ctx context.Context, txDelegate graph.TransactionDelegate, options ...graph.TransactionOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return txDelegate(&transaction{ctx: ctx, db: d, store: d.getStore()})
+}
+
+func (d *Database) WriteTransaction(ctx context.Context, txDelegate graph.TransactionDelegate, options ...graph.TransactionOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	d.writeLock.Lock()
+	defer d.writeLock.Unlock()
+
+	tx := &transaction{ctx: ctx, db: d, store: d.getStore().clone(), writable: true}
+	if err := txDelegate(tx); err != nil {
+		return err
+	}
+
+	d.lock.Lock()
+	d.current = tx.store
+	d.lock.Unlock()
+	return nil
+}
+
+func (d *Database) BatchOperation(ctx context.Context, batchDelegate graph.BatchDelegate) error {
+	return errors.New("batch operations are not supported")
+}
+
+func (d *Database) AssertSchema(ctx context.Context, schema *graph.Schema) error {
+	return nil
+}
+
+func (d *Database) FetchSchema(ctx context.Context) (*graph.Schema, error) {
+	return graph.NewSchema(), nil
+}
+
+func (d *Database) Run(ctx context.Context, query string, parameters map[string]any) error {
+	return d.WriteTransaction(ctx, func(tx graph.Transaction) error {
+		result := tx.Run(query, parameters)
+		defer result.Close()
+		return result.Error()
+	})
+}
+
+func (d *Database) Close() error {
+	return nil
+}
+
+type transaction struct {
+	ctx      context.Context
+	db       *Database
+	store    *store
+	writable bool
+}
+
+func (t *transaction) CreateNode(properties *graph.Properties, kinds ...graph.Kind) (*graph.Node, error) {
+	if !t.writable {
+		return nil, errReadOnly
+	}
+	node := t.store.createNode(graph.Kinds(kinds).Strings(), getPropertyMap(properties))
+	return newNode(node), nil
+}
+
+func (t *transaction) UpdateNode(target *graph.Node) error {
+	if !t.writable {
+		return errReadOnly
+	}
+	node, ok := t.store.nodes[int64(target.ID)]
+	if !ok {
+		return fmt.Errorf("node %d not found", target.ID)
+	}
+
+	for _, kind := range target.AddedKinds {
+		if !node.hasLabel(kind.String()) {
+			node.labels = append(node.labels, kind.String())
+		}
+	}
+	for _, kind := range target.DeletedKinds {
+		labels := []string{}
+		for _, label := range node.labels {
+			if label != kind.String() {
+				labels = append(labels, label)
+			}
+		}
+		node.labels = labels
+	}
+	updateProperties(node.properties, target.Properties)
+	return nil
+}
+
+func (t *transaction) UpdateNodeBy(update graph.NodeUpdate) error {
+	return errors.New("updating nodes by criteria is not supported")
+}
+
+func (t *transaction) Nodes() graph.NodeQuery {
+	return neo4j.NewNodeQuery(t.ctx, t)
+}
+
+func (t *transaction) CreateRelationship(startNode, endNode *graph.Node, kind graph.Kind, properties *graph.Properties) (*graph.Relationship, error) {
+	return t.CreateRelationshipByIDs(startNode.ID, endNode.ID, kind, properties)
+}
+
+func (t *transaction) CreateRelationshipByIDs(startNodeID, endNodeID graph.ID, kind graph.Kind, properties *graph.Properties) (*graph.Relationship, error) {
+	if !t.writable {
+		return nil, errReadOnly
+	}
+	relationship, err := t.store.createRelationship(int64(startNodeID), int64(endNodeID), kind.String(), getPropertyMap(properties))
+	if err != nil {
+		return nil, err
+	}
+	return newRelationship(relationship), nil
+}
+
+func (t *transaction) UpdateRelationship(target *graph.Relationship) error {
+	if !t.writable {
+		return errReadOnly
+	}
+	relationship, ok := t.store.relationships[int64(target.ID)]
+	if !ok {
+		return fmt.Errorf("relationship %d not found", target.ID)
+	}
+	updateProperties(relationship.properties, target.Properties)
+	return nil
+}
+
+func (t *transaction) UpdateRelationshipBy(update graph.RelationshipUpdate) error {
+	return errors.New("updating relationships by criteria is not supported")
+}
+
+func (t *transaction) Relationships() graph.RelationshipQuery {
+	return neo4j.NewRelationshipQuery(t.ctx, t)
+}
+
+func (t *transaction) Run(query string, parameters map[string]any) graph.Result {
+	parsed, err := t.db.parse(query)
+	if err != nil {
+		return &result{err: err}
+	}
+	if !t.writable && parsed.writes() {
+		return &result{err: errReadOnly}
+	}
+
+	rows, err := newExecutor(t, parameters).run(parsed)
+	if err != nil {
+		return &result{err: fmt.Errorf("failed running query %q: %w", query, err)}
+	}
+	return &result{rows: rows}
+}
+
+func (t *transaction) Commit() error {
+	return nil
+}
+
+// The default of the Neo4j driver
+func (t *transaction) TraversalMemoryLimit() size.Size {
+	return 2 * size.Gibibyte
+}
+
+// The rows of a query, with the values the Neo4j driver would return
+type result struct {
+	rows  [][]any
+	index int
+	err   error
+}
+
+func (r *result) Next() bool {
+	if r.err != nil || r.index >= len(r.rows) {
+		return false
+	}
+	r.index++
+	return true
+}
+
+func (r *result) Values() graph.ValueMapper {
+	if r.index == 0 {
+		return neo4j.NewValueMapper(nil)
+	}
+	return neo4j.NewValueMapper(r.rows[r.index-1])
+}
+
+func (r *result) Scan(targets ...any) error {
+	return r.Values().Scan(targets...)
+}
+
+func (r *result) Error() error {
+	return r.err
+}
+
+func (r *result) Close() {}
+
+func getPropertyMap(properties *graph.Properties) map[string]any {
+	if properties == nil {
+		return nil
+	}
+	return properties.Map
+}
+
+// Set the modified properties and remove the deleted ones
+func updateProperties(target map[string]any, properties *graph.Properties) {
+	if properties == nil {
+		return
+	}
+	setProperties(target, properties.ModifiedProperties())
+	for _, name := range properties.DeletedProperties() {
+		delete(target, name)
+	}
+}
+
+// Set properties the way SET does, where null removes a property
+func setProperties(target map[string]any, properties map[string]any) {
+	for name, value := range properties {
+		if value = normalize(value); value == nil {
+			delete(target, name)
+		} else {
+			target[name] = value
+		}
+	}
+}
+
+func cloneProperties(properties map[string]any) map[string]any {
+	copied := make(map[string]any, len(properties))
+	for name, value := range properties {
+		copied[name] = cloneValue(value)
+	}
+	return copied
+}
+
+func cloneValue(value any) any {
+	switch typed := value.(type) {
+	case []any:
+		items := make([]any, len(typed))
+		for i, item := range typed {
+			items[i] = cloneValue(item)
+		}
+		return items
+	case map[string]any:
+		return cloneProperties(typed)
+	}
+	return value
+}
+
+// The node as the Neo4j driver returns it
+func toDriverNode(node *storedNode) dbtype.Node {
+	return dbtype.Node{
+		Id:        node.id,
+		ElementId: fmt.Sprint(node.id),
+		Labels:    append([]string{}, node.labels...),
+		Props:     cloneProperties(node.properties),
+	}
+}
+
+func toDriverRelationship(relationship *storedRelationship) dbtype.Relationship {
+	return dbtype.Relationship{
+		Id:             relationship.id,
+		ElementId:      fmt.Sprint(relationship.id),
+		StartId:        relationship.start,
+		StartElementId: fmt.Sprint(relationship.start),
+		EndId:          relationship.end,
+		EndElementId:   fmt.Sprint(relationship.end),
+		Type:           relationship.kind,
+		Props:          cloneProperties(relationship.properties),
+	}
+}
+
+func newNode(node *storedNode) *graph.Node {
+	return graph.NewNode(graph.ID(node.id), graph.AsProperties(cloneProperties(node.properties)), graph.StringsToKinds(node.labels)...)
+}
+
+func newRelationship(relationship *storedRelationship) *graph.Relationship {
+	return graph.NewRelationship(graph.ID(relationship.id), graph.ID(relationship.start), graph.ID(relationship.end),
+		graph.AsProperties(cloneProperties(relationship.properties)), graph.StringKind(relationship.kind))
+}
@@ -0,0 +1,780 @@
+package offline
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// The variables of a row, by name
+type row map[string]any
+
+func (r row) clone() row {
+	copied := make(row, len(r))
+	for name, value := range r {
+		copied[name] = value
+	}
+	return copied
+}
+
+// Compiled =~ and regexGroups patterns
+var regexCache sync.Map
+
+func getRegex(pattern string, fullMatch bool) (*regexp.Regexp, error) {
+	key := pattern
+	if fullMatch {
+		key = "^(?:" + pattern + ")$"
+	}
+	if regex, ok := regexCache.Load(key); ok {
+		return regex.(*regexp.Regexp), nil
+	}
+	regex, err := regexp.Compile(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex %q: %w", pattern, err)
+	}
+	regexCache.Store(key, regex)
+	return regex, nil
+}
+
+var aggregateFunctions = map[string]bool{
+	"count":   true,
+	"collect": true,
+	"sum":     true,
+	"min":     true,
+	"max":     true,
+}
+
+// The number of arguments of each function, apart from coalesce, which
+// takes any number
+var functionArguments = map[string]int{
+	"id":                    1,
+	"size":                  1,
+	"length":                1,
+	"nodes":                 1,
+	"relationships":         1,
+	"rels":                  1,
+	"startnode":             1,
+	"endnode":               1,
+	"labels":                1,
+	"type":                  1,
+	"keys":                  1,
+	"properties":            1,
+	"head":                  1,
+	"last":                  1,
+	"tolower":               1,
+	"toupper":               1,
+	"trim":                  1,
+	"tostring":              1,
+	"tointeger":             1,
+	"split":                 2,
+	"replace":               3,
+	"apoc.text.regexgroups": 2,
+}
+
+func isAggregate(call *functionCall) bool {
+	return aggregateFunctions[call.name]
+}
+
+func (e *executor) evaluate(expr expression, r row) (any, error) {
+	switch typed := expr.(type) {
+	case *literal:
+		return typed.value, nil
+
+	case *parameter:
+		value, ok := e.parameters[typed.name]
+		if !ok {
+			return nil, fmt.Errorf("missing parameter $%s", typed.name)
+		}
+		return value, nil
+
+	case *variable:
+		value, ok := r[typed.name]
+		if !ok {
+			return nil, fmt.Errorf("variable %s not defined", typed.name)
+		}
+		return value, nil
+
+	case *propertyAccess:
+		subject, err := e.evaluate(typed.subject, r)
+		if err != nil {
+			return nil, err
+		}
+		return getProperty(subject, typed.property)
+
+	case *indexAccess:
+		subject, err := e.evaluate(typed.subject, r)
+		if err != nil {
+			return nil, err
+		}
+		index, err := e.evaluate(typed.index, r)
+		if err != nil {
+			return nil, err
+		}
+		return getIndex(subject, index)
+
+	case *listLiteral:
+		items := make([]any, 0, len(typed.items))
+		for _, item := range typed.items {
+			value, err := e.evaluate(item, r)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+		}
+		return items, nil
+
+	case *mapLiteral:
+		items := make(map[string]any, len(typed.keys))
+		for i, key := range typed.keys {
+			value, err := e.evaluate(typed.values[i], r)
+			if err != nil {
+				return nil, err
+			}
+			items[key] = value
+		}
+		return items, nil
+
+	case *labelCheck:
+		subject, err := e.evaluate(typed.subject, r)
+		if err != nil {
+			return nil, err
+		}
+		if subject == nil {
+			return nil, nil
+		}
+		node, ok := subject.(*storedNode)
+		if !ok {
+			return nil, fmt.Errorf("label check on %s", typeName(subject))
+		}
+		for _, label := range typed.labels {
+			if !node.hasLabel(label) {
+				return false, nil
+			}
+		}
+		return true, nil
+
+	case *unaryOperation:
+		return e.evaluateUnary(typed, r)
+
+	case *binaryOperation:
+		return e.evaluateBinary(typed, r)
+
+	case *listPredicate:
+		return e.evaluateListPredicate(typed, r)
+
+	case *patternPredicate:
+		matched := false
+		err := e.matchPatterns([]*pattern{typed.pattern}, nil, r, func(row) (bool, error) {
+			matched = true
+			return false, nil
+		})
+		return matched, err
+
+	case *functionCall:
+		if isAggregate(typed) {
+			value, ok := e.aggregates[typed]
+			if !ok {
+				return nil, fmt.Errorf("%s is only allowed in WITH and RETURN", typed.name)
+			}
+			return value, nil
+		}
+		return e.callFunction(typed, r)
+	}
+	return nil, fmt.Errorf("unsupported expression %T", expr)
+}
+
+func getProperty(subject any, property string) (any, error) {
+	switch typed := subject.(type) {
+	case nil:
+		return nil, nil
+	case *storedNode:
+		return typed.properties[property], nil
+	case *storedRelationship:
+		return typed.properties[property], nil
+	case map[string]any:
+		return typed[property], nil
+	}
+	return nil, fmt.Errorf("property %s of %s", property, typeName(subject))
+}
+
+func getIndex(subject any, index any) (any, error) {
+	if subject == nil || index == nil {
+		return nil, nil
+	}
+	switch typed := subject.(type) {
+	case []any:
+		position, ok := index.(int64)
+		if !ok {
+			return nil, fmt.Errorf("list index of type %s", typeName(index))
+		}
+		if position < 0 {
+			position += int64(len(typed))
+		}
+		if position < 0 || position >= int64(len(typed)) {
+			return nil, nil
+		}
+		return typed[position], nil
+	case map[string]any, *storedNode, *storedRelationship:
+		name, ok := index.(string)
+		if !ok {
+			return nil, fmt.Errorf("property name of type %s", typeName(index))
+		}
+		return getProperty(subject, name)
+	}
+	return nil, fmt.Errorf("index of %s", typeName(subject))
+}
+
+func (e *executor) evaluateUnary(operation *unaryOperation, r row) (any, error) {
+	operand, err := e.evaluate(operation.operand, r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch operation.operator {
+	case "not":
+		value, err := toBool(operand)
+		if err != nil {
+			return nil, err
+		}
+		return not(value), nil
+	case "is null":
+		return operand == nil, nil
+	case "is not null":
+		return operand != nil, nil
+	case "-":
+		switch typed := operand.(type) {
+		case nil:
+			return nil, nil
+		case int64:
+			return -typed, nil
+		case float64:
+			return -typed, nil
+		}
+		return nil, fmt.Errorf("negation of %s", typeName(operand))
+	}
+	return nil, fmt.Errorf("unsupported operator %s", operation.operator)
+}
+
+func (e *executor) evaluateBinary(operation *binaryOperation, r row) (any, error) {
+	left, err := e.evaluate(operation.left, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// AND and OR skip the right side when the left side decides
+	switch operation.operator {
+	case "and", "or", "xor":
+		if left, err = toBool(left); err != nil {
+			return nil, err
+		}
+		if operation.operator == "and" && left == false {
+			return false, nil
+		}
+		if operation.operator == "or" && left == true {
+			return true, nil
+		}
+	}
+
+	right, err := e.evaluate(operation.right, r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch operation.operator {
+	case "and", "or", "xor":
+		if right, err = toBool(right); err != nil {
+			return nil, err
+		}
+		switch operation.operator {
+		case "and":
+			return and(left, right), nil
+		case "or":
+			return or(left, right), nil
+		}
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		return left != right, nil
+
+	case "=":
+		return equals(left, right), nil
+
+	case "<>":
+		return not(equals(left, right)), nil
+
+	case "<", ">", "<=", ">=":
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		result, ok := compare(left, right)
+		if !ok {
+			return nil, nil
+		}
+		switch operation.operator {
+		case "<":
+			return result < 0, nil
+		case ">":
+			return result > 0, nil
+		case "<=":
+			return result <= 0, nil
+		}
+		return result >= 0, nil
+
+	case "=~":
+		text, ok := left.(string)
+		pattern, patternOK := right.(string)
+		if !ok || !patternOK {
+			return nil, nil
+		}
+		regex, err := getRegex(pattern, true)
+		if err != nil {
+			return nil, err
+		}
+		return regex.MatchString(text), nil
+
+	case "starts with", "ends with", "contains":
+		text, ok := left.(string)
+		other, otherOK := right.(string)
+		if !ok || !otherOK {
+			return nil, nil
+		}
+		switch operation.operator {
+		case "starts with":
+			return strings.HasPrefix(text, other), nil
+		case "ends with":
+			return strings.HasSuffix(text, other), nil
+		}
+		return strings.Contains(text, other), nil
+
+	case "in":
+		if right == nil {
+			return nil, nil
+		}
+		items, ok := right.([]any)
+		if !ok {
+			return nil, fmt.Errorf("IN of %s", typeName(right))
+		}
+		var result any = false
+		for _, item := range items {
+			result = or(result, equals(left, item))
+			if result == true {
+				break
+			}
+		}
+		return result, nil
+
+	case "+":
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		if leftItems, ok := left.([]any); ok {
+			if rightItems, ok := right.([]any); ok {
+				return append(append([]any{}, leftItems...), rightItems...), nil
+			}
+			return append(append([]any{}, leftItems...), right), nil
+		}
+		if leftText, ok := left.(string); ok {
+			return leftText + toText(right), nil
+		}
+		if rightText, ok := right.(string); ok {
+			return toText(left) + rightText, nil
+		}
+		return arithmetic(operation.operator, left, right)
+
+	case "-", "*", "/", "%":
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		return arithmetic(operation.operator, left, right)
+	}
+	return nil, fmt.Errorf("unsupported operator %s", operation.operator)
+}
+
+func arithmetic(operator string, left any, right any) (any, error) {
+	if !isNumber(left) || !isNumber(right) {
+		return nil, fmt.Errorf("%s of %s and %s", operator, typeName(left), typeName(right))
+	}
+
+	leftInt, leftIsInt := left.(int64)
+	rightInt, rightIsInt := right.(int64)
+	if leftIsInt && rightIsInt {
+		switch operator {
+		case "+":
+			return leftInt + rightInt, nil
+		case "-":
+			return leftInt - rightInt, nil
+		case "*":
+			return leftInt * rightInt, nil
+		}
+		if rightInt == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		if operator == "/" {
+			return leftInt / rightInt, nil
+		}
+		return leftInt % rightInt, nil
+	}
+
+	leftFloat, rightFloat := toFloat(left), toFloat(right)
+	switch operator {
+	case "+":
+		return leftFloat + rightFloat, nil
+	case "-":
+		return leftFloat - rightFloat, nil
+	case "*":
+		return leftFloat * rightFloat, nil
+	case "/":
+		return leftFloat / rightFloat, nil
+	}
+	return math.Mod(leftFloat, rightFloat), nil
+}
+
+// The text toString gives a value
+func toText(value any) string {
+	switch typed := value.(type) {
+	case string:
+		return typed
+	case int64:
+		return strconv.FormatInt(typed, 10)
+	case float64:
+		text := strconv.FormatFloat(typed, 'f', -1, 64)
+		if !strings.ContainsAny(text, ".eEN") {
+			text += ".0"
+		}
+		return text
+	case bool:
+		return strconv.FormatBool(typed)
+	}
+	return fmt.Sprint(value)
+}
+
+func (e *executor) evaluateListPredicate(predicate *listPredicate, r row) (any, error) {
+	list, err := e.evaluate(predicate.list, r)
+	if err != nil || list == nil {
+		return nil, err
+	}
+	items, ok := list.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s over %s", predicate.kind, typeName(list))
+	}
+
+	// The variable shadows any of the same name while the predicate runs
+	previous, hadPrevious := r[predicate.variable]
+	defer func() {
+		if hadPrevious {
+			r[predicate.variable] = previous
+		} else {
+			delete(r, predicate.variable)
+		}
+	}()
+
+	matches, unknown := 0, false
+	for _, item := range items {
+		r[predicate.variable] = item
+		value, err := e.evaluate(predicate.predicate, r)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = toBool(value); err != nil {
+			return nil, err
+		}
+
+		switch value {
+		case true:
+			matches++
+			if predicate.kind == "any" || predicate.kind == "none" {
+				return predicate.kind == "any", nil
+			}
+		case false:
+			if predicate.kind == "all" {
+				return false, nil
+			}
+		default:
+			unknown = true
+		}
+		if predicate.kind == "single" && matches > 1 {
+			return false, nil
+		}
+	}
+
+	if unknown {
+		return nil, nil
+	}
+	switch predicate.kind {
+	case "all":
+		return true, nil
+	case "any":
+		return false, nil
+	case "none":
+		return true, nil
+	}
+	return matches == 1, nil
+}
+
+func (e *executor) callFunction(call *functionCall, r row) (any, error) {
+	arguments := make([]any, 0, len(call.arguments))
+	for _, argument := range call.arguments {
+		value, err := e.evaluate(argument, r)
+		if err != nil {
+			return nil, err
+		}
+		arguments = append(arguments, value)
+	}
+
+	if call.name == "coalesce" {
+		for _, argument := range arguments {
+			if argument != nil {
+				return argument, nil
+			}
+		}
+		return nil, nil
+	}
+
+	expected, ok := functionArguments[call.name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported function %s", call.name)
+	}
+	if len(arguments) != expected {
+		return nil, fmt.Errorf("%s takes %d arguments", call.name, expected)
+	}
+
+	// Every function supported here returns null for null
+	for _, argument := range arguments {
+		if argument == nil {
+			return nil, nil
+		}
+	}
+	argument := arguments[0]
+
+	switch call.name {
+	case "id":
+		switch typed := argument.(type) {
+		case *storedNode:
+			return typed.id, nil
+		case *storedRelationship:
+			return typed.id, nil
+		}
+
+	case "size", "length":
+		switch typed := argument.(type) {
+		case []any:
+			return int64(len(typed)), nil
+		case string:
+			return int64(utf8.RuneCountInString(typed)), nil
+		case *pathValue:
+			return int64(len(typed.relationships)), nil
+		}
+
+	case "nodes":
+		if path, ok := argument.(*pathValue); ok {
+			items := make([]any, len(path.nodes))
+			for i, node := range path.nodes {
+				items[i] = node
+			}
+			return items, nil
+		}
+
+	case "relationships", "rels":
+		if path, ok := argument.(*pathValue); ok {
+			items := make([]any, len(path.relationships))
+			for i, relationship := range path.relationships {
+				items[i] = relationship
+			}
+			return items, nil
+		}
+
+	case "startnode", "endnode":
+		if relationship, ok := argument.(*storedRelationship); ok {
+			if call.name == "startnode" {
+				return e.store().nodes[relationship.start], nil
+			}
+			return e.store().nodes[relationship.end], nil
+		}
+
+	case "labels":
+		if node, ok := argument.(*storedNode); ok {
+			items := make([]any, len(node.labels))
+			for i, label := range node.labels {
+				items[i] = label
+			}
+			return items, nil
+		}
+
+	case "type":
+		if relationship, ok := argument.(*storedRelationship); ok {
+			return relationship.kind, nil
+		}
+
+	case "keys", "properties":
+		var properties map[string]any
+		switch typed := argument.(type) {
+		case *storedNode:
+			properties = typed.properties
+		case *storedRelationship:
+			properties = typed.properties
+		case map[string]any:
+			properties = typed
+		default:
+			return nil, fmt.Errorf("%s of %s", call.name, typeName(argument))
+		}
+		if call.name == "properties" {
+			return cloneProperties(properties), nil
+		}
+		names := []any{}
+		for name := range properties {
+			names = append(names, name)
+		}
+		return names, nil
+
+	case "head", "last":
+		if items, ok := argument.([]any); ok {
+			if len(items) == 0 {
+				return nil, nil
+			}
+			if call.name == "head" {
+				return items[0], nil
+			}
+			return items[len(items)-1], nil
+		}
+
+	case "tolower", "toupper", "trim":
+		if text, ok := argument.(string); ok {
+			switch call.name {
+			case "tolower":
+				return strings.ToLower(text), nil
+			case "toupper":
+				return strings.ToUpper(text), nil
+			}
+			return strings.TrimSpace(text), nil
+		}
+
+	case "tostring":
+		switch argument.(type) {
+		case string, int64, float64, bool:
+			return toText(argument), nil
+		}
+
+	case "tointeger":
+		switch typed := argument.(type) {
+		case int64:
+			return typed, nil
+		case float64:
+			return int64(typed), nil
+		case string:
+			if value, err := strconv.ParseInt(strings.TrimSpace(typed), 10, 64); err == nil {
+				return value, nil
+			}
+			if value, err := strconv.ParseFloat(strings.TrimSpace(typed), 64); err == nil {
+				return int64(value), nil
+			}
+			return nil, nil
+		}
+
+	case "split":
+		text, ok := argument.(string)
+		separator, separatorOK := arguments[1].(string)
+		if ok && separatorOK {
+			items := []any{}
+			for _, part := range strings.Split(text, separator) {
+				items = append(items, part)
+			}
+			return items, nil
+		}
+
+	case "replace":
+		text, ok := argument.(string)
+		search, searchOK := arguments[1].(string)
+		replacement, replacementOK := arguments[2].(string)
+		if ok && searchOK && replacementOK {
+			return strings.ReplaceAll(text, search, replacement), nil
+		}
+
+	// Every match of the regex, each a list of the match and its groups
+	case "apoc.text.regexgroups":
+		text, ok := argument.(string)
+		pattern, patternOK := arguments[1].(string)
+		if ok && patternOK {
+			regex, err := getRegex(pattern, false)
+			if err != nil {
+				return nil, err
+			}
+			matches := []any{}
+			for _, match := range regex.FindAllStringSubmatch(text, -1) {
+				groups := make([]any, len(match))
+				for i, group := range match {
+					groups[i] = group
+				}
+				matches = append(matches, groups)
+			}
+			return matches, nil
+		}
+
+	}
+
+	return nil, fmt.Errorf("%s of %s", call.name, typeName(argument))
+}
+
+// Compute an aggregate over the rows of a group
+func (e *executor) aggregate(call *functionCall, rows []row) (any, error) {
+	if call.all {
+		return int64(len(rows)), nil
+	}
+	if len(call.arguments) != 1 {
+		return nil, fmt.Errorf("%s takes 1 argument", call.name)
+	}
+
+	values := []any{}
+	seen := map[string]bool{}
+	for _, r := range rows {
+		value, err := e.evaluate(call.arguments[0], r)
+		if err != nil {
+			return nil, err
+		}
+		// Aggregates leave nulls out
+		if value == nil {
+			continue
+		}
+		if call.distinct {
+			key := valueKey(value)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		values = append(values, value)
+	}
+
+	switch call.name {
+	case "count":
+		return int64(len(values)), nil
+	case "collect":
+		return values, nil
+	case "sum":
+		var sum any = int64(0)
+		for _, value := range values {
+			var err error
+			if sum, err = arithmetic("+", sum, value); err != nil {
+				return nil, err
+			}
+		}
+		return sum, nil
+	}
+
+	// min and max
+	var result any
+	for _, value := range values {
+		if result == nil {
+			result = value
+			continue
+		}
+		order := orderValues(value, result)
+		if (call.name == "min" && order < 0) || (call.name == "max" && order > 0) {
+			result = value
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,677 @@
+package offline
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Runs a parsed query in a transaction. Each clause turns the rows of the
+// clause before it into new rows, starting from a single empty row.
+type executor struct {
+	tx         *transaction
+	parameters map[string]any
+	// The values of the aggregates of the group being projected
+	aggregates map[*functionCall]any
+}
+
+func newExecutor(tx *transaction, parameters map[string]any) *executor {
+	normalized := make(map[string]any, len(parameters))
+	for name, value := range parameters {
+		normalized[name] = normalize(value)
+	}
+	return &executor{tx: tx, parameters: normalized}
+}
+
+func (e *executor) store() *store {
+	return e.tx.store
+}
+
+func (q *cypherQuery) writes() bool {
+	for _, clause := range q.clauses {
+		switch clause.(type) {
+		case *setClause, *removeClause, *mergeClause, *createClause, *deleteClause:
+			return true
+		}
+	}
+	return false
+}
+
+// Run the query and return the rows of its RETURN clause, if it has one,
+// as the Neo4j driver would
+func (e *executor) run(query *cypherQuery) ([][]any, error) {
+	rows := []row{{}}
+	var columns []string
+
+	for _, clause := range query.clauses {
+		var err error
+		switch typed := clause.(type) {
+		case *matchClause:
+			rows, err = e.executeMatch(typed, rows)
+		case *unwindClause:
+			rows, err = e.executeUnwind(typed, rows)
+		case *withClause:
+			rows, _, err = e.project(typed.projection, rows)
+			if err == nil && typed.where != nil {
+				rows, err = e.filter(typed.where, rows)
+			}
+		case *returnClause:
+			rows, columns, err = e.project(typed.projection, rows)
+		case *setClause:
+			err = e.executeSet(typed.items, rows)
+		case *removeClause:
+			err = e.executeRemove(typed.items, rows)
+		case *mergeClause:
+			rows, err = e.executeMerge(typed, rows)
+		case *createClause:
+			err = e.executeCreate(typed, rows)
+		case *deleteClause:
+			err = e.executeDelete(typed, rows)
+		default:
+			err = fmt.Errorf("unsupported clause %T", clause)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if columns == nil {
+		return nil, nil
+	}
+	results := make([][]any, 0, len(rows))
+	for _, r := range rows {
+		values := make([]any, len(columns))
+		for i, column := range columns {
+			values[i] = toDriverValue(r[column])
+		}
+		results = append(results, values)
+	}
+	return results, nil
+}
+
+func (e *executor) filter(where expression, rows []row) ([]row, error) {
+	filtered := []row{}
+	for _, r := range rows {
+		value, err := e.evaluate(where, r)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = toBool(value); err != nil {
+			return nil, err
+		}
+		if value == true {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered, nil
+}
+
+func (e *executor) executeMatch(clause *matchClause, rows []row) ([]row, error) {
+	matches := []row{}
+	for _, r := range rows {
+		matched := false
+		err := e.matchPatterns(clause.patterns, clause.where, r, func(match row) (bool, error) {
+			matches = append(matches, match.clone())
+			matched = true
+			return true, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// OPTIONAL MATCH keeps the row, with the new variables null
+		if !matched && clause.optional {
+			unmatched := r.clone()
+			for _, p := range clause.patterns {
+				for _, name := range patternVariables(p) {
+					if _, ok := unmatched[name]; !ok {
+						unmatched[name] = nil
+					}
+				}
+			}
+			matches = append(matches, unmatched)
+		}
+	}
+	return matches, nil
+}
+
+func (e *executor) executeUnwind(clause *unwindClause, rows []row) ([]row, error) {
+	unwound := []row{}
+	for _, r := range rows {
+		value, err := e.evaluate(clause.list, r)
+		if err != nil {
+			return nil, err
+		}
+		items, ok := value.([]any)
+		if !ok {
+			if value == nil {
+				continue
+			}
+			items = []any{value}
+		}
+		for _, item := range items {
+			next := r.clone()
+			next[clause.variable] = item
+			unwound = append(unwound, next)
+		}
+	}
+	return unwound, nil
+}
+
+// The items of a projection that are aggregates, or contain one
+func getAggregates(expr expression) []*functionCall {
+	aggregates := []*functionCall{}
+	walkExpression(expr, func(inner expression) {
+		if call, ok := inner.(*functionCall); ok && isAggregate(call) {
+			aggregates = append(aggregates, call)
+		}
+	})
+	return aggregates
+}
+
+// The name of each projected column. Unnamed items get a name that can't
+// clash with a variable.
+func getColumns(p *projection, rows []row) []string {
+	columns := []string{}
+	if p.all {
+		names := map[string]bool{}
+		for _, r := range rows {
+			for name := range r {
+				names[name] = true
+			}
+		}
+		for name := range names {
+			columns = append(columns, name)
+		}
+		sort.Strings(columns)
+	}
+	for i, item := range p.items {
+		if item.name != "" {
+			columns = append(columns, item.name)
+		} else {
+			columns = append(columns, fmt.Sprintf(" column%d", i))
+		}
+	}
+	return columns
+}
+
+type projectedRow struct {
+	values   row
+	sortKeys []any
+}
+
+// Project rows for WITH and RETURN, and return the projected columns
+func (e *executor) project(p *projection, rows []row) ([]row, []string, error) {
+	columns := getColumns(p, rows)
+	// The columns the items go to, after the ones WITH * keeps
+	itemColumns := columns[len(columns)-len(p.items):]
+
+	aggregates := []*functionCall{}
+	grouping := []int{}
+	for i, item := range p.items {
+		itemAggregates := getAggregates(item.expression)
+		if len(itemAggregates) == 0 {
+			grouping = append(grouping, i)
+		}
+		aggregates = append(aggregates, itemAggregates...)
+	}
+
+	projected := []projectedRow{}
+	if len(aggregates) == 0 {
+		for _, r := range rows {
+			values := row{}
+			if p.all {
+				values = r.clone()
+			}
+			for i, item := range p.items {
+				value, err := e.evaluate(item.expression, r)
+				if err != nil {
+					return nil, nil, err
+				}
+				values[itemColumns[i]] = value
+			}
+
+			// ORDER BY sees the variables before the projection too
+			scope := r.clone()
+			for name, value := range values {
+				scope[name] = value
+			}
+			sortKeys, err := e.getSortKeys(p, scope)
+			if err != nil {
+				return nil, nil, err
+			}
+			projected = append(projected, projectedRow{values: values, sortKeys: sortKeys})
+		}
+	} else {
+		// Group the rows by the items that aren't aggregates
+		groups := [][]row{}
+		groupKeys := map[string]int{}
+		for _, r := range rows {
+			key := ""
+			for _, i := range grouping {
+				value, err := e.evaluate(p.items[i].expression, r)
+				if err != nil {
+					return nil, nil, err
+				}
+				key += valueKey(value) + "|"
+			}
+			index, ok := groupKeys[key]
+			if !ok {
+				index = len(groups)
+				groupKeys[key] = index
+				groups = append(groups, nil)
+			}
+			groups[index] = append(groups[index], r)
+		}
+		// Aggregating nothing still gives a row, unless it's grouped
+		if len(groups) == 0 && len(grouping) == 0 {
+			groups = append(groups, nil)
+		}
+
+		for _, group := range groups {
+			e.aggregates = map[*functionCall]any{}
+			for _, call := range aggregates {
+				value, err := e.aggregate(call, group)
+				if err != nil {
+					return nil, nil, err
+				}
+				e.aggregates[call] = value
+			}
+
+			first := row{}
+			if len(group) > 0 {
+				first = group[0]
+			}
+			values := row{}
+			for i, item := range p.items {
+				value, err := e.evaluate(item.expression, first)
+				if err != nil {
+					return nil, nil, err
+				}
+				values[itemColumns[i]] = value
+			}
+			sortKeys, err := e.getSortKeys(p, values)
+			if err != nil {
+				return nil, nil, err
+			}
+			projected = append(projected, projectedRow{values: values, sortKeys: sortKeys})
+		}
+		e.aggregates = nil
+	}
+
+	if p.distinct {
+		seen := map[string]bool{}
+		distinct := []projectedRow{}
+		for _, item := range projected {
+			key := ""
+			for _, column := range columns {
+				key += valueKey(item.values[column]) + "|"
+			}
+			if !seen[key] {
+				seen[key] = true
+				distinct = append(distinct, item)
+			}
+		}
+		projected = distinct
+	}
+
+	if len(p.orderBy) > 0 {
+		sort.SliceStable(projected, func(i, j int) bool {
+			for k, sortItem := range p.orderBy {
+				order := orderValues(projected[i].sortKeys[k], projected[j].sortKeys[k])
+				if sortItem.descending {
+					order = -order
+				}
+				if order != 0 {
+					return order < 0
+				}
+			}
+			return false
+		})
+	}
+
+	skip, err := e.getCount(p.skip)
+	if err != nil {
+		return nil, nil, err
+	}
+	limit, err := e.getCount(p.limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	if skip > len(projected) {
+		skip = len(projected)
+	}
+	projected = projected[skip:]
+	if p.limit != nil && limit < len(projected) {
+		projected = projected[:limit]
+	}
+
+	results := make([]row, len(projected))
+	for i, item := range projected {
+		results[i] = item.values
+	}
+	return results, columns, nil
+}
+
+func (e *executor) getSortKeys(p *projection, scope row) ([]any, error) {
+	keys := make([]any, len(p.orderBy))
+	for i, item := range p.orderBy {
+		value, err := e.evaluate(item.expression, scope)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = value
+	}
+	return keys, nil
+}
+
+// The value of SKIP or LIMIT
+func (e *executor) getCount(expr expression) (int, error) {
+	if expr == nil {
+		return 0, nil
+	}
+	value, err := e.evaluate(expr, row{})
+	if err != nil {
+		return 0, err
+	}
+	count, ok := value.(int64)
+	if !ok || count < 0 {
+		return 0, fmt.Errorf("invalid SKIP or LIMIT %v", value)
+	}
+	return int(count), nil
+}
+
+func (e *executor) getPropertiesOf(target any) (map[string]any, error) {
+	switch typed := target.(type) {
+	case *storedNode:
+		return typed.properties, nil
+	case *storedRelationship:
+		return typed.properties, nil
+	}
+	return nil, fmt.Errorf("properties of %s", typeName(target))
+}
+
+func (e *executor) executeSet(items []setItem, rows []row) error {
+	for _, r := range rows {
+		if err := e.applySet(items, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *executor) applySet(items []setItem, r row) error {
+	for _, item := range items {
+		target, ok := r[item.variable]
+		if !ok {
+			return fmt.Errorf("variable %s not defined", item.variable)
+		}
+		// Setting anything on null does nothing
+		if target == nil {
+			continue
+		}
+
+		if item.kind == setLabels {
+			node, ok := target.(*storedNode)
+			if !ok {
+				return fmt.Errorf("labels of %s", typeName(target))
+			}
+			for _, label := range item.labels {
+				if !node.hasLabel(label) {
+					node.labels = append(node.labels, label)
+				}
+			}
+			continue
+		}
+
+		properties, err := e.getPropertiesOf(target)
+		if err != nil {
+			return err
+		}
+		value, err := e.evaluate(item.value, r)
+		if err != nil {
+			return err
+		}
+
+		if item.kind == setProperty {
+			setProperties(properties, map[string]any{item.property: value})
+			continue
+		}
+
+		values, ok := value.(map[string]any)
+		if !ok {
+			if values, err = e.getPropertiesOf(value); err != nil {
+				return err
+			}
+		}
+		if item.kind == setReplace {
+			for name := range properties {
+				delete(properties, name)
+			}
+		}
+		setProperties(properties, cloneProperties(values))
+	}
+	return nil
+}
+
+func (e *executor) executeRemove(items []setItem, rows []row) error {
+	for _, r := range rows {
+		for _, item := range items {
+			target, ok := r[item.variable]
+			if !ok {
+				return fmt.Errorf("variable %s not defined", item.variable)
+			}
+			if target == nil {
+				continue
+			}
+
+			if item.kind == setProperty {
+				properties, err := e.getPropertiesOf(target)
+				if err != nil {
+					return err
+				}
+				delete(properties, item.property)
+				continue
+			}
+
+			node, ok := target.(*storedNode)
+			if !ok {
+				return fmt.Errorf("labels of %s", typeName(target))
+			}
+			labels := []string{}
+			for _, label := range node.labels {
+				removed := false
+				for _, removedLabel := range item.labels {
+					removed = removed || label == removedLabel
+				}
+				if !removed {
+					labels = append(labels, label)
+				}
+			}
+			node.labels = labels
+		}
+	}
+	return nil
+}
+
+// MERGE matches the pattern, or creates it when nothing matches. The
+// creations of earlier rows are seen by the later ones.
+func (e *executor) executeMerge(clause *mergeClause, rows []row) ([]row, error) {
+	merged := []row{}
+	for _, r := range rows {
+		matches := []row{}
+		err := e.matchPatterns([]*pattern{clause.pattern}, nil, r, func(match row) (bool, error) {
+			matches = append(matches, match.clone())
+			return true, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if len(matches) > 0 {
+			for _, match := range matches {
+				if err := e.applySet(clause.onMatch, match); err != nil {
+					return nil, err
+				}
+			}
+			merged = append(merged, matches...)
+			continue
+		}
+
+		created := r.clone()
+		if err := e.createPattern(clause.pattern, created, true); err != nil {
+			return nil, err
+		}
+		if err := e.applySet(clause.onCreate, created); err != nil {
+			return nil, err
+		}
+		merged = append(merged, created)
+	}
+	return merged, nil
+}
+
+func (e *executor) executeCreate(clause *createClause, rows []row) error {
+	for _, r := range rows {
+		for _, p := range clause.patterns {
+			if err := e.createPattern(p, r, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *executor) getPatternProperties(properties expression, r row, merge bool) (map[string]any, error) {
+	if properties == nil {
+		return nil, nil
+	}
+	value, err := e.evaluate(properties, r)
+	if err != nil {
+		return nil, err
+	}
+	values, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("properties of type %s", typeName(value))
+	}
+	if merge {
+		for name, item := range values {
+			if item == nil {
+				return nil, fmt.Errorf("cannot merge with a null %s", name)
+			}
+		}
+	}
+	return values, nil
+}
+
+// Create the nodes and relationships of a pattern that aren't bound in r,
+// and bind them
+func (e *executor) createPattern(p *pattern, r row, merge bool) error {
+	nodes := make([]*storedNode, len(p.nodes))
+	for i, nodePattern := range p.nodes {
+		if value, ok := r[nodePattern.variable]; ok && nodePattern.variable != "" {
+			node, ok := value.(*storedNode)
+			if !ok {
+				return fmt.Errorf("cannot create a relationship to %s", typeName(value))
+			}
+			nodes[i] = node
+			continue
+		}
+
+		labels := []string{}
+		for _, group := range nodePattern.labels {
+			if len(group) != 1 {
+				return fmt.Errorf("cannot create a node with alternative labels")
+			}
+			labels = append(labels, group[0])
+		}
+		properties, err := e.getPatternProperties(nodePattern.properties, r, merge)
+		if err != nil {
+			return err
+		}
+		nodes[i] = e.store().createNode(labels, properties)
+		if nodePattern.variable != "" {
+			r[nodePattern.variable] = nodes[i]
+		}
+	}
+
+	path := &pathValue{nodes: nodes}
+	for i, relationshipPattern := range p.relationships {
+		if len(relationshipPattern.kinds) != 1 || relationshipPattern.variableLength {
+			return fmt.Errorf("cannot create a relationship without exactly one type")
+		}
+		if _, ok := r[relationshipPattern.variable]; ok && relationshipPattern.variable != "" {
+			return fmt.Errorf("relationship %s is already defined", relationshipPattern.variable)
+		}
+
+		start, end := nodes[i], nodes[i+1]
+		switch relationshipPattern.direction {
+		case directionInbound:
+			start, end = end, start
+		case directionBoth:
+			if !merge {
+				return fmt.Errorf("cannot create a relationship without a direction")
+			}
+		}
+		properties, err := e.getPatternProperties(relationshipPattern.properties, r, merge)
+		if err != nil {
+			return err
+		}
+		relationship, err := e.store().createRelationship(start.id, end.id, relationshipPattern.kinds[0], properties)
+		if err != nil {
+			return err
+		}
+		if relationshipPattern.variable != "" {
+			r[relationshipPattern.variable] = relationship
+		}
+		path.relationships = append(path.relationships, relationship)
+	}
+
+	if p.variable != "" {
+		r[p.variable] = path
+	}
+	return nil
+}
+
+func (e *executor) executeDelete(clause *deleteClause, rows []row) error {
+	for _, r := range rows {
+		for _, expr := range clause.expressions {
+			value, err := e.evaluate(expr, r)
+			if err != nil {
+				return err
+			}
+			if err := e.delete(value, clause.detach); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *executor) delete(value any, detach bool) error {
+	switch typed := value.(type) {
+	case nil:
+		return nil
+	case *storedNode:
+		return e.store().deleteNode(typed.id, detach)
+	case *storedRelationship:
+		e.store().deleteRelationship(typed.id)
+		return nil
+	case *pathValue:
+		for _, relationship := range typed.relationships {
+			e.store().deleteRelationship(relationship.id)
+		}
+		for _, node := range typed.nodes {
+			if err := e.store().deleteNode(node.id, detach); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []any:
+		for _, item := range typed {
+			if err := e.delete(item, detach); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("cannot delete %s", typeName(value))
+}
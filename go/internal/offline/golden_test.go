@@ -0,0 +1,256 @@
+package offline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hotnops/apeman/analyze"
+	"github.com/hotnops/apeman/go/internal/queries"
+	"github.com/hotnops/apeman/graphschema/aws"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/specterops/bloodhound/dawgs/graph"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// An IdentityTransform relationship that
+// queries.CreateIdentityTransformEdges created
+type identityTransform struct {
+	Source      string `json:"source"`
+	Target      string `json:"target"`
+	Name        string `json:"name"`
+	Conditional bool   `json:"conditional"`
+}
+
+// What the golden files hold for a scenario
+type report struct {
+	RSOP               map[string]analyze.PrincipalToActionMap `json:"rsop"`
+	InboundRoles       map[string][]string                     `json:"inbound_roles"`
+	IdentityTransforms []identityTransform                     `json:"identity_transforms"`
+}
+
+// The scenarios are authorization details loaded into one graph, with the
+// resources in testdata/resources.txt
+var scenarios = []struct {
+	name    string
+	details []string
+}{
+	{name: "dev_account", details: []string{"dev_account.json"}},
+	{name: "cross_account", details: []string{"dev_account.json", "prod_account.json"}},
+}
+
+// Load a scenario the way the ingest and the analysis do, up to the
+// identity transforms
+func loadDatabase(t *testing.T, ctx context.Context, details []string) graph.Database {
+	t.Helper()
+	db := NewDatabase()
+
+	data, err := os.ReadFile(filepath.Join("testdata", "catalog.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := ParseCatalog(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadCatalog(ctx, db, catalog); err != nil {
+		t.Fatal(err)
+	}
+
+	authorizationDetails := []*analyze.AuthorizationDetails{}
+	for _, name := range details {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		item, err := analyze.ParseAuthorizationDetails(data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		authorizationDetails = append(authorizationDetails, item)
+	}
+	if err := LoadAuthorizationDetails(ctx, db, authorizationDetails...); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = os.ReadFile(filepath.Join("testdata", "resources.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadResources(ctx, db, strings.Fields(string(data))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := queries.ExpandGraph(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if err := queries.CreateIdentityTransformEdges(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func getArn(node *graph.Node) string {
+	arn, _ := node.Properties.Get("arn").String()
+	return arn
+}
+
+func getRSOP(t *testing.T, ctx context.Context, db graph.Database, node *graph.Node) analyze.PrincipalToActionMap {
+	t.Helper()
+
+	paths, err := queries.GetUnresolvedOutputPaths(ctx, db, node)
+	if err != nil {
+		t.Fatalf("%s: %v", getArn(node), err)
+	}
+	resolvedPaths, err := analyze.ResolveResourceAgainstIdentityPolicies(&analyze.ActionPathSet{}, &paths)
+	if err != nil {
+		t.Fatalf("%s: %v", getArn(node), err)
+	}
+	rsop := analyze.ResourcePathSetToMap(*resolvedPaths)
+	for _, actions := range rsop {
+		sort.Strings(actions)
+	}
+	return rsop
+}
+
+// The principals with a path of identity transforms to a role
+func getInboundRoles(t *testing.T, ctx context.Context, db graph.Database, node *graph.Node) []string {
+	t.Helper()
+
+	roleID, _ := node.Properties.Get(string(aws.RoleId)).String()
+	paths, err := queries.GetInboundRolePaths(ctx, db, roleID)
+	if err != nil {
+		t.Fatalf("%s: %v", getArn(node), err)
+	}
+
+	unique := map[string]bool{}
+	principals := []string{}
+	for _, path := range paths {
+		arn := getArn(path.Root())
+		if !unique[arn] {
+			unique[arn] = true
+			principals = append(principals, arn)
+		}
+	}
+	sort.Strings(principals)
+	return principals
+}
+
+func getIdentityTransforms(t *testing.T, ctx context.Context, db graph.Database) []identityTransform {
+	t.Helper()
+
+	results, err := queries.RawCypherQuery(ctx, db, "MATCH (a) - [r:IdentityTransform] -> (b) "+
+		"RETURN a.arn, b.arn, r.name, r."+analyze.ConditionalProperty, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unique := map[identityTransform]bool{}
+	transforms := []identityTransform{}
+	for _, result := range results {
+		transform := identityTransform{}
+		for _, target := range []any{&transform.Source, &transform.Target, &transform.Name, &transform.Conditional} {
+			if err := result.Map(target); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !unique[transform] {
+			unique[transform] = true
+			transforms = append(transforms, transform)
+		}
+	}
+
+	sort.Slice(transforms, func(i, j int) bool {
+		a, b := transforms[i], transforms[j]
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		if a.Target != b.Target {
+			return a.Target < b.Target
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return !a.Conditional && b.Conditional
+	})
+	return transforms
+}
+
+func getReport(t *testing.T, ctx context.Context, db graph.Database) report {
+	t.Helper()
+
+	r := report{
+		RSOP:         map[string]analyze.PrincipalToActionMap{},
+		InboundRoles: map[string][]string{},
+	}
+	for _, kind := range []graph.Kind{aws.AWSUser, aws.AWSRole, aws.AWSGroup} {
+		nodes, err := analyze.GetAWSNodesByKind(ctx, db, kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, node := range nodes {
+			r.RSOP[getArn(node)] = getRSOP(t, ctx, db, node)
+			if kind == aws.AWSRole {
+				r.InboundRoles[getArn(node)] = getInboundRoles(t, ctx, db, node)
+			}
+		}
+	}
+	r.IdentityTransforms = getIdentityTransforms(t, ctx, db)
+	return r
+}
+
+// Run with -update to rewrite the golden files after an intended change,
+// and review the diff
+func TestGolden(t *testing.T) {
+	// The queries log every step
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := loadDatabase(t, ctx, scenario.details)
+
+			actual, err := json.MarshalIndent(getReport(t, ctx, db), "", "    ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual = append(actual, '\n')
+
+			golden := filepath.Join("testdata", "golden", scenario.name+".json")
+			if *update {
+				if err := os.WriteFile(golden, actual, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(actual, expected) {
+				diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+					A:        difflib.SplitLines(string(expected)),
+					B:        difflib.SplitLines(string(actual)),
+					FromFile: golden,
+					ToFile:   "actual",
+					Context:  3,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Errorf("%s is out of date, run the test with -update if the change is intended:\n%s", golden, diff)
+			}
+		})
+	}
+}
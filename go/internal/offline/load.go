@@ -0,0 +1,632 @@
+package offline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hotnops/apeman/analyze"
+	"github.com/hotnops/apeman/go/internal/queries"
+	"github.com/specterops/bloodhound/dawgs/graph"
+)
+
+var policyVariableRegex = regexp.MustCompile(`\$\{.*?\}`)
+
+// The labels of the AWS schema nodes, which belong to no collection
+var sharedLabels = []string{"AWSAction", "AWSOperator", "AWSConditionValue"}
+
+func isSharedLabel(labels string) bool {
+	first := strings.Split(labels, ":")[0]
+	for _, label := range sharedLabels {
+		if label == first {
+			return true
+		}
+	}
+	return false
+}
+
+// The properties a node is merged on, with value as the key
+func getMergeKey(labels string, field string, value string) string {
+	if isSharedLabel(labels) {
+		return fmt.Sprintf("{%s: %s}", field, value)
+	}
+	return fmt.Sprintf("{%s: %s, collection: $collection}", field, value)
+}
+
+// The nodes of one of the CSV files the ingest writes. The first field is
+// the key the nodes are merged on.
+type nodeBatch struct {
+	labels string
+	fields []string
+	rows   []map[string]any
+	keys   map[any]bool
+}
+
+// Add a node unless one with the same key was added. Values are in the
+// order of the fields.
+func (b *nodeBatch) add(values ...any) {
+	row := map[string]any{}
+	for i, field := range b.fields {
+		row[field] = getCSVValue(values[i])
+	}
+	// Nodes without a key can't be merged, so the ingest fails to load
+	// them
+	key := row[b.fields[0]]
+	if key == nil || b.keys[key] {
+		return
+	}
+	b.keys[key] = true
+	b.rows = append(b.rows, row)
+}
+
+func (b *nodeBatch) query() string {
+	collection := ""
+	if !isSharedLabel(b.labels) {
+		collection = "a.collection = $collection, "
+	}
+	return fmt.Sprintf("UNWIND $rows AS row MERGE (a:%s %s) SET a = row, %sa.layer = 1",
+		b.labels, getMergeKey(b.labels, b.fields[0], "row."+b.fields[0]), collection)
+}
+
+// The relationships of one of the CSV files the ingest writes, between
+// nodes merged on the given fields
+type relationshipBatch struct {
+	source           string
+	sourceField      string
+	kind             string
+	destination      string
+	destinationField string
+	rows             []map[string]any
+	keys             map[[2]any]bool
+}
+
+func (b *relationshipBatch) add(source any, destination any) {
+	row := map[string]any{
+		"source":      getCSVValue(source),
+		"destination": getCSVValue(destination),
+	}
+	key := [2]any{row["source"], row["destination"]}
+	if key[0] == nil || key[1] == nil || b.keys[key] {
+		return
+	}
+	b.keys[key] = true
+	b.rows = append(b.rows, row)
+}
+
+func (b *relationshipBatch) query() string {
+	return fmt.Sprintf("UNWIND $rows AS row "+
+		"MERGE (s:%s %s) ON CREATE SET s.inferred = true, s.layer = 1 "+
+		"MERGE (d:%s %s) ON CREATE SET d.inferred = true, d.layer = 1 "+
+		"MERGE (s) - [:%s {layer: 1}] -> (d)",
+		b.source, getMergeKey(b.source, b.sourceField, "row.source"),
+		b.destination, getMergeKey(b.destination, b.destinationField, "row.destination"),
+		b.kind)
+}
+
+// The ingest reads authorization details into CSV files of nodes and
+// relationships, and loads those into the graph. ingest mirrors the
+// processing of utils/ingest/ingest.py, with the files as batches.
+type ingest struct {
+	nodeBatches         []*nodeBatch
+	relationshipBatches []*relationshipBatch
+
+	actionBlobs       *nodeBatch
+	trustPolicies     *nodeBatch
+	conditions        *nodeBatch
+	conditionKeys     *nodeBatch
+	conditionValues   *nodeBatch
+	groups            *nodeBatch
+	inlinePolicies    *nodeBatch
+	managedPolicies   *nodeBatch
+	policyDocuments   *nodeBatch
+	policyVersions    *nodeBatch
+	roles             *nodeBatch
+	statements        *nodeBatch
+	users             *nodeBatch
+	resourceBlobs     *nodeBatch
+	tags              *nodeBatch
+	identityProviders *nodeBatch
+	principalBlobs    *nodeBatch
+
+	hashToHash                 *relationshipBatch
+	hashToArn                  *relationshipBatch
+	arnToArn                   *relationshipBatch
+	memberOf                   *relationshipBatch
+	permissionsBoundary        *relationshipBatch
+	operatorToCondition        *relationshipBatch
+	statementToAction          *relationshipBatch
+	statementToNotAction       *relationshipBatch
+	statementToActionBlob      *relationshipBatch
+	statementToNotActionBlob   *relationshipBatch
+	statementToResource        *relationshipBatch
+	statementToNotResource     *relationshipBatch
+	statementToResourceBlob    *relationshipBatch
+	statementToNotResourceBlob *relationshipBatch
+	statementToPrincipal       *relationshipBatch
+	statementToUniqueName      *relationshipBatch
+	statementToPrincipalBlob   *relationshipBatch
+	conditionValueToKey        *relationshipBatch
+
+	// The ARNs of the groups processed so far, by name
+	groupArns map[string]string
+}
+
+func (i *ingest) addNodes(labels string, fields ...string) *nodeBatch {
+	batch := &nodeBatch{labels: labels, fields: fields, keys: map[any]bool{}}
+	i.nodeBatches = append(i.nodeBatches, batch)
+	return batch
+}
+
+func (i *ingest) addRelationships(source string, sourceField string, kind string, destination string, destinationField string) *relationshipBatch {
+	batch := &relationshipBatch{
+		source:           source,
+		sourceField:      sourceField,
+		kind:             kind,
+		destination:      destination,
+		destinationField: destinationField,
+		keys:             map[[2]any]bool{},
+	}
+	i.relationshipBatches = append(i.relationshipBatches, batch)
+	return batch
+}
+
+// The batches are loaded in the order they are added here, which is the
+// order of the ingest
+func newIngest() *ingest {
+	i := &ingest{groupArns: map[string]string{}}
+
+	i.actionBlobs = i.addNodes("AWSActionBlob:UniqueName", "name", "regex")
+	i.trustPolicies = i.addNodes("AWSAssumeRolePolicy:UniqueHash", "hash", "version", "sid")
+	i.conditions = i.addNodes("AWSCondition:UniqueHash", "hash", "sid")
+	i.conditionKeys = i.addNodes("AWSConditionKey:UniqueHash", "hash", "name")
+	i.conditionValues = i.addNodes("AWSConditionValue:UniqueName", "name")
+	i.groups = i.addNodes("AWSGroup:UniqueArn", "arn", "path", "name", "groupid", "createdate")
+	i.inlinePolicies = i.addNodes("AWSInlinePolicy:UniqueHash", "hash", "policyname")
+	i.managedPolicies = i.addNodes("AWSManagedPolicy:UniqueArn", "arn", "policyname", "policyid", "path",
+		"defaultversionid", "attachmentcount", "permissionsboundaryusagecount", "isattachable", "createdate", "updatedate")
+	i.policyDocuments = i.addNodes("AWSPolicyDocument:UniqueHash", "hash", "version")
+	i.policyVersions = i.addNodes("AWSPolicyVersion:UniqueHash", "hash", "versionid", "isdefaultversion")
+	i.roles = i.addNodes("AWSRole:UniqueArn", "arn", "path", "rolename", "roleid", "createdate", "rolelastused")
+	i.statements = i.addNodes("AWSStatement:UniqueHash", "hash", "effect", "sid")
+	i.users = i.addNodes("AWSUser:UniqueArn", "arn", "path", "name", "userid", "createdate")
+	i.resourceBlobs = i.addNodes("AWSResourceBlob:UniqueName", "name", "regex")
+	i.tags = i.addNodes("AWSTag:UniqueHash", "hash", "key", "value")
+	i.identityProviders = i.addNodes("AWSIdentityProvider:UniqueName", "name")
+	i.principalBlobs = i.addNodes("AWSPrincipalBlob:UniqueName", "name", "regex")
+
+	i.hashToHash = i.addRelationships("UniqueHash", "hash", "AttachedTo", "UniqueHash", "hash")
+	i.hashToArn = i.addRelationships("UniqueHash", "hash", "AttachedTo", "UniqueArn", "arn")
+	i.arnToArn = i.addRelationships("UniqueArn", "arn", "AttachedTo", "UniqueArn", "arn")
+	i.memberOf = i.addRelationships("AWSUser", "arn", "MemberOf", "AWSGroup", "arn")
+	i.permissionsBoundary = i.addRelationships("UniqueArn", "arn", "PermissionsBoundary", "UniqueArn", "arn")
+	i.operatorToCondition = i.addRelationships("AWSOperator:UniqueName", "name", "AttachedTo", "AWSCondition:UniqueHash", "hash")
+	i.statementToAction = i.addRelationships("AWSStatement:UniqueHash", "hash", "Action", "AWSAction:UniqueName", "name")
+	i.statementToNotAction = i.addRelationships("AWSStatement:UniqueHash", "hash", "NotAction", "AWSAction:UniqueName", "name")
+	i.statementToActionBlob = i.addRelationships("AWSStatement:UniqueHash", "hash", "Action", "AWSActionBlob:UniqueName", "name")
+	i.statementToNotActionBlob = i.addRelationships("AWSStatement:UniqueHash", "hash", "NotAction", "AWSActionBlob:UniqueName", "name")
+	i.statementToResource = i.addRelationships("AWSStatement:UniqueHash", "hash", "Resource", "UniqueArn", "arn")
+	i.statementToNotResource = i.addRelationships("AWSStatement:UniqueHash", "hash", "NotResource", "UniqueArn", "arn")
+	i.statementToResourceBlob = i.addRelationships("AWSStatement:UniqueHash", "hash", "Resource", "AWSResourceBlob:UniqueName", "name")
+	i.statementToNotResourceBlob = i.addRelationships("AWSStatement:UniqueHash", "hash", "NotResource", "AWSResourceBlob:UniqueName", "name")
+	i.statementToPrincipal = i.addRelationships("AWSStatement:UniqueHash", "hash", "Principal", "UniqueArn", "arn")
+	i.statementToUniqueName = i.addRelationships("AWSStatement:UniqueHash", "hash", "Principal", "UniqueName", "name")
+	i.statementToPrincipalBlob = i.addRelationships("AWSStatement:UniqueHash", "hash", "Principal", "AWSPrincipalBlob:UniqueName", "name")
+	i.conditionValueToKey = i.addRelationships("AWSConditionValue:UniqueName", "name", "AttachedTo", "AWSConditionKey:UniqueHash", "hash")
+
+	return i
+}
+
+// Values are written to the CSV files with Python's str, and the empty
+// ones are loaded as null
+func getCSVValue(value any) any {
+	text := ""
+	switch typed := value.(type) {
+	case nil:
+	case string:
+		text = typed
+	case bool:
+		text = "False"
+		if typed {
+			text = "True"
+		}
+	case int:
+		text = strconv.Itoa(typed)
+	case float64:
+		text = strconv.FormatFloat(typed, 'f', -1, 64)
+	case time.Time:
+		if !typed.IsZero() {
+			text = typed.Format(time.RFC3339)
+		}
+	default:
+		text = fmt.Sprint(typed)
+	}
+
+	if text == "" {
+		return nil
+	}
+	return text
+}
+
+// Policy elements are identified by the hash the ingest gives them.
+// Details are turned back into the JSON values they were parsed from
+// first, which only differ from the file in how dates are written.
+func getHash(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		panic(err)
+	}
+	return queries.HashPolicyElement(queries.GetHashSalt(queries.DefaultCollection), decoded)
+}
+
+// Policy elements can be a single value or a list of them
+func getList(value any) []any {
+	switch typed := value.(type) {
+	case nil:
+		return nil
+	case []any:
+		return typed
+	}
+	return []any{value}
+}
+
+func getSortedKeys(values map[string]any) []string {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func isArn(value string) bool {
+	return strings.HasPrefix(value, "arn") && strings.Count(value, ":") >= 5
+}
+
+func isAccountNumber(value string) bool {
+	if len(value) != 12 {
+		return false
+	}
+	_, err := strconv.ParseUint(value, 10, 64)
+	return err == nil
+}
+
+func (i *ingest) addCondition(operator string, keyValues map[string]any) string {
+	conditionHash := getHash(map[string]any{operator: keyValues})
+	i.conditions.add(conditionHash, keyValues["sid"])
+	i.operatorToCondition.add(operator, conditionHash)
+
+	for _, key := range getSortedKeys(keyValues) {
+		values := keyValues[key]
+		keyHash := getHash(map[string]any{key: values})
+		i.conditionKeys.add(keyHash, key)
+		i.hashToHash.add(keyHash, conditionHash)
+
+		for _, value := range getList(values) {
+			i.conditionValues.add(value)
+			i.conditionValueToKey.add(value, keyHash)
+		}
+	}
+	return conditionHash
+}
+
+func (i *ingest) addActions(statementHash string, actions any, negated bool) {
+	for _, item := range getList(actions) {
+		action, ok := item.(string)
+		if !ok {
+			continue
+		}
+		action = strings.ToLower(action)
+
+		if strings.Contains(action, "*") {
+			i.actionBlobs.add(action, blobRegex(action))
+			if negated {
+				i.statementToNotActionBlob.add(statementHash, action)
+			} else {
+				i.statementToActionBlob.add(statementHash, action)
+			}
+		} else if negated {
+			i.statementToNotAction.add(statementHash, action)
+		} else {
+			i.statementToAction.add(statementHash, action)
+		}
+	}
+}
+
+func (i *ingest) addResources(statementHash string, resources any, negated bool) {
+	for _, item := range getList(resources) {
+		resource, ok := item.(string)
+		if !ok {
+			continue
+		}
+
+		// Policy variables can be anything, so they match like a wildcard
+		hasPolicyVariable := isArn(resource) && strings.Contains(resource, "${")
+		if strings.Contains(resource, "*") || hasPolicyVariable {
+			i.resourceBlobs.add(resource, blobRegex(policyVariableRegex.ReplaceAllString(resource, "*")))
+			if negated {
+				i.statementToNotResourceBlob.add(statementHash, resource)
+			} else {
+				i.statementToResourceBlob.add(statementHash, resource)
+			}
+		} else if !isArn(resource) {
+			continue
+		} else if negated {
+			i.statementToNotResource.add(statementHash, resource)
+		} else {
+			i.statementToResource.add(statementHash, resource)
+		}
+	}
+}
+
+func (i *ingest) addPrincipalBlob(statementHash string, name string) {
+	i.principalBlobs.add(name, blobRegex(name))
+	i.statementToPrincipalBlob.add(statementHash, name)
+}
+
+func (i *ingest) addPrincipals(statementHash string, principals any) {
+	// A principal of * is anonymous, which is the same as "AWS": "*"
+	if principals == "*" {
+		principals = map[string]any{"AWS": "*"}
+	}
+	block, _ := principals.(map[string]any)
+
+	for _, item := range getList(block["AWS"]) {
+		principal, _ := item.(string)
+		switch {
+		case principal == "*":
+			i.addPrincipalBlob(statementHash, "*")
+		case isArn(principal) && strings.HasSuffix(principal, ":root"):
+			i.addPrincipalBlob(statementHash, strings.ReplaceAll(principal, "root", "*"))
+		case isArn(principal):
+			i.statementToPrincipal.add(statementHash, principal)
+		case isAccountNumber(principal):
+			i.addPrincipalBlob(statementHash, fmt.Sprintf("arn:aws:iam::%s:*", principal))
+		}
+	}
+	for _, service := range getList(block["Service"]) {
+		i.statementToUniqueName.add(statementHash, service)
+	}
+	for _, provider := range getList(block["Federated"]) {
+		i.identityProviders.add(provider)
+		i.statementToUniqueName.add(statementHash, provider)
+	}
+}
+
+func (i *ingest) addStatement(value any) string {
+	statement, _ := value.(map[string]any)
+	statementHash := getHash(statement)
+	if i.statements.keys[statementHash] {
+		return statementHash
+	}
+	i.statements.add(statementHash, statement["Effect"], statement["Sid"])
+
+	conditions, _ := statement["Condition"].(map[string]any)
+	for _, operator := range getSortedKeys(conditions) {
+		keyValues, _ := conditions[operator].(map[string]any)
+		conditionHash := i.addCondition(strings.ToLower(operator), keyValues)
+		i.hashToHash.add(conditionHash, statementHash)
+	}
+
+	i.addActions(statementHash, statement["Action"], false)
+	i.addActions(statementHash, statement["NotAction"], true)
+	i.addResources(statementHash, statement["Resource"], false)
+	i.addResources(statementHash, statement["NotResource"], true)
+	i.addPrincipals(statementHash, statement["Principal"])
+	return statementHash
+}
+
+// Add the statements of a document, and attach them to hash
+func (i *ingest) addStatements(document map[string]any, hash string) {
+	for _, statement := range getList(document["Statement"]) {
+		i.hashToHash.add(i.addStatement(statement), hash)
+	}
+}
+
+func (i *ingest) addPolicyDocument(document map[string]any) string {
+	documentHash := getHash(document)
+	if i.policyDocuments.keys[documentHash] {
+		return documentHash
+	}
+	i.policyDocuments.add(documentHash, document["Version"])
+	i.addStatements(document, documentHash)
+	return documentHash
+}
+
+// Only the default version of a managed policy is ingested
+func (i *ingest) addManagedPolicy(policy analyze.ManagedPolicyDetail) {
+	i.managedPolicies.add(policy.Arn, policy.PolicyName, policy.PolicyId, policy.Path,
+		policy.DefaultVersionId, policy.AttachmentCount, policy.PermissionsBoundaryUsageCount,
+		policy.IsAttachable, policy.CreateDate, policy.UpdateDate)
+
+	for _, version := range policy.PolicyVersionList {
+		if !version.IsDefaultVersion {
+			continue
+		}
+		versionHash := getHash(version)
+		if !i.policyVersions.keys[versionHash] {
+			i.policyVersions.add(versionHash, version.VersionId, version.IsDefaultVersion)
+			i.hashToHash.add(i.addPolicyDocument(version.Document), versionHash)
+		}
+		i.hashToArn.add(versionHash, policy.Arn)
+		return
+	}
+}
+
+func (i *ingest) addTags(arn string, tags []analyze.TagDetail) {
+	for _, tag := range tags {
+		tagHash := getHash(tag)
+		i.tags.add(tagHash, tag.Key, tag.Value)
+		i.hashToArn.add(tagHash, arn)
+	}
+}
+
+func (i *ingest) addPrincipalPolicies(arn string, attached []analyze.AttachedPolicy, inline []analyze.InlinePolicyDetail, boundary *analyze.PermissionsBoundaryDetail, tags []analyze.TagDetail) {
+	for _, policy := range attached {
+		i.arnToArn.add(policy.PolicyArn, arn)
+	}
+	if boundary != nil {
+		i.permissionsBoundary.add(boundary.PermissionsBoundaryArn, arn)
+	}
+	i.addTags(arn, tags)
+
+	for _, policy := range inline {
+		policyHash := getHash(policy)
+		if !i.inlinePolicies.keys[policyHash] {
+			i.inlinePolicies.add(policyHash, policy.PolicyName)
+			i.hashToHash.add(i.addPolicyDocument(policy.PolicyDocument), policyHash)
+		}
+		i.hashToArn.add(policyHash, arn)
+	}
+}
+
+func (i *ingest) addRole(role analyze.RoleDetail) {
+	if i.roles.keys[role.Arn] {
+		return
+	}
+	parts := strings.SplitN(role.Arn, ":", 6)
+	resource := strings.Split(parts[len(parts)-1], "/")
+	i.roles.add(role.Arn, role.Path, resource[len(resource)-1], role.RoleId, role.CreateDate, nil)
+	i.addPrincipalPolicies(role.Arn, role.AttachedManagedPolicies, role.RolePolicyList, role.PermissionsBoundary, role.Tags)
+
+	trustHash := getHash(role.AssumeRolePolicyDocument)
+	if !i.trustPolicies.keys[trustHash] {
+		i.trustPolicies.add(trustHash, role.AssumeRolePolicyDocument["Version"], role.AssumeRolePolicyDocument["Sid"])
+		i.addStatements(role.AssumeRolePolicyDocument, trustHash)
+	}
+	i.hashToArn.add(trustHash, role.Arn)
+}
+
+func (i *ingest) addGroup(group analyze.GroupDetail) {
+	if i.groups.keys[group.Arn] {
+		return
+	}
+	i.groups.add(group.Arn, group.Path, group.GroupName, group.GroupId, group.CreateDate)
+	i.groupArns[group.GroupName] = group.Arn
+	i.addPrincipalPolicies(group.Arn, group.AttachedManagedPolicies, group.GroupPolicyList, nil, nil)
+}
+
+// Users are members of the groups with their group names that were
+// processed before them
+func (i *ingest) addUser(user analyze.UserDetail) {
+	if i.users.keys[user.Arn] {
+		return
+	}
+	i.users.add(user.Arn, user.Path, user.UserName, user.UserId, user.CreateDate)
+	i.addPrincipalPolicies(user.Arn, user.AttachedManagedPolicies, user.UserPolicyList, user.PermissionsBoundary, user.Tags)
+	for _, name := range user.GroupList {
+		i.memberOf.add(user.Arn, i.groupArns[name])
+	}
+}
+
+func (i *ingest) addAuthorizationDetails(details *analyze.AuthorizationDetails) {
+	for _, policy := range details.Policies {
+		i.addManagedPolicy(policy)
+	}
+	for _, role := range details.RoleDetailList {
+		i.addRole(role)
+	}
+	for _, group := range details.GroupDetailList {
+		i.addGroup(group)
+	}
+	for _, user := range details.UserDetailList {
+		i.addUser(user)
+	}
+}
+
+func writeRows(ctx context.Context, db graph.Database, query string, rows []map[string]any) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	params := map[string]any{
+		"rows":       rows,
+		"collection": queries.DefaultCollection,
+	}
+	if err := queries.RawCypherWrite(ctx, db, query, params); err != nil {
+		return fmt.Errorf("failed running %q: %w", query, err)
+	}
+	return nil
+}
+
+// LoadAuthorizationDetails loads the output of aws iam
+// get-account-authorization-details into the default collection, like the
+// ingest does with the files of a directory
+func LoadAuthorizationDetails(ctx context.Context, db graph.Database, details ...*analyze.AuthorizationDetails) error {
+	i := newIngest()
+	for _, item := range details {
+		i.addAuthorizationDetails(item)
+	}
+
+	for _, batch := range i.nodeBatches {
+		if err := writeRows(ctx, db, batch.query(), batch.rows); err != nil {
+			return err
+		}
+	}
+	for _, batch := range i.relationshipBatches {
+		if err := writeRows(ctx, db, batch.query(), batch.rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadResources loads the ARNs of resources that aren't in the
+// authorization details, like the ingest does with arns.csv
+func LoadResources(ctx context.Context, db graph.Database, arns []string) error {
+	rows := []map[string]any{}
+	for _, arn := range arns {
+		rows = append(rows, map[string]any{"arn": arn})
+	}
+	return writeRows(ctx, db, "UNWIND $rows AS row "+
+		"MERGE (a:UniqueArn {arn: row.arn, collection: $collection}) "+
+		"ON CREATE SET a.arn = row.arn, a.collection = $collection, a.layer = 1", rows)
+}
+
+// LoadCatalog loads the actions and resource types of a catalog, like the
+// init script does with the service authorization reference. Names are
+// lowercased, as the init script does.
+func LoadCatalog(ctx context.Context, db graph.Database, catalog *Catalog) error {
+	resourceTypes := []map[string]any{}
+	for _, item := range catalog.ResourceTypes {
+		resourceTypes = append(resourceTypes, map[string]any{
+			"name":  strings.ToLower(item.Name),
+			"arn":   strings.ToLower(item.Arn),
+			"regex": resourceTypeRegex(item.Arn),
+		})
+	}
+	if err := writeRows(ctx, db, "UNWIND $rows AS row "+
+		"MERGE (a:AWSResourceType:UniqueName {name: row.name}) "+
+		"SET a.name = row.name, a.arn = row.arn, a.regex = row.regex, a.layer = 0", resourceTypes); err != nil {
+		return err
+	}
+
+	actions := []map[string]any{}
+	actsOn := []map[string]any{}
+	for _, item := range catalog.Actions {
+		name := strings.ToLower(item.Name)
+		actions = append(actions, map[string]any{"name": name})
+		for _, resourceType := range item.ResourceTypes {
+			actsOn = append(actsOn, map[string]any{
+				"source":      name,
+				"destination": strings.ToLower(resourceType),
+			})
+		}
+	}
+	if err := writeRows(ctx, db, "UNWIND $rows AS row "+
+		"MERGE (a:AWSAction:UniqueName {name: row.name}) "+
+		"SET a.name = row.name, a.layer = 0", actions); err != nil {
+		return err
+	}
+	return writeRows(ctx, db, "UNWIND $rows AS row "+
+		"MERGE (s:AWSAction:UniqueName {name: row.source}) ON CREATE SET s.inferred = true "+
+		"MERGE (d:AWSResourceType:UniqueName {name: row.destination}) ON CREATE SET d.inferred = true "+
+		"MERGE (s) - [:ActsOn {layer: 0}] -> (d)", actsOn)
+}
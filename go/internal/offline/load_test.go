@@ -0,0 +1,98 @@
+package offline
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/hotnops/apeman/go/internal/queries"
+	"github.com/zeebo/xxh3"
+)
+
+// The hash the ingest gives the output of Python's
+// json.dumps(value, sort_keys=True)
+func getIngestHash(salt string, pythonJSON string) string {
+	hash := xxh3.HashString128(salt + pythonJSON).Bytes()
+	return hex.EncodeToString(hash[:])
+}
+
+func TestHashPolicyElement(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      string
+		pythonJSON string
+	}{
+		{
+			name:       "statement",
+			value:      `{"Effect":"Allow","Action":"*","Resource":"*","Condition":{"ArnLike":{"aws:PrincipalArn":["arn:aws:iam::*:role/A","arn:aws:iam::*:role/B"]}}}`,
+			pythonJSON: `{"Action": "*", "Condition": {"ArnLike": {"aws:PrincipalArn": ["arn:aws:iam::*:role/A", "arn:aws:iam::*:role/B"]}}, "Effect": "Allow", "Resource": "*"}`,
+		},
+		{
+			name:       "escapes",
+			value:      `{"Version":1.5,"N":10,"B":[true,null],"Sid":"Café 😀"}`,
+			pythonJSON: `{"B": [true, null], "N": 10, "Sid": "Caf\u00e9 \ud83d\ude00", "Version": 1.5}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var value any
+			if err := json.Unmarshal([]byte(test.value), &value); err != nil {
+				t.Fatal(err)
+			}
+			for _, salt := range []string{"", "customer-a"} {
+				expected := getIngestHash(salt, test.pythonJSON)
+				if actual := queries.HashPolicyElement(salt, value); actual != expected {
+					t.Errorf("salt %q: got %s, expected %s", salt, actual, expected)
+				}
+			}
+		})
+	}
+}
+
+// The loaded policy elements have to carry the hashes the ingest gives
+// them, or the graph the analyses see offline isn't the ingested one
+func TestLoadedHashes(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	ctx := context.Background()
+	db := loadDatabase(t, ctx, []string{"dev_account.json"})
+
+	tests := []struct {
+		labels     string
+		pythonJSON string
+	}{
+		{
+			labels:     "AWSStatement",
+			pythonJSON: `{"Action": "ssm:GetParameter", "Condition": {"StringEquals": {"aws:ResourceTag/team": "${aws:PrincipalTag/team}"}}, "Effect": "Allow", "Resource": "arn:aws:ssm:us-east-1:123456789012:parameter/artifactory_key"}`,
+		},
+		{
+			labels:     "AWSCondition",
+			pythonJSON: `{"stringequals": {"aws:ResourceTag/team": "${aws:PrincipalTag/team}"}}`,
+		},
+		{
+			labels:     "AWSConditionKey",
+			pythonJSON: `{"aws:ResourceTag/team": "${aws:PrincipalTag/team}"}`,
+		},
+		{
+			labels:     "AWSAssumeRolePolicy",
+			pythonJSON: `{"Statement": [{"Action": "sts:AssumeRole", "Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::123456789012:root"}}], "Version": "2012-10-17"}`,
+		},
+	}
+
+	for _, test := range tests {
+		hash := getIngestHash(queries.GetHashSalt(queries.DefaultCollection), test.pythonJSON)
+		results, err := queries.RawCypherQuery(ctx, db, "MATCH (n:"+test.labels+" {hash: $hash}) RETURN ID(n)", map[string]any{"hash": hash})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) == 0 {
+			t.Errorf("no %s with hash %s of %s", test.labels, hash, test.pythonJSON)
+		}
+	}
+}
@@ -0,0 +1,678 @@
+package offline
+
+import "fmt"
+
+// Call visit with the expression and every expression inside it
+func walkExpression(expr expression, visit func(expression)) {
+	if expr == nil {
+		return
+	}
+	visit(expr)
+
+	switch typed := expr.(type) {
+	case *propertyAccess:
+		walkExpression(typed.subject, visit)
+	case *indexAccess:
+		walkExpression(typed.subject, visit)
+		walkExpression(typed.index, visit)
+	case *listLiteral:
+		for _, item := range typed.items {
+			walkExpression(item, visit)
+		}
+	case *mapLiteral:
+		for _, value := range typed.values {
+			walkExpression(value, visit)
+		}
+	case *functionCall:
+		for _, argument := range typed.arguments {
+			walkExpression(argument, visit)
+		}
+	case *binaryOperation:
+		walkExpression(typed.left, visit)
+		walkExpression(typed.right, visit)
+	case *unaryOperation:
+		walkExpression(typed.operand, visit)
+	case *labelCheck:
+		walkExpression(typed.subject, visit)
+	case *listPredicate:
+		walkExpression(typed.list, visit)
+		walkExpression(typed.predicate, visit)
+	case *patternPredicate:
+		for _, node := range typed.pattern.nodes {
+			walkExpression(node.properties, visit)
+		}
+		for _, relationship := range typed.pattern.relationships {
+			walkExpression(relationship.properties, visit)
+		}
+	}
+}
+
+// The variables an expression reads, apart from the ones list predicates
+// define
+func expressionVariables(expr expression) map[string]bool {
+	variables := map[string]bool{}
+	var collect func(expr expression, locals map[string]bool)
+	collect = func(expr expression, locals map[string]bool) {
+		switch typed := expr.(type) {
+		case nil:
+		case *variable:
+			if !locals[typed.name] {
+				variables[typed.name] = true
+			}
+		case *listPredicate:
+			collect(typed.list, locals)
+			inner := map[string]bool{typed.variable: true}
+			for name := range locals {
+				inner[name] = true
+			}
+			collect(typed.predicate, inner)
+		case *patternPredicate:
+			for _, name := range patternVariables(typed.pattern) {
+				if !locals[name] {
+					variables[name] = true
+				}
+			}
+			for _, node := range typed.pattern.nodes {
+				collect(node.properties, locals)
+			}
+			for _, relationship := range typed.pattern.relationships {
+				collect(relationship.properties, locals)
+			}
+		case *propertyAccess:
+			collect(typed.subject, locals)
+		case *indexAccess:
+			collect(typed.subject, locals)
+			collect(typed.index, locals)
+		case *listLiteral:
+			for _, item := range typed.items {
+				collect(item, locals)
+			}
+		case *mapLiteral:
+			for _, value := range typed.values {
+				collect(value, locals)
+			}
+		case *functionCall:
+			for _, argument := range typed.arguments {
+				collect(argument, locals)
+			}
+		case *binaryOperation:
+			collect(typed.left, locals)
+			collect(typed.right, locals)
+		case *unaryOperation:
+			collect(typed.operand, locals)
+		case *labelCheck:
+			collect(typed.subject, locals)
+		}
+	}
+	collect(expr, map[string]bool{})
+	return variables
+}
+
+// The named variables of a pattern, in the order they appear
+func patternVariables(p *pattern) []string {
+	names := []string{}
+	add := func(name string) {
+		if name == "" {
+			return
+		}
+		for _, existing := range names {
+			if existing == name {
+				return
+			}
+		}
+		names = append(names, name)
+	}
+
+	for i, node := range p.nodes {
+		add(node.variable)
+		if i < len(p.relationships) {
+			add(p.relationships[i].variable)
+		}
+	}
+	add(p.variable)
+	return names
+}
+
+// Split a predicate into the parts joined by AND
+func getConjuncts(where expression) []expression {
+	if operation, ok := where.(*binaryOperation); ok && operation.operator == "and" {
+		return append(getConjuncts(operation.left), getConjuncts(operation.right)...)
+	}
+	if where == nil {
+		return nil
+	}
+	return []expression{where}
+}
+
+func isVariable(expr expression, name string) bool {
+	v, ok := expr.(*variable)
+	return ok && v.name == name
+}
+
+// Whether expr is nodes(name)
+func isNodesOf(expr expression, name string) bool {
+	call, ok := expr.(*functionCall)
+	return ok && call.name == "nodes" && len(call.arguments) == 1 && isVariable(call.arguments[0], name)
+}
+
+// Whether a predicate only keeps paths that don't visit a node twice, as
+// in ALL(n IN nodes(p) WHERE SINGLE(x IN nodes(p) WHERE x = n)). The
+// matching then stops following a path as soon as it visits a node twice,
+// rather than checking the predicate on every path of a variable length
+// relationship.
+func isDistinctNodesPredicate(expr expression, path string) bool {
+	all, ok := expr.(*listPredicate)
+	if !ok || all.kind != "all" || !isNodesOf(all.list, path) {
+		return false
+	}
+	single, ok := all.predicate.(*listPredicate)
+	if !ok || single.kind != "single" || !isNodesOf(single.list, path) {
+		return false
+	}
+	equality, ok := single.predicate.(*binaryOperation)
+	if !ok || equality.operator != "=" {
+		return false
+	}
+	return (isVariable(equality.left, single.variable) && isVariable(equality.right, all.variable)) ||
+		(isVariable(equality.left, all.variable) && isVariable(equality.right, single.variable))
+}
+
+// A pattern as it is traversed. Patterns are traversed from the end that
+// the predicates narrow down, so the relationships are reversed when the
+// traversal starts from the last node.
+type plannedPattern struct {
+	source        *pattern
+	reversed      bool
+	nodes         []*nodePattern
+	relationships []*relationshipPattern
+	directions    []direction
+	distinctNodes bool
+}
+
+func reverseDirection(d direction) direction {
+	switch d {
+	case directionInbound:
+		return directionOutbound
+	case directionOutbound:
+		return directionInbound
+	}
+	return d
+}
+
+// Matches the patterns of a clause against the graph, binding their
+// variables in a row one element at a time. Each part of the WHERE
+// predicate is checked as soon as the variables it reads are bound.
+type matcher struct {
+	e        *executor
+	patterns []*plannedPattern
+	row      row
+	// Relationships are used once per match, like in Neo4j
+	used map[int64]bool
+	// The position in which each variable is bound, and the predicates to
+	// check once a position is bound. Predicates on variables bound
+	// before matching are checked at position 0.
+	positions map[string]int
+	checks    map[int][]expression
+	final     int
+	yield     func(row) (bool, error)
+}
+
+// Order the bindings of the patterns, for the predicates to be checked as
+// early as possible
+func (m *matcher) plan(patterns []*pattern, conjuncts []expression) {
+	conjunctVariables := make([]map[string]bool, len(conjuncts))
+	for i, conjunct := range conjuncts {
+		conjunctVariables[i] = expressionVariables(conjunct)
+	}
+
+	// Whether a predicate narrows down a variable on its own
+	isNarrowed := func(name string) bool {
+		if name == "" {
+			return false
+		}
+		if _, ok := m.row[name]; ok {
+			return true
+		}
+		for _, variables := range conjunctVariables {
+			if !variables[name] {
+				continue
+			}
+			narrows := true
+			for other := range variables {
+				if _, ok := m.row[other]; other != name && !ok {
+					narrows = false
+				}
+			}
+			if narrows {
+				return true
+			}
+		}
+		return false
+	}
+
+	position := 1
+	m.positions = map[string]int{}
+	bind := func(name string) {
+		if name == "" {
+			return
+		}
+		if _, ok := m.row[name]; ok {
+			return
+		}
+		if _, ok := m.positions[name]; !ok {
+			m.positions[name] = position
+			position++
+		}
+	}
+
+	for _, p := range patterns {
+		planned := &plannedPattern{source: p, nodes: p.nodes, relationships: p.relationships}
+		last := p.nodes[len(p.nodes)-1]
+		if len(p.relationships) > 0 && !isNarrowed(p.nodes[0].variable) && isNarrowed(last.variable) {
+			planned.reversed = true
+			planned.nodes = make([]*nodePattern, len(p.nodes))
+			for i, node := range p.nodes {
+				planned.nodes[len(p.nodes)-1-i] = node
+			}
+			planned.relationships = make([]*relationshipPattern, len(p.relationships))
+			for i, relationship := range p.relationships {
+				planned.relationships[len(p.relationships)-1-i] = relationship
+			}
+		}
+		for _, relationship := range planned.relationships {
+			if planned.reversed {
+				planned.directions = append(planned.directions, reverseDirection(relationship.direction))
+			} else {
+				planned.directions = append(planned.directions, relationship.direction)
+			}
+		}
+		if p.variable != "" {
+			for _, conjunct := range conjuncts {
+				planned.distinctNodes = planned.distinctNodes || isDistinctNodesPredicate(conjunct, p.variable)
+			}
+		}
+
+		for i, node := range planned.nodes {
+			bind(node.variable)
+			if i < len(planned.relationships) {
+				bind(planned.relationships[i].variable)
+			}
+		}
+		bind(p.variable)
+		m.patterns = append(m.patterns, planned)
+	}
+
+	m.final = position
+	m.checks = map[int][]expression{}
+	for i, conjunct := range conjuncts {
+		ready := 0
+		for name := range conjunctVariables[i] {
+			if _, ok := m.row[name]; ok {
+				continue
+			}
+			bound, ok := m.positions[name]
+			if !ok {
+				// Left for the evaluation to report
+				bound = m.final
+			}
+			if bound > ready {
+				ready = bound
+			}
+		}
+		m.checks[ready] = append(m.checks[ready], conjunct)
+	}
+}
+
+// Check the predicates of a position
+func (m *matcher) check(position int) (bool, error) {
+	for _, conjunct := range m.checks[position] {
+		value, err := m.e.evaluate(conjunct, m.row)
+		if err != nil {
+			return false, err
+		}
+		if value, err = toBool(value); err != nil {
+			return false, err
+		}
+		if value != true {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Bind a variable, or check the value it is bound to. The returned
+// function undoes the binding.
+func (m *matcher) bind(name string, value any, matches func(bound any) bool) (bool, func(), error) {
+	if name == "" {
+		return true, func() {}, nil
+	}
+	if bound, ok := m.row[name]; ok {
+		return matches(bound), func() {}, nil
+	}
+
+	m.row[name] = value
+	unbind := func() { delete(m.row, name) }
+	ok, err := m.check(m.positions[name])
+	if err != nil || !ok {
+		unbind()
+		return false, func() {}, err
+	}
+	return true, unbind, nil
+}
+
+func (m *matcher) bindNode(name string, node *storedNode) (bool, func(), error) {
+	return m.bind(name, node, func(bound any) bool {
+		other, ok := bound.(*storedNode)
+		return ok && other.id == node.id
+	})
+}
+
+func (m *matcher) nodeMatches(p *nodePattern, node *storedNode) (bool, error) {
+	for _, group := range p.labels {
+		found := false
+		for _, label := range group {
+			found = found || node.hasLabel(label)
+		}
+		if !found {
+			return false, nil
+		}
+	}
+	return m.propertiesMatch(p.properties, node.properties)
+}
+
+func (m *matcher) propertiesMatch(expr expression, properties map[string]any) (bool, error) {
+	if expr == nil {
+		return true, nil
+	}
+	value, err := m.e.evaluate(expr, m.row)
+	if err != nil {
+		return false, err
+	}
+	values, ok := value.(map[string]any)
+	if !ok {
+		return false, fmt.Errorf("properties of type %s", typeName(value))
+	}
+	for name, expected := range values {
+		if equals(properties[name], expected) != true {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (m *matcher) relationshipMatches(p *relationshipPattern, relationship *storedRelationship) (bool, error) {
+	if len(p.kinds) > 0 {
+		found := false
+		for _, kind := range p.kinds {
+			found = found || relationship.kind == kind
+		}
+		if !found {
+			return false, nil
+		}
+	}
+	return m.propertiesMatch(p.properties, relationship.properties)
+}
+
+// The relationships leaving node in a direction, with the node at their
+// other end
+func (m *matcher) getNeighbours(node *storedNode, d direction, delegate func(relationship *storedRelationship, other *storedNode) (bool, error)) (bool, error) {
+	store := m.e.store()
+	if d != directionInbound {
+		for _, id := range node.outbound {
+			relationship := store.relationships[id]
+			if next, err := delegate(relationship, store.nodes[relationship.end]); err != nil || !next {
+				return next, err
+			}
+		}
+	}
+	if d != directionOutbound {
+		for _, id := range node.inbound {
+			relationship := store.relationships[id]
+			// Loops are only followed once in either direction
+			if d == directionBoth && relationship.start == relationship.end {
+				continue
+			}
+			if next, err := delegate(relationship, store.nodes[relationship.start]); err != nil || !next {
+				return next, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// The traversal of one pattern so far
+type traversal struct {
+	pattern       *plannedPattern
+	nodes         []*storedNode
+	relationships []*storedRelationship
+}
+
+func (t *traversal) visited(node *storedNode) bool {
+	for _, existing := range t.nodes {
+		if existing.id == node.id {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *traversal) getPath() *pathValue {
+	path := &pathValue{
+		nodes:         append([]*storedNode{}, t.nodes...),
+		relationships: append([]*storedRelationship{}, t.relationships...),
+	}
+	if t.pattern.reversed {
+		reverseNodes(path.nodes)
+		reverseRelationships(path.relationships)
+	}
+	return path
+}
+
+func reverseNodes(nodes []*storedNode) {
+	for i, j := 0, len(nodes)-1; i < j; i, j = i+1, j-1 {
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	}
+}
+
+func reverseRelationships(relationships []*storedRelationship) {
+	for i, j := 0, len(relationships)-1; i < j; i, j = i+1, j-1 {
+		relationships[i], relationships[j] = relationships[j], relationships[i]
+	}
+}
+
+// Match patterns, starting from the variables in r, and call yield with
+// every match until it returns false. The row given to yield is reused.
+func (e *executor) matchPatterns(patterns []*pattern, where expression, r row, yield func(row) (bool, error)) error {
+	m := &matcher{
+		e:     e,
+		row:   r.clone(),
+		used:  map[int64]bool{},
+		yield: yield,
+	}
+	m.plan(patterns, getConjuncts(where))
+
+	if ok, err := m.check(0); err != nil || !ok {
+		return err
+	}
+	_, err := m.matchPattern(0)
+	return err
+}
+
+// Match the pattern at index, and the ones after it. Returns false when
+// the matching should stop.
+func (m *matcher) matchPattern(index int) (bool, error) {
+	if index == len(m.patterns) {
+		if ok, err := m.check(m.final); err != nil || !ok {
+			return true, err
+		}
+		return m.yield(m.row)
+	}
+
+	p := m.patterns[index]
+	start := p.nodes[0]
+	candidates := []*storedNode{}
+	if bound, ok := m.row[start.variable]; ok && start.variable != "" {
+		node, ok := bound.(*storedNode)
+		if !ok {
+			// Null never matches
+			return true, nil
+		}
+		candidates = append(candidates, node)
+	} else {
+		m.e.store().eachNode(func(node *storedNode) bool {
+			candidates = append(candidates, node)
+			return true
+		})
+	}
+
+	for _, node := range candidates {
+		ok, err := m.nodeMatches(start, node)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			continue
+		}
+		ok, unbind, err := m.bindNode(start.variable, node)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			continue
+		}
+		next, err := m.expand(index, 0, &traversal{pattern: p, nodes: []*storedNode{node}})
+		unbind()
+		if err != nil || !next {
+			return next, err
+		}
+	}
+	return true, nil
+}
+
+// Match the relationship at hop of a pattern, and everything after it
+func (m *matcher) expand(index int, hop int, t *traversal) (bool, error) {
+	p := t.pattern
+	if hop == len(p.relationships) {
+		ok, unbind, err := m.bind(p.source.variable, t.getPath(), func(any) bool { return false })
+		if err != nil || !ok {
+			return true, err
+		}
+		defer unbind()
+		return m.matchPattern(index + 1)
+	}
+
+	relationshipPattern := p.relationships[hop]
+	if relationshipPattern.variableLength {
+		return m.expandVariableLength(index, hop, t, t.nodes[len(t.nodes)-1], 0)
+	}
+
+	current := t.nodes[len(t.nodes)-1]
+	return m.getNeighbours(current, p.directions[hop], func(relationship *storedRelationship, other *storedNode) (bool, error) {
+		if m.used[relationship.id] || (p.distinctNodes && t.visited(other)) {
+			return true, nil
+		}
+		ok, err := m.relationshipMatches(relationshipPattern, relationship)
+		if err != nil || !ok {
+			return true, err
+		}
+
+		m.used[relationship.id] = true
+		t.nodes = append(t.nodes, other)
+		t.relationships = append(t.relationships, relationship)
+		defer func() {
+			delete(m.used, relationship.id)
+			t.nodes = t.nodes[:len(t.nodes)-1]
+			t.relationships = t.relationships[:len(t.relationships)-1]
+		}()
+
+		ok, unbind, err := m.bind(relationshipPattern.variable, relationship, func(bound any) bool {
+			other, ok := bound.(*storedRelationship)
+			return ok && other.id == relationship.id
+		})
+		if err != nil || !ok {
+			return true, err
+		}
+		defer unbind()
+
+		return m.bindNext(index, hop, t, other)
+	})
+}
+
+// Bind the node at the end of the relationship at hop, and carry on
+func (m *matcher) bindNext(index int, hop int, t *traversal, node *storedNode) (bool, error) {
+	nodePattern := t.pattern.nodes[hop+1]
+	ok, err := m.nodeMatches(nodePattern, node)
+	if err != nil || !ok {
+		return true, err
+	}
+	ok, unbind, err := m.bindNode(nodePattern.variable, node)
+	if err != nil || !ok {
+		return true, err
+	}
+	defer unbind()
+	return m.expand(index, hop+1, t)
+}
+
+// Follow a variable length relationship from node, which is depth
+// relationships away from where it started
+func (m *matcher) expandVariableLength(index int, hop int, t *traversal, node *storedNode, depth int) (bool, error) {
+	p := t.pattern
+	relationshipPattern := p.relationships[hop]
+
+	if depth >= relationshipPattern.minHops {
+		relationships := t.relationships[len(t.relationships)-depth:]
+		items := make([]any, len(relationships))
+		for i, relationship := range relationships {
+			items[i] = relationship
+		}
+		if p.reversed {
+			for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+				items[i], items[j] = items[j], items[i]
+			}
+		}
+
+		ok, unbind, err := m.bind(relationshipPattern.variable, items, func(bound any) bool {
+			return equals(bound, items) == true
+		})
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			// A path of no relationships ends where it started, and only
+			// binds the next node
+			var next bool
+			if depth == 0 {
+				next, err = m.bindNext(index, hop, &traversal{pattern: p, nodes: t.nodes, relationships: t.relationships}, node)
+			} else {
+				next, err = m.bindNext(index, hop, t, node)
+			}
+			unbind()
+			if err != nil || !next {
+				return next, err
+			}
+		}
+	}
+
+	if relationshipPattern.maxHops >= 0 && depth >= relationshipPattern.maxHops {
+		return true, nil
+	}
+
+	return m.getNeighbours(node, p.directions[hop], func(relationship *storedRelationship, other *storedNode) (bool, error) {
+		if m.used[relationship.id] || (p.distinctNodes && t.visited(other)) {
+			return true, nil
+		}
+		ok, err := m.relationshipMatches(relationshipPattern, relationship)
+		if err != nil || !ok {
+			return true, err
+		}
+
+		m.used[relationship.id] = true
+		t.nodes = append(t.nodes, other)
+		t.relationships = append(t.relationships, relationship)
+		next, err := m.expandVariableLength(index, hop, t, other, depth+1)
+		delete(m.used, relationship.id)
+		t.nodes = t.nodes[:len(t.nodes)-1]
+		t.relationships = t.relationships[:len(t.relationships)-1]
+		return next, err
+	})
+}
@@ -0,0 +1,1038 @@
+package offline
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdentifier
+	tokenString
+	tokenNumber
+	tokenParameter
+	tokenSymbol
+)
+
+type token struct {
+	kind tokenKind
+	// The name of an identifier or parameter, the text of a symbol and
+	// the unquoted text of a string
+	text  string
+	value any
+	// Quoted identifiers are never keywords
+	quoted bool
+	pos    int
+}
+
+// Symbols made of two characters. Arrows aren't among them, as the
+// queries put spaces inside them, like - >.
+var twoCharSymbols = []string{"<>", "<=", ">=", "=~", "+=", ".."}
+
+func tokenize(input string) ([]token, error) {
+	tokens := []token{}
+	runes := []rune(input)
+
+	for i := 0; i < len(runes); {
+		char := runes[i]
+		switch {
+		case unicode.IsSpace(char):
+			i++
+
+		case char == '/' && i+1 < len(runes) && runes[i+1] == '/':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+
+		case unicode.IsLetter(char) || char == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdentifier, text: string(runes[start:i]), pos: start})
+
+		case char == '`':
+			start := i
+			end := i + 1
+			for end < len(runes) && runes[end] != '`' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("unterminated identifier at %d", start)
+			}
+			tokens = append(tokens, token{kind: tokenIdentifier, text: string(runes[i+1 : end]), quoted: true, pos: start})
+			i = end + 1
+
+		case char == '$':
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			if i == start+1 {
+				return nil, fmt.Errorf("missing parameter name at %d", start)
+			}
+			tokens = append(tokens, token{kind: tokenParameter, text: string(runes[start+1 : i]), pos: start})
+
+		case unicode.IsDigit(char):
+			start := i
+			for i < len(runes) && unicode.IsDigit(runes[i]) {
+				i++
+			}
+			// A dot only makes a float when a digit follows, so ranges
+			// like *1..2 stay integers
+			isFloat := i+1 < len(runes) && runes[i] == '.' && unicode.IsDigit(runes[i+1])
+			if isFloat {
+				i++
+				for i < len(runes) && unicode.IsDigit(runes[i]) {
+					i++
+				}
+			}
+			text := string(runes[start:i])
+			var value any
+			var err error
+			if isFloat {
+				value, err = strconv.ParseFloat(text, 64)
+			} else {
+				value, err = strconv.ParseInt(text, 10, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %s at %d", text, start)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: text, value: value, pos: start})
+
+		case char == '\'' || char == '"':
+			start := i
+			text := strings.Builder{}
+			i++
+			for ; i < len(runes) && runes[i] != char; i++ {
+				if runes[i] != '\\' {
+					text.WriteRune(runes[i])
+					continue
+				}
+				i++
+				if i == len(runes) {
+					break
+				}
+				switch runes[i] {
+				case 'n':
+					text.WriteRune('\n')
+				case 't':
+					text.WriteRune('\t')
+				case 'r':
+					text.WriteRune('\r')
+				default:
+					text.WriteRune(runes[i])
+				}
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at %d", start)
+			}
+			i++
+			tokens = append(tokens, token{kind: tokenString, text: text.String(), pos: start})
+
+		default:
+			symbol := string(char)
+			for _, candidate := range twoCharSymbols {
+				if strings.HasPrefix(string(runes[i:]), candidate) {
+					symbol = candidate
+					break
+				}
+			}
+			if !strings.Contains("()[]{},.:|*+-/%=<>;", string(char)) {
+				return nil, fmt.Errorf("unexpected character %q at %d", char, i)
+			}
+			tokens = append(tokens, token{kind: tokenSymbol, text: symbol, pos: i})
+			i += len([]rune(symbol))
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(runes)}), nil
+}
+
+// The parsed form of a query. Clauses run in order, each on the rows
+// produced by the one before.
+type cypherQuery struct {
+	clauses []any
+}
+
+type matchClause struct {
+	optional bool
+	patterns []*pattern
+	where    expression
+}
+
+type unwindClause struct {
+	list     expression
+	variable string
+}
+
+type withClause struct {
+	projection *projection
+	where      expression
+}
+
+type returnClause struct {
+	projection *projection
+}
+
+type setClause struct {
+	items []setItem
+}
+
+type removeClause struct {
+	items []setItem
+}
+
+type mergeClause struct {
+	pattern  *pattern
+	onCreate []setItem
+	onMatch  []setItem
+}
+
+type createClause struct {
+	patterns []*pattern
+}
+
+type deleteClause struct {
+	detach      bool
+	expressions []expression
+}
+
+type projection struct {
+	distinct bool
+	// Keep every variable, as in WITH *
+	all     bool
+	items   []projectionItem
+	orderBy []sortItem
+	skip    expression
+	limit   expression
+}
+
+type projectionItem struct {
+	expression expression
+	// Empty when the item isn't a variable and isn't aliased
+	name string
+}
+
+type sortItem struct {
+	expression expression
+	descending bool
+}
+
+type setItemKind int
+
+const (
+	setProperty setItemKind = iota
+	// n = {map}
+	setReplace
+	// n += {map}
+	setMerge
+	setLabels
+)
+
+type setItem struct {
+	kind     setItemKind
+	variable string
+	property string
+	value    expression
+	labels   []string
+}
+
+// A chain of nodes connected by relationships, like (a)-[:R]->(b)
+type pattern struct {
+	// The variable the whole path is bound to, as in p=(a)-->(b)
+	variable      string
+	nodes         []*nodePattern
+	relationships []*relationshipPattern
+}
+
+type nodePattern struct {
+	variable string
+	// Every group has to match, with any label of a group. (n:A:B) is two
+	// groups, (n:A|B) is one.
+	labels     [][]string
+	properties expression
+}
+
+type direction int
+
+const (
+	directionBoth direction = iota
+	directionOutbound
+	directionInbound
+)
+
+type relationshipPattern struct {
+	variable   string
+	kinds      []string
+	direction  direction
+	properties expression
+	// Variable length relationships, like [:R*1..2]. A maximum below zero
+	// is unbounded.
+	variableLength bool
+	minHops        int
+	maxHops        int
+}
+
+type expression any
+
+type literal struct {
+	value any
+}
+
+type parameter struct {
+	name string
+}
+
+type variable struct {
+	name string
+}
+
+type propertyAccess struct {
+	subject  expression
+	property string
+}
+
+type indexAccess struct {
+	subject expression
+	index   expression
+}
+
+type listLiteral struct {
+	items []expression
+}
+
+type mapLiteral struct {
+	keys   []string
+	values []expression
+}
+
+type functionCall struct {
+	// Lower case, with the namespace, like apoc.text.regexgroups
+	name     string
+	distinct bool
+	// count(*)
+	all       bool
+	arguments []expression
+}
+
+type binaryOperation struct {
+	operator string
+	left     expression
+	right    expression
+}
+
+type unaryOperation struct {
+	operator string
+	operand  expression
+}
+
+type labelCheck struct {
+	subject expression
+	labels  []string
+}
+
+// all(x IN list WHERE predicate) and its any, none and single variants
+type listPredicate struct {
+	kind      string
+	variable  string
+	list      expression
+	predicate expression
+}
+
+// A pattern used as a predicate, which is true when it matches
+type patternPredicate struct {
+	pattern *pattern
+}
+
+type syntaxError struct {
+	message string
+}
+
+func (e syntaxError) Error() string {
+	return e.message
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse a query. The parser panics with a syntaxError on invalid input,
+// which is turned into an error here.
+func parseQuery(text string) (query *cypherQuery, err error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			syntaxErr, ok := recovered.(syntaxError)
+			if !ok {
+				panic(recovered)
+			}
+			query, err = nil, syntaxErr
+		}
+	}()
+
+	p := &parser{tokens: tokens}
+	query = p.parseQuery()
+	return query, nil
+}
+
+func (p *parser) fail(format string, args ...any) {
+	current := p.peek()
+	panic(syntaxError{message: fmt.Sprintf("%s at %d", fmt.Sprintf(format, args...), current.pos)})
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) peekAt(offset int) token {
+	if p.pos+offset >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+offset]
+}
+
+func (p *parser) next() token {
+	current := p.tokens[p.pos]
+	if current.kind != tokenEOF {
+		p.pos++
+	}
+	return current
+}
+
+func isKeyword(t token, keyword string) bool {
+	return t.kind == tokenIdentifier && !t.quoted && strings.EqualFold(t.text, keyword)
+}
+
+// Whether the next tokens are the given keywords
+func (p *parser) isKeyword(keywords ...string) bool {
+	for i, keyword := range keywords {
+		if !isKeyword(p.peekAt(i), keyword) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *parser) acceptKeyword(keywords ...string) bool {
+	if !p.isKeyword(keywords...) {
+		return false
+	}
+	p.pos += len(keywords)
+	return true
+}
+
+func (p *parser) expectKeyword(keywords ...string) {
+	if !p.acceptKeyword(keywords...) {
+		p.fail("expected %s", strings.Join(keywords, " "))
+	}
+}
+
+func (p *parser) isSymbol(symbol string) bool {
+	current := p.peek()
+	return current.kind == tokenSymbol && current.text == symbol
+}
+
+func (p *parser) acceptSymbol(symbol string) bool {
+	if !p.isSymbol(symbol) {
+		return false
+	}
+	p.pos++
+	return true
+}
+
+func (p *parser) expectSymbol(symbol string) {
+	if !p.acceptSymbol(symbol) {
+		p.fail("expected %s", symbol)
+	}
+}
+
+func (p *parser) expectIdentifier() string {
+	current := p.peek()
+	if current.kind != tokenIdentifier {
+		p.fail("expected a name")
+	}
+	p.pos++
+	return current.text
+}
+
+func (p *parser) parseQuery() *cypherQuery {
+	query := &cypherQuery{}
+	for p.peek().kind != tokenEOF && !p.isSymbol(";") {
+		query.clauses = append(query.clauses, p.parseClause())
+	}
+	p.acceptSymbol(";")
+	if p.peek().kind != tokenEOF {
+		p.fail("unexpected input")
+	}
+	if len(query.clauses) == 0 {
+		p.fail("empty query")
+	}
+	return query
+}
+
+func (p *parser) parseClause() any {
+	switch {
+	case p.acceptKeyword("OPTIONAL", "MATCH"):
+		return p.parseMatch(true)
+	case p.acceptKeyword("MATCH"):
+		return p.parseMatch(false)
+	case p.acceptKeyword("UNWIND"):
+		clause := &unwindClause{list: p.parseExpression()}
+		p.expectKeyword("AS")
+		clause.variable = p.expectIdentifier()
+		return clause
+	case p.acceptKeyword("WITH"):
+		clause := &withClause{projection: p.parseProjection()}
+		if p.acceptKeyword("WHERE") {
+			clause.where = p.parseExpression()
+		}
+		return clause
+	case p.acceptKeyword("RETURN"):
+		return &returnClause{projection: p.parseProjection()}
+	case p.acceptKeyword("SET"):
+		return &setClause{items: p.parseSetItems()}
+	case p.acceptKeyword("REMOVE"):
+		return &removeClause{items: p.parseRemoveItems()}
+	case p.acceptKeyword("MERGE"):
+		clause := &mergeClause{pattern: p.parsePattern()}
+		for p.acceptKeyword("ON") {
+			if p.acceptKeyword("CREATE") {
+				p.expectKeyword("SET")
+				clause.onCreate = append(clause.onCreate, p.parseSetItems()...)
+			} else {
+				p.expectKeyword("MATCH")
+				p.expectKeyword("SET")
+				clause.onMatch = append(clause.onMatch, p.parseSetItems()...)
+			}
+		}
+		return clause
+	case p.acceptKeyword("CREATE"):
+		return &createClause{patterns: p.parsePatterns()}
+	case p.acceptKeyword("DETACH", "DELETE"):
+		return &deleteClause{detach: true, expressions: p.parseExpressionList()}
+	case p.acceptKeyword("DELETE"):
+		return &deleteClause{expressions: p.parseExpressionList()}
+	}
+	p.fail("unsupported clause")
+	return nil
+}
+
+func (p *parser) parseMatch(optional bool) *matchClause {
+	clause := &matchClause{optional: optional, patterns: p.parsePatterns()}
+	if p.acceptKeyword("WHERE") {
+		clause.where = p.parseExpression()
+	}
+	return clause
+}
+
+func (p *parser) parseExpressionList() []expression {
+	expressions := []expression{p.parseExpression()}
+	for p.acceptSymbol(",") {
+		expressions = append(expressions, p.parseExpression())
+	}
+	return expressions
+}
+
+func (p *parser) parseProjection() *projection {
+	result := &projection{distinct: p.acceptKeyword("DISTINCT")}
+	if p.acceptSymbol("*") {
+		result.all = true
+	} else {
+		for {
+			item := projectionItem{expression: p.parseExpression()}
+			if p.acceptKeyword("AS") {
+				item.name = p.expectIdentifier()
+			} else if v, ok := item.expression.(*variable); ok {
+				item.name = v.name
+			}
+			result.items = append(result.items, item)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+
+	if p.acceptKeyword("ORDER", "BY") {
+		for {
+			item := sortItem{expression: p.parseExpression()}
+			if p.acceptKeyword("DESC") || p.acceptKeyword("DESCENDING") {
+				item.descending = true
+			} else if !p.acceptKeyword("ASC") {
+				p.acceptKeyword("ASCENDING")
+			}
+			result.orderBy = append(result.orderBy, item)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+	if p.acceptKeyword("SKIP") {
+		result.skip = p.parseExpression()
+	}
+	if p.acceptKeyword("LIMIT") {
+		result.limit = p.parseExpression()
+	}
+	return result
+}
+
+func (p *parser) parseSetItems() []setItem {
+	items := []setItem{}
+	for {
+		item := setItem{variable: p.expectIdentifier()}
+		switch {
+		case p.acceptSymbol("."):
+			item.kind = setProperty
+			item.property = p.expectIdentifier()
+			p.expectSymbol("=")
+			item.value = p.parseExpression()
+		case p.acceptSymbol("="):
+			item.kind = setReplace
+			item.value = p.parseExpression()
+		case p.acceptSymbol("+="):
+			item.kind = setMerge
+			item.value = p.parseExpression()
+		case p.isSymbol(":"):
+			item.kind = setLabels
+			for p.acceptSymbol(":") {
+				item.labels = append(item.labels, p.expectIdentifier())
+			}
+		default:
+			p.fail("invalid SET item")
+		}
+		items = append(items, item)
+		if !p.acceptSymbol(",") {
+			return items
+		}
+	}
+}
+
+func (p *parser) parseRemoveItems() []setItem {
+	items := []setItem{}
+	for {
+		item := setItem{variable: p.expectIdentifier()}
+		if p.acceptSymbol(".") {
+			item.kind = setProperty
+			item.property = p.expectIdentifier()
+		} else {
+			item.kind = setLabels
+			for p.acceptSymbol(":") {
+				item.labels = append(item.labels, p.expectIdentifier())
+			}
+			if len(item.labels) == 0 {
+				p.fail("invalid REMOVE item")
+			}
+		}
+		items = append(items, item)
+		if !p.acceptSymbol(",") {
+			return items
+		}
+	}
+}
+
+func (p *parser) parsePatterns() []*pattern {
+	patterns := []*pattern{p.parsePattern()}
+	for p.acceptSymbol(",") {
+		patterns = append(patterns, p.parsePattern())
+	}
+	return patterns
+}
+
+func (p *parser) parsePattern() *pattern {
+	result := &pattern{}
+	if p.peek().kind == tokenIdentifier && p.peekAt(1).kind == tokenSymbol && p.peekAt(1).text == "=" {
+		result.variable = p.expectIdentifier()
+		p.expectSymbol("=")
+	}
+	result.nodes = append(result.nodes, p.parseNodePattern())
+	p.parsePatternChain(result)
+	return result
+}
+
+// Whether a relationship starts at the current token
+func (p *parser) isRelationshipStart() bool {
+	if p.isSymbol("<") {
+		return p.peekAt(1).kind == tokenSymbol && p.peekAt(1).text == "-"
+	}
+	if !p.isSymbol("-") {
+		return false
+	}
+	following := p.peekAt(1)
+	return following.kind == tokenSymbol && (following.text == "[" || following.text == "-" || following.text == ">")
+}
+
+func (p *parser) parsePatternChain(result *pattern) {
+	for p.isRelationshipStart() {
+		result.relationships = append(result.relationships, p.parseRelationshipPattern())
+		result.nodes = append(result.nodes, p.parseNodePattern())
+	}
+}
+
+func (p *parser) parseNodePattern() *nodePattern {
+	p.expectSymbol("(")
+	node := &nodePattern{}
+	if p.peek().kind == tokenIdentifier {
+		node.variable = p.expectIdentifier()
+	}
+	for p.acceptSymbol(":") {
+		group := []string{p.expectIdentifier()}
+		for p.acceptSymbol("|") {
+			p.acceptSymbol(":")
+			group = append(group, p.expectIdentifier())
+		}
+		node.labels = append(node.labels, group)
+	}
+	if p.isSymbol("{") {
+		node.properties = p.parseMapLiteral()
+	} else if p.peek().kind == tokenParameter {
+		node.properties = &parameter{name: p.next().text}
+	}
+	p.expectSymbol(")")
+	return node
+}
+
+func (p *parser) parseRelationshipPattern() *relationshipPattern {
+	relationship := &relationshipPattern{}
+	inbound := p.acceptSymbol("<")
+	p.expectSymbol("-")
+
+	if p.acceptSymbol("[") {
+		if p.peek().kind == tokenIdentifier {
+			relationship.variable = p.expectIdentifier()
+		}
+		if p.acceptSymbol(":") {
+			relationship.kinds = append(relationship.kinds, p.expectIdentifier())
+			for p.acceptSymbol("|") {
+				p.acceptSymbol(":")
+				relationship.kinds = append(relationship.kinds, p.expectIdentifier())
+			}
+		}
+		if p.acceptSymbol("*") {
+			relationship.variableLength = true
+			relationship.minHops = 1
+			relationship.maxHops = -1
+			if p.peek().kind == tokenNumber {
+				relationship.minHops = p.parseHops()
+				relationship.maxHops = relationship.minHops
+			}
+			if p.acceptSymbol("..") {
+				relationship.maxHops = -1
+				if p.peek().kind == tokenNumber {
+					relationship.maxHops = p.parseHops()
+				}
+			}
+		}
+		if p.isSymbol("{") {
+			relationship.properties = p.parseMapLiteral()
+		} else if p.peek().kind == tokenParameter {
+			relationship.properties = &parameter{name: p.next().text}
+		}
+		p.expectSymbol("]")
+	}
+
+	p.expectSymbol("-")
+	outbound := p.acceptSymbol(">")
+	switch {
+	case inbound && outbound:
+		p.fail("relationship with two directions")
+	case inbound:
+		relationship.direction = directionInbound
+	case outbound:
+		relationship.direction = directionOutbound
+	}
+	return relationship
+}
+
+func (p *parser) parseHops() int {
+	hops, ok := p.next().value.(int64)
+	if !ok {
+		p.fail("invalid length")
+	}
+	return int(hops)
+}
+
+func (p *parser) parseMapLiteral() *mapLiteral {
+	p.expectSymbol("{")
+	result := &mapLiteral{}
+	if !p.acceptSymbol("}") {
+		for {
+			key := p.next()
+			if key.kind != tokenIdentifier && key.kind != tokenString {
+				p.fail("expected a key")
+			}
+			p.expectSymbol(":")
+			result.keys = append(result.keys, key.text)
+			result.values = append(result.values, p.parseExpression())
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+		p.expectSymbol("}")
+	}
+	return result
+}
+
+func (p *parser) parseExpression() expression {
+	return p.parseOr()
+}
+
+func (p *parser) parseOr() expression {
+	left := p.parseXor()
+	for p.acceptKeyword("OR") {
+		left = &binaryOperation{operator: "or", left: left, right: p.parseXor()}
+	}
+	return left
+}
+
+func (p *parser) parseXor() expression {
+	left := p.parseAnd()
+	for p.acceptKeyword("XOR") {
+		left = &binaryOperation{operator: "xor", left: left, right: p.parseAnd()}
+	}
+	return left
+}
+
+func (p *parser) parseAnd() expression {
+	left := p.parseNot()
+	for p.acceptKeyword("AND") {
+		left = &binaryOperation{operator: "and", left: left, right: p.parseNot()}
+	}
+	return left
+}
+
+func (p *parser) parseNot() expression {
+	if p.acceptKeyword("NOT") {
+		return &unaryOperation{operator: "not", operand: p.parseNot()}
+	}
+	return p.parseComparison()
+}
+
+var comparisonSymbols = []string{"=", "<>", "<", ">", "<=", ">=", "=~"}
+
+func (p *parser) parseComparison() expression {
+	left := p.parseAdditive()
+	for {
+		matched := false
+		for _, symbol := range comparisonSymbols {
+			if p.acceptSymbol(symbol) {
+				left = &binaryOperation{operator: symbol, left: left, right: p.parseAdditive()}
+				matched = true
+				break
+			}
+		}
+		switch {
+		case matched:
+		case p.acceptKeyword("IS", "NOT", "NULL"):
+			left = &unaryOperation{operator: "is not null", operand: left}
+		case p.acceptKeyword("IS", "NULL"):
+			left = &unaryOperation{operator: "is null", operand: left}
+		case p.acceptKeyword("STARTS", "WITH"):
+			left = &binaryOperation{operator: "starts with", left: left, right: p.parseAdditive()}
+		case p.acceptKeyword("ENDS", "WITH"):
+			left = &binaryOperation{operator: "ends with", left: left, right: p.parseAdditive()}
+		case p.acceptKeyword("CONTAINS"):
+			left = &binaryOperation{operator: "contains", left: left, right: p.parseAdditive()}
+		case p.acceptKeyword("IN"):
+			left = &binaryOperation{operator: "in", left: left, right: p.parseAdditive()}
+		default:
+			return left
+		}
+	}
+}
+
+func (p *parser) parseAdditive() expression {
+	left := p.parseMultiplicative()
+	for p.isSymbol("+") || p.isSymbol("-") {
+		operator := p.next().text
+		left = &binaryOperation{operator: operator, left: left, right: p.parseMultiplicative()}
+	}
+	return left
+}
+
+func (p *parser) parseMultiplicative() expression {
+	left := p.parseUnary()
+	for p.isSymbol("*") || p.isSymbol("/") || p.isSymbol("%") {
+		operator := p.next().text
+		left = &binaryOperation{operator: operator, left: left, right: p.parseUnary()}
+	}
+	return left
+}
+
+func (p *parser) parseUnary() expression {
+	if p.acceptSymbol("-") {
+		return &unaryOperation{operator: "-", operand: p.parseUnary()}
+	}
+	if p.acceptSymbol("+") {
+		return p.parseUnary()
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() expression {
+	subject := p.parseAtom()
+	for {
+		switch {
+		case p.isSymbol(".") && p.peekAt(1).kind == tokenIdentifier:
+			p.next()
+			subject = &propertyAccess{subject: subject, property: p.expectIdentifier()}
+		case p.acceptSymbol("["):
+			subject = &indexAccess{subject: subject, index: p.parseExpression()}
+			p.expectSymbol("]")
+		case p.isSymbol(":") && p.peekAt(1).kind == tokenIdentifier:
+			check := &labelCheck{subject: subject}
+			for p.acceptSymbol(":") {
+				check.labels = append(check.labels, p.expectIdentifier())
+			}
+			subject = check
+		default:
+			return subject
+		}
+	}
+}
+
+var listPredicateKinds = []string{"all", "any", "none", "single"}
+
+func (p *parser) parseAtom() expression {
+	current := p.peek()
+	switch current.kind {
+	case tokenNumber:
+		p.next()
+		return &literal{value: current.value}
+	case tokenString:
+		p.next()
+		return &literal{value: current.text}
+	case tokenParameter:
+		p.next()
+		return &parameter{name: current.text}
+	case tokenSymbol:
+		switch current.text {
+		case "(":
+			if predicate := p.tryPatternPredicate(); predicate != nil {
+				return predicate
+			}
+			p.next()
+			inner := p.parseExpression()
+			p.expectSymbol(")")
+			return inner
+		case "[":
+			p.next()
+			list := &listLiteral{}
+			if !p.acceptSymbol("]") {
+				list.items = p.parseExpressionList()
+				p.expectSymbol("]")
+			}
+			return list
+		case "{":
+			return p.parseMapLiteral()
+		}
+	case tokenIdentifier:
+		if !current.quoted {
+			switch {
+			case p.acceptKeyword("TRUE"):
+				return &literal{value: true}
+			case p.acceptKeyword("FALSE"):
+				return &literal{value: false}
+			case p.acceptKeyword("NULL"):
+				return &literal{value: nil}
+			}
+			for _, kind := range listPredicateKinds {
+				if isKeyword(current, kind) && p.peekAt(1).kind == tokenSymbol && p.peekAt(1).text == "(" &&
+					p.peekAt(2).kind == tokenIdentifier && isKeyword(p.peekAt(3), "IN") {
+					return p.parseListPredicate(kind)
+				}
+			}
+		}
+		if name, ok := p.functionName(); ok {
+			return p.parseFunctionCall(name)
+		}
+		p.next()
+		return &variable{name: current.text}
+	}
+	p.fail("unexpected %q", current.text)
+	return nil
+}
+
+// The name of the function called at the current token, such as
+// apoc.text.regexGroups. Names are case insensitive.
+func (p *parser) functionName() (string, bool) {
+	parts := []string{}
+	offset := 0
+	for {
+		part := p.peekAt(offset)
+		if part.kind != tokenIdentifier {
+			return "", false
+		}
+		parts = append(parts, strings.ToLower(part.text))
+		following := p.peekAt(offset + 1)
+		if following.kind == tokenSymbol && following.text == "(" {
+			return strings.Join(parts, "."), true
+		}
+		if following.kind != tokenSymbol || following.text != "." {
+			return "", false
+		}
+		offset += 2
+	}
+}
+
+func (p *parser) parseFunctionCall(name string) expression {
+	for p.next().text != "(" {
+	}
+	call := &functionCall{name: name}
+	if p.acceptSymbol("*") {
+		call.all = true
+		p.expectSymbol(")")
+		return call
+	}
+	call.distinct = p.acceptKeyword("DISTINCT")
+	if !p.acceptSymbol(")") {
+		call.arguments = p.parseExpressionList()
+		p.expectSymbol(")")
+	}
+	return call
+}
+
+func (p *parser) parseListPredicate(kind string) expression {
+	p.next()
+	p.expectSymbol("(")
+	predicate := &listPredicate{kind: kind, variable: p.expectIdentifier()}
+	p.expectKeyword("IN")
+	predicate.list = p.parseExpression()
+	p.expectKeyword("WHERE")
+	predicate.predicate = p.parseExpression()
+	p.expectSymbol(")")
+	return predicate
+}
+
+// Parse a pattern used as a predicate, like (a)-[:R]->(b). Nothing is
+// consumed when the parenthesis starts an expression instead.
+func (p *parser) tryPatternPredicate() (predicate expression) {
+	start := p.pos
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			if _, ok := recovered.(syntaxError); !ok {
+				panic(recovered)
+			}
+			p.pos = start
+			predicate = nil
+		}
+	}()
+
+	node := p.parseNodePattern()
+	if !p.isRelationshipStart() {
+		p.pos = start
+		return nil
+	}
+	result := &pattern{nodes: []*nodePattern{node}}
+	p.parsePatternChain(result)
+	return &patternPredicate{pattern: result}
+}
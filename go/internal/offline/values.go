@@ -0,0 +1,398 @@
+package offline
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j/dbtype"
+	"github.com/specterops/bloodhound/dawgs/graph"
+)
+
+// Query values are nil, bool, int64, float64, string, []any,
+// map[string]any, nodes, relationships and paths. Go values from
+// parameters and properties are converted to these.
+
+type pathValue struct {
+	nodes         []*storedNode
+	relationships []*storedRelationship
+}
+
+func normalize(value any) any {
+	switch typed := value.(type) {
+	case nil, bool, int64, float64, string, *storedNode, *storedRelationship, *pathValue:
+		return typed
+	case int:
+		return int64(typed)
+	case int8:
+		return int64(typed)
+	case int16:
+		return int64(typed)
+	case int32:
+		return int64(typed)
+	case uint8:
+		return int64(typed)
+	case uint16:
+		return int64(typed)
+	case uint32:
+		return int64(typed)
+	case uint64:
+		return int64(typed)
+	case uint:
+		return int64(typed)
+	case graph.ID:
+		return int64(typed)
+	case float32:
+		return float64(typed)
+	case graph.Kind:
+		return typed.String()
+	case []any:
+		items := make([]any, len(typed))
+		for i, item := range typed {
+			items[i] = normalize(item)
+		}
+		return items
+	case map[string]any:
+		items := make(map[string]any, len(typed))
+		for key, item := range typed {
+			items[key] = normalize(item)
+		}
+		return items
+	}
+
+	reflected := reflect.ValueOf(value)
+	switch reflected.Kind() {
+	case reflect.Slice, reflect.Array:
+		items := make([]any, reflected.Len())
+		for i := range items {
+			items[i] = normalize(reflected.Index(i).Interface())
+		}
+		return items
+	case reflect.Map:
+		if reflected.Type().Key().Kind() == reflect.String {
+			items := make(map[string]any, reflected.Len())
+			iterator := reflected.MapRange()
+			for iterator.Next() {
+				items[iterator.Key().String()] = normalize(iterator.Value().Interface())
+			}
+			return items
+		}
+	case reflect.String:
+		return reflected.String()
+	}
+	return value
+}
+
+// The value as the Neo4j driver returns it
+func toDriverValue(value any) any {
+	switch typed := value.(type) {
+	case *storedNode:
+		return toDriverNode(typed)
+	case *storedRelationship:
+		return toDriverRelationship(typed)
+	case *pathValue:
+		path := dbtype.Path{}
+		for _, node := range typed.nodes {
+			path.Nodes = append(path.Nodes, toDriverNode(node))
+		}
+		for _, relationship := range typed.relationships {
+			path.Relationships = append(path.Relationships, toDriverRelationship(relationship))
+		}
+		return path
+	case []any:
+		items := make([]any, len(typed))
+		for i, item := range typed {
+			items[i] = toDriverValue(item)
+		}
+		return items
+	case map[string]any:
+		items := make(map[string]any, len(typed))
+		for key, item := range typed {
+			items[key] = toDriverValue(item)
+		}
+		return items
+	}
+	return value
+}
+
+func isNumber(value any) bool {
+	switch value.(type) {
+	case int64, float64:
+		return true
+	}
+	return false
+}
+
+func toFloat(value any) float64 {
+	switch typed := value.(type) {
+	case int64:
+		return float64(typed)
+	case float64:
+		return typed
+	}
+	return math.NaN()
+}
+
+// Cypher equality, which is null when either side is null
+func equals(left any, right any) any {
+	if left == nil || right == nil {
+		return nil
+	}
+	if isNumber(left) && isNumber(right) {
+		if leftInt, ok := left.(int64); ok {
+			if rightInt, ok := right.(int64); ok {
+				return leftInt == rightInt
+			}
+		}
+		return toFloat(left) == toFloat(right)
+	}
+
+	switch typed := left.(type) {
+	case bool, string:
+		return left == right
+	case *storedNode:
+		other, ok := right.(*storedNode)
+		return ok && other.id == typed.id
+	case *storedRelationship:
+		other, ok := right.(*storedRelationship)
+		return ok && other.id == typed.id
+	case *pathValue:
+		other, ok := right.(*pathValue)
+		if !ok || len(other.nodes) != len(typed.nodes) || len(other.relationships) != len(typed.relationships) {
+			return false
+		}
+		for i := range typed.nodes {
+			if typed.nodes[i].id != other.nodes[i].id {
+				return false
+			}
+		}
+		for i := range typed.relationships {
+			if typed.relationships[i].id != other.relationships[i].id {
+				return false
+			}
+		}
+		return true
+	case []any:
+		other, ok := right.([]any)
+		if !ok || len(other) != len(typed) {
+			return false
+		}
+		var result any = true
+		for i := range typed {
+			result = and(result, equals(typed[i], other[i]))
+		}
+		return result
+	case map[string]any:
+		other, ok := right.(map[string]any)
+		if !ok || len(other) != len(typed) {
+			return false
+		}
+		var result any = true
+		for key, item := range typed {
+			otherItem, ok := other[key]
+			if !ok {
+				return false
+			}
+			result = and(result, equals(item, otherItem))
+		}
+		return result
+	}
+	return false
+}
+
+// Compare two values with <, which is null for values of different types
+func compare(left any, right any) (int, bool) {
+	if isNumber(left) && isNumber(right) {
+		leftFloat, rightFloat := toFloat(left), toFloat(right)
+		switch {
+		case leftFloat < rightFloat:
+			return -1, true
+		case leftFloat > rightFloat:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch typed := left.(type) {
+	case string:
+		if other, ok := right.(string); ok {
+			return strings.Compare(typed, other), true
+		}
+	case bool:
+		if other, ok := right.(bool); ok {
+			switch {
+			case typed == other:
+				return 0, true
+			case !typed:
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+// The order of ORDER BY, which orders values of every type. Nulls come
+// last.
+func orderValues(left any, right any) int {
+	rank := func(value any) int {
+		switch value.(type) {
+		case map[string]any:
+			return 0
+		case *storedNode:
+			return 1
+		case *storedRelationship:
+			return 2
+		case []any:
+			return 3
+		case *pathValue:
+			return 4
+		case string:
+			return 5
+		case bool:
+			return 6
+		case int64, float64:
+			return 7
+		case nil:
+			return 9
+		}
+		return 8
+	}
+
+	if leftRank, rightRank := rank(left), rank(right); leftRank != rightRank {
+		return leftRank - rightRank
+	}
+	if result, ok := compare(left, right); ok {
+		return result
+	}
+	switch typed := left.(type) {
+	case *storedNode:
+		return int(typed.id - right.(*storedNode).id)
+	case *storedRelationship:
+		return int(typed.id - right.(*storedRelationship).id)
+	case []any:
+		other := right.([]any)
+		for i := 0; i < len(typed) && i < len(other); i++ {
+			if result := orderValues(typed[i], other[i]); result != 0 {
+				return result
+			}
+		}
+		return len(typed) - len(other)
+	}
+	return strings.Compare(valueKey(left), valueKey(right))
+}
+
+// A key that is the same for equal values, for DISTINCT and grouping
+func valueKey(value any) string {
+	switch typed := value.(type) {
+	case nil:
+		return "null"
+	case *storedNode:
+		return fmt.Sprintf("node:%d", typed.id)
+	case *storedRelationship:
+		return fmt.Sprintf("relationship:%d", typed.id)
+	case *pathValue:
+		builder := strings.Builder{}
+		builder.WriteString("path:")
+		for _, node := range typed.nodes {
+			fmt.Fprintf(&builder, "%d,", node.id)
+		}
+		builder.WriteString(";")
+		for _, relationship := range typed.relationships {
+			fmt.Fprintf(&builder, "%d,", relationship.id)
+		}
+		return builder.String()
+	case string:
+		return fmt.Sprintf("string:%q", typed)
+	case int64:
+		return fmt.Sprintf("number:%d", typed)
+	case float64:
+		if typed == math.Trunc(typed) && math.Abs(typed) < 1e18 {
+			return fmt.Sprintf("number:%d", int64(typed))
+		}
+		return fmt.Sprintf("number:%g", typed)
+	case bool:
+		return fmt.Sprintf("bool:%t", typed)
+	case []any:
+		keys := []string{}
+		for _, item := range typed {
+			keys = append(keys, valueKey(item))
+		}
+		return "list:[" + strings.Join(keys, ",") + "]"
+	case map[string]any:
+		names := []string{}
+		for name := range typed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		keys := []string{}
+		for _, name := range names {
+			keys = append(keys, fmt.Sprintf("%q:%s", name, valueKey(typed[name])))
+		}
+		return "map:{" + strings.Join(keys, ",") + "}"
+	}
+	return fmt.Sprintf("%T:%v", value, value)
+}
+
+// Three valued logic, where null is unknown
+func and(left any, right any) any {
+	if left == false || right == false {
+		return false
+	}
+	if left == nil || right == nil {
+		return nil
+	}
+	return true
+}
+
+func or(left any, right any) any {
+	if left == true || right == true {
+		return true
+	}
+	if left == nil || right == nil {
+		return nil
+	}
+	return false
+}
+
+func not(value any) any {
+	if value == nil {
+		return nil
+	}
+	return value != true
+}
+
+func toBool(value any) (any, error) {
+	switch value.(type) {
+	case nil, bool:
+		return value, nil
+	}
+	return nil, fmt.Errorf("expected a boolean, got %s", typeName(value))
+}
+
+func typeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case int64:
+		return "integer"
+	case float64:
+		return "float"
+	case string:
+		return "string"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "map"
+	case *storedNode:
+		return "node"
+	case *storedRelationship:
+		return "relationship"
+	case *pathValue:
+		return "path"
+	}
+	return fmt.Sprintf("%T", value)
+}
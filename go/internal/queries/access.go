@@ -0,0 +1,104 @@
+package queries
+
+import (
+	"context"
+	"log"
+
+	"github.com/hotnops/apeman/analyze"
+	"github.com/specterops/bloodhound/dawgs/graph"
+)
+
+// Get every identity policy path from a user or role to a resource whose
+// ARN matches resourceRegex, for each action matching actionRegex that
+// acts on that resource. Policies inherited from groups are included.
+func GetAllUnresolvedIdentityPolicyPathsMatching(ctx context.Context, db graph.Database, actionRegex string, resourceRegex string) (*analyze.ActionPathSet, error) {
+	params := map[string]any{
+		"actionRegex":   actionRegex,
+		"resourceRegex": resourceRegex,
+	}
+
+	query := "MATCH (b:UniqueArn) WHERE b.arn =~ $resourceRegex AND " + collectionFilter(ctx, "b", params) + " " +
+		"MATCH (act:AWSAction) - [:ActsOn] -> (:AWSResourceType) <- [:TypeOf] - (b) WHERE act.name =~ $actionRegex " +
+		"WITH DISTINCT b, act " +
+		"MATCH (a:AWSUser|AWSRole) WHERE (a.account_id = b.account_id OR b.account_id = '') AND " + collectionFilter(ctx, "a", params) + " " +
+		"MATCH (a) - [:MemberOf*0..1] -> (:AWSUser|AWSRole|AWSGroup) <- [:AttachedTo*3..4] - (s:AWSStatement) - [:Resource|ExpandsTo*1..2] -> (b) " +
+		"MATCH (s) - [:Action|ExpandsTo*1..2] -> (act) " +
+		"OPTIONAL MATCH (s) <- [:AttachedTo] - (c:AWSCondition) " +
+		"RETURN DISTINCT a, b, s, act.name, COALESCE(c IS NOT NULL, false)"
+
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	actionPathSet := analyze.ActionPathSet{}
+	for _, result := range results {
+		newActionPathEntry := analyze.ActionPathEntry{}
+		var sourceNode graph.Node
+		var destNode graph.Node
+		var statement graph.Node
+		var action string
+		var conditionExists bool
+		err = result.Map(&sourceNode)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&destNode)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&statement)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&action)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&conditionExists)
+		if err != nil {
+			continue
+		}
+
+		effect, _ := statement.Properties.Get("effect").String()
+
+		if conditionExists {
+			conditions, err := GetConditionsFromStatement(ctx, db, statement.ID)
+			if err != nil {
+				log.Printf("[!] Error getting conditions: %s", err.Error())
+				continue
+			}
+			newActionPathEntry.Conditions = conditions
+		}
+
+		newActionPathEntry.PrincipalID = sourceNode.ID
+		sourceArn, _ := sourceNode.Properties.Get("arn").String()
+		newActionPathEntry.PrincipalArn = sourceArn
+		newActionPathEntry.ResourceID = destNode.ID
+		destArn, _ := destNode.Properties.Get("arn").String()
+		newActionPathEntry.ResourceArn = destArn
+		newActionPathEntry.Effect = effect
+		newActionPathEntry.Action = action
+		newActionPathEntry.Statement = &statement
+		if conditionExists {
+			PopulateTags(ctx, db, &newActionPathEntry)
+		}
+		actionPathSet.Add(newActionPathEntry)
+	}
+
+	return &actionPathSet, nil
+}
+
+// Get the resolved paths for every principal that can perform an action
+// matching actionPattern on a resource matching resourcePattern. Both
+// patterns use IAM wildcard semantics.
+func GetEffectiveAccess(ctx context.Context, db graph.Database, actionPattern string, resourcePattern string) (*analyze.ActionPathSet, error) {
+	identityPaths, err := GetAllUnresolvedIdentityPolicyPathsMatching(ctx, db,
+		analyze.ActionWildcardToRegex(actionPattern),
+		analyze.WildcardToRegex(resourcePattern))
+	if err != nil {
+		return nil, err
+	}
+
+	return analyze.ResolveResourceAgainstIdentityPolicies(&analyze.ActionPathSet{}, identityPaths)
+}
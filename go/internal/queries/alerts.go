@@ -0,0 +1,43 @@
+package queries
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/hotnops/apeman/analyze"
+	"github.com/specterops/bloodhound/dawgs/graph"
+)
+
+// Compare the current graph against the previous analysis run, saved in
+// stateDir, and pass the alert to deliver if there are new escalation
+// paths. The current graph only replaces the previous run once deliver
+// succeeds, so the paths of a failed delivery are alerted on again by the
+// next run. The first run has nothing to compare against and returns an
+// empty alert.
+func GetEscalationAlert(ctx context.Context, db graph.Database, stateDir string, deliver func(analyze.EscalationAlert) error) (analyze.EscalationAlert, error) {
+	current, err := CaptureSnapshot(ctx, db, analyze.LastAnalysisSnapshotName)
+	if err != nil {
+		return analyze.EscalationAlert{}, err
+	}
+
+	previous, err := analyze.LoadSnapshot(stateDir, analyze.LastAnalysisSnapshotName)
+	if errors.Is(err, os.ErrNotExist) {
+		previous = current
+	} else if err != nil {
+		return analyze.EscalationAlert{}, err
+	}
+
+	alert := analyze.GetEscalationAlert(previous, current)
+	if !alert.IsEmpty() {
+		if err := deliver(alert); err != nil {
+			return alert, err
+		}
+	}
+
+	if err := analyze.SaveSnapshot(stateDir, current); err != nil {
+		return analyze.EscalationAlert{}, err
+	}
+
+	return alert, nil
+}
@@ -0,0 +1,108 @@
+package queries
+
+import (
+	"context"
+
+	"github.com/hotnops/apeman/analyze"
+	"github.com/specterops/bloodhound/dawgs/graph"
+)
+
+// Get the identity transform edges of the collection in ctx, along with
+// the nodes at either end of them
+func getIdentityTransformGraph(ctx context.Context, db graph.Database) (map[graph.ID]*graph.Node, []*graph.Relationship, error) {
+	params := map[string]any{}
+	query := "MATCH p=(a) - [:IdentityTransform] -> (b) WHERE " + collectionFilter(ctx, "a", params) + " RETURN p"
+
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nodes := map[graph.ID]*graph.Node{}
+	edges := []*graph.Relationship{}
+	for _, result := range results {
+		var path graph.Path
+		err = result.Map(&path)
+		if err != nil {
+			continue
+		}
+		for _, node := range path.Nodes {
+			nodes[node.ID] = node
+		}
+		edges = append(edges, path.Edges...)
+	}
+
+	return nodes, edges, nil
+}
+
+// Get the shortest identity transform path from a node to every node it
+// can reach. If target kinds are given, only paths ending in one of them
+// are returned.
+func GetOutboundIdentityPaths(ctx context.Context, db graph.Database, nodeID graph.ID, targetKinds ...graph.Kind) (graph.PathSet, error) {
+	nodes, edges, err := getIdentityTransformGraph(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	pathSet := graph.NewPathSet()
+	for _, path := range analyze.GetTransformTargetPaths(nodes, edges, nodeID) {
+		if len(targetKinds) > 0 && !path.Terminal().Kinds.ContainsOneOf(targetKinds...) {
+			continue
+		}
+		pathSet.AddPath(path)
+	}
+
+	return pathSet, nil
+}
+
+// Get everything a principal can do, directly or by becoming any principal
+// it has an identity transform path to
+func GetBlastRadius(ctx context.Context, db graph.Database, principalNode *graph.Node) (*analyze.BlastRadius, error) {
+	identityPaths, err := GetOutboundIdentityPaths(ctx, db, principalNode.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	principals := map[graph.ID]*graph.Node{principalNode.ID: principalNode}
+	chains := map[graph.ID][]string{principalNode.ID: analyze.GetChainFromPath(graph.Path{Nodes: []*graph.Node{principalNode}})}
+	for _, path := range identityPaths {
+		target := path.Terminal()
+		principals[target.ID] = target
+		chains[target.ID] = analyze.GetChainFromPath(path)
+	}
+
+	blastRadius := analyze.NewBlastRadius()
+	for principalID, principal := range principals {
+		paths, err := GetUnresolvedOutputPaths(ctx, db, principal)
+		if err != nil {
+			return nil, err
+		}
+
+		resolvedPaths, err := analyze.ResolveResourceAgainstIdentityPolicies(&analyze.ActionPathSet{}, &paths)
+		if err != nil {
+			return nil, err
+		}
+
+		blastRadius.AddResolvedPaths(chains[principalID], resolvedPaths)
+	}
+	blastRadius.Finalize()
+
+	return blastRadius, nil
+}
+
+// Get the shortest identity transform path to each of the given nodes
+// from every node that can reach it. A node that reaches several of them
+// has a path to each.
+func GetInboundIdentityPaths(ctx context.Context, db graph.Database, nodeIDs []graph.ID) (graph.PathSet, error) {
+	nodes, edges, err := getIdentityTransformGraph(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	pathSet := graph.NewPathSet()
+	for _, nodeID := range nodeIDs {
+		pathSet = append(pathSet, analyze.GetTransformSourcePathsTo(nodes, edges, nodeID)...)
+	}
+
+	return pathSet, nil
+}
@@ -0,0 +1,248 @@
+package queries
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hotnops/apeman/analyze"
+	"github.com/hotnops/apeman/graphschema/aws"
+	"github.com/specterops/bloodhound/dawgs/graph"
+)
+
+// The number of activity entries stored in one transaction
+const activityBatchSize = 1000
+
+// Record CloudTrail activity as UsedAction edges from users and roles to
+// the actions they used. Activity is merged into existing edges, keeping
+// the latest use and the most recent source IPs, so logs can be ingested
+// in any order. The activity is split into what was stored and what
+// wasn't, because its principal or action isn't in the graph.
+func StoreActionActivity(ctx context.Context, db graph.Database, activity []analyze.ActionActivity) ([]analyze.ActionActivity, []analyze.ActionActivity, error) {
+	params := map[string]any{}
+	query := fmt.Sprintf("MATCH (p:UniqueArn {arn: $principalArn}) WHERE (p:AWSUser OR p:AWSRole) AND %s "+
+		"MATCH (a:AWSAction {name: $action}) "+
+		"MERGE (p) - [u:%s] -> (a) "+
+		"SET u.layer = 2, "+
+		"u.%s = CASE WHEN u.%s IS NULL OR u.%s < $lastUsed THEN $lastUsed ELSE u.%s END, "+
+		"u.%s = ($sourceIps + [ip IN COALESCE(u.%s, []) WHERE NOT ip IN $sourceIps])[..$maxSourceIps] "+
+		"RETURN ID(u)",
+		collectionFilter(ctx, "p", params), aws.UsedAction,
+		aws.LastUsed, aws.LastUsed, aws.LastUsed, aws.LastUsed,
+		aws.SourceIPs, aws.SourceIPs)
+
+	stored := []analyze.ActionActivity{}
+	unmatched := []analyze.ActionActivity{}
+	for start := 0; start < len(activity); start += activityBatchSize {
+		end := start + activityBatchSize
+		if end > len(activity) {
+			end = len(activity)
+		}
+		batchStored := []analyze.ActionActivity{}
+		batchUnmatched := []analyze.ActionActivity{}
+		err := db.WriteTransaction(ctx, func(tx graph.Transaction) error {
+			for _, entry := range activity[start:end] {
+				entryParams := map[string]any{
+					"principalArn": entry.PrincipalArn,
+					"action":       entry.Action,
+					"lastUsed":     entry.LastUsed.UTC().Format(time.RFC3339),
+					"sourceIps":    entry.SourceIPs,
+					"maxSourceIps": analyze.MaxActivitySourceIPs,
+				}
+				for key, value := range params {
+					entryParams[key] = value
+				}
+
+				result := tx.Run(query, entryParams)
+				if result.Error() != nil {
+					return result.Error()
+				}
+				matched := result.Next()
+				result.Close()
+				if result.Error() != nil {
+					return result.Error()
+				}
+				if matched {
+					batchStored = append(batchStored, entry)
+				} else {
+					batchUnmatched = append(batchUnmatched, entry)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		stored = append(stored, batchStored...)
+		unmatched = append(unmatched, batchUnmatched...)
+	}
+
+	return stored, unmatched, nil
+}
+
+// Record the time span of the ingested CloudTrail logs for the collection
+// in ctx, widening any span recorded before. Activity without a collection
+// belongs to the default one.
+func StoreCloudTrailCoverage(ctx context.Context, db graph.Database, coverage analyze.CloudTrailCoverage) error {
+	if coverage.IsEmpty() {
+		return nil
+	}
+
+	collection, ok := GetCollection(ctx)
+	if !ok {
+		collection = DefaultCollection
+	}
+	params := map[string]any{
+		"collection": collection,
+		"firstEvent": coverage.FirstEvent.UTC().Format(time.RFC3339),
+		"lastEvent":  coverage.LastEvent.UTC().Format(time.RFC3339),
+	}
+	query := fmt.Sprintf("MERGE (c:%s {%s: $collection}) "+
+		"SET c.layer = 2, "+
+		"c.%s = CASE WHEN c.%s IS NULL OR c.%s > $firstEvent THEN $firstEvent ELSE c.%s END, "+
+		"c.%s = CASE WHEN c.%s IS NULL OR c.%s < $lastEvent THEN $lastEvent ELSE c.%s END",
+		aws.AWSCloudTrailCoverage, aws.Collection,
+		aws.FirstEvent, aws.FirstEvent, aws.FirstEvent, aws.FirstEvent,
+		aws.LastEvent, aws.LastEvent, aws.LastEvent, aws.LastEvent)
+
+	return RawCypherWrite(ctx, db, query, params)
+}
+
+// Get the time span of the CloudTrail logs ingested into the collection in
+// ctx. The span is empty when no logs were ingested.
+func GetCloudTrailCoverage(ctx context.Context, db graph.Database) (analyze.CloudTrailCoverage, error) {
+	params := map[string]any{}
+	query := fmt.Sprintf("MATCH (c:%s) WHERE %s RETURN COALESCE(c.%s, ''), COALESCE(c.%s, '')",
+		aws.AWSCloudTrailCoverage, collectionFilter(ctx, "c", params), aws.FirstEvent, aws.LastEvent)
+
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return analyze.CloudTrailCoverage{}, err
+	}
+
+	coverage := analyze.CloudTrailCoverage{}
+	for _, result := range results {
+		var firstEvent string
+		var lastEvent string
+		err = result.Map(&firstEvent)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&lastEvent)
+		if err != nil {
+			continue
+		}
+
+		firstEventTime, firstOk := analyze.ParseAWSDate(firstEvent)
+		lastEventTime, lastOk := analyze.ParseAWSDate(lastEvent)
+		if !firstOk || !lastOk {
+			continue
+		}
+		if coverage.IsEmpty() || firstEventTime.Before(coverage.FirstEvent) {
+			coverage.FirstEvent = firstEventTime
+		}
+		if coverage.IsEmpty() || lastEventTime.After(coverage.LastEvent) {
+			coverage.LastEvent = lastEventTime
+		}
+	}
+
+	return coverage, nil
+}
+
+// Read every CloudTrail log below dir and store the activity in the graph,
+// along with the time span the logs cover
+func IngestCloudTrailDirectory(ctx context.Context, db graph.Database, dir string) (analyze.CloudTrailIngest, error) {
+	activity, err := analyze.ReadCloudTrailDirectory(dir)
+	if err != nil {
+		return analyze.CloudTrailIngest{}, err
+	}
+
+	entries := activity.Entries()
+	stored, unmatched, err := StoreActionActivity(ctx, db, entries)
+	if err != nil {
+		return analyze.CloudTrailIngest{}, err
+	}
+	if len(unmatched) > 0 {
+		log.Printf("[!] %d of %d CloudTrail activity entries don't match a principal and action in the graph", len(unmatched), len(entries))
+	}
+
+	coverage := activity.Coverage()
+	if err := StoreCloudTrailCoverage(ctx, db, coverage); err != nil {
+		return analyze.CloudTrailIngest{}, err
+	}
+
+	return analyze.CloudTrailIngest{
+		Coverage:  coverage,
+		Stored:    stored,
+		Unmatched: unmatched,
+	}, nil
+}
+
+// Get the recorded activity of a principal, keyed by action
+func GetPrincipalActionActivity(ctx context.Context, db graph.Database, node *graph.Node) (map[string]analyze.ActionActivity, error) {
+	params := map[string]any{
+		"nodeId": node.ID,
+	}
+	query := fmt.Sprintf("MATCH (p) - [u:%s] -> (a:AWSAction) WHERE ID(p) = $nodeId "+
+		"RETURN a.name, u.%s, COALESCE(u.%s, [])", aws.UsedAction, aws.LastUsed, aws.SourceIPs)
+
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	principalArn, _ := node.Properties.Get("arn").String()
+	activity := map[string]analyze.ActionActivity{}
+	for _, result := range results {
+		var action string
+		var lastUsed string
+		var sourceIPs []string
+		err = result.Map(&action)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&lastUsed)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&sourceIPs)
+		if err != nil {
+			continue
+		}
+
+		lastUsedTime, ok := analyze.ParseAWSDate(lastUsed)
+		if !ok {
+			continue
+		}
+		activity[action] = analyze.ActionActivity{
+			PrincipalArn: principalArn,
+			Action:       action,
+			LastUsed:     lastUsedTime,
+			SourceIPs:    sourceIPs,
+		}
+	}
+
+	return activity, nil
+}
+
+// Get every action allowed by the effective permissions of a principal,
+// along with when it was last used
+func GetPrincipalActionUsage(ctx context.Context, db graph.Database, node *graph.Node) ([]analyze.ActionUsage, error) {
+	paths, err := GetUnresolvedOutputPaths(ctx, db, node)
+	if err != nil {
+		return nil, err
+	}
+
+	resolvedPaths, err := analyze.ResolveResourceAgainstIdentityPolicies(&analyze.ActionPathSet{}, &paths)
+	if err != nil {
+		return nil, err
+	}
+
+	activity, err := GetPrincipalActionActivity(ctx, db, node)
+	if err != nil {
+		return nil, err
+	}
+
+	return analyze.GetActionUsage(*resolvedPaths, activity), nil
+}
@@ -0,0 +1,132 @@
+package queries
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hotnops/apeman/graphschema/aws"
+	"github.com/specterops/bloodhound/dawgs/graph"
+	"github.com/specterops/bloodhound/dawgs/query"
+)
+
+// Collections keep the data of unrelated organizations apart in one graph.
+// Every ingested node carries the collection it was ingested into. Queries
+// run with a collection in their context only see that collection, and
+// queries without one see the whole graph, apart from scratch collections.
+
+// The collection that data is ingested into when none is given
+const DefaultCollection = "default"
+
+// Temporary copies of a collection, such as the ones a Terraform impact
+// analysis applies a plan to, are named with this prefix. They are only
+// seen by queries confined to them, and the prefix can't be used for
+// ingested collections.
+const ScratchCollectionPrefix = "impact-"
+
+func IsScratchCollection(name string) bool {
+	return strings.HasPrefix(name, ScratchCollectionPrefix)
+}
+
+type collectionKey struct{}
+
+var collectionNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+func ValidateCollectionName(name string) error {
+	if !collectionNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid collection name %q", name)
+	}
+	if IsScratchCollection(name) {
+		return fmt.Errorf("collection names starting with %q are reserved", ScratchCollectionPrefix)
+	}
+	return nil
+}
+
+func WithCollection(ctx context.Context, collection string) context.Context {
+	return context.WithValue(ctx, collectionKey{}, collection)
+}
+
+// GetCollection returns the collection that ctx is confined to, if any
+func GetCollection(ctx context.Context) (string, bool) {
+	collection, ok := ctx.Value(collectionKey{}).(string)
+	return collection, ok && collection != ""
+}
+
+// A Cypher predicate confining variable to the collection in ctx. The
+// collection is added to params.
+func collectionFilter(ctx context.Context, variable string, params map[string]any) string {
+	collection, ok := GetCollection(ctx)
+	if !ok {
+		return scratchCollectionFilter(variable)
+	}
+	params["collection"] = collection
+	return fmt.Sprintf("%s.%s = $collection", variable, aws.Collection)
+}
+
+// A Cypher predicate leaving the scratch collections out, for queries that
+// aren't confined to a collection
+func scratchCollectionFilter(variable string) string {
+	return fmt.Sprintf("(NOT COALESCE(%s.%s, '') STARTS WITH '%s')", variable, aws.Collection, ScratchCollectionPrefix)
+}
+
+// The same as collectionFilter, for queries that can't take parameters.
+// Invalid collection names match nothing rather than being quoted.
+func collectionLiteralFilter(ctx context.Context, variable string) string {
+	collection, ok := GetCollection(ctx)
+	if !ok {
+		return scratchCollectionFilter(variable)
+	}
+	if !collectionNamePattern.MatchString(collection) {
+		return "false"
+	}
+	return fmt.Sprintf("%s.%s = '%s'", variable, aws.Collection, collection)
+}
+
+// Add the collection in ctx to the criteria of a node filter. The AWS
+// schema nodes, such as actions and resource types, belong to no
+// collection and stay visible.
+func collectionCriteria(ctx context.Context, criteria ...graph.Criteria) []graph.Criteria {
+	property := query.NodeProperty(string(aws.Collection))
+	if collection, ok := GetCollection(ctx); ok {
+		criteria = append(criteria, query.Or(
+			query.Equals(property, collection),
+			query.IsNull(property),
+		))
+	} else {
+		criteria = append(criteria, query.Or(
+			query.Not(query.StringStartsWith(property, ScratchCollectionPrefix)),
+			query.IsNull(property),
+		))
+	}
+	return criteria
+}
+
+// Whether node belongs to the collection in ctx. Every node outside of
+// the scratch collections does when ctx has no collection.
+func inCollection(ctx context.Context, node *graph.Node) bool {
+	nodeCollection, _ := node.Properties.Get(string(aws.Collection)).String()
+	collection, ok := GetCollection(ctx)
+	if !ok {
+		return !IsScratchCollection(nodeCollection)
+	}
+	return nodeCollection == collection
+}
+
+func GetCollections(ctx context.Context, db graph.Database) ([]string, error) {
+	collections := []string{}
+	query := fmt.Sprintf("MATCH (a:UniqueArn) WHERE a.%s IS NOT NULL AND %s RETURN DISTINCT a.%s AS collection ORDER BY collection",
+		aws.Collection, scratchCollectionFilter("a"), aws.Collection)
+	results, err := RawCypherQuery(ctx, db, query, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		var collection string
+		if err := result.Map(&collection); err != nil {
+			return nil, err
+		}
+		collections = append(collections, collection)
+	}
+	return collections, nil
+}
@@ -0,0 +1,86 @@
+package queries
+
+import (
+	"context"
+
+	"github.com/hotnops/apeman/analyze"
+	"github.com/specterops/bloodhound/dawgs/graph"
+)
+
+// Get the k cheapest identity transform paths from a node to each tier
+// zero or high value principal it can reach, ranked by cost. The
+// transforms of the collection are loaded once and searched for every
+// reachable target.
+func GetEscalationPaths(ctx context.Context, db graph.Database, nodeID graph.ID, costs analyze.PathCosts, k int) ([]analyze.EscalationPath, error) {
+	if _, err := GetAWSNodeByGraphID(ctx, db, nodeID); err != nil {
+		return nil, err
+	}
+
+	params := map[string]any{
+		"tagKey": analyze.HighValueTagKey,
+	}
+	query := "MATCH (t:AWSUser|AWSRole) WHERE " + collectionFilter(ctx, "t", params) + " AND (t.tierzero = true OR " +
+		"EXISTS { MATCH (t) <- [:AttachedTo] - (tag:AWSTag) WHERE toLower(tag.key) = $tagKey AND toLower(tag.value) <> 'false' }) " +
+		"RETURN ID(t), COALESCE(t.tierzero, false)"
+
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	targetReasons := map[graph.ID]string{}
+	for _, result := range results {
+		var targetID graph.ID
+		var isTierZero bool
+		err = result.Map(&targetID)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&isTierZero)
+		if err != nil {
+			continue
+		}
+
+		targetReasons[targetID] = analyze.EscalationReasonHighValue
+		if isTierZero {
+			targetReasons[targetID] = analyze.EscalationReasonTierZero
+		}
+	}
+
+	nodes, edges, err := getIdentityTransformGraph(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	escalationPaths := []analyze.EscalationPath{}
+	for _, path := range analyze.GetTransformTargetPaths(nodes, edges, nodeID) {
+		targetID := path.Terminal().ID
+		reason, ok := targetReasons[targetID]
+		if !ok {
+			continue
+		}
+		for _, weightedPath := range costs.KCheapestPaths(nodes, edges, nodeID, targetID, k) {
+			escalationPaths = append(escalationPaths, analyze.NewEscalationPath(weightedPath.Path, reason, costs))
+		}
+	}
+
+	analyze.RankEscalationPaths(escalationPaths)
+	return escalationPaths, nil
+}
+
+// Get the k cheapest identity transform paths between two nodes, searching
+// the transforms of the collection as a whole
+func GetCheapestIdentityPaths(ctx context.Context, db graph.Database, sourceNodeID graph.ID, destNodeID graph.ID, costs analyze.PathCosts, k int) ([]analyze.WeightedPath, error) {
+	for _, nodeID := range []graph.ID{sourceNodeID, destNodeID} {
+		if _, err := GetAWSNodeByGraphID(ctx, db, nodeID); err != nil {
+			return nil, err
+		}
+	}
+
+	nodes, edges, err := getIdentityTransformGraph(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	return costs.KCheapestPaths(nodes, edges, sourceNodeID, destNodeID, k), nil
+}
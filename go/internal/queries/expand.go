@@ -0,0 +1,75 @@
+package queries
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/specterops/bloodhound/dawgs/graph"
+)
+
+type graphExpansion struct {
+	description string
+	query       string
+	// The ingested node that the collection filter applies to
+	variable string
+}
+
+// Queries that fill in the relationships implied by the ingested data.
+// These have to run before any other analysis, in this order. Each query
+// has a placeholder for the collection filter. Blobs only expand to nodes
+// of their own collection or to the shared AWS schema nodes.
+var graphExpansions = []graphExpansion{
+	{
+		description: "Populating ARN fields",
+		query: "MATCH (u:UniqueArn) WHERE %s " +
+			"WITH u, apoc.text.regexGroups(u.arn, 'arn:([^:]*):([^:]*):([^:]*):([^:]*):(.+)')[0] AS arn_parts " +
+			"WHERE size(arn_parts) = 6 " +
+			"SET u.partition = arn_parts[1], u.service = arn_parts[2], u.region = arn_parts[3], " +
+			"u.account_id = arn_parts[4], u.resource = arn_parts[5]",
+		variable: "u",
+	},
+	{
+		description: "Expanding resource types",
+		query: "MATCH (a:AWSResourceType) " +
+			"MATCH (b:UniqueArn) WHERE (b.arn =~ a.regex) AND %s " +
+			"MERGE (b) - [:TypeOf {layer: 2}] -> (a)",
+		variable: "b",
+	},
+	{
+		description: "Expanding action blobs",
+		query: "MATCH (a:AWSActionBlob) WHERE %s " +
+			"MATCH (b:AWSAction) WHERE b.name =~ a.regex " +
+			"MERGE (a) - [:ExpandsTo {layer: 2}] -> (b)",
+		variable: "a",
+	},
+	{
+		description: "Expanding resource blobs",
+		query: "MATCH (a:AWSResourceBlob) WHERE %s " +
+			"MATCH (b:UniqueArn) WHERE b.arn =~ a.regex AND b.collection = a.collection " +
+			"MERGE (a) - [:ExpandsTo {layer: 2}] -> (b)",
+		variable: "a",
+	},
+	{
+		description: "Expanding principal blobs",
+		query: "MATCH (a:AWSPrincipalBlob) WHERE %s " +
+			"MATCH (b:AWSUser|AWSRole|AWSGroup|AWSIdentityProvider|AWSService) " +
+			"WHERE (b.arn =~ a.regex OR b.name =~ a.regex) AND (b.collection = a.collection OR b.collection IS NULL) " +
+			"MERGE (a) - [:ExpandsTo {layer: 2}] -> (b)",
+		variable: "a",
+	},
+}
+
+// Expand the freshly ingested graph. Every other analysis relies on the
+// relationships created here.
+func ExpandGraph(ctx context.Context, db graph.Database) error {
+	for _, expansion := range graphExpansions {
+		log.Printf("[*] %s", expansion.description)
+		params := map[string]any{}
+		query := fmt.Sprintf(expansion.query, collectionFilter(ctx, expansion.variable, params))
+		if err := RawCypherWrite(ctx, db, query, params); err != nil {
+			return err
+		}
+	}
+	return nil
+}
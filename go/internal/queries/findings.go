@@ -0,0 +1,533 @@
+package queries
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hotnops/apeman/analyze"
+	"github.com/hotnops/apeman/graphschema/aws"
+	"github.com/specterops/bloodhound/dawgs/graph"
+)
+
+type findingCheckFunc func(ctx context.Context, db graph.Database, check analyze.FindingCheck, options analyze.FindingOptions) ([]analyze.Finding, error)
+
+// The function implementing each built in check, keyed by check ID
+var findingCheckFuncs = map[string]findingCheckFunc{
+	analyze.CheckAdminWildcard:          getAdminWildcardFindings,
+	analyze.CheckPublicTrust:            getPublicTrustFindings,
+	analyze.CheckIAMWildcardAction:      getIAMWildcardActionFindings,
+	analyze.CheckInlineAdminPolicy:      getInlineAdminPolicyFindings,
+	analyze.CheckExternalTrustNoId:      getExternalTrustFindings,
+	analyze.CheckUserConsoleKeysNoGroup: getUserConsoleKeysFindings,
+	analyze.CheckUserConsoleNoMFA:       getUserConsoleNoMFAFindings,
+	analyze.CheckUnusedRole:             getUnusedRoleFindings,
+	analyze.CheckUnusedPermissions:      getUnusedPermissionsFindings,
+}
+
+// Run a query returning a principal and the statement that triggered the
+// check, and turn each row into a finding
+func getStatementFindings(ctx context.Context, db graph.Database, check analyze.FindingCheck, query string, params map[string]any) ([]analyze.Finding, error) {
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []analyze.Finding{}
+	for _, result := range results {
+		var principal graph.Node
+		var statement graph.Node
+		err = result.Map(&principal)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&statement)
+		if err != nil {
+			continue
+		}
+
+		principalArn, _ := principal.Properties.Get("arn").String()
+		statementHash, _ := statement.Properties.Get("hash").String()
+		findings = append(findings, analyze.NewFinding(check, principal.ID, principalArn, statementHash, ""))
+	}
+
+	return findings, nil
+}
+
+func getAdminWildcardFindings(ctx context.Context, db graph.Database, check analyze.FindingCheck, options analyze.FindingOptions) ([]analyze.Finding, error) {
+	params := map[string]any{
+		"actionNames":  analyze.AdminActionNames,
+		"resourceName": analyze.AdminResourceName,
+	}
+
+	query := "MATCH (s:AWSStatement {effect: 'Allow'}) - [:Action] -> (act:AWSActionBlob) " +
+		"WHERE act.name IN $actionNames AND (s) - [:Resource] -> (:AWSResourceBlob {name: $resourceName}) " +
+		"AND " + collectionFilter(ctx, "s", params) + " " +
+		"MATCH (s) - [:AttachedTo*3..4] -> (a:AWSUser|AWSRole|AWSGroup) " +
+		"RETURN DISTINCT a, s"
+
+	return getStatementFindings(ctx, db, check, query, params)
+}
+
+func getInlineAdminPolicyFindings(ctx context.Context, db graph.Database, check analyze.FindingCheck, options analyze.FindingOptions) ([]analyze.Finding, error) {
+	params := map[string]any{
+		"actionNames":  analyze.AdminActionNames,
+		"resourceName": analyze.AdminResourceName,
+	}
+
+	query := "MATCH (s:AWSStatement {effect: 'Allow'}) - [:Action] -> (act:AWSActionBlob) " +
+		"WHERE act.name IN $actionNames AND (s) - [:Resource] -> (:AWSResourceBlob {name: $resourceName}) " +
+		"AND " + collectionFilter(ctx, "s", params) + " " +
+		"MATCH (s) - [:AttachedTo*2] -> (:AWSInlinePolicy) - [:AttachedTo] -> (a:AWSUser|AWSRole|AWSGroup) " +
+		"RETURN DISTINCT a, s"
+
+	return getStatementFindings(ctx, db, check, query, params)
+}
+
+func getIAMWildcardActionFindings(ctx context.Context, db graph.Database, check analyze.FindingCheck, options analyze.FindingOptions) ([]analyze.Finding, error) {
+	params := map[string]any{
+		"actionNames": analyze.IAMWildcardActionNames,
+	}
+
+	query := "MATCH (s:AWSStatement {effect: 'Allow'}) - [:Action] -> (act:AWSActionBlob) " +
+		"WHERE act.name IN $actionNames AND " + collectionFilter(ctx, "s", params) + " " +
+		"MATCH (s) - [:AttachedTo*3..4] -> (a:AWSUser|AWSRole|AWSGroup) " +
+		"RETURN DISTINCT a, s"
+
+	return getStatementFindings(ctx, db, check, query, params)
+}
+
+func getPublicTrustFindings(ctx context.Context, db graph.Database, check analyze.FindingCheck, options analyze.FindingOptions) ([]analyze.Finding, error) {
+	params := map[string]any{
+		"anonymous": analyze.AnonymousPrincipal,
+	}
+
+	query := "MATCH (s:AWSStatement {effect: 'Allow'}) - [:Principal] -> (:AWSPrincipalBlob {name: $anonymous}) " +
+		"WHERE " + collectionFilter(ctx, "s", params) + " " +
+		"MATCH (s) - [:AttachedTo] -> (:AWSAssumeRolePolicy) - [:AttachedTo] -> (a:AWSRole) " +
+		"WHERE NOT EXISTS { MATCH (s) <- [:AttachedTo] - (:AWSCondition) } " +
+		"RETURN DISTINCT a, s"
+
+	return getStatementFindings(ctx, db, check, query, params)
+}
+
+func getExternalTrustFindings(ctx context.Context, db graph.Database, check analyze.FindingCheck, options analyze.FindingOptions) ([]analyze.Finding, error) {
+	ingestedAccountIDs, err := GetIngestedAccountIDs(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	params := map[string]any{}
+	query := "MATCH (s:AWSStatement {effect: 'Allow'}) - [:Principal] -> (p) " +
+		"WHERE (p:UniqueArn OR p:AWSPrincipalBlob) AND " + collectionFilter(ctx, "s", params) + " " +
+		"MATCH (s) - [:AttachedTo] -> (:AWSAssumeRolePolicy) - [:AttachedTo] -> (a:AWSRole) " +
+		"RETURN a, s, COALESCE(p.arn, p.name)"
+
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []analyze.Finding{}
+	externalIdEnforced := map[graph.ID]bool{}
+	for _, result := range results {
+		var role graph.Node
+		var statement graph.Node
+		var principal string
+		err = result.Map(&role)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&statement)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&principal)
+		if err != nil {
+			continue
+		}
+
+		accountID := analyze.GetTrustedAccountID(principal)
+		if accountID == "" || ingestedAccountIDs[accountID] {
+			continue
+		}
+
+		enforced, ok := externalIdEnforced[statement.ID]
+		if !ok {
+			conditions, err := GetConditionsFromStatement(ctx, db, statement.ID)
+			if err != nil {
+				log.Printf("[!] Error getting conditions: %s", err.Error())
+				continue
+			}
+			enforced = analyze.IsExternalIdEnforced(conditions)
+			externalIdEnforced[statement.ID] = enforced
+		}
+		if enforced {
+			continue
+		}
+
+		roleArn, _ := role.Properties.Get("arn").String()
+		statementHash, _ := statement.Properties.Get("hash").String()
+		findings = append(findings, analyze.NewFinding(check, role.ID, roleArn, statementHash,
+			fmt.Sprintf("trusts account %s", accountID)))
+	}
+
+	return findings, nil
+}
+
+// The password and access key properties are set by ingesting a
+// credential report. Users without one are skipped.
+func getUserConsoleKeysFindings(ctx context.Context, db graph.Database, check analyze.FindingCheck, options analyze.FindingOptions) ([]analyze.Finding, error) {
+	params := map[string]any{}
+	query := fmt.Sprintf("MATCH (u:AWSUser) WHERE u.%s = true AND (u.%s = true OR u.%s = true) "+
+		"AND NOT (u) - [:MemberOf] -> (:AWSGroup) AND %s "+
+		"RETURN u", aws.PasswordEnabled, aws.AccessKey1Active, aws.AccessKey2Active, collectionFilter(ctx, "u", params))
+
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []analyze.Finding{}
+	for _, result := range results {
+		var user graph.Node
+		err = result.Map(&user)
+		if err != nil {
+			continue
+		}
+
+		userArn, _ := user.Properties.Get("arn").String()
+		findings = append(findings, analyze.NewFinding(check, user.ID, userArn, "", ""))
+	}
+
+	return findings, nil
+}
+
+func getUserConsoleNoMFAFindings(ctx context.Context, db graph.Database, check analyze.FindingCheck, options analyze.FindingOptions) ([]analyze.Finding, error) {
+	params := map[string]any{}
+	query := fmt.Sprintf("MATCH (u:AWSUser) WHERE u.%s = true AND u.%s = false AND %s "+
+		"RETURN u, COALESCE(u.%s, '')", aws.PasswordEnabled, aws.MFAActive, collectionFilter(ctx, "u", params), aws.PasswordLastUsed)
+
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []analyze.Finding{}
+	for _, result := range results {
+		var user graph.Node
+		var passwordLastUsed string
+		err = result.Map(&user)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&passwordLastUsed)
+		if err != nil {
+			continue
+		}
+
+		detail := "password never used"
+		if lastUsed, ok := analyze.ParseAWSDate(passwordLastUsed); ok {
+			detail = fmt.Sprintf("password last used %s", lastUsed.Format(time.RFC3339))
+		}
+
+		userArn, _ := user.Properties.Get("arn").String()
+		findings = append(findings, analyze.NewFinding(check, user.ID, userArn, "", detail))
+	}
+
+	return findings, nil
+}
+
+// Service linked roles are managed by AWS and can't be removed, so they
+// aren't reported. Activity recorded from CloudTrail counts as use, as
+// does the last use in the authorization details file.
+func getUnusedRoleFindings(ctx context.Context, db graph.Database, check analyze.FindingCheck, options analyze.FindingOptions) ([]analyze.Finding, error) {
+	params := map[string]any{}
+	query := fmt.Sprintf("MATCH (r:AWSRole) WHERE r.inferred IS NULL AND NOT COALESCE(r.%s, '') STARTS WITH '/aws-service-role/' AND %s "+
+		"OPTIONAL MATCH (r) - [u:%s] -> (:AWSAction) "+
+		"WITH r, max(u.%s) AS activityLastUsed "+
+		"RETURN r, COALESCE(r.%s, ''), COALESCE(r.%s, ''), COALESCE(activityLastUsed, '')",
+		aws.Path, collectionFilter(ctx, "r", params), aws.UsedAction, aws.LastUsed, aws.CreateDate, aws.RoleLastUsed)
+
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	findings := []analyze.Finding{}
+	for _, result := range results {
+		var role graph.Node
+		var createDate string
+		var roleLastUsed string
+		var activityLastUsed string
+		err = result.Map(&role)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&createDate)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&roleLastUsed)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&activityLastUsed)
+		if err != nil {
+			continue
+		}
+
+		if !analyze.IsRoleUnused(createDate, roleLastUsed, now, analyze.UnusedRoleThreshold) {
+			continue
+		}
+
+		detail := "never used"
+		lastUsed, ok := analyze.ParseRoleLastUsed(roleLastUsed)
+		if activityTime, activityOk := analyze.ParseAWSDate(activityLastUsed); activityOk && (!ok || activityTime.After(lastUsed)) {
+			lastUsed, ok = activityTime, true
+		}
+		if ok {
+			if now.Sub(lastUsed) < analyze.UnusedRoleThreshold {
+				continue
+			}
+			detail = fmt.Sprintf("last used %s", lastUsed.Format(time.RFC3339))
+		}
+
+		roleArn, _ := role.Properties.Get("arn").String()
+		findings = append(findings, analyze.NewFinding(check, role.ID, roleArn, "", detail))
+	}
+
+	return findings, nil
+}
+
+// Only principals with CloudTrail activity are checked, since without it
+// every permission would look unused. Use is measured up to the last
+// ingested event, and nothing is reported until the logs cover the whole
+// threshold.
+func getUnusedPermissionsFindings(ctx context.Context, db graph.Database, check analyze.FindingCheck, options analyze.FindingOptions) ([]analyze.Finding, error) {
+	coverage, err := GetCloudTrailCoverage(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	findings := []analyze.Finding{}
+	if coverage.IsEmpty() {
+		return findings, nil
+	}
+	if coverage.Duration() < options.UnusedPermissionThreshold {
+		log.Printf("[*] CloudTrail logs cover %s, less than the unused permission threshold of %s, skipping %s",
+			coverage.Duration(), options.UnusedPermissionThreshold, check.ID)
+		return findings, nil
+	}
+
+	params := map[string]any{}
+	query := fmt.Sprintf("MATCH (p:UniqueArn) - [:%s] -> (:AWSAction) WHERE (p:AWSUser OR p:AWSRole) AND %s "+
+		"RETURN DISTINCT p", aws.UsedAction, collectionFilter(ctx, "p", params))
+
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		var principal graph.Node
+		err = result.Map(&principal)
+		if err != nil {
+			continue
+		}
+
+		usage, err := GetPrincipalActionUsage(ctx, db, &principal)
+		if err != nil {
+			log.Printf("[!] Error getting action usage: %s", err.Error())
+			continue
+		}
+		unused := analyze.GetUnusedActions(usage, coverage.LastEvent, options.UnusedPermissionThreshold)
+		if len(unused) == 0 {
+			continue
+		}
+
+		detail := fmt.Sprintf("%d of %d allowed actions unused", len(unused), len(usage))
+		principalArn, _ := principal.Properties.Get("arn").String()
+		findings = append(findings, analyze.NewFinding(check, principal.ID, principalArn, "", detail))
+	}
+
+	return findings, nil
+}
+
+// Run a user defined rule. The first column is the affected node and the
+// optional second column the statement used as evidence. Rules may use
+// $collection, and nodes outside of the collection being analyzed are
+// dropped either way.
+func getRuleFindings(ctx context.Context, db graph.Database, rule analyze.Rule) ([]analyze.Finding, error) {
+	collection, _ := GetCollection(ctx)
+	params := map[string]any{
+		"collection": collection,
+	}
+
+	results, err := RawCypherQuery(ctx, db, rule.Query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []analyze.Finding{}
+	for _, result := range results {
+		var node graph.Node
+		err = result.Map(&node)
+		if err != nil {
+			continue
+		}
+		if !inCollection(ctx, &node) {
+			continue
+		}
+
+		statementHash := ""
+		var statement graph.Node
+		if err := result.Map(&statement); err == nil && statement.Kinds.ContainsOneOf(aws.AWSStatement) {
+			statementHash, _ = statement.Properties.Get("hash").String()
+		}
+
+		nodeArn, _ := node.Properties.Get("arn").String()
+		findings = append(findings, analyze.NewFinding(rule.FindingCheck, node.ID, nodeArn, statementHash, ""))
+	}
+
+	return findings, nil
+}
+
+// Run every built in check and user defined rule. A failing check is
+// logged and skipped so the rest still produce findings.
+func RunFindingChecks(ctx context.Context, db graph.Database, rules []analyze.Rule, options analyze.FindingOptions) []analyze.Finding {
+	findings := []analyze.Finding{}
+	for _, check := range analyze.FindingChecks {
+		checkFunc, ok := findingCheckFuncs[check.ID]
+		if !ok {
+			log.Printf("[!] No implementation for check %s", check.ID)
+			continue
+		}
+
+		checkFindings, err := checkFunc(ctx, db, check, options)
+		if err != nil {
+			log.Printf("[!] Error running check %s: %s", check.ID, err.Error())
+			continue
+		}
+		findings = append(findings, checkFindings...)
+	}
+
+	for _, rule := range rules {
+		ruleFindings, err := getRuleFindings(ctx, db, rule)
+		if err != nil {
+			log.Printf("[!] Error running rule %s: %s", rule.ID, err.Error())
+			continue
+		}
+		findings = append(findings, ruleFindings...)
+	}
+
+	analyze.SortFindings(findings)
+	return findings
+}
+
+// Replace the stored findings. Each finding is an AWSFinding node that
+// Affects the flagged node and, when a statement triggered it, links to
+// the statement as Evidence. Findings belong to the collection of the
+// flagged node, and only those of the collection in ctx are replaced.
+func StoreFindings(ctx context.Context, db graph.Database, findings []analyze.Finding) error {
+	deleteParams := map[string]any{}
+	deleteQuery := "MATCH (f:AWSFinding) WHERE " + collectionFilter(ctx, "f", deleteParams) + " DETACH DELETE f"
+	if err := RawCypherWrite(ctx, db, deleteQuery, deleteParams); err != nil {
+		return err
+	}
+
+	query := "MATCH (n) WHERE ID(n) = $nodeId " +
+		"CREATE (f:AWSFinding) - [:Affects {layer: 2}] -> (n) " +
+		"SET f = $properties, f.layer = 2, f.collection = n.collection " +
+		"WITH f " +
+		"MATCH (s:AWSStatement {hash: $statementHash}) " +
+		"CREATE (f) - [:Evidence {layer: 2}] -> (s)"
+
+	return db.WriteTransaction(ctx, func(tx graph.Transaction) error {
+		for _, finding := range findings {
+			params := map[string]any{
+				"nodeId":        finding.NodeID,
+				"statementHash": finding.StatementHash,
+				"properties": map[string]any{
+					"id":             finding.ID,
+					"check_id":       finding.CheckID,
+					"title":          finding.Title,
+					"severity":       finding.Severity,
+					"description":    finding.Description,
+					"node_arn":       finding.NodeArn,
+					"account_id":     finding.AccountID,
+					"statement_hash": finding.StatementHash,
+					"detail":         finding.Detail,
+				},
+			}
+
+			result := tx.Run(query, params)
+			if result.Error() != nil {
+				return result.Error()
+			}
+			result.Close()
+		}
+		return nil
+	})
+}
+
+// Run every check and rule and store the results in place of the previous
+// findings
+func AnalyzeFindings(ctx context.Context, db graph.Database, rules []analyze.Rule, options analyze.FindingOptions) ([]analyze.Finding, error) {
+	findings := RunFindingChecks(ctx, db, rules, options)
+
+	if err := StoreFindings(ctx, db, findings); err != nil {
+		return nil, err
+	}
+
+	return findings, nil
+}
+
+func GetFindings(ctx context.Context, db graph.Database, filter analyze.FindingFilter) ([]analyze.Finding, error) {
+	params := map[string]any{
+		"accountId": filter.AccountID,
+		"severity":  filter.Severity,
+		"checkId":   filter.CheckID,
+	}
+
+	query := "MATCH (f:AWSFinding) - [:Affects] -> (n) " +
+		"WHERE ($accountId = '' OR f.account_id = $accountId) " +
+		"AND ($severity = '' OR f.severity = $severity) " +
+		"AND ($checkId = '' OR f.check_id = $checkId) " +
+		"AND " + collectionFilter(ctx, "f", params) + " " +
+		"RETURN f, ID(n)"
+
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []analyze.Finding{}
+	for _, result := range results {
+		var findingNode graph.Node
+		var finding analyze.Finding
+		err = result.Map(&findingNode)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&finding.NodeID)
+		if err != nil {
+			continue
+		}
+
+		finding.ID, _ = findingNode.Properties.Get("id").String()
+		finding.CheckID, _ = findingNode.Properties.Get("check_id").String()
+		finding.Title, _ = findingNode.Properties.Get("title").String()
+		finding.Severity, _ = findingNode.Properties.Get("severity").String()
+		finding.Description, _ = findingNode.Properties.Get("description").String()
+		finding.NodeArn, _ = findingNode.Properties.Get("node_arn").String()
+		finding.AccountID, _ = findingNode.Properties.Get("account_id").String()
+		finding.StatementHash, _ = findingNode.Properties.Get("statement_hash").String()
+		finding.Detail, _ = findingNode.Properties.Get("detail").String()
+		findings = append(findings, finding)
+	}
+
+	analyze.SortFindings(findings)
+	return findings, nil
+}
@@ -2,6 +2,7 @@ package queries
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -22,15 +23,15 @@ type PermissionMapping struct {
 
 // Get all the policies attached to a particular action node
 func GetActionPolicies(ctx context.Context, db graph.Database, action string) (graph.PathSet, error) {
-	query := "MATCH p=(a:AWSAction) <- [:ExpandsTo|Action*1..2] - (s:AWSStatement) - [:AttachedTo*2..3] - (pol:AWSManagedPolicy|AWSInlinePolicy) WHERE a.name = '%s' RETURN p"
-	query = fmt.Sprintf(query, action)
+	query := "MATCH p=(a:AWSAction) <- [:ExpandsTo|Action*1..2] - (s:AWSStatement) - [:AttachedTo*2..3] - (pol:AWSManagedPolicy|AWSInlinePolicy) WHERE a.name = '%s' AND %s RETURN p"
+	query = fmt.Sprintf(query, action, collectionLiteralFilter(ctx, "s"))
 	paths, err := CypherQueryPaths(ctx, db, query)
 	return paths, err
 }
 
 func GetInboundRolePaths(ctx context.Context, db graph.Database, roleId string) (graph.PathSet, error) {
-	query := "MATCH p=(a:UniqueArn) - [:IdentityTransform*] -> (b:AWSRole) WHERE b.roleid = '%s' AND ALL(n IN nodes(p) WHERE SINGLE(x IN nodes(p) WHERE x = n)) RETURN p"
-	query = fmt.Sprintf(query, roleId)
+	query := "MATCH p=(a:UniqueArn) - [:IdentityTransform*] -> (b:AWSRole) WHERE b.roleid = '%s' AND %s AND ALL(n IN nodes(p) WHERE SINGLE(x IN nodes(p) WHERE x = n)) RETURN p"
+	query = fmt.Sprintf(query, roleId, collectionLiteralFilter(ctx, "b"))
 	paths, err := CypherQueryPaths(ctx, db, query)
 
 	return paths, err
@@ -54,6 +55,17 @@ func RawCypherQuery(ctx context.Context, db graph.Database, query string, parama
 	return values, nil
 }
 
+func RawCypherWrite(ctx context.Context, db graph.Database, query string, paramaters map[string]any) error {
+	return db.WriteTransaction(ctx, func(tx graph.Transaction) error {
+		if result := tx.Run(query, paramaters); result.Error() != nil {
+			return result.Error()
+		} else {
+			result.Close()
+			return nil
+		}
+	})
+}
+
 func CypherQueryPaths(ctx context.Context, db graph.Database, cypherQuery string) (graph.PathSet, error) {
 
 	var returnPathSet graph.PathSet
@@ -82,11 +94,11 @@ func Search(ctx context.Context, db graph.Database, searchString string) (graph.
 	db.ReadTransaction(ctx, func(tx graph.Transaction) error {
 		if fetchedNodes, err := ops.FetchNodes(tx.Nodes().Filterf(func() graph.Criteria {
 
-			return query.Or(
+			return query.And(collectionCriteria(ctx, query.Or(
 				query.CaseInsensitiveStringContains(query.NodeProperty("arn"), searchString),
 				query.CaseInsensitiveStringContains(query.NodeProperty("name"), searchString),
 				query.CaseInsensitiveStringContains(query.NodeProperty("hash"), searchString),
-			)
+			))...)
 		})); err != nil {
 			return err
 		} else {
@@ -102,8 +114,9 @@ func Search(ctx context.Context, db graph.Database, searchString string) (graph.
 
 func GetAWSAccountIDs(ctx context.Context, db graph.Database) ([]string, error) {
 	var accountIDs []string
-	query := "MATCH (a:UniqueArn) RETURN DISTINCT a.account_id"
-	results, err := RawCypherQuery(ctx, db, query, nil)
+	params := map[string]any{}
+	query := "MATCH (a:UniqueArn) WHERE " + collectionFilter(ctx, "a", params) + " RETURN DISTINCT a.account_id"
+	results, err := RawCypherQuery(ctx, db, query, params)
 	if err != nil {
 		return nil, err
 	}
@@ -124,8 +137,8 @@ func GetAWSAccountIDs(ctx context.Context, db graph.Database) ([]string, error)
 func GetAWSAccountServices(ctx context.Context, db graph.Database, accountID string) ([]string, error) {
 	var services []string
 
-	query := "MATCH (a:UniqueArn) WHERE a.account_id = $account_id RETURN DISTINCT a.service"
 	params := map[string]interface{}{"account_id": accountID}
+	query := "MATCH (a:UniqueArn) WHERE a.account_id = $account_id AND " + collectionFilter(ctx, "a", params) + " RETURN DISTINCT a.service"
 
 	results, err := RawCypherQuery(ctx, db, query, params)
 	if err != nil {
@@ -149,8 +162,9 @@ func PopulateTags(ctx context.Context, db graph.Database, entry *analyze.ActionP
 	// Get tags for the principal and resource
 	principalTags := map[string]string{}
 	resourceTags := map[string]string{}
-	query := "MATCH (a:UniqueArn) <- [:AttachedTo] - (t:AWSTag) WHERE a.arn = $arn RETURN t "
-	params := map[string]any{"arn": entry.PrincipalArn}
+	params := map[string]any{}
+	query := "MATCH (a:UniqueArn) <- [:AttachedTo] - (t:AWSTag) WHERE a.arn = $arn AND " + collectionFilter(ctx, "a", params) + " RETURN t "
+	params["arn"] = entry.PrincipalArn
 
 	results, err := RawCypherQuery(ctx, db, query, params)
 	if err != nil {
@@ -169,7 +183,7 @@ func PopulateTags(ctx context.Context, db graph.Database, entry *analyze.ActionP
 
 	entry.PrincipalTags = principalTags
 
-	params = map[string]any{"arn": entry.ResourceArn}
+	params["arn"] = entry.ResourceArn
 	results, err = RawCypherQuery(ctx, db, query, params)
 	if err != nil {
 		log.Printf("[!] Error getting tags: %s", err.Error())
@@ -186,20 +200,35 @@ func PopulateTags(ctx context.Context, db graph.Database, entry *analyze.ActionP
 	}
 
 	entry.ResourceTags = resourceTags
+
+	// Whether a user has an MFA device comes from the credential report
+	params = map[string]any{"arn": entry.PrincipalArn}
+	query = fmt.Sprintf("MATCH (a:AWSUser) WHERE a.arn = $arn AND a.%s IS NOT NULL AND %s RETURN a.%s",
+		aws.MFAActive, collectionFilter(ctx, "a", params), aws.MFAActive)
+	results, err = RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		log.Printf("[!] Error getting MFA status: %s", err.Error())
+	}
+	for _, result := range results {
+		var mfaActive bool
+		if err := result.Map(&mfaActive); err == nil {
+			entry.PrincipalMFA = &mfaActive
+		}
+	}
 }
 
 func GetAWSRoleInboundRoleAssumptionPaths(ctx context.Context, db graph.Database, roleId string) (*analyze.ActionPathSet, error) {
 	// First, get all the principals that are trusted to assume this role
-	query := "MATCH p=(a:AWSRole) <- [:AttachedTo] - (:AWSAssumeRolePolicy) <- [:AttachedTo] - (s:AWSStatement) - [:Principal|ExpandsTo*1..2] -> (b:AWSRole|AWSUser) WHERE a.roleid = $roleid AND (s) - [:Action|ExpandsTo*1..2] -> (:AWSAction {name:'sts:assumerole'}) " +
+	params := map[string]any{
+		"roleid": roleId,
+	}
+
+	query := "MATCH p=(a:AWSRole) <- [:AttachedTo] - (:AWSAssumeRolePolicy) <- [:AttachedTo] - (s:AWSStatement) - [:Principal|ExpandsTo*1..2] -> (b:AWSRole|AWSUser) WHERE a.roleid = $roleid AND " + collectionFilter(ctx, "a", params) + " AND (s) - [:Action|ExpandsTo*1..2] -> (:AWSAction {name:'sts:assumerole'}) " +
 		"WITH a, s, b " +
 		"OPTIONAL MATCH (s) <- [:AttachedTo] - (c:AWSCondition) " +
 		"OPTIONAL MATCH (s) - [:Principal] - > (pb:AWSPrincipalBlob) - [:ExpandsTo*1..2] -> (b) " +
 		"RETURN b, a, s, COALESCE(c IS NOT NULL, false), COALESCE(pb IS NOT NULL, false)"
 
-	params := map[string]any{
-		"roleid": roleId,
-	}
-
 	results, err := RawCypherQuery(ctx, db, query, params)
 	if err != nil {
 		return nil, err
@@ -287,12 +316,13 @@ func GetAWSRoleInboundRoleAssumptionPaths(ctx context.Context, db graph.Database
 	return resolvedPaths, nil
 }
 
-func CreateIdentityTransformEdge(ctx context.Context, db graph.Database, sourceNodes []graph.ID, targetNode graph.ID, name string) error {
+func CreateIdentityTransformEdge(ctx context.Context, db graph.Database, sourceNodes []graph.ID, targetNode graph.ID, name string, conditional bool) error {
 	return db.WriteTransaction(ctx, func(tx graph.Transaction) error {
 		for _, sourceNode := range sourceNodes {
 			properties := graph.NewProperties()
 			properties.Set("layer", 2)
 			properties.Set("name", name)
+			properties.Set(analyze.ConditionalProperty, conditional)
 			_, err := tx.CreateRelationshipByIDs(sourceNode, targetNode, aws.IdentityTransform, properties)
 			if err != nil {
 				return err
@@ -303,19 +333,19 @@ func CreateIdentityTransformEdge(ctx context.Context, db graph.Database, sourceN
 }
 
 func GetNodePermissionPath(ctx context.Context, db graph.Database, sourdeNodeID graph.ID, destNodeID graph.ID, actionName string) ([]graph.Path, error) {
-	// First, get all paths to target resource
-	// TODO: This doesn't account for group memberships!!
-	query := "MATCH p=(a:AWSUser|AWSRole) <- [:AttachedTo] - (:AWSInlinePolicy|AWSManagedPolicy) <- [:AttachedTo*2..3] - (s:AWSStatement) - [:Resource|ExpandsTo*1..2] -> (b) " +
-		"WHERE ID(a) = $sourceNodeId AND ID(b) = $destNodeId " +
-		"WITH s, p " +
-		"MATCH p2=(s) - [:Action|ExpandsTo*1..2] -> (act:AWSAction {name: $actionName}) " +
-		"RETURN p, p2"
-
+	// First, get all paths to target resource. The optional MemberOf hop
+	// picks up policies a user inherits from its groups, and keeps the
+	// group in the returned path.
 	params := map[string]any{
 		"sourceNodeId": sourdeNodeID,
 		"destNodeId":   destNodeID,
 		"actionName":   actionName,
 	}
+	query := "MATCH p=(a:AWSUser|AWSRole|AWSGroup) - [:MemberOf*0..1] -> (:AWSUser|AWSRole|AWSGroup) <- [:AttachedTo] - (:AWSInlinePolicy|AWSManagedPolicy) <- [:AttachedTo*2..3] - (s:AWSStatement) - [:Resource|ExpandsTo*1..2] -> (b) " +
+		"WHERE ID(a) = $sourceNodeId AND ID(b) = $destNodeId AND " + collectionFilter(ctx, "a", params) + " " +
+		"WITH s, p " +
+		"MATCH p2=(s) - [:Action|ExpandsTo*1..2] -> (act:AWSAction {name: $actionName}) " +
+		"RETURN p, p2"
 
 	log.Print(query)
 
@@ -360,12 +390,21 @@ func CreateAssumeRoleEdgesToRole(ctx context.Context, db graph.Database, roleNod
 
 	if len(*rolePaths) > 0 {
 		sourceIDs := make([]graph.ID, 0)
+		conditionalSourceIDs := make([]graph.ID, 0)
 		for _, actionPath := range *rolePaths {
 			log.Printf("[*] Creating assume role edge from %s to %s", actionPath.PrincipalArn, actionPath.ResourceArn)
-			sourceIDs = append(sourceIDs, actionPath.PrincipalID)
+			if actionPath.UnresolvedConditions {
+				conditionalSourceIDs = append(conditionalSourceIDs, actionPath.PrincipalID)
+			} else {
+				sourceIDs = append(sourceIDs, actionPath.PrincipalID)
+			}
 		}
 
-		err := CreateIdentityTransformEdge(ctx, db, sourceIDs, roleNode.ID, string(aws.IdentityTransformAssumeRole))
+		err := CreateIdentityTransformEdge(ctx, db, sourceIDs, roleNode.ID, string(aws.IdentityTransformAssumeRole), false)
+		if err != nil {
+			log.Printf("[!] Error creating assume role edge: %s", err.Error())
+		}
+		err = CreateIdentityTransformEdge(ctx, db, conditionalSourceIDs, roleNode.ID, string(aws.IdentityTransformAssumeRole), true)
 		if err != nil {
 			log.Printf("[!] Error creating assume role edge: %s", err.Error())
 		}
@@ -403,6 +442,9 @@ func CreateCreateAccessKeyEdges(ctx context.Context, db graph.Database) error {
 	}
 
 	for _, node := range nodes {
+		if !inCollection(ctx, node) {
+			continue
+		}
 		arnString, err := node.Properties.Get("arn").String()
 		if err != nil {
 			return err
@@ -420,7 +462,8 @@ func CreateCreateAccessKeyEdges(ctx context.Context, db graph.Database) error {
 				db,
 				[]graph.ID{path.PrincipalID},
 				path.ResourceID,
-				string(aws.IdentityTransformCreateAccessKey)); err != nil {
+				string(aws.IdentityTransformCreateAccessKey),
+				path.UnresolvedConditions); err != nil {
 				return err
 			}
 
@@ -441,6 +484,9 @@ func CreateUpdateAssumeRoleEdges(ctx context.Context, db graph.Database) error {
 	}
 
 	for _, role := range roles {
+		if !inCollection(ctx, role) {
+			continue
+		}
 		arnString, err := role.Properties.Get("arn").String()
 		if err != nil {
 			return err
@@ -458,7 +504,8 @@ func CreateUpdateAssumeRoleEdges(ctx context.Context, db graph.Database) error {
 				db,
 				[]graph.ID{path.PrincipalID},
 				path.ResourceID,
-				string(aws.IdentityTransformUpdateAssumeRolePolicy)); err != nil {
+				string(aws.IdentityTransformUpdateAssumeRolePolicy),
+				path.UnresolvedConditions); err != nil {
 				return err
 			}
 
@@ -470,12 +517,36 @@ func CreateUpdateAssumeRoleEdges(ctx context.Context, db graph.Database) error {
 
 }
 
+// Create every kind of identity transform edge. The kinds are independent
+// so they are created concurrently.
+func CreateIdentityTransformEdges(ctx context.Context, db graph.Database) error {
+	wg := sync.WaitGroup{}
+	errs := make([]error, 3)
+
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		errs[0] = CreateAssumeRoleEdges(ctx, db)
+	}()
+	go func() {
+		defer wg.Done()
+		errs[1] = CreateUpdateAssumeRoleEdges(ctx, db)
+	}()
+	go func() {
+		defer wg.Done()
+		errs[2] = CreateCreateAccessKeyEdges(ctx, db)
+	}()
+
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
 func CreateAssumeRoleEdges(ctx context.Context, db graph.Database) error {
 	roleNodes := graph.NewNodeSet()
 	log.Printf("[*] Getting all nodes")
 	db.ReadTransaction(ctx, func(tx graph.Transaction) error {
 		if fetchedNodes, err := ops.FetchNodeSet(tx.Nodes().Filterf(func() graph.Criteria {
-			return query.And(query.Kind(query.Node(), aws.AWSRole))
+			return query.And(collectionCriteria(ctx, query.Kind(query.Node(), aws.AWSRole))...)
 		})); err != nil {
 			return err
 		} else {
@@ -520,16 +591,53 @@ func CreateAssumeRoleEdges(ctx context.Context, db graph.Database) error {
 
 // Get all paths from a principal to all resources
 func GetUnresolvedOutputPaths(ctx context.Context, db graph.Database, principalNode *graph.Node) (analyze.ActionPathSet, error) {
+	return getUnresolvedPolicyPaths(ctx, db, principalNode, aws.AttachedTo)
+}
+
+// Get the paths granted by the permissions boundary of a principal. The
+// returned bool is false if the principal has no boundary, in which case
+// the boundary doesn't limit anything.
+func GetPermissionsBoundaryPaths(ctx context.Context, db graph.Database, principalNode *graph.Node) (*analyze.ActionPathSet, bool, error) {
+	query := "MATCH (a) <- [:PermissionsBoundary] - (:AWSManagedPolicy) WHERE ID(a) = $principalId RETURN COUNT(a) > 0"
+	params := map[string]any{
+		"principalId": principalNode.ID,
+	}
+
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, false, err
+	}
+
+	hasBoundary := false
+	for _, result := range results {
+		err = result.Map(&hasBoundary)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	if !hasBoundary {
+		return nil, false, nil
+	}
+
+	paths, err := getUnresolvedPolicyPaths(ctx, db, principalNode, aws.PermissionsBoundary)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &paths, true, nil
+}
+
+func getUnresolvedPolicyPaths(ctx context.Context, db graph.Database, principalNode *graph.Node, attachment graph.Kind) (analyze.ActionPathSet, error) {
 	// First, get all resources that this principal has a path to, regardless of deny or allow
-	query := "MATCH p=(a:AWSUser|AWSRole) <- [:AttachedTo] - (:AWSManagedPolicy|AWSInlinePolicy) <- [:AttachedTo*2..3] - (s:AWSStatement) - [:Resource|ExpandsTo*1..2] -> (b:UniqueArn) " +
-		"WHERE ID(a) = %d AND a.account_id = b.account_id OR b.account_id = '' " +
+	query := "MATCH p=(a:AWSUser|AWSRole|AWSGroup) <- [:%s] - (:AWSManagedPolicy|AWSInlinePolicy) <- [:AttachedTo*2..3] - (s:AWSStatement) - [:Resource|ExpandsTo*1..2] -> (b:UniqueArn) " +
+		"WHERE ID(a) = %d AND (a.account_id = b.account_id OR b.account_id = '') " +
 		"WITH a, s, b " +
-		"MATCH p2=(a) <- [:AttachedTo*3..4] - (s) - [:Action|ExpandsTo*1..2] -> (act:AWSAction) " +
+		"MATCH p2=(s) - [:Action|ExpandsTo*1..2] -> (act:AWSAction) " +
 		"WHERE (act) - [:ActsOn] -> (:AWSResourceType) <- [:TypeOf] - (b) " +
 		"OPTIONAL MATCH (s) <- [:AttachedTo] - (c:AWSCondition) " +
 		"RETURN a, b, s, act.name, COALESCE(c IS NOT NULL, false)"
 
-	formatted_query := fmt.Sprintf(query, principalNode.ID)
+	formatted_query := fmt.Sprintf(query, attachment.String(), principalNode.ID)
 	log.Print(formatted_query)
 	actionPathSet := analyze.ActionPathSet{}
 	result, err := RawCypherQuery(ctx, db, formatted_query, nil)
@@ -729,8 +837,9 @@ func GetPathsWithStatement(paths graph.PathSet, statement *graph.Node) graph.Pat
 }
 
 func GenerateAssumeRolePolicy(ctx context.Context, db graph.Database, roleId string) (map[string]any, error) {
-	statementQuery := "MATCH (a:AWSRole {roleid: $roleId}) <- [:AttachedTo] - (p:AWSAssumeRolePolicy) <- [:AttachedTo] - (s:AWSStatement) RETURN s"
 	statementParams := map[string]any{"roleId": roleId}
+	statementQuery := "MATCH (a:AWSRole {roleid: $roleId}) <- [:AttachedTo] - (p:AWSAssumeRolePolicy) <- [:AttachedTo] - (s:AWSStatement) " +
+		"WHERE " + collectionFilter(ctx, "a", statementParams) + " RETURN s"
 
 	statementResults, err := RawCypherQuery(ctx, db, statementQuery, statementParams)
 
@@ -770,8 +879,9 @@ func GenerateAssumeRolePolicy(ctx context.Context, db graph.Database, roleId str
 func GenerateInlinePolicyObject(ctx context.Context, db graph.Database, policyHash string) (map[string]any, error) {
 
 	// Get each statement in the policy
-	statementQuery := "MATCH (p:AWSInlinePolicy) <- [:AttachedTo*2] - (s:AWSStatement) WHERE p.hash = $policy_hash RETURN s"
 	statementParams := map[string]any{"policy_hash": policyHash}
+	statementQuery := "MATCH (p:AWSInlinePolicy) <- [:AttachedTo*2] - (s:AWSStatement) WHERE p.hash = $policy_hash AND " +
+		collectionFilter(ctx, "p", statementParams) + " RETURN s"
 
 	statementResults, err := RawCypherQuery(ctx, db, statementQuery, statementParams)
 
@@ -806,8 +916,9 @@ func GenerateInlinePolicyObject(ctx context.Context, db graph.Database, policyHa
 
 func GenerateManagedPolicyObject(ctx context.Context, db graph.Database, policyId string) (map[string]any, error) {
 
-	statementQuery := "MATCH (p:AWSManagedPolicy) <- [:AttachedTo*3] - (s:AWSStatement) WHERE p.policyid = $policy_id RETURN s"
 	statementParams := map[string]any{"policy_id": policyId}
+	statementQuery := "MATCH (p:AWSManagedPolicy) <- [:AttachedTo*3] - (s:AWSStatement) WHERE p.policyid = $policy_id AND " +
+		collectionFilter(ctx, "p", statementParams) + " RETURN s"
 
 	statementResults, err := RawCypherQuery(ctx, db, statementQuery, statementParams)
 
@@ -935,8 +1046,9 @@ func GenerateConditionKeysObject(ctx context.Context, db graph.Database, conditi
 }
 
 func GetResouceActions(ctx context.Context, db graph.Database, resourceArn string) ([]string, error) {
-	query := "MATCH (a:UniqueArn {arn: $resource_arn}) - [:TypeOf] - > () <- [:ActsOn] - (act:AWSAction) RETURN act.name"
 	params := map[string]any{"resource_arn": resourceArn}
+	query := "MATCH (a:UniqueArn {arn: $resource_arn}) - [:TypeOf] - > () <- [:ActsOn] - (act:AWSAction) " +
+		"WHERE " + collectionFilter(ctx, "a", params) + " RETURN act.name"
 
 	results, err := RawCypherQuery(ctx, db, query, params)
 	if err != nil {
@@ -959,9 +1071,9 @@ func GetResouceActions(ctx context.Context, db graph.Database, resourceArn strin
 }
 
 func GetPoliciesAttachedToStatement(ctx context.Context, db graph.Database, statementHash string) ([]graph.Path, error) {
-	query := "MATCH p=(a:AWSStatement {hash: $hash}) - [:AttachedTo*2..3] -> (b:AWSManagedPolicy|AWSInlinePolicy) RETURN p"
-
 	queryParams := map[string]any{"hash": statementHash}
+	query := "MATCH p=(a:AWSStatement {hash: $hash}) - [:AttachedTo*2..3] -> (b:AWSManagedPolicy|AWSInlinePolicy) " +
+		"WHERE " + collectionFilter(ctx, "a", queryParams) + " RETURN p"
 
 	results, err := RawCypherQuery(ctx, db, query, queryParams)
 
@@ -1131,22 +1243,22 @@ func GenerateStatementObject(ctx context.Context, db graph.Database, statement g
 
 func GetAllUnresolvedIdentityPolicyPathsOnArnWithArnsAndActions(ctx context.Context, db graph.Database, roleId string, actionName string, sourceArns []string) (*analyze.ActionPathSet, error) {
 
+	params := map[string]any{
+		"roleId":     roleId,
+		"sourceArns": sourceArns,
+		"actionName": actionName,
+	}
+
 	query := "MATCH (b:AWSRole) " +
-		"WHERE b.roleid = $roleId " +
+		"WHERE b.roleid = $roleId AND " + collectionFilter(ctx, "b", params) + " " +
 		"MATCH (a:AWSUser|AWSRole) " +
-		"WHERE a.arn in $sourceArns " +
+		"WHERE a.arn in $sourceArns AND " + collectionFilter(ctx, "a", params) + " " +
 		"MATCH p1=(a) <- [:AttachedTo*3..4] - (s:AWSStatement) - [:Resource|ExpandsTo*1..2] -> (b) " +
 		"WITH a, s, b " +
 		"MATCH p2 = (s) - [:Action|ExpandsTo*1..2] -> (act:AWSAction {name: $actionName}) - [:ActsOn] -> (:AWSResourceType) <- [:TypeOf] - (b) " +
 		"OPTIONAL MATCH (s) <- [:AttachedTo] - (c:AWSCondition) " +
 		"RETURN a, b, s, act.name, COALESCE(c IS NOT NULL, false)"
 
-	params := map[string]any{
-		"roleId":     roleId,
-		"sourceArns": sourceArns,
-		"actionName": actionName,
-	}
-
 	results, err := RawCypherQuery(ctx, db, query, params)
 	if err != nil {
 		return nil, err
@@ -1211,22 +1323,22 @@ func GetAllUnresolvedIdentityPolicyPathsOnArnWithArnsAndActions(ctx context.Cont
 
 func GetAllUnresolvedIdentityPolicyPathsOnArnWithAction(ctx context.Context, db graph.Database, targetArn string, actionName string) (*analyze.ActionPathSet, error) {
 
+	params := map[string]any{
+		"targetArn":  targetArn,
+		"actionName": actionName,
+	}
+
 	query := "MATCH (b:UniqueArn) " +
-		"WHERE b.arn = $targetArn " +
+		"WHERE b.arn = $targetArn AND " + collectionFilter(ctx, "b", params) + " " +
 		"MATCH (a:AWSUser|AWSRole) " +
 		// Some resources, like s3 buckets, don't have account ids
-		"WHERE a.account_id = b.account_id OR b.account_id = '' " +
+		"WHERE (a.account_id = b.account_id OR b.account_id = '') AND " + collectionFilter(ctx, "a", params) + " " +
 		"MATCH p1=(a) <- [:AttachedTo*3..4] - (s:AWSStatement) - [:Resource|ExpandsTo*1..2] -> (b) " +
 		"WITH a, s, b " +
 		"MATCH p2 = (s) - [:Action|ExpandsTo*1..2] -> (act:AWSAction {name: $actionName}) - [:ActsOn] -> (:AWSResourceType) <- [:TypeOf] - (b) " +
 		"OPTIONAL MATCH (s) <- [:AttachedTo] - (c:AWSCondition) " +
 		"RETURN a, b, s, act.name, COALESCE(c IS NOT NULL, false)"
 
-	params := map[string]any{
-		"targetArn":  targetArn,
-		"actionName": actionName,
-	}
-
 	results, err := RawCypherQuery(ctx, db, query, params)
 	if err != nil {
 		return nil, err
@@ -1291,9 +1403,10 @@ func GetAllUnresolvedIdentityPolicyPathsOnArnWithAction(ctx context.Context, db
 
 func GetAllUnresolvedIdentityPolicyPathsOnArn(ctx context.Context, db graph.Database, arn string) (*analyze.ActionPathSet, error) {
 
-	query := "MATCH (b:UniqueArn) WHERE b.arn = '%s' " +
+	params := map[string]any{}
+	query := "MATCH (b:UniqueArn) WHERE b.arn = '%s' AND " + collectionFilter(ctx, "b", params) + " " +
 		"MATCH (a:AWSUser|AWSRole) " +
-		"WHERE a.account_id = b.account_id OR b.account_id = '' " +
+		"WHERE (a.account_id = b.account_id OR b.account_id = '') AND " + collectionFilter(ctx, "a", params) + " " +
 		"OPTIONAL MATCH p1=(a) <- [:AttachedTo*3..4] - (s1:AWSStatement) - [:Resource|ExpandsTo*1..2] -> (b) WHERE (s1) - [:Action|ExpandsTo*1..2] -> (:AWSAction) - [:ActsOn] -> (:AWSResourceType) <- [:TypeOf] - (b) " +
 		"OPTIONAL MATCH p2=(a) - [:MemberOf] -> (:AWSGroup) <- [:AttachedTo*3..4] - (s2:AWSStatement) - [:Resource|ExpandsTo*1..2] -> (b) WHERE (s2) - [:Action|ExpandsTo*1..2] -> (:AWSAction) - [:ActsOn] -> (:AWSResourceType) <- [:TypeOf] - (b) " +
 		"WITH collect(p1) + collect(p2) AS paths, collect(s1) + collect(s2) as statements, b, a WHERE paths IS NOT NULL " +
@@ -1306,7 +1419,7 @@ func GetAllUnresolvedIdentityPolicyPathsOnArn(ctx context.Context, db graph.Data
 	formatted_query := fmt.Sprintf(query, arn)
 
 	log.Printf("%s", formatted_query)
-	results, err := RawCypherQuery(ctx, db, formatted_query, nil)
+	results, err := RawCypherQuery(ctx, db, formatted_query, params)
 	if err != nil {
 		return nil, err
 	}
@@ -1370,8 +1483,13 @@ func GetAllUnresolvedIdentityPolicyPathsOnArn(ctx context.Context, db graph.Data
 
 func GetAllUnresolvedIdentityPolicyPathsOnArnFromArn(ctx context.Context, db graph.Database, arn string, principalArn string) (*analyze.ActionPathSet, error) {
 
-	query := "MATCH (b:UniqueArn) WHERE b.arn = $destArn " +
-		"MATCH (a:AWSUser|AWSRole) WHERE a.arn = $sourceArn " +
+	params := map[string]any{
+		"destArn":   arn,
+		"sourceArn": principalArn,
+	}
+
+	query := "MATCH (b:UniqueArn) WHERE b.arn = $destArn AND " + collectionFilter(ctx, "b", params) + " " +
+		"MATCH (a:AWSUser|AWSRole) WHERE a.arn = $sourceArn AND " + collectionFilter(ctx, "a", params) + " " +
 		"OPTIONAL MATCH p1=(a) <- [:AttachedTo*3..4] - (s1:AWSStatement) - [:Resource|ExpandsTo*1..2] -> (b) WHERE (s1) - [:Action|ExpandsTo*1..2] -> (:AWSAction) - [:ActsOn] -> (:AWSResourceType) <- [:TypeOf] - (b) " +
 		"OPTIONAL MATCH p2=(a) - [:MemberOf] -> (:AWSGroup) <- [:AttachedTo*3..4] - (s2:AWSStatement) - [:Resource|ExpandsTo*1..2] -> (b) WHERE (s2) - [:Action|ExpandsTo*1..2] -> (:AWSAction) - [:ActsOn] -> (:AWSResourceType) <- [:TypeOf] - (b) " +
 		"WITH collect(p1) + collect(p2) AS paths, collect(s1) + collect(s2) as statements, b, a WHERE paths IS NOT NULL " +
@@ -1381,11 +1499,6 @@ func GetAllUnresolvedIdentityPolicyPathsOnArnFromArn(ctx context.Context, db gra
 		"OPTIONAL MATCH (s) <- [:AttachedTo] - (c:AWSCondition) " +
 		"RETURN a, b, s, COALESCE(act1.name, act2.name), COALESCE(c IS NOT NULL, false)"
 
-	params := map[string]any{
-		"destArn":   arn,
-		"sourceArn": principalArn,
-	}
-
 	results, err := RawCypherQuery(ctx, db, query, params)
 	if err != nil {
 		return nil, err
@@ -1482,16 +1595,30 @@ func GetAWSRelationshipByGraphID(ctx context.Context, db graph.Database, id grap
 		rel, err = ops.FetchRelationship(tx, id)
 		return nil
 	})
+	if graph.IsErrNotFound(err) {
+		return nil, fmt.Errorf("relationship %d: %w", id, ErrNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Relationships are only visible when both their nodes are
+	for _, nodeID := range []graph.ID{rel.StartID, rel.EndID} {
+		if _, err := GetAWSNodeByGraphID(ctx, db, nodeID); errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("relationship %d: %w", id, ErrNotFound)
+		} else if err != nil {
+			return nil, err
+		}
+	}
 
-	return rel, err
+	return rel, nil
 }
 
 func GetPrincipalsOfPolicy(ctx context.Context, db graph.Database, policyID string) (graph.NodeSet, error) {
+	params := map[string]any{"policyID": policyID}
 	query := "MATCH (pol:AWSManagedPolicy {policyid: $policyID}) - [:AttachedTo] -> (prin:AWSUser|AWSRole|AWSGroup) " +
+		"WHERE " + collectionFilter(ctx, "pol", params) + " " +
 		"RETURN prin"
 
-	params := map[string]any{"policyID": policyID}
-
 	results, err := RawCypherQuery(ctx, db, query, params)
 
 	if err != nil {
@@ -1513,15 +1640,14 @@ func GetPrincipalsOfPolicy(ctx context.Context, db graph.Database, policyID stri
 }
 
 func GetNodesOfPolicy(ctx context.Context, db graph.Database, policyID graph.ID) (graph.PathSet, error) {
-	query := "MATCH p=(a:AWSManagedPolicy|AWSInlinePolicy) <- [:AttachedTo*2..3] - (s:AWSStatement) WHERE ID(a) = $policyID " +
+	params := map[string]any{"policyID": policyID}
+	query := "MATCH p=(a:AWSManagedPolicy|AWSInlinePolicy) <- [:AttachedTo*2..3] - (s:AWSStatement) WHERE ID(a) = $policyID AND " + collectionFilter(ctx, "a", params) + " " +
 		"WITH p, s " +
 		"MATCH p2 =(s) - [:Resource] -> () " +
 		"MATCH p3= (s) - [:Action] -> () " +
 		"OPTIONAL MATCH p4 = (s) <- [:AttachedTo] - (c:AWSCondition) <- [:AttachedTo*1..3] - () " +
 		"RETURN p, p2, p3, p4"
 
-	params := map[string]any{"policyID": policyID}
-
 	results, err := RawCypherQuery(ctx, db, query, params)
 
 	if err != nil {
@@ -1573,11 +1699,11 @@ func GetPoliciesOfEntity(ctx context.Context, db graph.Database, propertyName st
 	var err error
 
 	err = db.ReadTransaction(ctx, func(tx graph.Transaction) error {
-		if fetchedNodes, err := ops.FetchNodes(tx.Nodes().Filter(
+		if fetchedNodes, err := ops.FetchNodes(tx.Nodes().Filter(query.And(collectionCriteria(ctx,
 			query.Equals(
 				query.NodeProperty(propertyName),
 				strings.ToUpper(id),
-			))); err != nil {
+			))...))); err != nil {
 			return err
 		} else {
 			node = fetchedNodes[0]
@@ -0,0 +1,544 @@
+package queries
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/hotnops/apeman/analyze"
+	"github.com/hotnops/apeman/graphschema/aws"
+	"github.com/specterops/bloodhound/dawgs/graph"
+)
+
+// Terraform plans are analyzed on a scratch copy of a collection. The
+// proposed changes are applied to the copy, which is analyzed from scratch
+// and compared with the collection it was copied from. The copy is deleted
+// afterwards.
+
+// The number of nodes or relationships copied in one transaction
+const impactBatchSize = 1000
+
+// The label of each kind of IAM resource
+var iamResourceKinds = map[string]graph.Kind{
+	analyze.IAMResourceRole:   aws.AWSRole,
+	analyze.IAMResourceUser:   aws.AWSUser,
+	analyze.IAMResourceGroup:  aws.AWSGroup,
+	analyze.IAMResourcePolicy: aws.AWSManagedPolicy,
+}
+
+// The property the ingest stores the name of each kind of IAM resource in
+var iamResourceNameProperties = map[string]string{
+	analyze.IAMResourceRole:   "rolename",
+	analyze.IAMResourceUser:   "name",
+	analyze.IAMResourceGroup:  "name",
+	analyze.IAMResourcePolicy: "policyname",
+}
+
+// A change that can't be applied to the graph, which is reported rather
+// than failing the analysis
+type unresolvedChange string
+
+func (e unresolvedChange) Error() string {
+	return string(e)
+}
+
+func newImpactCollection() (string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return ScratchCollectionPrefix + hex.EncodeToString(suffix), nil
+}
+
+// Copy the ingested nodes and relationships of a collection into another.
+// Shared nodes, such as actions, aren't copied and the copies link to the
+// same shared nodes.
+func copyCollection(ctx context.Context, db graph.Database, source string, target string) error {
+	params := map[string]any{"source": source}
+	results, err := RawCypherQuery(ctx, db,
+		"MATCH (n) WHERE n.collection = $source AND n.layer = 1 RETURN n", params)
+	if err != nil {
+		return err
+	}
+
+	nodes := make([]*graph.Node, 0, len(results))
+	for _, result := range results {
+		node := &graph.Node{}
+		if err := result.Map(node); err != nil {
+			return err
+		}
+		nodes = append(nodes, node)
+	}
+
+	copies := map[graph.ID]graph.ID{}
+	for start := 0; start < len(nodes); start += impactBatchSize {
+		end := start + impactBatchSize
+		if end > len(nodes) {
+			end = len(nodes)
+		}
+		err := db.WriteTransaction(ctx, func(tx graph.Transaction) error {
+			for _, node := range nodes[start:end] {
+				properties := node.Properties.Clone()
+				properties.Set(string(aws.Collection), target)
+				copied, err := tx.CreateNode(properties, node.Kinds...)
+				if err != nil {
+					return err
+				}
+				copies[node.ID] = copied.ID
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	results, err = RawCypherQuery(ctx, db,
+		"MATCH (a) - [r {layer: 1}] -> (b) WHERE a.collection = $source OR b.collection = $source RETURN r", params)
+	if err != nil {
+		return err
+	}
+
+	relationships := make([]*graph.Relationship, 0, len(results))
+	for _, result := range results {
+		relationship := &graph.Relationship{}
+		if err := result.Map(relationship); err != nil {
+			return err
+		}
+		relationships = append(relationships, relationship)
+	}
+
+	for start := 0; start < len(relationships); start += impactBatchSize {
+		end := start + impactBatchSize
+		if end > len(relationships) {
+			end = len(relationships)
+		}
+		err := db.WriteTransaction(ctx, func(tx graph.Transaction) error {
+			for _, relationship := range relationships[start:end] {
+				startID, endID := relationship.StartID, relationship.EndID
+				if copied, ok := copies[startID]; ok {
+					startID = copied
+				}
+				if copied, ok := copies[endID]; ok {
+					endID = copied
+				}
+				if _, err := tx.CreateRelationshipByIDs(startID, endID, relationship.Kind, relationship.Properties.Clone()); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete every node of a collection, a batch at a time
+func deleteCollection(ctx context.Context, db graph.Database, collection string) error {
+	params := map[string]any{"collection": collection}
+	for {
+		results, err := RawCypherQuery(ctx, db, "MATCH (n) WHERE n.collection = $collection RETURN ID(n) LIMIT 1", params)
+		if err != nil {
+			return err
+		}
+		if len(results) == 0 {
+			return nil
+		}
+
+		err = RawCypherWrite(ctx, db, fmt.Sprintf("MATCH (n) WHERE n.collection = $collection "+
+			"WITH n LIMIT %d DETACH DELETE n", impactBatchSize), params)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Delete the scratch collections left behind by impact analyses that
+// didn't finish, such as when the server stopped during one. Only call
+// this when no analysis is running.
+func DeleteScratchCollections(ctx context.Context, db graph.Database) error {
+	params := map[string]any{"prefix": ScratchCollectionPrefix}
+	results, err := RawCypherQuery(ctx, db, "MATCH (n) WHERE n.collection STARTS WITH $prefix RETURN DISTINCT n.collection", params)
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		var collection string
+		if err := result.Map(&collection); err != nil {
+			continue
+		}
+		log.Printf("[*] Deleting stale collection %s", collection)
+		if err := deleteCollection(ctx, db, collection); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The account that principals created by the plan belong to. Without an
+// account ID, it's taken from the ARNs in the plan, or from the collection
+// if it holds a single account. Returns an empty string if it isn't known.
+func getImpactAccountID(ctx context.Context, db graph.Database, accountID string, changes []analyze.IAMChange) (string, error) {
+	if accountID != "" {
+		return accountID, nil
+	}
+
+	for _, change := range changes {
+		for _, arn := range []string{change.Arn, change.PolicyArn} {
+			if id := analyze.GetAccountIDFromArn(arn); accountNumberRegex.MatchString(id) {
+				return id, nil
+			}
+		}
+	}
+
+	accountIDs, err := GetIngestedAccountIDs(ctx, db)
+	if err != nil {
+		return "", err
+	}
+	if len(accountIDs) == 1 {
+		for id := range accountIDs {
+			return id, nil
+		}
+	}
+	return "", nil
+}
+
+// impactApplier applies the changes of a plan to the collection in ctx
+type impactApplier struct {
+	ctx       context.Context
+	db        graph.Database
+	accountID string
+	// The hash salt of the collection that was copied
+	salt string
+	// The principals and policies the plan changes, by configuration
+	// address
+	planned map[string]analyze.IAMChange
+	// The ARNs of the principals whose permissions may change
+	affected map[string]bool
+}
+
+func (a *impactApplier) write(delegate func(w *policyGraphWriter) error) error {
+	collection, _ := GetCollection(a.ctx)
+	return a.db.WriteTransaction(a.ctx, func(tx graph.Transaction) error {
+		return delegate(&policyGraphWriter{tx: tx, collection: collection, salt: a.salt})
+	})
+}
+
+func (a *impactApplier) getStrings(query string, params map[string]any) ([]string, error) {
+	query = fmt.Sprintf(query, collectionFilter(a.ctx, "n", params))
+	return getStringColumn(a.ctx, a.db, query, params)
+}
+
+// The ARN of a principal or policy. Resources already in the graph are
+// found by name, and the ARN of anything else is the one IAM will give it.
+func (a *impactApplier) getArn(change analyze.IAMChange) (string, error) {
+	if change.Arn != "" {
+		return change.Arn, nil
+	}
+	if change.Name == "" {
+		return "", unresolvedChange(fmt.Sprintf("the %s name is not known until apply", change.Resource))
+	}
+
+	kind := iamResourceKinds[change.Resource]
+	arns, err := a.getStrings(fmt.Sprintf("MATCH (n:%s {%s: $name}) WHERE %%s RETURN n.arn LIMIT 1",
+		kind, iamResourceNameProperties[change.Resource]), map[string]any{"name": change.Name})
+	if err != nil {
+		return "", err
+	}
+	if len(arns) > 0 {
+		return arns[0], nil
+	}
+
+	if a.accountID == "" {
+		return "", unresolvedChange(fmt.Sprintf("the account of %s %s is not known", change.Resource, change.Name))
+	}
+	return analyze.GetIAMArn(a.accountID, change.Resource, change.Path, change.Name), nil
+}
+
+// The ARN of the principal an inline policy or attachment belongs to
+func (a *impactApplier) getPrincipalArn(change analyze.IAMChange) (string, error) {
+	if planned, ok := a.planned[change.PrincipalReference]; ok {
+		return a.getArn(planned)
+	}
+	if change.PrincipalName == "" {
+		return "", unresolvedChange(fmt.Sprintf("the %s is not known until apply", change.PrincipalKind))
+	}
+	return a.getArn(analyze.IAMChange{Resource: change.PrincipalKind, Name: change.PrincipalName})
+}
+
+func (a *impactApplier) getPolicyArn(change analyze.IAMChange) (string, error) {
+	if change.PolicyArn != "" {
+		return change.PolicyArn, nil
+	}
+	if planned, ok := a.planned[change.PolicyReference]; ok {
+		return a.getArn(planned)
+	}
+	return "", unresolvedChange("the policy_arn is not known until apply")
+}
+
+// Mark the principal as affected, along with the members of a group and
+// the principals a policy is attached to
+func (a *impactApplier) addAffected(arn string) error {
+	a.affected[arn] = true
+	arns, err := a.getStrings("MATCH (p:UniqueArn {arn: $arn}) "+
+		"MATCH (n:AWSUser|AWSRole|AWSGroup) WHERE ((n) - [:MemberOf] -> (p) OR (p) - [:AttachedTo] -> (n)) AND %s "+
+		"RETURN DISTINCT n.arn", map[string]any{"arn": arn})
+	if err != nil {
+		return err
+	}
+	for _, arn := range arns {
+		a.affected[arn] = true
+	}
+	return nil
+}
+
+func (a *impactApplier) applyResource(change analyze.IAMChange) error {
+	arn, err := a.getArn(change)
+	if err != nil {
+		return err
+	}
+	if err := a.addAffected(arn); err != nil {
+		return err
+	}
+
+	if change.Action == analyze.IAMChangeDelete {
+		return a.write(func(w *policyGraphWriter) error {
+			return w.run("MATCH (n:UniqueArn {arn: $arn, collection: $collection}) DETACH DELETE n",
+				map[string]any{"arn": arn})
+		})
+	}
+
+	path := change.Path
+	if path == "" {
+		path = "/"
+	}
+	return a.write(func(w *policyGraphWriter) error {
+		err := w.run(fmt.Sprintf("MERGE (n:UniqueArn {arn: $arn, collection: $collection}) "+
+			"SET n:%s, n.%s = $name, n.path = $path, n.layer = 1, n.%sid = COALESCE(n.%sid, $id) "+
+			"REMOVE n.inferred", iamResourceKinds[change.Resource], iamResourceNameProperties[change.Resource],
+			change.Resource, change.Resource),
+			map[string]any{
+				"arn":  arn,
+				"name": change.Name,
+				"path": path,
+				"id":   "proposed:" + change.Name,
+			})
+		if err != nil || change.Document == nil {
+			return err
+		}
+
+		if change.Resource == analyze.IAMResourceRole {
+			return w.writeTrustPolicy(arn, change.Document)
+		} else if change.Resource == analyze.IAMResourcePolicy {
+			return w.writeManagedPolicyDocument(arn, change.Document)
+		}
+		return nil
+	})
+}
+
+func (a *impactApplier) applyInlinePolicy(change analyze.IAMChange) error {
+	principalArn, err := a.getPrincipalArn(change)
+	if err != nil {
+		return err
+	}
+	if err := a.addAffected(principalArn); err != nil {
+		return err
+	}
+
+	return a.write(func(w *policyGraphWriter) error {
+		if change.Action == analyze.IAMChangeDelete {
+			return w.removeInlinePolicy(principalArn, change.Name)
+		}
+		return w.writeInlinePolicy(principalArn, change.Name, change.Document)
+	})
+}
+
+func (a *impactApplier) applyAttachment(change analyze.IAMChange) error {
+	principalArn, err := a.getPrincipalArn(change)
+	if err != nil {
+		return err
+	}
+	policyArn, err := a.getPolicyArn(change)
+	if err != nil {
+		return err
+	}
+
+	policies, err := a.getStrings("MATCH (n:AWSManagedPolicy {arn: $arn}) WHERE %s RETURN n.arn",
+		map[string]any{"arn": policyArn})
+	if err != nil {
+		return err
+	}
+	if len(policies) == 0 {
+		return unresolvedChange(fmt.Sprintf("policy %s is not in the graph", policyArn))
+	}
+	if err := a.addAffected(principalArn); err != nil {
+		return err
+	}
+
+	query := "MATCH (p:AWSManagedPolicy {arn: $policy, collection: $collection}), (n:UniqueArn {arn: $principal, collection: $collection}) " +
+		"MERGE (p) - [:AttachedTo {layer: 1}] -> (n)"
+	if change.Action == analyze.IAMChangeDelete {
+		query = "MATCH (p:AWSManagedPolicy {arn: $policy, collection: $collection}) - [r:AttachedTo] -> (n:UniqueArn {arn: $principal, collection: $collection}) " +
+			"DELETE r"
+	}
+	return a.write(func(w *policyGraphWriter) error {
+		return w.run(query, map[string]any{
+			"policy":    policyArn,
+			"principal": principalArn,
+		})
+	})
+}
+
+func (a *impactApplier) apply(change analyze.IAMChange) error {
+	switch change.Resource {
+	case analyze.IAMResourceInline:
+		return a.applyInlinePolicy(change)
+	case analyze.IAMResourceAttachment:
+		return a.applyAttachment(change)
+	default:
+		return a.applyResource(change)
+	}
+}
+
+// The effective permissions of a principal, as resources mapped to
+// actions. A principal that doesn't exist has none.
+func getPrincipalRSOPByArn(ctx context.Context, db graph.Database, arn string) (analyze.PrincipalToActionMap, error) {
+	params := map[string]any{"arn": arn}
+	results, err := RawCypherQuery(ctx, db, "MATCH (n:UniqueArn {arn: $arn}) "+
+		"WHERE (n:AWSUser OR n:AWSRole OR n:AWSGroup) AND "+collectionFilter(ctx, "n", params)+" RETURN n LIMIT 1", params)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return analyze.PrincipalToActionMap{}, nil
+	}
+
+	node := &graph.Node{}
+	if err := results[0].Map(node); err != nil {
+		return nil, err
+	}
+
+	paths, err := GetUnresolvedOutputPaths(ctx, db, node)
+	if err != nil {
+		return nil, err
+	}
+	resolvedPaths, err := analyze.ResolveResourceAgainstIdentityPolicies(&analyze.ActionPathSet{}, &paths)
+	if err != nil {
+		return nil, err
+	}
+	return analyze.ResourcePathSetToMap(*resolvedPaths), nil
+}
+
+// Apply the changes of a Terraform plan to a copy of the collection in
+// ctx, or the default collection, and report how the attack paths and
+// effective permissions would change. The collection has to be analyzed
+// already, as it is the baseline. Changes that can't be applied are added
+// to skipped.
+func AnalyzeTerraformImpact(ctx context.Context, db graph.Database, changes []analyze.IAMChange, skipped []analyze.SkippedChange, accountID string) (*analyze.ImpactReport, error) {
+	source, ok := GetCollection(ctx)
+	if !ok {
+		source = DefaultCollection
+	}
+	sourceCtx := WithCollection(ctx, source)
+
+	scratch, err := newImpactCollection()
+	if err != nil {
+		return nil, err
+	}
+	scratchCtx := WithCollection(ctx, scratch)
+	defer func() {
+		if err := deleteCollection(ctx, db, scratch); err != nil {
+			log.Printf("[!] Failed deleting collection %s: %v", scratch, err)
+		}
+	}()
+
+	log.Printf("[*] Copying collection %s to %s", source, scratch)
+	if err := copyCollection(ctx, db, source, scratch); err != nil {
+		return nil, err
+	}
+
+	accountID, err = getImpactAccountID(sourceCtx, db, accountID, changes)
+	if err != nil {
+		return nil, err
+	}
+
+	applier := &impactApplier{
+		ctx:       scratchCtx,
+		db:        db,
+		accountID: accountID,
+		salt:      GetHashSalt(source),
+		planned:   map[string]analyze.IAMChange{},
+		affected:  map[string]bool{},
+	}
+	for _, change := range changes {
+		if _, ok := iamResourceKinds[change.Resource]; ok {
+			applier.planned[analyze.GetTerraformConfigAddress(change.Address)] = change
+		}
+	}
+
+	report := &analyze.ImpactReport{
+		Collection: source,
+		Applied:    []analyze.IAMChange{},
+		Skipped:    skipped,
+		RSOP:       []analyze.RSOPChange{},
+	}
+	for _, change := range changes {
+		err := applier.apply(change)
+		if unresolved, ok := err.(unresolvedChange); ok {
+			report.Skipped = append(report.Skipped, analyze.SkippedChange{Address: change.Address, Reason: string(unresolved)})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		report.Applied = append(report.Applied, change)
+	}
+
+	if err := ExpandGraph(scratchCtx, db); err != nil {
+		return nil, err
+	}
+	if err := CreateIdentityTransformEdges(scratchCtx, db); err != nil {
+		return nil, err
+	}
+	if _, err := AnalyzeTierZero(scratchCtx, db); err != nil {
+		return nil, err
+	}
+
+	current, err := CaptureSnapshot(sourceCtx, db, "current")
+	if err != nil {
+		return nil, err
+	}
+	proposed, err := CaptureSnapshot(scratchCtx, db, "proposed")
+	if err != nil {
+		return nil, err
+	}
+	report.Graph = analyze.DiffSnapshots(current, proposed)
+
+	principals := []string{}
+	for arn := range applier.affected {
+		principals = append(principals, arn)
+	}
+	sort.Strings(principals)
+
+	for _, arn := range principals {
+		before, err := getPrincipalRSOPByArn(sourceCtx, db, arn)
+		if err != nil {
+			return nil, err
+		}
+		after, err := getPrincipalRSOPByArn(scratchCtx, db, arn)
+		if err != nil {
+			return nil, err
+		}
+		if change := analyze.DiffRSOP(arn, before, after); !change.IsEmpty() {
+			report.RSOP = append(report.RSOP, change)
+		}
+	}
+
+	return report, nil
+}
@@ -2,7 +2,10 @@ package queries
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/url"
+	"strconv"
 
 	"github.com/hotnops/apeman/graphschema/aws"
 	"github.com/specterops/bloodhound/dawgs/graph"
@@ -19,11 +22,18 @@ func GetAllAWSNodes(ctx context.Context, db graph.Database, parameters url.Value
 			for key := range parameters {
 				if key == "kind" {
 					criteria = append(criteria, query.Kind(query.Node(), graph.StringKind(parameters.Get(key))))
+				} else if value, err := strconv.ParseBool(parameters.Get(key)); err == nil {
+					// Flags set by analysis are stored as booleans, but may
+					// also have been ingested as strings
+					criteria = append(criteria, query.Or(
+						query.Equals(query.NodeProperty(key), value),
+						query.Equals(query.NodeProperty(key), parameters.Get(key)),
+					))
 				} else {
 					criteria = append(criteria, query.Equals(query.NodeProperty(key), parameters.Get(key)))
 				}
 			}
-			return query.And(criteria...)
+			return query.And(collectionCriteria(ctx, criteria...)...)
 		})); err != nil {
 			return err
 		} else {
@@ -37,6 +47,17 @@ func GetAllAWSNodes(ctx context.Context, db graph.Database, parameters url.Value
 	return nodes, nil
 }
 
+// ErrNotFound is returned when a node doesn't exist in the collection in
+// ctx
+var ErrNotFound = errors.New("not found")
+
+// Whether a node fetched by ID can be seen from the collection in ctx. As
+// with collectionCriteria, the AWS schema nodes belong to no collection and
+// stay visible.
+func isVisible(ctx context.Context, node *graph.Node) bool {
+	return !node.Properties.Exists(string(aws.Collection)) || inCollection(ctx, node)
+}
+
 func GetAWSNodeByGraphID(ctx context.Context, db graph.Database, id graph.ID) (*graph.Node, error) {
 	var node *graph.Node
 	err := db.ReadTransaction(ctx, func(tx graph.Transaction) error {
@@ -47,18 +68,21 @@ func GetAWSNodeByGraphID(ctx context.Context, db graph.Database, id graph.ID) (*
 			return nil
 		}
 	})
+	if graph.IsErrNotFound(err) || (err == nil && !isVisible(ctx, node)) {
+		return nil, fmt.Errorf("node %d: %w", id, ErrNotFound)
+	}
 	return node, err
 }
 
 func GetAWSNodeByKindID(ctx context.Context, db graph.Database, propertyName string, id string, kind graph.Kind) (*graph.Node, error) {
 	var nodes = graph.NewNodeSet()
 
-	db.ReadTransaction(ctx, func(tx graph.Transaction) error {
+	err := db.ReadTransaction(ctx, func(tx graph.Transaction) error {
 		if fetchedNodes, err := ops.FetchNodeSet(tx.Nodes().Filterf(func() graph.Criteria {
-			return query.And(
+			return query.And(collectionCriteria(ctx,
 				query.Equals(query.NodeProperty(propertyName), id),
 				query.Kind(query.Node(), kind),
-			)
+			)...)
 		})); err != nil {
 			return err
 		} else {
@@ -66,11 +90,20 @@ func GetAWSNodeByKindID(ctx context.Context, db graph.Database, propertyName str
 			return nil
 		}
 	})
+	if err != nil {
+		return nil, err
+	}
+	if nodes.Len() == 0 {
+		return nil, fmt.Errorf("%s with %s %s: %w", kind, propertyName, id, ErrNotFound)
+	}
 
 	return nodes.Slice()[0], nil
 }
 
 func GetAWSNodeEdges(ctx context.Context, db graph.Database, id graph.ID, direction graph.Direction, queryParams url.Values) ([]*graph.Relationship, error) {
+	if _, err := GetAWSNodeByGraphID(ctx, db, id); err != nil {
+		return nil, err
+	}
 
 	var returnValue []*graph.Relationship
 
@@ -140,3 +173,53 @@ func GetAWSNodeTags(ctx context.Context, db graph.Database, nodeID graph.ID) (gr
 
 	return graphSet, nil
 }
+
+// Get the access level of each of the given actions
+func GetActionAccessLevels(ctx context.Context, db graph.Database, actionNames []string) (map[string]string, error) {
+	query := fmt.Sprintf("MATCH (a:AWSAction) WHERE a.name IN $actionNames RETURN a.name, a.%s", aws.AccessLevel)
+	params := map[string]any{
+		"actionNames": actionNames,
+	}
+
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	accessLevels := map[string]string{}
+	for _, result := range results {
+		var actionName string
+		var accessLevel string
+		err = result.Map(&actionName)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&accessLevel)
+		if err != nil {
+			continue
+		}
+		accessLevels[actionName] = accessLevel
+	}
+
+	return accessLevels, nil
+}
+
+// Get the name of every action in the service authorization reference
+func GetActionNames(ctx context.Context, db graph.Database) ([]string, error) {
+	results, err := RawCypherQuery(ctx, db, "MATCH (a:AWSAction) RETURN a.name", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	actionNames := []string{}
+	for _, result := range results {
+		var actionName string
+		err = result.Map(&actionName)
+		if err != nil {
+			continue
+		}
+		actionNames = append(actionNames, actionName)
+	}
+
+	return actionNames, nil
+}
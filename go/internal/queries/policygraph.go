@@ -0,0 +1,501 @@
+package queries
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/specterops/bloodhound/dawgs/graph"
+	"github.com/zeebo/xxh3"
+)
+
+// policyGraphWriter writes policy documents into a collection the same way
+// the ingest does, so that the analyses treat them like ingested policies.
+type policyGraphWriter struct {
+	tx         graph.Transaction
+	collection string
+	// What the hashes are salted with. Writes to a copy of a collection
+	// use the salt of the original, so they merge with the copied nodes.
+	salt string
+}
+
+var policyVariableRegex = regexp.MustCompile(`\$\{.*?\}`)
+
+var accountNumberRegex = regexp.MustCompile(`^[0-9]{12}$`)
+
+func (w *policyGraphWriter) run(query string, params map[string]any) error {
+	params["collection"] = w.collection
+	result := w.tx.Run(query, params)
+	if err := result.Error(); err != nil {
+		return err
+	}
+	result.Close()
+	return nil
+}
+
+// GetHashSalt returns the salt the ingest uses for the hashes of a
+// collection. The default collection isn't salted.
+func GetHashSalt(collection string) string {
+	if collection == DefaultCollection {
+		return ""
+	}
+	return collection
+}
+
+// Write a JSON value the way Python's json.dumps(value, sort_keys=True)
+// does, which is what the ingest hashes
+func writePythonJSON(builder *strings.Builder, value any) {
+	switch typed := value.(type) {
+	case nil:
+		builder.WriteString("null")
+	case bool:
+		builder.WriteString(strconv.FormatBool(typed))
+	case float64:
+		if typed == math.Trunc(typed) && math.Abs(typed) < 1e16 {
+			builder.WriteString(strconv.FormatFloat(typed, 'f', 0, 64))
+		} else {
+			builder.WriteString(strconv.FormatFloat(typed, 'g', -1, 64))
+		}
+	case string:
+		builder.WriteByte('"')
+		for _, char := range typed {
+			switch {
+			case char == '"':
+				builder.WriteString(`\"`)
+			case char == '\\':
+				builder.WriteString(`\\`)
+			case char == '\n':
+				builder.WriteString(`\n`)
+			case char == '\r':
+				builder.WriteString(`\r`)
+			case char == '\t':
+				builder.WriteString(`\t`)
+			case char == '\b':
+				builder.WriteString(`\b`)
+			case char == '\f':
+				builder.WriteString(`\f`)
+			case char < 0x20 || (char > 0x7e && char <= 0xffff):
+				fmt.Fprintf(builder, `\u%04x`, char)
+			case char > 0xffff:
+				high, low := utf16.EncodeRune(char)
+				fmt.Fprintf(builder, `\u%04x\u%04x`, high, low)
+			default:
+				builder.WriteRune(char)
+			}
+		}
+		builder.WriteByte('"')
+	case []string:
+		items := []any{}
+		for _, item := range typed {
+			items = append(items, item)
+		}
+		writePythonJSON(builder, items)
+	case []any:
+		builder.WriteByte('[')
+		for i, item := range typed {
+			if i > 0 {
+				builder.WriteString(", ")
+			}
+			writePythonJSON(builder, item)
+		}
+		builder.WriteByte(']')
+	case map[string]any:
+		keys := []string{}
+		for key := range typed {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		builder.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				builder.WriteString(", ")
+			}
+			writePythonJSON(builder, key)
+			builder.WriteString(": ")
+			writePythonJSON(builder, typed[key])
+		}
+		builder.WriteByte('}')
+	default:
+		writePythonJSON(builder, fmt.Sprint(typed))
+	}
+}
+
+// HashPolicyElement hashes a policy element like the ingest: xxh128 of
+// its JSON with sorted keys, salted so identical policies in different
+// collections stay apart. The value has to be made of the types JSON
+// decodes into.
+func HashPolicyElement(salt string, value any) string {
+	var builder strings.Builder
+	builder.WriteString(salt)
+	writePythonJSON(&builder, value)
+	hash := xxh3.HashString128(builder.String()).Bytes()
+	return hex.EncodeToString(hash[:])
+}
+
+func (w *policyGraphWriter) hash(value any) string {
+	return HashPolicyElement(w.salt, value)
+}
+
+// The regex the ingest stores on blobs, matching the names a wildcard
+// covers
+func blobRegex(name string) string {
+	name = strings.ReplaceAll(name, ".", "\\.")
+	name = strings.ReplaceAll(name, "*", ".*")
+	name = strings.ReplaceAll(name, "?", "\\?")
+	name = strings.ReplaceAll(name, "[", "\\[")
+	return name
+}
+
+// Policy elements can be a single string or a list of them
+func getStringList(value any) []string {
+	switch typed := value.(type) {
+	case string:
+		return []string{typed}
+	case []any:
+		values := []string{}
+		for _, item := range typed {
+			values = append(values, fmt.Sprint(item))
+		}
+		return values
+	}
+	return nil
+}
+
+func getObjectList(value any) []map[string]any {
+	switch typed := value.(type) {
+	case map[string]any:
+		return []map[string]any{typed}
+	case []any:
+		objects := []map[string]any{}
+		for _, item := range typed {
+			if object, ok := item.(map[string]any); ok {
+				objects = append(objects, object)
+			}
+		}
+		return objects
+	}
+	return nil
+}
+
+// Link a statement to a node, creating the node if needed. Shared nodes,
+// such as actions, belong to no collection.
+func (w *policyGraphWriter) linkStatement(statementHash string, kind string, labels string, keyField string, key string, shared bool, properties map[string]any) error {
+	collectionKey := ", collection: $collection"
+	if shared {
+		collectionKey = ""
+	}
+
+	query := fmt.Sprintf("MATCH (s:AWSStatement {hash: $statement, collection: $collection}) "+
+		"MERGE (n:%s {%s: $key%s}) ON CREATE SET n += $properties, n.layer = 1 "+
+		"MERGE (s) - [:%s {layer: 1}] -> (n)", labels, keyField, collectionKey, kind)
+
+	return w.run(query, map[string]any{
+		"statement":  statementHash,
+		"key":        key,
+		"properties": properties,
+	})
+}
+
+func (w *policyGraphWriter) writeActions(statementHash string, actions []string, kind string) error {
+	for _, action := range actions {
+		action = strings.ToLower(action)
+		var err error
+		if strings.Contains(action, "*") {
+			err = w.linkStatement(statementHash, kind, "AWSActionBlob:UniqueName", "name", action, false,
+				map[string]any{"regex": blobRegex(action)})
+		} else {
+			err = w.linkStatement(statementHash, kind, "AWSAction:UniqueName", "name", action, true,
+				map[string]any{"inferred": true})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *policyGraphWriter) writeResources(statementHash string, resources []string, kind string) error {
+	for _, resource := range resources {
+		var err error
+		if strings.Contains(resource, "*") || strings.Contains(resource, "${") {
+			err = w.linkStatement(statementHash, kind, "AWSResourceBlob:UniqueName", "name", resource, false,
+				map[string]any{"regex": blobRegex(policyVariableRegex.ReplaceAllString(resource, "*"))})
+		} else if strings.HasPrefix(resource, "arn:") {
+			err = w.linkStatement(statementHash, kind, "UniqueArn", "arn", resource, false,
+				map[string]any{"inferred": true})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *policyGraphWriter) writePrincipals(statementHash string, principals any) error {
+	// Principal "*" is the same as {"AWS": "*"}
+	if principals == "*" {
+		principals = map[string]any{"AWS": "*"}
+	}
+	principalMap, ok := principals.(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	for _, principal := range getStringList(principalMap["AWS"]) {
+		blob := ""
+		if principal == "*" {
+			blob = "*"
+		} else if strings.HasPrefix(principal, "arn:") && strings.HasSuffix(principal, ":root") {
+			blob = strings.TrimSuffix(principal, "root") + "*"
+		} else if accountNumberRegex.MatchString(principal) {
+			blob = fmt.Sprintf("arn:aws:iam::%s:*", principal)
+		}
+
+		var err error
+		if blob != "" {
+			err = w.linkStatement(statementHash, "Principal", "AWSPrincipalBlob:UniqueName", "name", blob, false,
+				map[string]any{"regex": blobRegex(blob)})
+		} else if strings.HasPrefix(principal, "arn:") {
+			err = w.linkStatement(statementHash, "Principal", "UniqueArn", "arn", principal, false,
+				map[string]any{"inferred": true})
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, service := range getStringList(principalMap["Service"]) {
+		if err := w.linkStatement(statementHash, "Principal", "UniqueName", "name", service, false,
+			map[string]any{"inferred": true}); err != nil {
+			return err
+		}
+	}
+
+	for _, provider := range getStringList(principalMap["Federated"]) {
+		if err := w.linkStatement(statementHash, "Principal", "AWSIdentityProvider:UniqueName", "name", provider, false,
+			map[string]any{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Conditions are attached to the statement, with the operator, the keys
+// and their values attached to the condition
+func (w *policyGraphWriter) writeConditions(statementHash string, conditions map[string]any) error {
+	for operator, keys := range conditions {
+		keyMap, ok := keys.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		conditionHash := w.hash(map[string]any{operator: keys})
+		err := w.run("MATCH (s:AWSStatement {hash: $statement, collection: $collection}) "+
+			"MERGE (c:AWSCondition:UniqueHash {hash: $hash, collection: $collection}) ON CREATE SET c.sid = '', c.layer = 1 "+
+			"MERGE (c) - [:AttachedTo {layer: 1}] -> (s) "+
+			"MERGE (o:AWSOperator:UniqueName {name: $operator}) ON CREATE SET o.inferred = true, o.layer = 1 "+
+			"MERGE (o) - [:AttachedTo {layer: 1}] -> (c)", map[string]any{
+			"statement": statementHash,
+			"hash":      conditionHash,
+			"operator":  strings.ToLower(operator),
+		})
+		if err != nil {
+			return err
+		}
+
+		for key, values := range keyMap {
+			conditionValues := getStringList(values)
+			if conditionValues == nil {
+				conditionValues = []string{fmt.Sprint(values)}
+			}
+
+			err := w.run("MATCH (c:AWSCondition {hash: $condition, collection: $collection}) "+
+				"MERGE (k:AWSConditionKey:UniqueHash {hash: $hash, collection: $collection}) ON CREATE SET k.name = $name, k.layer = 1 "+
+				"MERGE (k) - [:AttachedTo {layer: 1}] -> (c) "+
+				"WITH k UNWIND $values AS value "+
+				"MERGE (v:AWSConditionValue:UniqueName {name: value}) ON CREATE SET v.layer = 1 "+
+				"MERGE (v) - [:AttachedTo {layer: 1}] -> (k)", map[string]any{
+				"condition": conditionHash,
+				"hash":      w.hash(map[string]any{key: values}),
+				"name":      key,
+				"values":    conditionValues,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *policyGraphWriter) writeStatement(statement map[string]any) (string, error) {
+	statementHash := w.hash(statement)
+	effect, _ := statement["Effect"].(string)
+	sid, _ := statement["Sid"].(string)
+
+	err := w.run("MERGE (s:AWSStatement:UniqueHash {hash: $hash, collection: $collection}) "+
+		"ON CREATE SET s.effect = $effect, s.sid = $sid, s.layer = 1", map[string]any{
+		"hash":   statementHash,
+		"effect": effect,
+		"sid":    sid,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if conditions, ok := statement["Condition"].(map[string]any); ok {
+		if err := w.writeConditions(statementHash, conditions); err != nil {
+			return "", err
+		}
+	}
+	if err := w.writeActions(statementHash, getStringList(statement["Action"]), "Action"); err != nil {
+		return "", err
+	}
+	if err := w.writeActions(statementHash, getStringList(statement["NotAction"]), "NotAction"); err != nil {
+		return "", err
+	}
+	if err := w.writeResources(statementHash, getStringList(statement["Resource"]), "Resource"); err != nil {
+		return "", err
+	}
+	if err := w.writeResources(statementHash, getStringList(statement["NotResource"]), "NotResource"); err != nil {
+		return "", err
+	}
+	if principals, ok := statement["Principal"]; ok {
+		if err := w.writePrincipals(statementHash, principals); err != nil {
+			return "", err
+		}
+	}
+
+	return statementHash, nil
+}
+
+// Write the statements of a document and attach them to a node with the
+// given labels and hash, which is created if needed
+func (w *policyGraphWriter) writeStatements(document map[string]any, labels string, hash string, properties map[string]any) error {
+	err := w.run(fmt.Sprintf("MERGE (d:%s {hash: $hash, collection: $collection}) "+
+		"ON CREATE SET d += $properties, d.layer = 1", labels), map[string]any{
+		"hash":       hash,
+		"properties": properties,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, statement := range getObjectList(document["Statement"]) {
+		statementHash, err := w.writeStatement(statement)
+		if err != nil {
+			return err
+		}
+		err = w.run("MATCH (s:AWSStatement {hash: $statement, collection: $collection}), (d:UniqueHash {hash: $hash, collection: $collection}) "+
+			"MERGE (s) - [:AttachedTo {layer: 1}] -> (d)", map[string]any{
+			"statement": statementHash,
+			"hash":      hash,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write a permissions policy document and return its hash
+func (w *policyGraphWriter) writePolicyDocument(document map[string]any) (string, error) {
+	documentHash := w.hash(document)
+	version, _ := document["Version"].(string)
+	return documentHash, w.writeStatements(document, "AWSPolicyDocument:UniqueHash", documentHash,
+		map[string]any{"version": version})
+}
+
+// Attach the node with the given hash to another node with a hash or ARN
+func (w *policyGraphWriter) attachHash(hash string, targetField string, target string) error {
+	targetLabel := "UniqueHash"
+	if targetField == "arn" {
+		targetLabel = "UniqueArn"
+	}
+	return w.run(fmt.Sprintf("MATCH (a:UniqueHash {hash: $hash, collection: $collection}), (b:%s {%s: $target, collection: $collection}) "+
+		"MERGE (a) - [:AttachedTo {layer: 1}] -> (b)", targetLabel, targetField), map[string]any{
+		"hash":   hash,
+		"target": target,
+	})
+}
+
+// Replace the trust policy of a role
+func (w *policyGraphWriter) writeTrustPolicy(roleArn string, document map[string]any) error {
+	err := w.run("MATCH (:AWSAssumeRolePolicy) - [r:AttachedTo] -> (:AWSRole {arn: $arn, collection: $collection}) DELETE r",
+		map[string]any{"arn": roleArn})
+	if err != nil {
+		return err
+	}
+
+	trustHash := w.hash(document)
+	version, _ := document["Version"].(string)
+	if err := w.writeStatements(document, "AWSAssumeRolePolicy:UniqueHash", trustHash,
+		map[string]any{"version": version, "sid": ""}); err != nil {
+		return err
+	}
+	return w.attachHash(trustHash, "arn", roleArn)
+}
+
+// Replace the default version of a managed policy
+func (w *policyGraphWriter) writeManagedPolicyDocument(policyArn string, document map[string]any) error {
+	err := w.run("MATCH (:AWSPolicyVersion) - [r:AttachedTo] -> (:AWSManagedPolicy {arn: $arn, collection: $collection}) DELETE r",
+		map[string]any{"arn": policyArn})
+	if err != nil {
+		return err
+	}
+
+	documentHash, err := w.writePolicyDocument(document)
+	if err != nil {
+		return err
+	}
+
+	versionHash := w.hash(map[string]any{"arn": policyArn, "document": documentHash})
+	err = w.run("MERGE (v:AWSPolicyVersion:UniqueHash {hash: $hash, collection: $collection}) "+
+		"ON CREATE SET v.versionid = 'proposed', v.isdefaultversion = true, v.layer = 1", map[string]any{
+		"hash": versionHash,
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.attachHash(documentHash, "hash", versionHash); err != nil {
+		return err
+	}
+	return w.attachHash(versionHash, "arn", policyArn)
+}
+
+func (w *policyGraphWriter) removeInlinePolicy(principalArn string, name string) error {
+	return w.run("MATCH (i:AWSInlinePolicy {policyname: $name}) - [r:AttachedTo] -> (:UniqueArn {arn: $arn, collection: $collection}) DELETE r",
+		map[string]any{"arn": principalArn, "name": name})
+}
+
+// Replace the inline policy of a principal with the same name
+func (w *policyGraphWriter) writeInlinePolicy(principalArn string, name string, document map[string]any) error {
+	if err := w.removeInlinePolicy(principalArn, name); err != nil {
+		return err
+	}
+
+	documentHash, err := w.writePolicyDocument(document)
+	if err != nil {
+		return err
+	}
+
+	inlineHash := w.hash(map[string]any{"PolicyName": name, "PolicyDocument": document})
+	err = w.run("MERGE (i:AWSInlinePolicy:UniqueHash {hash: $hash, collection: $collection}) "+
+		"ON CREATE SET i.policyname = $name, i.layer = 1", map[string]any{
+		"hash": inlineHash,
+		"name": name,
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.attachHash(documentHash, "hash", inlineHash); err != nil {
+		return err
+	}
+	return w.attachHash(inlineHash, "arn", principalArn)
+}
@@ -0,0 +1,112 @@
+package queries
+
+import (
+	"context"
+	"log"
+
+	"github.com/hotnops/apeman/analyze"
+	"github.com/hotnops/apeman/awsconditions"
+	"github.com/specterops/bloodhound/dawgs/graph"
+)
+
+// Get the action paths granted to the anonymous principal by any statement,
+// limited to the actions that act on the type of the resource the statement
+// is attached to. The resource type of each resource is returned alongside.
+func GetAnonymousActionPaths(ctx context.Context, db graph.Database) (*analyze.ActionPathSet, map[graph.ID]string, error) {
+	params := map[string]any{
+		"principal": analyze.AnonymousPrincipal,
+	}
+
+	query := "MATCH (s:AWSStatement) - [:Principal] -> (:AWSPrincipalBlob {name: $principal}) " +
+		"WHERE " + collectionFilter(ctx, "s", params) + " " +
+		"MATCH (s) - [:AttachedTo*2] -> (b:UniqueArn) " +
+		"MATCH (s) - [:Action|ExpandsTo*1..2] -> (act:AWSAction) - [:ActsOn] -> (rt:AWSResourceType) <- [:TypeOf] - (b) " +
+		"OPTIONAL MATCH (s) <- [:AttachedTo] - (c:AWSCondition) " +
+		"RETURN DISTINCT s, b, rt.name, act.name, COALESCE(c IS NOT NULL, false)"
+
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	actionPathSet := analyze.ActionPathSet{}
+	resourceTypes := map[graph.ID]string{}
+	statementConditions := map[graph.ID][]awsconditions.AWSCondition{}
+
+	for _, result := range results {
+		newActionPathEntry := analyze.ActionPathEntry{}
+		var statement graph.Node
+		var destNode graph.Node
+		var resourceType string
+		var action string
+		var conditionExists bool
+
+		err = result.Map(&statement)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&destNode)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&resourceType)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&action)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&conditionExists)
+		if err != nil {
+			continue
+		}
+
+		if conditionExists {
+			if _, ok := statementConditions[statement.ID]; !ok {
+				conditions, err := GetConditionsFromStatement(ctx, db, statement.ID)
+				if err != nil {
+					log.Printf("[!] Error getting conditions: %s", err.Error())
+					continue
+				}
+				statementConditions[statement.ID] = conditions
+			}
+			newActionPathEntry.Conditions = statementConditions[statement.ID]
+		}
+
+		effect, _ := statement.Properties.Get("effect").String()
+		destArn, _ := destNode.Properties.Get("arn").String()
+
+		newActionPathEntry.PrincipalArn = analyze.AnonymousPrincipal
+		newActionPathEntry.ResourceID = destNode.ID
+		newActionPathEntry.ResourceArn = destArn
+		newActionPathEntry.Effect = effect
+		newActionPathEntry.Action = action
+		newActionPathEntry.Statement = &statement
+		if conditionExists {
+			PopulateTags(ctx, db, &newActionPathEntry)
+		}
+		resourceTypes[destNode.ID] = resourceType
+		actionPathSet.Add(newActionPathEntry)
+	}
+
+	return &actionPathSet, resourceTypes, nil
+}
+
+// Find every resource the anonymous principal can reach once deny
+// statements and conditions have been evaluated
+func GetPublicExposures(ctx context.Context, db graph.Database) ([]analyze.PublicAccountExposure, error) {
+	anonymousPaths, resourceTypes, err := GetAnonymousActionPaths(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	// The anonymous principal has no identity policies, so only the
+	// resource policies decide access
+	resolvedPaths, err := analyze.ResolveResourceAgainstIdentityPolicies(anonymousPaths, &analyze.ActionPathSet{})
+	if err != nil {
+		return nil, err
+	}
+
+	return analyze.GroupPublicExposures(resolvedPaths, resourceTypes), nil
+}
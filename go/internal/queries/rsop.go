@@ -0,0 +1,108 @@
+package queries
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hotnops/apeman/analyze"
+	"github.com/specterops/bloodhound/dawgs/graph"
+)
+
+func IsGroupMember(ctx context.Context, db graph.Database, groupNode *graph.Node, memberNode *graph.Node) (bool, error) {
+	query := "MATCH (n:AWSUser) - [:MemberOf] -> (g:AWSGroup) WHERE ID(n) = $memberId AND ID(g) = $groupId RETURN COUNT(n) > 0"
+	params := map[string]any{
+		"memberId": memberNode.ID,
+		"groupId":  groupNode.ID,
+	}
+
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return false, err
+	}
+
+	isMember := false
+	for _, result := range results {
+		err = result.Map(&isMember)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return isMember, nil
+}
+
+// Get the resolved paths a group grants to one of its members. The group's
+// policies are evaluated as the member, so principal tags and ARNs in
+// conditions refer to the member, and the member's own deny statements and
+// permissions boundary are applied on top.
+func GetEffectiveGroupPathsForMember(ctx context.Context, db graph.Database, groupNode *graph.Node, memberNode *graph.Node) (*analyze.ActionPathSet, error) {
+	isMember, err := IsGroupMember(ctx, db, groupNode, memberNode)
+	if err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return nil, fmt.Errorf("principal %d is not a member of group %d", memberNode.ID, groupNode.ID)
+	}
+
+	groupPaths, err := GetUnresolvedOutputPaths(ctx, db, groupNode)
+	if err != nil {
+		return nil, err
+	}
+
+	memberArn, _ := memberNode.Properties.Get("arn").String()
+	for i := range groupPaths {
+		groupPaths[i].PrincipalID = memberNode.ID
+		groupPaths[i].PrincipalArn = memberArn
+		if len(groupPaths[i].Conditions) > 0 {
+			PopulateTags(ctx, db, &groupPaths[i])
+		}
+	}
+
+	memberPaths, err := GetUnresolvedOutputPaths(ctx, db, memberNode)
+	if err != nil {
+		return nil, err
+	}
+	_, memberDeny, _, memberCondDeny := memberPaths.SplitByConditionalEffect()
+	groupPaths.AddPathSet(*memberDeny)
+	groupPaths.AddPathSet(*memberCondDeny)
+
+	resolvedPaths, err := analyze.ResolveResourceAgainstIdentityPolicies(&analyze.ActionPathSet{}, &groupPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	boundaryPaths, hasBoundary, err := GetPermissionsBoundaryPaths(ctx, db, memberNode)
+	if err != nil {
+		return nil, err
+	}
+	if !hasBoundary {
+		return resolvedPaths, nil
+	}
+
+	resolvedBoundaryPaths, err := analyze.ResolveResourceAgainstIdentityPolicies(&analyze.ActionPathSet{}, boundaryPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	return analyze.ApplyPermissionsBoundary(resolvedPaths, resolvedBoundaryPaths), nil
+}
+
+// Summarize resolved paths by service and access level
+func GetServiceAccessSummary(ctx context.Context, db graph.Database, resolvedPaths *analyze.ActionPathSet) (analyze.ServiceAccessSummary, error) {
+	actionMap, err := analyze.GetActionMapFromPathSet(*resolvedPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	actionNames := []string{}
+	for actionName := range actionMap {
+		actionNames = append(actionNames, actionName)
+	}
+
+	accessLevels, err := GetActionAccessLevels(ctx, db, actionNames)
+	if err != nil {
+		return nil, err
+	}
+
+	return analyze.SummarizeActionMap(actionMap, accessLevels), nil
+}
@@ -0,0 +1,173 @@
+package queries
+
+import (
+	"context"
+	"time"
+
+	"github.com/hotnops/apeman/analyze"
+	"github.com/specterops/bloodhound/dawgs/graph"
+)
+
+// Run a query returning a single string column
+func getStringColumn(ctx context.Context, db graph.Database, query string, params map[string]any) ([]string, error) {
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	values := []string{}
+	for _, result := range results {
+		var value string
+		err = result.Map(&value)
+		if err != nil {
+			continue
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+func getSnapshotPolicyAttachments(ctx context.Context, db graph.Database) ([]analyze.PolicyAttachment, error) {
+	params := map[string]any{}
+	query := "MATCH (p:AWSManagedPolicy|AWSInlinePolicy) - [r:AttachedTo|PermissionsBoundary] -> (a:AWSUser|AWSRole|AWSGroup) " +
+		"WHERE " + collectionFilter(ctx, "a", params) + " " +
+		"WITH DISTINCT COALESCE(p.arn, p.policyname) AS policy, a.arn AS principal, type(r) AS kind " +
+		"RETURN policy, principal, kind ORDER BY principal, policy, kind"
+
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	attachments := []analyze.PolicyAttachment{}
+	for _, result := range results {
+		var attachment analyze.PolicyAttachment
+		err = result.Map(&attachment.Policy)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&attachment.Principal)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&attachment.Kind)
+		if err != nil {
+			continue
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	return attachments, nil
+}
+
+func getSnapshotTrustRelationships(ctx context.Context, db graph.Database) ([]analyze.TrustRelationship, error) {
+	params := map[string]any{}
+	query := "MATCH (s:AWSStatement {effect: 'Allow'}) - [:AttachedTo] -> (:AWSAssumeRolePolicy) - [:AttachedTo] -> (r:AWSRole) " +
+		"WHERE " + collectionFilter(ctx, "r", params) + " " +
+		"MATCH (s) - [:Principal] -> (p) " +
+		"WITH DISTINCT r.arn AS role, COALESCE(p.arn, p.name) AS principal " +
+		"RETURN role, principal ORDER BY role, principal"
+
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	trusts := []analyze.TrustRelationship{}
+	for _, result := range results {
+		var trust analyze.TrustRelationship
+		err = result.Map(&trust.Role)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&trust.Principal)
+		if err != nil {
+			continue
+		}
+		trusts = append(trusts, trust)
+	}
+
+	return trusts, nil
+}
+
+func getSnapshotIdentityTransforms(ctx context.Context, db graph.Database) ([]analyze.IdentityTransformEdge, error) {
+	params := map[string]any{}
+	query := "MATCH (a) - [t:IdentityTransform] -> (b) " +
+		"WHERE " + collectionFilter(ctx, "a", params) + " " +
+		"WITH DISTINCT COALESCE(a.arn, a.name) AS source, COALESCE(b.arn, b.name) AS target, t.name AS name " +
+		"RETURN source, target, name ORDER BY source, target, name"
+
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	edges := []analyze.IdentityTransformEdge{}
+	for _, result := range results {
+		var edge analyze.IdentityTransformEdge
+		err = result.Map(&edge.Source)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&edge.Target)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&edge.Name)
+		if err != nil {
+			continue
+		}
+		edges = append(edges, edge)
+	}
+
+	return edges, nil
+}
+
+// Capture the parts of the current graph that are compared between
+// snapshots
+func CaptureSnapshot(ctx context.Context, db graph.Database, name string) (*analyze.Snapshot, error) {
+	var err error
+	snapshot := &analyze.Snapshot{
+		Name:      name,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	params := map[string]any{}
+	snapshot.Principals, err = getStringColumn(ctx, db,
+		"MATCH (a:AWSUser|AWSRole|AWSGroup) WHERE a.inferred IS NULL AND "+collectionFilter(ctx, "a", params)+" "+
+			"RETURN DISTINCT a.arn AS arn ORDER BY arn", params)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot.PolicyAttachments, err = getSnapshotPolicyAttachments(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot.Statements, err = getStringColumn(ctx, db,
+		"MATCH (s:AWSStatement) WHERE "+collectionFilter(ctx, "s", params)+" RETURN DISTINCT s.hash AS hash ORDER BY hash", params)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot.TrustRelationships, err = getSnapshotTrustRelationships(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot.IdentityTransforms, err = getSnapshotIdentityTransforms(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot.TierZero, err = getStringColumn(ctx, db,
+		"MATCH (n) WHERE n.tierzero = true AND "+collectionFilter(ctx, "n", params)+" "+
+			"RETURN DISTINCT COALESCE(n.arn, n.name) AS name ORDER BY name", params)
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
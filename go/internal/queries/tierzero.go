@@ -0,0 +1,291 @@
+package queries
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/hotnops/apeman/analyze"
+	"github.com/hotnops/apeman/graphschema/aws"
+	"github.com/specterops/bloodhound/dawgs/graph"
+)
+
+// Get the paths of every statement that acts on all actions and all
+// resources, keyed by the user or role it applies to. Statements attached
+// to a group are included for each member. The attachment kind selects
+// between regular policies and permissions boundaries.
+func GetAdminStatementPaths(ctx context.Context, db graph.Database, attachment graph.Kind) (map[graph.ID]*analyze.ActionPathSet, error) {
+	params := map[string]any{
+		"actionNames":  analyze.AdminActionNames,
+		"resourceName": analyze.AdminResourceName,
+	}
+
+	query := fmt.Sprintf("MATCH (a:AWSUser|AWSRole) - [:MemberOf*0..1] -> (g:AWSUser|AWSRole|AWSGroup) <- [:%s] - (:AWSManagedPolicy|AWSInlinePolicy) <- [:AttachedTo*2..3] - (s:AWSStatement) - [:Action] -> (act:AWSActionBlob) "+
+		"WHERE act.name IN $actionNames AND (s) - [:Resource] -> (:AWSResourceBlob {name: $resourceName}) AND %s "+
+		"OPTIONAL MATCH (s) <- [:AttachedTo] - (c:AWSCondition) "+
+		"RETURN DISTINCT a, s, COALESCE(c IS NOT NULL, false)", attachment.String(), collectionFilter(ctx, "a", params))
+
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	principalPaths := map[graph.ID]*analyze.ActionPathSet{}
+	for _, result := range results {
+		var principal graph.Node
+		var statement graph.Node
+		var conditionExists bool
+		err = result.Map(&principal)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&statement)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&conditionExists)
+		if err != nil {
+			continue
+		}
+
+		entry := analyze.ActionPathEntry{}
+		entry.PrincipalID = principal.ID
+		entry.PrincipalArn, _ = principal.Properties.Get("arn").String()
+		entry.ResourceArn = analyze.AdminResourceName
+		entry.Action = analyze.AdminActionNames[0]
+		entry.Effect, _ = statement.Properties.Get("effect").String()
+		entry.Statement = &statement
+		if conditionExists {
+			conditions, err := GetConditionsFromStatement(ctx, db, statement.ID)
+			if err != nil {
+				log.Printf("[!] Error getting conditions: %s", err.Error())
+				continue
+			}
+			entry.Conditions = conditions
+			PopulateTags(ctx, db, &entry)
+		}
+
+		paths, ok := principalPaths[principal.ID]
+		if !ok {
+			paths = &analyze.ActionPathSet{}
+			principalPaths[principal.ID] = paths
+		}
+		paths.Add(entry)
+	}
+
+	return principalPaths, nil
+}
+
+func GetPrincipalsWithPermissionsBoundary(ctx context.Context, db graph.Database) (map[graph.ID]bool, error) {
+	params := map[string]any{}
+	query := "MATCH (a:AWSUser|AWSRole) <- [:PermissionsBoundary] - (:AWSManagedPolicy) WHERE " + collectionFilter(ctx, "a", params) + " RETURN DISTINCT ID(a)"
+
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	principals := map[graph.ID]bool{}
+	for _, result := range results {
+		var principalID graph.ID
+		err = result.Map(&principalID)
+		if err != nil {
+			continue
+		}
+		principals[principalID] = true
+	}
+
+	return principals, nil
+}
+
+// Get the IDs of every user and role whose policies allow all actions on
+// all resources
+func GetAdminEquivalentPrincipals(ctx context.Context, db graph.Database) ([]graph.ID, error) {
+	adminPaths, err := GetAdminStatementPaths(ctx, db, aws.AttachedTo)
+	if err != nil {
+		return nil, err
+	}
+	boundaryPaths, err := GetAdminStatementPaths(ctx, db, aws.PermissionsBoundary)
+	if err != nil {
+		return nil, err
+	}
+	bounded, err := GetPrincipalsWithPermissionsBoundary(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	adminIDs := []graph.ID{}
+	for principalID, paths := range adminPaths {
+		var principalBoundaryPaths *analyze.ActionPathSet
+		if bounded[principalID] {
+			principalBoundaryPaths = boundaryPaths[principalID]
+			if principalBoundaryPaths == nil {
+				// The boundary doesn't grant everything
+				continue
+			}
+		}
+
+		isAdmin, err := analyze.IsAdminEquivalent(paths, principalBoundaryPaths)
+		if err != nil {
+			log.Printf("[!] Error resolving admin paths: %s", err.Error())
+			continue
+		}
+		if isAdmin {
+			adminIDs = append(adminIDs, principalID)
+		}
+	}
+
+	return adminIDs, nil
+}
+
+// Recompute the tier zero property of every node. Admin equivalent
+// principals are tier zero, as is anything with an identity transform path
+// to a tier zero node. Manually marked nodes are kept. Only the nodes of
+// the collection in ctx are recomputed.
+func AnalyzeTierZero(ctx context.Context, db graph.Database) ([]analyze.TierZeroEntry, error) {
+	adminIDs, err := GetAdminEquivalentPrincipals(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	params := map[string]any{
+		"admin":     analyze.TierZeroReasonAdmin,
+		"transform": analyze.TierZeroReasonTransform,
+		"manual":    analyze.TierZeroReasonManual,
+		"adminIds":  adminIDs,
+	}
+
+	resetQuery := "MATCH (n) WHERE n.tierzero_reason IN [$admin, $transform] AND " + collectionFilter(ctx, "n", params) + " " +
+		"SET n.tierzero = COALESCE(n.tierzero_manual, false), " +
+		"n.tierzero_reason = CASE WHEN n.tierzero_manual = true THEN $manual ELSE null END"
+	if err := RawCypherWrite(ctx, db, resetQuery, params); err != nil {
+		return nil, err
+	}
+
+	adminQuery := "MATCH (n) WHERE ID(n) IN $adminIds AND " + collectionFilter(ctx, "n", params) + " " +
+		"SET n.tierzero = true, n.tierzero_reason = $admin"
+	if err := RawCypherWrite(ctx, db, adminQuery, params); err != nil {
+		return nil, err
+	}
+
+	// Walking the transforms in Cypher enumerates every path, which blows
+	// up when roles can assume each other, so the reachability is
+	// computed here
+	transformIDs, err := getTierZeroTransformSources(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	params["transformIds"] = transformIDs
+	transformQuery := "MATCH (n) WHERE ID(n) IN $transformIds AND " + collectionFilter(ctx, "n", params) + " " +
+		"SET n.tierzero = true, n.tierzero_reason = $transform"
+	if err := RawCypherWrite(ctx, db, transformQuery, params); err != nil {
+		return nil, err
+	}
+
+	return GetTierZeroNodes(ctx, db)
+}
+
+// Get the nodes of the collection in ctx with an identity transform path to
+// a tier zero node
+func getTierZeroTransformSources(ctx context.Context, db graph.Database) ([]graph.ID, error) {
+	params := map[string]any{}
+	query := "MATCH (a) - [:IdentityTransform] -> (b) WHERE " + collectionFilter(ctx, "a", params) + " " +
+		"RETURN DISTINCT ID(a), ID(b)"
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	edges := []graph.Relationship{}
+	for _, result := range results {
+		edge := graph.Relationship{}
+		err = result.Map(&edge.StartID)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&edge.EndID)
+		if err != nil {
+			continue
+		}
+		edges = append(edges, edge)
+	}
+
+	query = "MATCH (n) WHERE n.tierzero = true AND " + collectionFilter(ctx, "n", params) + " RETURN ID(n)"
+	results, err = RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	tierZeroIDs := []graph.ID{}
+	for _, result := range results {
+		var nodeID graph.ID
+		err = result.Map(&nodeID)
+		if err != nil {
+			continue
+		}
+		tierZeroIDs = append(tierZeroIDs, nodeID)
+	}
+
+	return analyze.GetTransformSources(edges, tierZeroIDs), nil
+}
+
+func GetTierZeroNodes(ctx context.Context, db graph.Database) ([]analyze.TierZeroEntry, error) {
+	params := map[string]any{}
+	query := "MATCH (n) WHERE n.tierzero = true AND " + collectionFilter(ctx, "n", params) + " " +
+		"RETURN ID(n), COALESCE(n.arn, n.name, ''), COALESCE(n.tierzero_reason, ''), COALESCE(n.tierzero_manual, false)"
+
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []analyze.TierZeroEntry{}
+	for _, result := range results {
+		entry := analyze.TierZeroEntry{}
+		err = result.Map(&entry.PrincipalID)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&entry.PrincipalArn)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&entry.Reason)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&entry.Manual)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// Manually mark a node as tier zero, or remove the manual mark. Removing
+// the mark leaves computed tier zero status in place.
+func SetTierZeroManual(ctx context.Context, db graph.Database, nodeID graph.ID, manual bool) error {
+	if _, err := GetAWSNodeByGraphID(ctx, db, nodeID); err != nil {
+		return err
+	}
+
+	params := map[string]any{
+		"nodeId": nodeID,
+		"manual": analyze.TierZeroReasonManual,
+	}
+	match := "MATCH (n) WHERE ID(n) = $nodeId AND " + collectionFilter(ctx, "n", params) + " "
+
+	query := match +
+		"SET n.tierzero_manual = true, n.tierzero = true, " +
+		"n.tierzero_reason = COALESCE(n.tierzero_reason, $manual)"
+	if !manual {
+		query = match +
+			"SET n.tierzero_manual = false, " +
+			"n.tierzero = (n.tierzero_reason IS NOT NULL AND n.tierzero_reason <> $manual), " +
+			"n.tierzero_reason = CASE WHEN n.tierzero_reason = $manual THEN null ELSE n.tierzero_reason END"
+	}
+
+	return RawCypherWrite(ctx, db, query, params)
+}
@@ -0,0 +1,184 @@
+package queries
+
+import (
+	"context"
+	"log"
+	"sort"
+
+	"github.com/hotnops/apeman/analyze"
+	"github.com/specterops/bloodhound/dawgs/graph"
+)
+
+// Find every allow statement that trusts an AWS service principal without
+// tying it to a source account or resource
+func GetConfusedDeputyStatements(ctx context.Context, db graph.Database) ([]analyze.ConfusedDeputyEntry, error) {
+	params := map[string]any{}
+	query := "MATCH (s:AWSStatement) - [:Principal] -> (p:UniqueName) " +
+		"WHERE s.effect = 'Allow' AND p.name CONTAINS '.amazonaws.com' AND " + collectionFilter(ctx, "s", params) + " " +
+		"WITH s, collect(DISTINCT p.name) AS services " +
+		"OPTIONAL MATCH (s) - [:AttachedTo*2..3] -> (t:UniqueArn) " +
+		"RETURN s, services, collect(DISTINCT t.arn)"
+
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []analyze.ConfusedDeputyEntry{}
+
+	for _, result := range results {
+		var statement graph.Node
+		var principals []string
+		var targetArns []string
+
+		err = result.Map(&statement)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&principals)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&targetArns)
+		if err != nil {
+			continue
+		}
+
+		services := []string{}
+		for _, principal := range principals {
+			if analyze.IsServicePrincipal(principal) {
+				services = append(services, principal)
+			}
+		}
+
+		conditions, err := GetConditionsFromStatement(ctx, db, statement.ID)
+		if err != nil {
+			log.Printf("[!] Error getting conditions: %s", err.Error())
+			continue
+		}
+
+		if !analyze.IsConfusedDeputy(services, conditions) {
+			continue
+		}
+
+		statementHash, _ := statement.Properties.Get("hash").String()
+		entries = append(entries, analyze.ConfusedDeputyEntry{
+			StatementHash: statementHash,
+			Services:      services,
+			TargetArns:    targetArns,
+		})
+	}
+
+	return entries, nil
+}
+
+// Get the account IDs of every principal that came from an ingested
+// authorization details file. Principals only referenced by a policy are
+// created as inferred nodes and are not counted.
+func GetIngestedAccountIDs(ctx context.Context, db graph.Database) (map[string]bool, error) {
+	params := map[string]any{}
+	query := "MATCH (a:AWSUser|AWSRole|AWSGroup) WHERE a.inferred IS NULL AND " + collectionFilter(ctx, "a", params) + " RETURN DISTINCT a.arn"
+
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	accountIDs := map[string]bool{}
+	for _, result := range results {
+		var arn string
+		err = result.Map(&arn)
+		if err != nil {
+			continue
+		}
+		accountIDs[analyze.GetAccountIDFromArn(arn)] = true
+	}
+
+	return accountIDs, nil
+}
+
+// Find every account outside of the ingested accounts that is trusted by a
+// statement, along with what the trust grants it
+func GetExternalAccountTrusts(ctx context.Context, db graph.Database, vendors analyze.VendorRegistry) ([]analyze.ExternalAccount, error) {
+	ingestedAccountIDs, err := GetIngestedAccountIDs(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	params := map[string]any{}
+	query := "MATCH (s:AWSStatement) - [:Principal] -> (p) " +
+		"WHERE s.effect = 'Allow' AND (p:UniqueArn OR p:AWSPrincipalBlob) AND " + collectionFilter(ctx, "s", params) + " " +
+		"MATCH (s) - [:AttachedTo*2] -> (t:UniqueArn) " +
+		"RETURN s, COALESCE(p.arn, p.name), t.arn"
+
+	results, err := RawCypherQuery(ctx, db, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := map[string]*analyze.ExternalAccount{}
+	externalIdEnforced := map[graph.ID]bool{}
+
+	for _, result := range results {
+		var statement graph.Node
+		var principal string
+		var targetArn string
+
+		err = result.Map(&statement)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&principal)
+		if err != nil {
+			continue
+		}
+		err = result.Map(&targetArn)
+		if err != nil {
+			continue
+		}
+
+		accountID := analyze.GetTrustedAccountID(principal)
+		if accountID == "" || ingestedAccountIDs[accountID] {
+			continue
+		}
+
+		enforced, ok := externalIdEnforced[statement.ID]
+		if !ok {
+			conditions, err := GetConditionsFromStatement(ctx, db, statement.ID)
+			if err != nil {
+				log.Printf("[!] Error getting conditions: %s", err.Error())
+				continue
+			}
+			enforced = analyze.IsExternalIdEnforced(conditions)
+			externalIdEnforced[statement.ID] = enforced
+		}
+
+		account, ok := accounts[accountID]
+		if !ok {
+			account = &analyze.ExternalAccount{
+				AccountID: accountID,
+				Vendor:    vendors.Lookup(accountID),
+				Targets:   []analyze.ExternalTrustTarget{},
+			}
+			accounts[accountID] = account
+		}
+
+		statementHash, _ := statement.Properties.Get("hash").String()
+		account.Targets = append(account.Targets, analyze.ExternalTrustTarget{
+			Arn:                targetArn,
+			Principal:          principal,
+			StatementHash:      statementHash,
+			ExternalIdEnforced: enforced,
+		})
+	}
+
+	externalAccounts := []analyze.ExternalAccount{}
+	for _, account := range accounts {
+		externalAccounts = append(externalAccounts, *account)
+	}
+	sort.Slice(externalAccounts, func(i, j int) bool {
+		return externalAccounts[i].AccountID < externalAccounts[j].AccountID
+	})
+
+	return externalAccounts, nil
+}
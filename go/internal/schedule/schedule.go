@@ -0,0 +1,159 @@
+// Package schedule parses the standard five field cron format:
+//
+//	minute hour day-of-month month day-of-week
+//
+// Each field accepts *, single values, ranges (1-5), lists (1,15,30) and
+// steps (*/15 or 0-30/5). Day of week runs from 0 (Sunday) to 6, with 7
+// also accepted for Sunday. As in cron, when both day fields are
+// restricted a time matches if either of them does.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type field struct {
+	name string
+	min  int
+	max  int
+}
+
+var fields = []field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+type Schedule struct {
+	minutes     map[int]bool
+	hours       map[int]bool
+	daysOfMonth map[int]bool
+	months      map[int]bool
+	daysOfWeek  map[int]bool
+	// Whether the day fields were given as *, which changes how they
+	// combine
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+func parseValue(value string, f field) (int, error) {
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s value %s", f.name, value)
+	}
+	if number < f.min || number > f.max {
+		return 0, fmt.Errorf("%s value %d is outside of %d-%d", f.name, number, f.min, f.max)
+	}
+	return number, nil
+}
+
+func parseField(spec string, f field) (map[int]bool, error) {
+	values := map[int]bool{}
+
+	for _, part := range strings.Split(spec, ",") {
+		rangeSpec, stepSpec, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepSpec)
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid %s step %s", f.name, stepSpec)
+			}
+		}
+
+		start, end := f.min, f.max
+		if rangeSpec != "*" {
+			startSpec, endSpec, isRange := strings.Cut(rangeSpec, "-")
+			var err error
+			start, err = parseValue(startSpec, f)
+			if err != nil {
+				return nil, err
+			}
+			end = start
+			if isRange {
+				end, err = parseValue(endSpec, f)
+				if err != nil {
+					return nil, err
+				}
+			} else if hasStep {
+				// A step on a single value runs to the end of the field
+				end = f.max
+			}
+			if end < start {
+				return nil, fmt.Errorf("invalid %s range %s", f.name, rangeSpec)
+			}
+		}
+
+		for value := start; value <= end; value += step {
+			values[value] = true
+		}
+	}
+
+	return values, nil
+}
+
+func Parse(spec string) (*Schedule, error) {
+	specFields := strings.Fields(spec)
+	if len(specFields) != len(fields) {
+		return nil, fmt.Errorf("schedule %q must have %d fields", spec, len(fields))
+	}
+
+	parsed := make([]map[int]bool, len(fields))
+	for i, f := range fields {
+		values, err := parseField(specFields[i], f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+		parsed[i] = values
+	}
+
+	// Sunday can be written as 0 or 7
+	if parsed[4][7] {
+		parsed[4][0] = true
+	}
+
+	return &Schedule{
+		minutes:       parsed[0],
+		hours:         parsed[1],
+		daysOfMonth:   parsed[2],
+		months:        parsed[3],
+		daysOfWeek:    parsed[4],
+		anyDayOfMonth: strings.HasPrefix(specFields[2], "*"),
+		anyDayOfWeek:  strings.HasPrefix(specFields[4], "*"),
+	}, nil
+}
+
+func (s *Schedule) matchesDay(t time.Time) bool {
+	dayOfMonth := s.daysOfMonth[t.Day()]
+	dayOfWeek := s.daysOfWeek[int(t.Weekday())]
+
+	if s.anyDayOfMonth || s.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// Matches returns true if the schedule fires during the minute of t
+func (s *Schedule) Matches(t time.Time) bool {
+	return s.minutes[t.Minute()] && s.hours[t.Hour()] && s.months[int(t.Month())] && s.matchesDay(t)
+}
+
+// Next returns the first minute after t that the schedule fires, or the
+// zero time if it never does within five years
+func (s *Schedule) Next(t time.Time) time.Time {
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+	for next.Before(end) {
+		if s.Matches(next) {
+			return next
+		}
+		next = next.Add(time.Minute)
+	}
+	return time.Time{}
+}
@@ -0,0 +1,114 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func parse(t *testing.T, spec string) *Schedule {
+	t.Helper()
+	s, err := Parse(spec)
+	if err != nil {
+		t.Fatalf("%s: %v", spec, err)
+	}
+	return s
+}
+
+func date(value string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", value)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1- * * * *",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("%q parsed", spec)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		spec    string
+		time    string
+		matches bool
+	}{
+		{"* * * * *", "2024-01-08 13:37", true},
+		{"0 2 * * *", "2024-01-08 02:00", true},
+		{"0 2 * * *", "2024-01-08 02:01", false},
+		{"0-30/5 * * * *", "2024-01-08 13:25", true},
+		{"0-30/5 * * * *", "2024-01-08 13:35", false},
+		{"1,15,30 * * * *", "2024-01-08 13:15", true},
+		// A step on a single value runs to the end of the field
+		{"50/5 * * * *", "2024-01-08 13:55", true},
+		{"50/5 * * * *", "2024-01-08 13:45", false},
+		{"* 20/2 * * *", "2024-01-08 22:00", true},
+		// 7 is Sunday, like 0. 2024-01-07 is a Sunday.
+		{"0 0 * * 7", "2024-01-07 00:00", true},
+		{"0 0 * * 0", "2024-01-07 00:00", true},
+		{"0 0 * * 5-7", "2024-01-07 00:00", true},
+		{"0 0 * * 7", "2024-01-08 00:00", false},
+		// Either day field matches when both are restricted. 2024-01-15
+		// is a Monday and 2024-01-09 a Tuesday.
+		{"0 0 1 * 1", "2024-01-15 00:00", true},
+		{"0 0 1 * 1", "2024-02-01 00:00", true},
+		{"0 0 1 * 1", "2024-01-09 00:00", false},
+		// Both have to match when either is *
+		{"0 0 1 * *", "2024-01-15 00:00", false},
+		{"0 0 * * 1", "2024-02-01 00:00", false},
+		{"0 0 */2 * 1", "2024-01-15 00:00", true},
+	}
+
+	for _, test := range tests {
+		if matches := parse(t, test.spec).Matches(date(test.time)); matches != test.matches {
+			t.Errorf("%q at %s: got %v, want %v", test.spec, test.time, matches, test.matches)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		spec string
+		from string
+		next string
+	}{
+		{"* * * * *", "2024-01-08 13:37", "2024-01-08 13:38"},
+		{"0 2 * * *", "2024-01-08 13:37", "2024-01-09 02:00"},
+		{"0 2 * * *", "2024-01-08 02:00", "2024-01-09 02:00"},
+		{"50/5 * * * *", "2024-01-08 13:51", "2024-01-08 13:55"},
+		{"50/5 * * * *", "2024-01-08 13:56", "2024-01-08 14:50"},
+		{"0 0 * * 7", "2024-01-08 00:00", "2024-01-14 00:00"},
+		// The 1st of the month comes before the next Friday
+		{"0 0 1 * 5", "2024-01-27 00:00", "2024-02-01 00:00"},
+		{"0 0 1 * 5", "2024-02-01 00:00", "2024-02-02 00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00", "2028-02-29 00:00"},
+	}
+
+	for _, test := range tests {
+		next := parse(t, test.spec).Next(date(test.from))
+		if !next.Equal(date(test.next)) {
+			t.Errorf("%q from %s: got %s, want %s", test.spec, test.from, next, test.next)
+		}
+	}
+
+	if next := parse(t, "0 0 31 2 *").Next(date("2024-01-08 00:00")); !next.IsZero() {
+		t.Errorf("February 31st fires at %s", next)
+	}
+}
@@ -1,4 +1,5 @@
 #!/usr/bin/env python3
+import argparse
 import arn
 import re
 import requests
@@ -6,28 +7,8 @@ import time
 
 from neo4j import GraphDatabase
 
-def populate_principal_blob(session):  
-    print("[*] Expanding principal blobs")
-    cypher_query = """
-        MATCH (a:AWSPrincipalBlob)
-        MATCH (b:AWSUser|AWSRole|AWSGroup|AWSIdentityProvider|AWSService)
-        WHERE b.arn =~ a.regex OR b.name =~ a.regex
-        MERGE (a) - [:ExpandsTo {layer: 2}] -> (b)
-    """
-    session.run(cypher_query)
-
-def populate_resource_blob(session):
-    print("[*] Expanding resource blobs")
-
-    cypher_query = """
-        MATCH (a:AWSResourceBlob)
-        MATCH (b:UniqueArn)
-        WHERE b.arn =~ a.regex
-        MERGE (a) - [:ExpandsTo {layer: 2}] -> (b)
-    """
-
-    session.run(cypher_query)
-
+# Analysis is confined to a collection by prefixing every route with it
+api_url = "http://apeman-backend.localhost"
 
 
 # def populate_not_resources(session):
@@ -45,18 +26,6 @@ def populate_resource_blob(session):
 #     session.run(cypher_query)
 
 
-def populate_action_blob(session):
-    print("[*] Expanding action blobs")
-    cypher_query = """
-        MATCH (a:AWSActionBlob)
-        MATCH (b:AWSAction)
-        WHERE b.name =~ a.regex
-        MERGE (a) - [:ExpandsTo {layer: 2}] -> (b)
-    """
-    session.run(
-        cypher_query
-    )
-
 # def populate_not_actions(session):
 #     print("[*] Expanding not action blobs")
 #     # If the statement uses a notaction, we will simply
@@ -89,58 +58,85 @@ def get_all_arn_nodes(session):
     return results.values()
 
 
-def populate_resource_types(session):
-    print("[*] Expanding resources types")
-    cypher_query = """
-        MATCH (a:AWSResourceType)
-        MATCH (b:UniqueArn) WHERE (b.arn =~ a.regex)
-        MERGE (b)  - [:TypeOf {layer: 2}] -> (a)
-    """
-    session.run(cypher_query)
-
-
-def populate_arn_fields(session):
-    print("[*] Populating ARN fields")
-    cypher_query = """
-        MATCH (u:UniqueArn)
-        WITH u, apoc.text.regexGroups(u.arn, 'arn:([^:]*):([^:]*):([^:]*):([^:]*):(.+)')[0] AS arn_parts
-        WHERE size(arn_parts) = 6
-        SET u.partition = arn_parts[1],
-            u.service = arn_parts[2],
-            u.region = arn_parts[3],
-            u.account_id = arn_parts[4],
-            u.resource = arn_parts[5]
-    """
-    session.run(cypher_query)
-
+def expand_graph():
+    print("[*] Expanding graph")
+    resp = requests.get(f"{api_url}/analyze/expand")
+    if resp.status_code == 200:
+        print("[*] Graph expansion complete")
+    else:
+        print("[!] Could not expand graph")
+        print(resp)
 
 def analyze_identity_transforms():
     print("[*] Analyzing assume roles")
-    resp = requests.get("http://apeman-backend.localhost/analyze/identitytransforms")
+    resp = requests.get(f"{api_url}/analyze/identitytransforms")
     if resp.status_code == 200:
         print("[*] Assume role analysis complete")
     else:
         print("[!] Could not analyze assume roles")
         print(resp)
 
-def analyze():
-    driver = GraphDatabase.driver("bolt://localhost:7687",
-                                  auth=("neo4j", "p@ssw0rd!"))
+def analyze_tier_zero():
+    print("[*] Analyzing tier zero principals")
+    resp = requests.get(f"{api_url}/analyze/tierzero")
+    if resp.status_code == 200:
+        print("[*] Tier zero analysis complete")
+    else:
+        print("[!] Could not analyze tier zero principals")
+        print(resp)
+
+def analyze_findings():
+    print("[*] Running findings checks")
+    resp = requests.get(f"{api_url}/analyze/findings")
+    if resp.status_code == 200:
+        print(f"[*] Findings analysis complete, {len(resp.json())} findings")
+    else:
+        print("[!] Could not run findings checks")
+        print(resp)
+
+def analyze_alerts():
+    print("[*] Checking for new escalation paths")
+    resp = requests.post(f"{api_url}/analyze/alerts")
+    if resp.status_code == 200:
+        alert = resp.json()
+        print(f"[*] {len(alert['new_identity_transforms'] or [])} new identity transforms, "
+              f"{len(alert['new_tier_zero_paths'] or [])} new tier zero paths")
+    else:
+        print("[!] Could not check for new escalation paths")
+        print(resp)
+
+def create_snapshot(name: str = None):
+    # Without a name, the server names the snapshot after the time it was
+    # taken
+    print("[*] Creating snapshot")
+    params = {"name": name} if name else {}
+    resp = requests.post(f"{api_url}/snapshots", params=params)
+    if resp.status_code == 200:
+        print(f"[*] Snapshot {resp.json()['name']} created")
+    else:
+        print("[!] Could not create snapshot")
+        print(resp)
 
-    with driver.session() as session:
-        populate_arn_fields(session)
-        populate_resource_types(session)
-        populate_action_blob(session)
-        #populate_not_actions(session)
-        populate_resource_blob(session)
-        populate_principal_blob(session)
-        #populate_not_resources(session)
+def analyze(snapshot_name: str = None):
+    # Graph expansion and every analysis after it run on the
+    # server, in this order
+    expand_graph()
+    analyze_identity_transforms()
+    analyze_tier_zero()
+    analyze_findings()
+    analyze_alerts()
 
-        # This is the first migration into server
-        # based analysis. All others will move over
-        # to API calls
-        analyze_identity_transforms()
+    create_snapshot(snapshot_name)
 
 
 if __name__ == "__main__":
-    analyze()
+    parser = argparse.ArgumentParser()
+    parser.add_argument("-s", "--snapshot",
+                        help="Name the snapshot of the analyzed graph. Defaults to "
+                             "the time it was taken")
+    parser.add_argument("-c", "--collection",
+                        help="Only analyze this collection")
+    args = parser.parse_args()
+    if args.collection:
+        api_url = f"{api_url}/collections/{args.collection}"
+    analyze(args.snapshot)
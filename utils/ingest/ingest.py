@@ -2,6 +2,7 @@
 import argparse
 import csv
 import glob
+import io
 import json
 import neo4j
 import os
@@ -14,6 +15,16 @@ from neo4j import GraphDatabase
 
 import arn
 
+# Every node ingested from an authorization details file belongs to a
+# collection, which keeps the data of unrelated organizations apart. The
+# AWS schema nodes created during initialization are shared by every
+# collection.
+DEFAULT_COLLECTION = "default"
+# Reserved for the temporary collections of Terraform plan analyses
+SCRATCH_COLLECTION_PREFIX = "impact-"
+SHARED_LABELS = ["AWSAction", "AWSOperator", "AWSConditionValue"]
+collection = DEFAULT_COLLECTION
+
 condition_map = {}
 condition_key_map = {}
 condition_value_map = {}
@@ -31,11 +42,14 @@ resource_blob_map = {}
 tag_map = {}
 identity_provider_map = {}
 principal_blob_map = {}
+credential_report_map = {}
+access_key_map = {}
 
 hash_to_hash_rels = {}
 hash_to_arn_rels = {}
 arn_to_arn_rels = {}
 member_of_rels = {}
+permissions_boundary_rels = {}
 operator_to_condition_rels = {}
 multi_operator_to_condition_rels = {}
 statement_to_action_rels = {}
@@ -53,7 +67,22 @@ condition_key_to_resource_rels = {}
 condition_value_to_key_rels = {}
 
 def get_hash(item_to_hash: dict):
-    return xxhash.xxh128_hexdigest(json.dumps(item_to_hash, sort_keys=True))
+    text = json.dumps(item_to_hash, sort_keys=True)
+    # Salt the hash so identical policies in different collections
+    # become separate nodes
+    if collection != DEFAULT_COLLECTION:
+        text = collection + text
+    return xxhash.xxh128_hexdigest(text)
+
+
+def is_shared_label(label: str):
+    return label.split(":")[0] in SHARED_LABELS
+
+
+def merge_key(label: str, field: str, value: str):
+    if is_shared_label(label):
+        return f"{{{field}: {value}}}"
+    return f"{{{field}: {value}, collection: $collection}}"
 
 
 def process_condition_value(condition_value):
@@ -422,6 +451,12 @@ def process_principal_policies(principal: dict):
         policy_arn = managed_policy['PolicyArn']
         add_to_rels(arn_to_arn_rels, policy_arn, principal_arn)
 
+    permissions_boundary = principal.get('PermissionsBoundary', None)
+    if permissions_boundary:
+        add_to_rels(permissions_boundary_rels,
+                    permissions_boundary['PermissionsBoundaryArn'],
+                    principal_arn)
+
     process_tags(principal)
     inlines_policy_hashes = []
     key_name = None
@@ -489,6 +524,66 @@ def process_group(group):
     process_principal_policies(group)
 
 
+# The header of the IAM credential report, from
+# aws iam get-credential-report
+CREDENTIAL_REPORT_HEADER = "user,arn,user_creation_time,password_enabled"
+
+# Values the credential report uses for data that doesn't exist
+CREDENTIAL_REPORT_EMPTY_VALUES = ["N/A", "no_information", "not_supported"]
+
+
+def is_credential_report(text: str):
+    return text.lstrip("\ufeff").startswith(CREDENTIAL_REPORT_HEADER)
+
+
+def credential_report_value(value: str):
+    if value in CREDENTIAL_REPORT_EMPTY_VALUES:
+        return ""
+    return value
+
+
+def credential_report_bool(value: str):
+    return "true" if value == "true" else "false"
+
+
+def process_credential_report(text: str):
+    reader = csv.DictReader(io.StringIO(text.lstrip("\ufeff")))
+    for row in reader:
+        # The root user isn't part of the authorization details
+        if row["user"] == "<root_account>":
+            continue
+
+        user_arn = row["arn"]
+        credential_report_map[user_arn] = {
+            'arn': user_arn,
+            'password_enabled': credential_report_bool(row["password_enabled"]),
+            'password_last_used': credential_report_value(row["password_last_used"]),
+            'password_last_changed': credential_report_value(row["password_last_changed"]),
+            'mfa_active': credential_report_bool(row["mfa_active"]),
+            'access_key_1_active': credential_report_bool(row["access_key_1_active"]),
+            'access_key_2_active': credential_report_bool(row["access_key_2_active"]),
+        }
+
+        # Each user has two access key slots. A slot that was never
+        # rotated has never held a key.
+        for slot in [1, 2]:
+            prefix = f"access_key_{slot}_"
+            if not credential_report_value(row[prefix + "last_rotated"]):
+                continue
+
+            key_hash = get_hash({'arn': user_arn, 'slot': slot})
+            access_key_map[key_hash] = {
+                'hash': key_hash,
+                'slot': slot,
+                'active': credential_report_bool(row[prefix + "active"]),
+                'last_rotated': credential_report_value(row[prefix + "last_rotated"]),
+                'last_used_date': credential_report_value(row[prefix + "last_used_date"]),
+                'last_used_region': credential_report_value(row[prefix + "last_used_region"]),
+                'last_used_service': credential_report_value(row[prefix + "last_used_service"]),
+            }
+            add_to_rels(hash_to_arn_rels, key_hash, user_arn)
+
+
 def write_to_csv(filename, items, field_names):
     with open(filename, 'w') as f:
         writer = csv.DictWriter(f, fieldnames=field_names, extrasaction='ignore')
@@ -547,13 +642,18 @@ def ingest_csv(session, filename, datatype, fields):
 
     query += delimiter.join(field_names)
 
+    collection_field = ""
+    if not is_shared_label(datatype):
+        collection_field = "a.collection = $collection, "
+
     query += (
-        f" MERGE (a:{datatype} {{{fields[0]}:{fields[0]}}}) "
+        f" MERGE (a:{datatype} {merge_key(datatype, fields[0], fields[0])}) "
         "ON CREATE SET "
     )
 
     for field in fields:
         query += f"a.{field} = {field}, "
+    query += collection_field
     query += "a.layer = 1 "
 
     # If the node already exists, delete all properties
@@ -561,9 +661,10 @@ def ingest_csv(session, filename, datatype, fields):
     query += "ON MATCH SET a = {}, "
     for field in fields:
         query += f"a.{field} = {field}, "
+    query += collection_field
     query += "a.layer = 1 "
     try:
-        return session.run(query)
+        return session.run(query, collection=collection)
     except Exception as e:
         print(e)
         import pdb; pdb.set_trace()
@@ -574,14 +675,44 @@ def ingest_resources(session, filename):
     query = (
         f'LOAD CSV FROM "file:///{filename}" AS row '
         'WITH row[0] AS arn '
-        'MERGE (a:UniqueArn {arn:arn}) '
-        'ON CREATE SET a.arn = arn, a.layer = 1 '
+        'MERGE (a:UniqueArn {arn: arn, collection: $collection}) '
+        'ON CREATE SET a.arn = arn, a.collection = $collection, a.layer = 1 '
     )
 
-    session.run(query)
+    session.run(query, collection=collection)
 
 
 
+# Credential report values are set on users that are already in the graph.
+# Empty fields are loaded as null, which removes the property.
+def ingest_credential_report(session, filename):
+    print(f"[*] Processing csv {filename}")
+    query = (
+        f'LOAD CSV FROM "file:///{filename}" AS row '
+        f'MATCH (u:AWSUser {merge_key("AWSUser", "arn", "row[0]")}) '
+        "SET u.password_enabled = row[1] = 'true', "
+        'u.password_last_used = row[2], '
+        'u.password_last_changed = row[3], '
+        "u.mfa_active = row[4] = 'true', "
+        "u.access_key_1_active = row[5] = 'true', "
+        "u.access_key_2_active = row[6] = 'true'"
+    )
+    session.run(query, collection=collection)
+
+
+def ingest_access_keys(session, filename):
+    print(f"[*] Processing csv {filename}")
+    query = (
+        f'LOAD CSV FROM "file:///{filename}" AS row '
+        f'MERGE (k:AWSAccessKey:UniqueHash {merge_key("UniqueHash", "hash", "row[0]")}) '
+        'SET k = {}, k.hash = row[0], k.collection = $collection, k.layer = 1, '
+        "k.slot = toInteger(row[1]), k.active = row[2] = 'true', "
+        'k.last_rotated = row[3], k.last_used_date = row[4], '
+        'k.last_used_region = row[5], k.last_used_service = row[6]'
+    )
+    session.run(query, collection=collection)
+
+
 def ingest_relationships(session, filename, source_label, source_field,
                          rel_name, dest_label, dest_field):
     print(f"[*] Processing relationship: {filename}")
@@ -589,14 +720,14 @@ def ingest_relationships(session, filename, source_label, source_field,
         f'LOAD CSV FROM "file:///{filename}" AS row '
         'CALL { '
         'WITH row '
-        f'MERGE (s:{source_label} {{{source_field}: row[0]}}) '
+        f'MERGE (s:{source_label} {merge_key(source_label, source_field, "row[0]")}) '
         f'ON CREATE SET s.inferred = true, s.layer = 1 '
-        f'MERGE (d:{dest_label} {{{dest_field}: row[1]}}) '
+        f'MERGE (d:{dest_label} {merge_key(dest_label, dest_field, "row[1]")}) '
         f'ON CREATE SET d.inferred = true, d.layer = 1 '
         f'MERGE (s) - [:{rel_name} {{layer: 1}}] -> (d) '
         '} IN TRANSACTIONS'
     )
-    session.run(query)
+    session.run(query, collection=collection)
 
 
 def load_csvs_into_database():
@@ -642,6 +773,8 @@ def load_csvs_into_database():
         ingest_csv(session, "users.csv", "AWSUser:UniqueArn",
                    ["arn", "path", "name", "userid",
                     "createdate"])
+        ingest_credential_report(session, "credentialreport.csv")
+        ingest_access_keys(session, "accesskeys.csv")
         ingest_csv(session, "resourceblobs.csv", "AWSResourceBlob:UniqueName",
                    ['name', 'regex'])
         ingest_csv(session, "tags.csv", "AWSTag:UniqueHash",
@@ -658,6 +791,9 @@ def load_csvs_into_database():
                              "arn", "AttachedTo", "UniqueArn", "arn")
         ingest_relationships(session, "member_of_rels.csv", "AWSUser", "arn",
                              "MemberOf", "AWSGroup", "arn")
+        ingest_relationships(session, "permissions_boundary_rels.csv",
+                             "UniqueArn", "arn", "PermissionsBoundary",
+                             "UniqueArn", "arn")
         ingest_relationships(session, "operator_to_condition_rels.csv",
                              "AWSOperator:UniqueName", "name",
                              "AttachedTo",
@@ -740,6 +876,10 @@ def write_rels_to_csv(outputdir):
     hash_to_arn_filename = os.path.join(outputdir, "hash_to_arn_rels.csv")
     arn_to_arn_rels_filename = os.path.join(outputdir, "arn_to_arn_rels.csv")
     member_of_rels_filename = os.path.join(outputdir, "member_of_rels.csv")
+    permissions_boundary_rels_filename = os.path.join(
+        outputdir,
+        "permissions_boundary_rels.csv"
+    )
 
     operator_to_condition_rels_filename = os.path.join(
         outputdir,
@@ -820,6 +960,8 @@ def write_rels_to_csv(outputdir):
                  rels_to_unique_list(arn_to_arn_rels), fields)
     write_to_csv(member_of_rels_filename,
                  rels_to_unique_list(member_of_rels), fields)
+    write_to_csv(permissions_boundary_rels_filename,
+                 rels_to_unique_list(permissions_boundary_rels), fields)
 
     write_to_csv(operator_to_condition_rels_filename,
                  rels_to_unique_list(operator_to_condition_rels),
@@ -898,6 +1040,19 @@ def delete_layer_1():
             )
 
 
+def delete_collection(collection_name: str):
+    print(f"[*] Deleting collection {collection_name}")
+    driver = GraphDatabase.driver("bolt://localhost:7687",
+                                  auth=("bloodhound", "bloodhound"))
+    with driver.session() as session:
+        for i in [2, 1]:
+            session.run(
+                f"MATCH (n {{layer: {i}, collection: $collection}}) "
+                "DETACH DELETE n",
+                collection=collection_name
+            )
+
+
 def write_nodes_to_csv(output_dir: str):
     managed_policies_filename = os.path.join(output_dir,
                                              "managedpolicies.csv")
@@ -975,6 +1130,17 @@ def write_nodes_to_csv(output_dir: str):
     principal_blob_filename = os.path.join(output_dir, "principalblobs.csv")
     write_to_csv(principal_blob_filename, principal_blob_map, ["name", "regex"])
 
+    credential_report_filename = os.path.join(output_dir, "credentialreport.csv")
+    write_to_csv(credential_report_filename, credential_report_map,
+                 ["arn", "password_enabled", "password_last_used",
+                  "password_last_changed", "mfa_active",
+                  "access_key_1_active", "access_key_2_active"])
+
+    access_keys_filename = os.path.join(output_dir, "accesskeys.csv")
+    write_to_csv(access_keys_filename, access_key_map,
+                 ["hash", "slot", "active", "last_rotated", "last_used_date",
+                  "last_used_region", "last_used_service"])
+
 
 if __name__ == "__main__":
     parser = argparse.ArgumentParser()
@@ -985,13 +1151,30 @@ if __name__ == "__main__":
                         action="store_true")
     parser.add_argument("-i", "--input-dir",
                         help="Input file")
+    parser.add_argument("-c", "--collection",
+                        help="The collection to ingest into or delete. "
+                        "Without one, everything goes into the default "
+                        "collection and -d deletes all collections")
     
     args = parser.parse_args()
     input_dir = args.input_dir
     output_dir = args.output_dir
 
+    if args.collection:
+        if not re.fullmatch(r"[A-Za-z0-9._-]+", args.collection):
+            print(f"[!] Invalid collection name {args.collection}")
+            sys.exit(1)
+        if args.collection.startswith(SCRATCH_COLLECTION_PREFIX):
+            print(f"[!] Collection names starting with "
+                  f"{SCRATCH_COLLECTION_PREFIX} are reserved")
+            sys.exit(1)
+        collection = args.collection
+
     if args.delete:
-        delete_layer_1()
+        if args.collection:
+            delete_collection(args.collection)
+        else:
+            delete_layer_1()
         sys.exit(0)
 
     for root, dirs, files in os.walk(input_dir):
@@ -1000,6 +1183,11 @@ if __name__ == "__main__":
                 with open(os.path.join(root, filename), 'r') as f:
                     text = f.read()
                     parse_json(text)
+            elif filename.endswith('.csv'):
+                with open(os.path.join(root, filename), 'r') as f:
+                    text = f.read()
+                    if is_credential_report(text):
+                        process_credential_report(text)
         write_nodes_to_csv(output_dir)
         write_rels_to_csv(output_dir)
         load_csvs_into_database()
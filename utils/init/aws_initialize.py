@@ -200,7 +200,7 @@ def write_data_to_csv(actions: dict, actions_file, resources_file,
         lines = []
         for action_name, action_def in definition["Actions"].items():
             full_name = f"{service_prefix}:{action_name}"
-            access_level = action_def['access_level']
+            access_level = action_def.get('access_level', '')
             lines.append(f"{full_name},{access_level}\n".lower())
             for resource in action_def.get('resource_types', []):
                 action_to_resources_types_rels_file.write(
@@ -245,9 +245,12 @@ def ingest_csv(session, filename, datatype, fields):
 
     query += row_names.join(rows)
 
+    # Skip the header row, if the file has one
+    query += f" WHERE {fields[0]} <> '{fields[0]}'"
+
     query += (
         f" MERGE (a:{datatype} {{{fields[0]}:{fields[0]}}}) "
-        "ON CREATE SET "
+        "SET "
     )
 
     for field in fields:
@@ -311,39 +314,52 @@ def create_relationship_constraint(session, constraint_name, rel_name,
     session.run(query)
 
 
+def create_collection_constraint(session, constraint_name, label, property):
+    # Nodes ingested from authorization details are unique within their
+    # collection. Deployments created before collections existed have a
+    # single property constraint which has to go first.
+    session.run(f"DROP CONSTRAINT {constraint_name} IF EXISTS")
+    query = (
+        f"CREATE CONSTRAINT {constraint_name}_collection IF NOT EXISTS "
+        f"FOR (n:{label}) REQUIRE (n.{property}, n.collection) IS UNIQUE")
+
+    session.run(query)
+
+
 def create_constraints(driver):
     with driver.session() as session:
         create_constraint(session, 'awsactionconstraint',
                           'AWSAction', 'name')
-        create_constraint(session, 'actionblobconstraint',
-                          'AWSActionBlob', 'name')
-        create_constraint(session, 'assumerolepolicyconstraint',
-                          'AWSAssumeRolePolicy', 'hash')
-        create_constraint(session, "conditionconstraint",
-                          "AWSCondition", "hash")
+        create_collection_constraint(session, 'actionblobconstraint',
+                                     'AWSActionBlob', 'name')
+        create_collection_constraint(session, 'assumerolepolicyconstraint',
+                                     'AWSAssumeRolePolicy', 'hash')
+        create_collection_constraint(session, "conditionconstraint",
+                                     "AWSCondition", "hash")
         create_constraint(session, "conditionvalueconstraint",
                           "AWSConditionValue", "name")
-        create_constraint(session, "groupconstraint",
-                          "AWSGroup", "arn")
-        create_constraint(session, "inlinepolicyconstraint",
-                          "AWSInlinePolicy", "hash")
-        create_constraint(session, "managedpolicyconstraint",
-                          "AWSManagedPolicy", "arn")
-        create_constraint(session, "policydocumentconstraint",
-                          "AWSPolicyDocument", "hash")
-        create_constraint(session, "policyversionconstraint",
-                          "AWSPolicyVersion", "hash")
-        create_constraint(session, "roleconstraint",
-                          "AWSRole", "arn")
-        create_constraint(session, "statementconstraint",
-                          "AWSStatement", "hash")
-        create_constraint(session, "userconstraint",
-                          "AWSUser", "arn")
-        create_constraint(session, "resourceblobconstraint",
-                          'AWSResourceBlob', 'name')
-        create_constraint(session, "tagconstraint",
-                          'AWSTag', 'hash')
-        create_constraint(session, "uniquehashconstraint", 'UniqueHash', 'hash')
+        create_collection_constraint(session, "groupconstraint",
+                                     "AWSGroup", "arn")
+        create_collection_constraint(session, "inlinepolicyconstraint",
+                                     "AWSInlinePolicy", "hash")
+        create_collection_constraint(session, "managedpolicyconstraint",
+                                     "AWSManagedPolicy", "arn")
+        create_collection_constraint(session, "policydocumentconstraint",
+                                     "AWSPolicyDocument", "hash")
+        create_collection_constraint(session, "policyversionconstraint",
+                                     "AWSPolicyVersion", "hash")
+        create_collection_constraint(session, "roleconstraint",
+                                     "AWSRole", "arn")
+        create_collection_constraint(session, "statementconstraint",
+                                     "AWSStatement", "hash")
+        create_collection_constraint(session, "userconstraint",
+                                     "AWSUser", "arn")
+        create_collection_constraint(session, "resourceblobconstraint",
+                                     'AWSResourceBlob', 'name')
+        create_collection_constraint(session, "tagconstraint",
+                                     'AWSTag', 'hash')
+        create_collection_constraint(session, "uniquehashconstraint",
+                                     'UniqueHash', 'hash')
 
 def create_indices(driver):
     with driver.session() as session: